@@ -46,6 +46,12 @@ const LUA_MIN_STACK = 20
 const LUA_MAX_STACK = 1000000
 const LUA_REGISTRY_INDEX = -LUA_MAX_STACK - 1000
 
+// Go调用栈上嵌套调用(由Go代码发起的调用以及resume)的最大层数,与官方LUAI_MAXCCALLS一致
+const LUAI_MAXCCALLS = 200
+
+// 默认的调用链层数上限,可以通过SetCallDepthLimit修改;调用链占用的栈空间同时受LUA_MAX_STACK限制
+const LUAI_MAXCALLS = 200000
+
 const LUA_MAIN_COROUTING_RIDX int64 = 1
 const LUA_GLOBALS_RIDX int64 = 2 //全局表默认key为2放在注册表中
 
@@ -57,6 +63,12 @@ const LUA_PRELOAD_TABLE = "_PRELOAD"
 
 const LUA_MULTRET = -1
 
+/* 语言版本 */
+const (
+	LUA_VERSION_53 = 503 //默认版本
+	LUA_VERSION_54 = 504 //支持<const>、<close>局部变量,整数for循环不会溢出,coroutine.close
+)
+
 /* thread status */
 const (
 	//LUA_OK到LUA_ERR_FILE作为协程的返回值,都属于LUA_DEAD状态
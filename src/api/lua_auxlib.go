@@ -2,13 +2,41 @@ package api
 
 type FuncReg map[string]GoFunc
 
+// 标准库的打开方式,嵌入时用于限制不受信任脚本能够使用的功能
+//
+// ReadOnlyGlobal只保护全局表本身:代理通过__newindex拒绝赋值,rawset仍可以绕过它,所以需要同时移除rawset;
+// string、table、math等库表不受保护,脚本仍可以修改其中的字段(如string.rep = nil),
+// 修改会影响同一状态中之后运行的所有脚本,需要隔离时应当为每个不受信任的脚本使用单独的状态;
+// 代理通过__pairs使pairs(_G)遍历原全局表,但next(_G)与rawget(_G, k)直接访问空的代理,不会返回任何内容
+type LibProfile struct {
+	Libs           []string //需要打开的库,如"_G","string","os";为nil时打开全部标准库
+	Strip          []string //打开库后移除的全局变量或库字段,如"load","io","os.exit"
+	ReadOnlyGlobal bool     //是否以只读代理替换全局表,脚本对全局变量赋值时抛出错误(见下方说明)
+	LoadMode       string   //见SetLoadMode,为空串时不限制
+}
+
+// 用于运行不受信任脚本的库配置
+// 只打开不访问宿主资源的库,移除加载代码及访问文件、进程、环境变量的函数,全局表只读且只能加载文本chunk
+// rawset会绕过只读全局表的__newindex,所以同样被移除
+func SandboxProfile() *LibProfile {
+	return &LibProfile{
+		Libs: []string{"_G", "string", "table", "math", "utf8", "coroutine", "os"},
+		Strip: []string{"load", "loadfile", "dofile", "rawset",
+			"os.exit", "os.execute", "os.getenv", "os.remove", "os.rename", "os.tmpname"},
+		ReadOnlyGlobal: true,
+		LoadMode:       "t",
+	}
+}
+
 /*
 * auxiliary interface
  */
 type AuxLib interface {
 	/* Error-report functions */
-	Error2(fmt string, a ...interface{}) int //压入fmt格式串错误信息并触发panic,方法不会返回
-	ArgError(idx int, extraMsg string) int   //方法调用时参数错误,方法不会返回
+	Error2(fmt string, a ...interface{}) int      //压入fmt格式串错误信息并触发panic,方法不会返回
+	ArgError(idx int, extraMsg string) int        //方法调用时参数错误,方法不会返回
+	Where(level int)                              //压入第level层函数当前执行位置"chunkname:currentline: ",level 0为当前运行的函数,不是lua函数则压入""
+	Traceback(co LuaState, msg string, level int) //压入co从第level层开始的调用栈回溯信息,msg不为空时添加在开头
 	/* Argument check functions */
 	ArgCheck(cond bool, idx int, extraMsg string) //检查cond是否为true。如果不是则抛出带有标准消息的错误
 	CheckAny(idx int)                             //检查R(idx)是否为LuaValueType
@@ -19,6 +47,8 @@ type AuxLib interface {
 	OptInteger(idx int, d int64) int64            //如果R(idx)是整数（或可转换为整数），则返回该整数。如果此参数不存在或为nil，则返回d。否则，引发错误。
 	OptFloat(idx int, d float64) float64          //如果R(idx)是整数（或可转换为浮点数），则返回该浮点数。如果此参数不存在或为nil，则返回d。否则，引发错误。
 	OptString(idx int, d string) string           //如果R(idx)是字符串（或可转换为整数），则返回该字符串。如果此参数不存在或为nil，则返回d。否则，引发错误。
+	CheckUdata(idx int, tname string) interface{} //检查R(idx)是否为元表为registry[tname]的userdata,是则返回其包装的Go值,不是则ArgError
+	TestUdata(idx int, tname string) interface{}  //同CheckUdata,但不是则返回nil而不引发错误
 	/* Load functions */
 	DoFile(filename string) bool         //加载并运行给定的文件。如果没有错误则返回false；反之返回true
 	LoadFile(filename string) int        //实质为LoadFileX但提供空mode
@@ -34,6 +64,7 @@ type AuxLib interface {
 	CallMeta(obj int, e string) bool             //调用元方法;如果索引obj处的对象有元表，并且这个元表有字段e，则此函数调用该字段，并将该对象作为其唯一参数。在本例中，该函数返回true并将调用返回的值压入堆栈。如果没有元表或元方法，则此函数返回false（不向堆栈上压入任何值）。
 
 	OpenLibs()
+	OpenLibsWith(profile *LibProfile) //按profile打开标准库,profile为nil时同OpenLibs
 	//确保modname模块加载
 	//如果modname不存在于包中package.loaded,则以字符串modname作为参数调用函数openf，并在包中package.loaded设置调用结果
 	//如果glb为true，还将模块存储到全局modname中。
@@ -41,4 +72,9 @@ type AuxLib interface {
 	RequireF(modname string, openf GoFunc, glb bool)
 
 	NewLib(funcs map[string]GoFunc) //通过funcs创建一个模块(table),并将其压入栈顶
+
+	/* Metatable functions */
+	NewMetatable(tname string) bool               //如果registry[tname]已存在则压入该值并返回false;否则创建带有__name=tname的元表,存入registry[tname]并压入栈,返回true
+	GetMetatableByName(tname string) LuaValueType //将registry[tname]压入栈并返回其类型
+	SetMetatableByName(tname string)              //将registry[tname]设置为栈顶值的元表
 }
@@ -0,0 +1,56 @@
+package api
+
+/* hook事件 */
+const (
+	LUA_HOOKCALL = iota
+	LUA_HOOKRET
+	LUA_HOOKLINE
+	LUA_HOOKCOUNT
+	LUA_HOOKTAILCALL
+)
+
+/* hook事件掩码 */
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
+)
+
+// 函数的调试信息,对应官方的lua_Debug
+// GetInfo根据what中的字符填充对应的字段
+type DebugInfo struct {
+	Event           int    //触发hook的事件
+	Name            string //'n':函数名
+	NameWhat        string //'n':函数名的含义,"global","local","method","field","upvalue"或""
+	What            string //'S':"Lua"表示lua函数,"main"表示主函数,"C"表示Go函数
+	Source          string //'S':函数所属chunk的名称
+	ShortSrc        string //'S':便于输出的Source
+	CurrentLine     int    //'l':当前执行的行号,没有则为-1
+	LineDefined     int    //'S':函数定义的开始行号
+	LastLineDefined int    //'S':函数定义的结束行号
+	NUps            int    //'u':upvalue的个数
+	NParams         int    //'u':固定参数的个数
+	IsVararg        bool   //'u':是否有可变参数
+	IsTailCall      bool   //'t':是否为尾调用
+
+	CallInfo interface{} //GetStack获取的函数栈,仅供内部使用
+}
+
+// hook函数,在对应的事件发生时被调用
+type HookFunc func(ls LuaState, ar *DebugInfo)
+
+type DebugAPI interface {
+	GetStack(level int) *DebugInfo            //获取第level层函数的调试信息(只设置CallInfo),level 0为当前运行的函数,不存在则返回nil
+	GetInfo(what string, ar *DebugInfo) bool  //根据what填充ar;what以'>'开头时弹出栈顶函数作为目标;'f'压入函数,'L'压入有效行号表
+	GetLocal(ar *DebugInfo, n int) string     //压入ar函数的第n个局部变量并返回其名称,不存在则不压入并返回"";ar为nil时返回栈顶函数第n个参数名称
+	SetLocal(ar *DebugInfo, n int) string     //弹出栈顶值设置为ar函数的第n个局部变量并返回其名称,不存在则只弹出并返回""
+	GetUpvalue(funcIdx, n int) (string, bool) //压入函数的第n个upvalue并返回其名称,不存在则返回false
+	SetUpvalue(funcIdx, n int) (string, bool) //弹出栈顶值设置为函数的第n个upvalue并返回其名称,不存在则返回false
+	UpvalueId(funcIdx, n int) interface{}     //返回函数第n个upvalue的唯一标识,共享的upvalue标识相同
+	UpvalueJoin(f1, n1, f2, n2 int)           //使函数f1的第n1个upvalue引用函数f2的第n2个upvalue
+	SetHook(f HookFunc, mask, count int)      //设置hook函数,mask为LUA_MASKXXX的组合,count为触发LUA_HOOKCOUNT的指令间隔;f为nil或mask为0时关闭hook
+	GetHook() HookFunc                        //获取当前的hook函数
+	GetHookMask() int                         //获取当前的hook掩码
+	GetHookCount() int                        //获取当前的hook指令间隔
+}
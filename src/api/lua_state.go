@@ -1,5 +1,7 @@
 package api
 
+import "context"
+
 type LuaValueType int //Lua的数据类型
 
 func (tp LuaValueType) String() string {
@@ -80,6 +82,11 @@ func (compare CompareOp) String() string {
 // Go函数;return返回值的个数
 type GoFunc func(LuaVM) int
 
+// Go函数的continuation;Go函数通过CallK、PCallK调用的函数yield或者调用YieldK之后,
+// 协程恢复执行时不会回到原来的Go函数中,而是调用k继续完成该Go函数,k的返回值即为该Go函数的返回值
+// status为LUA_SUSPENDED(yield之后恢复执行)或PCallK捕获到的错误状态,ctx为设置k时传入的上下文
+type KFunc func(vm LuaVM, status int, ctx int) int
+
 /*		Stack					Index
 *		|		nil		|	  7 		 -					-
 *		|		nil		|	  6			 |	无效索引		 |
@@ -101,6 +108,7 @@ type BasicAPI interface {
 	SetTop(idx int)          //设置top index并将其后的出栈
 	AbsIndex(idx int) int    //获取绝对index，返回的合法index应当大于0
 	CheckStack(n int)        //检查是否可以容纳n个value,n>0
+	CheckStackX(n int) bool  //同CheckStack,超出栈空间上限时不报错而是返回false
 	Pop(n int)               //弹出n个val
 	Copy(fromIdx, toIdx int) //将from的val复制到to
 	PushValue(idx int)       //将指定索引的val压入栈顶
@@ -118,7 +126,10 @@ type BasicAPI interface {
 	PushInteger(n int64)
 	PushFloat(n float64)
 	PushString(s string)
-	PushBasic(v interface{}) //v==bool,int64,float64,string,nil
+	PushBasic(v interface{})         //v==bool,int64,float64,string,nil,或者SortI传给compare的值
+	PushUserdata(v interface{})      //创建包装Go值v的full userdata并压入栈
+	NewUserdata(size int) []byte     //创建包装size字节内存块的full userdata并压入栈,返回该内存块
+	PushLightUserdata(p interface{}) //压入引用Go值p的light userdata,包装同一个p的light userdata相等;p必须是可比较的值(如指针),否则报错
 
 	/*
 	*	栈元素访问
@@ -130,7 +141,8 @@ type BasicAPI interface {
 	IsNil(idx int) bool
 	IsNoneOrNil(idx int) bool
 	IsBoolean(idx int) bool
-	IsInteger(idx int) bool
+	IsInteger(idx int) bool        //是否为整数或可转换为整数的值
+	IsIntegerSubtype(idx int) bool //是否为整数子类型的数字,不进行类型转换
 	IsFloat(idx int) bool
 	IsString(idx int) bool
 	IsTable(idx int) bool
@@ -143,7 +155,10 @@ type BasicAPI interface {
 	ToFloatX(idx int) (float64, bool)
 	ToString(idx int) string //获取指定索引的string值
 	ToStringX(idx int) (string, bool)
-	ToPointer(idx int) interface{} //获取指定索引寄存器内的值
+	ToPointer(idx int) interface{} //获取指定索引的引用类型值(table、函数、协程、userdata)的指针,仅用于区分不同的对象;其余类型返回nil
+	IsUserdata(idx int) bool       //是否为full userdata或light userdata
+	IsLightUserdata(idx int) bool
+	ToUserdata(idx int) interface{} //获取指定索引userdata包装的Go值,如果不是userdata则返回nil
 
 	/*
 	*	运算操作
@@ -175,12 +190,14 @@ type BasicAPI interface {
 
 	Load(chunk []byte, chunckName, mode string) int                               //加载chunk获得对应的closure并将其压入栈,成功返回LUA_OK
 	LoadWithEnv(chunk []byte, chunkName string, mode string, env interface{}) int //不同于Load默认使用'_ENV'环境,该方法可以指定外部环境,即捕获的外部变量,env必须是luatable
+	Dump(strip bool) ([]byte, bool)                                               //将栈顶的lua函数序列化为二进制chunk,strip为true时不包含调试信息;栈顶不是lua函数则返回false
 
 	//lua函数：将nArgs+1数量的val弹出作为函数及其参数，执行closure，最后将nResults数量的结果值压入栈(nResults<0则压入所有返回值)
 	//go函数：将nArgs数量的val弹出作为外部Go函数的参数，执行Go函数并将所有返回值都压入栈中
 	//nArgs为参数个数
 	//nResults为返回值个数:==0则不返回任何值,<0则返回值全部压入,>0则返回nResults个返回值
 	Call(nArgs, nResults int)
+	CallK(nArgs, nResults, ctx int, k KFunc) //同Call,被调函数yield之后以k代替当前Go函数继续执行;k为nil或不能yield时同Call
 	/*
 	*	Go函数支持
 	 */
@@ -201,14 +218,17 @@ type BasicAPI interface {
 	 */
 
 	UpvalueIndex(i int) int //获取Upvalue索引
-	CloseUpvalues(a int)    //取消对>=局部变量R[a-1]的Upvalue引用
+	CloseUpvalues(a int)    //取消对>=局部变量R[a-1]的Upvalue引用,并关闭其中的待关闭变量
+	ToClose(idx int)        //将指定idx的值标记为待关闭变量,离开作用域、函数返回或出错时调用其__close元方法;值为nil或false时不做处理
 
 	/*
 	*	元编程支持
 	 */
 
-	GetMetaTable(idx int) bool //如果指定idx值具备元表则将其压入栈并返回true;若没有元表则直接返回false
-	SetMetaTable(idx int)      //将栈顶的一个值弹出作为指定idx值的元表,只弹出1个值
+	GetMetaTable(idx int) bool         //如果指定idx值具备元表则将其压入栈并返回true;若没有元表则直接返回false
+	SetMetaTable(idx int)              //将栈顶的一个值弹出作为指定idx值的元表,只弹出1个值
+	GetUserValue(idx int) LuaValueType //将指定idx的full userdata关联的user value压入栈,并返回其类型
+	SetUserValue(idx int)              //将栈顶的一个值弹出作为指定idx的full userdata关联的user value
 
 	/*
 	*	标准库支持
@@ -233,25 +253,46 @@ type BasicAPI interface {
 	*	异常处理支持
 	 */
 
-	Error() int                                        //弹出栈顶值作为错误抛出
-	PCall(nArgs, nResults int, hasErrhandler bool) int //以保护模式执行方法,调用期间如果出现panic并不会停止运行而是立马抛出异常,如果有errhandler约定stack[1]为其函数closure
+	Error() int                                                           //弹出栈顶值作为错误抛出
+	PCall(nArgs, nResults int, hasErrhandler bool) int                    //以保护模式执行方法,调用期间如果出现panic并不会停止运行而是立马抛出异常,如果有errhandler约定stack[1]为其函数closure
+	PCallK(nArgs, nResults int, hasErrhandler bool, ctx int, k KFunc) int //同PCall,被调函数yield之后以k代替当前Go函数继续执行,之后出现的错误也由k处理
 
 	/*
 	*	协程支持
 	 */
 
-	NewCoroutine() LuaState            //创建coroutine,与创建该coroutine的协程共享registry,全局表也是属于registry的所以全局变量也是共享的
-	Resume(co LuaState, nArgs int) int //恢复co的控制权
-	Yield() int                        //让出当前coroutine的控制权
-	Status() int                       //返回当前coroutine状态信息
-	IsYieldable() bool                 //当前coroutine是否能yield
+	NewCoroutine() LuaState                //创建coroutine,与创建该coroutine的协程共享registry,全局表也是属于registry的所以全局变量也是共享的
+	Resume(co LuaState, nArgs int) int     //恢复co的控制权
+	Yield() int                            //让出当前coroutine的控制权,栈中的所有值作为resume的返回值;必须作为Go函数的return表达式调用
+	YieldK(nResults, ctx int, k KFunc) int //同Yield,栈顶的nResults个值作为resume的返回值,恢复执行时以k代替当前Go函数继续执行
+	Status() int                           //返回当前coroutine状态信息
+	IsYieldable() bool                     //当前coroutine是否能yield
+
+	PushCoroutine() bool            //将当前coroutine推入栈,并返回是否为主coroutine
+	ToCoroutine(idx int) LuaState   //将指定索引的LuaValue转换为coroutine返回,如果是其他类型则返回nil
+	XMove(to LuaState, n int)       //用于两个coroutine之间移动元素,从当前coroutine弹出n个元素压入to(coroutine)中
+	CloseCoroutine(co LuaState) int //关闭挂起或死亡的co,关闭其待关闭变量并将其标记为死亡;返回LUA_OK或错误状态,出错时错误值位于co的栈顶
+
+	/*
+	*	执行限制,由同一个主协程创建的所有coroutine共享
+	 */
+
+	SetContext(ctx context.Context) //设置执行的上下文,ctx被取消后执行lua指令时抛出错误;ctx为nil时不限制
+	SetInstructionLimit(n int64)    //设置可执行的lua指令数上限并清零已执行的指令数,超出上限时抛出错误;n<=0时不限制
+	InstructionCount() int64        //返回上次SetInstructionLimit以来已执行的lua指令数
+	SetLoadMode(mode string)        //限制Load能够加载的chunk类型,"t"只允许文本,"b"只允许二进制,"bt"或空串不限制
+	SetCallDepthLimit(n int)        //设置调用链的层数上限,超出时抛出"stack overflow"错误;n<=0时使用LUAI_MAXCALLS
+
+	/*
+	*	语言版本,新创建的coroutine继承创建者的版本
+	 */
 
-	PushCoroutine() bool          //将当前coroutine推入栈,并返回是否为主coroutine
-	ToCoroutine(idx int) LuaState //将指定索引的LuaValue转换为coroutine返回,如果是其他类型则返回nil
-	XMove(to LuaState, n int)     //用于两个coroutine之间移动元素,从当前coroutine弹出n个元素压入to(coroutine)中
+	SetVersion(v int) //设置Load编译源代码时接受的语法及运行时语义,LUA_VERSION_53(默认)或LUA_VERSION_54;应当在OpenLibs之前设置
+	Version() int     //返回当前的语言版本
 }
 
 type LuaState interface {
 	BasicAPI
 	AuxLib
+	DebugAPI
 }
@@ -11,4 +11,7 @@ type LuaVM interface {
 	LoadProto(idx int)  //将函数原型表中指定索引的原型压入栈顶
 	RegisterCount() int //返回函数所操作寄存器的数量
 	LoadVarargs(n int)  //将n个vararg压入栈顶，n<0表示将全部varargs压入栈顶
+
+	PreCall(nArgs, nResults int) bool //CALL指令调用函数:lua函数只创建调用帧并返回true,由执行循环继续执行;Go函数直接执行,yield时返回true,否则返回false
+	PreTailCall(nArgs int) bool       //TAILCALL指令调用函数:lua函数替换当前的函数栈并返回true;Go函数与PreCall相同
 }
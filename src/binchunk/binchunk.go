@@ -72,15 +72,27 @@ type Upvalue struct {
 	Idx     byte //instack==1表示栈上索引；instack==0表示外围函数的upvalue表索引,即closure内的upvals列表
 }
 
+// 局部变量的作用范围为指令区间[StartPC,EndPC)
 type LocVar struct {
-	VarName   string
-	StartLine uint32
-	EndLine   uint32
+	VarName string
+	StartPC uint32 //局部变量生效的第一条指令
+	EndPC   uint32 //局部变量失效的第一条指令
 }
 
-// to do
+// 序列化为可供LUA虚拟机执行的二进制chunk
 func (p *Prototype) ToBytes() []byte {
-	return nil
+	return Dump(p)
+}
+
+// 返回去除调试信息(行号表、局部变量、upvalue名)后的副本,子函数同样去除,p本身不会被修改
+func (p *Prototype) Strip() *Prototype {
+	sp := *p
+	sp.LineInfo, sp.LocVars, sp.UpvalueNames = nil, nil, nil
+	sp.Protos = make([]*Prototype, len(p.Protos))
+	for i, sub := range p.Protos {
+		sp.Protos[i] = sub.Strip()
+	}
+	return &sp
 }
 
 type prototypeInfo struct {
@@ -2,17 +2,42 @@ package binchunk
 
 import (
 	"encoding/binary"
+	"fmt"
 	"math"
+	"strings"
 
 	"nskbz.cn/lua/instruction"
 )
 
-func Undump(datas []byte) *Prototype {
+// 二进制chunk格式错误,由Undump拦截并作为error返回
+type undumpError string
+
+// 解析二进制chunk并校验其中的指令,name为chunkname
+// chunk被截断、头部不匹配或指令非法时返回error而不是panic,用于安全地加载来自不可信来源的chunk
+func Undump(datas []byte, name string) (proto *Prototype, err error) {
+	switch {
+	case strings.HasPrefix(name, "@"), strings.HasPrefix(name, "="):
+		name = name[1:]
+	case strings.HasPrefix(name, LUA_SIGNATURE[:1]):
+		name = "binary string"
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(undumpError)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s: %s precompiled chunk", name, string(e))
+		}
+	}()
 	r := reader{byteReader: byteReader{data: datas}}
 	r.checkHeader()
 	r.readByte() //跳过sizeUpvalues字段
-	proto := r.readProto("")
-	return proto
+	proto = r.readProto("")
+	if err := Verify(proto); err != nil {
+		return nil, fmt.Errorf("%s: bad code in precompiled chunk (%v)", name, err)
+	}
+	return proto, nil
 }
 
 type reader struct {
@@ -21,37 +46,37 @@ type reader struct {
 
 func (r *reader) checkHeader() {
 	if LUA_SIGNATURE != string(r.readBytes(4)) {
-		panic("error signature")
+		panic(undumpError("not a"))
 	}
 	if LUAC_VERSION != r.readByte() {
-		panic("error version")
+		panic(undumpError("version mismatch in"))
 	}
 	if LUAC_FORMAT != r.readByte() {
-		panic("error format")
+		panic(undumpError("format mismatch in"))
 	}
 	if LUAC_DATA != string(r.readBytes(6)) {
-		panic("error luac_data")
+		panic(undumpError("corrupted"))
 	}
 	if CINT_SIZE != r.readByte() {
-		panic("error cint_size")
+		panic(undumpError("int size mismatch in"))
 	}
 	if CSIZET_SIZE != r.readByte() {
-		panic("error csizet_size")
+		panic(undumpError("size_t size mismatch in"))
 	}
 	if INSTRUCTION_SIZE != r.readByte() {
-		panic("error instruction_size")
+		panic(undumpError("Instruction size mismatch in"))
 	}
 	if LUA_INTEGER_SIZE != r.readByte() {
-		panic("error lua_int_size")
+		panic(undumpError("lua_Integer size mismatch in"))
 	}
 	if LUA_FLOAT_SIZE != r.readByte() {
-		panic("error lua_float_size")
+		panic(undumpError("lua_Number size mismatch in"))
 	}
 	if LUAC_INT != r.readLuaInteger() {
-		panic("error lua_int")
+		panic(undumpError("endianness mismatch in"))
 	}
 	if LUAC_FLOAT != r.readLuaFloat() {
-		panic("error lua_float")
+		panic(undumpError("float format mismatch in"))
 	}
 
 }
@@ -92,17 +117,21 @@ func (r *reader) readString() string {
 		return ""
 	}
 	length := 0
-	if b <= 0xFD {
+	if b < 0xFF {
 		length = int(b) - 1
 	} else {
-		length = int(r.readUint64()) - 1
+		n := r.readUint64()
+		if n > uint64(len(r.data)+1) {
+			panic(undumpError("truncated"))
+		}
+		length = int(n) - 1
 	}
 	s := string(r.byteReader.readBytes(uint(length)))
 	return s
 }
 
 func (r *reader) readCodes() []instruction.Instruction {
-	length := r.readUint32()
+	length := r.readLength()
 	codes := make([]instruction.Instruction, length)
 	for i := 0; i < int(length); i++ {
 		codes[i] = instruction.Instruction(r.readUint32())
@@ -111,7 +140,7 @@ func (r *reader) readCodes() []instruction.Instruction {
 }
 
 func (r *reader) readConstants() []interface{} {
-	length := r.readUint32()
+	length := r.readLength()
 	constants := make([]interface{}, length)
 	for i := 0; i < int(length); i++ {
 		constants[i] = r.readConstant()
@@ -133,11 +162,11 @@ func (r *reader) readConstant() interface{} {
 	case TAG_SHORT_STR, TAG_LONG_STR:
 		return r.readString()
 	}
-	panic("error")
+	panic(undumpError("corrupted"))
 }
 
 func (r *reader) readUpvalues() []Upvalue {
-	length := r.readUint32()
+	length := r.readLength()
 	uvs := make([]Upvalue, length)
 	for i := 0; i < int(length); i++ {
 		uvs[i] = Upvalue{
@@ -149,7 +178,7 @@ func (r *reader) readUpvalues() []Upvalue {
 }
 
 func (r *reader) readProtos(parentSource string) []*Prototype {
-	length := r.readUint32()
+	length := r.readLength()
 	ps := make([]*Prototype, length)
 	for i := 0; i < int(length); i++ {
 		ps[i] = r.readProto(parentSource)
@@ -158,7 +187,7 @@ func (r *reader) readProtos(parentSource string) []*Prototype {
 }
 
 func (r *reader) readLineInfo() []uint32 {
-	length := r.readUint32()
+	length := r.readLength()
 	lis := make([]uint32, length)
 	for i := 0; i < int(length); i++ {
 		lis[i] = r.readUint32()
@@ -167,20 +196,20 @@ func (r *reader) readLineInfo() []uint32 {
 }
 
 func (r *reader) readLocVars() []LocVar {
-	length := r.readUint32()
+	length := r.readLength()
 	lvs := make([]LocVar, length)
 	for i := 0; i < int(length); i++ {
 		lvs[i] = LocVar{
-			VarName:   r.readString(),
-			StartLine: r.readUint32(),
-			EndLine:   r.readUint32(),
+			VarName: r.readString(),
+			StartPC: r.readUint32(),
+			EndPC:   r.readUint32(),
 		}
 	}
-	return nil
+	return lvs
 }
 
 func (r *reader) readUpvalueNames() []string {
-	length := r.readUint32()
+	length := r.readLength()
 	ss := make([]string, length)
 	for i := 0; i < int(length); i++ {
 		ss[i] = r.readString()
@@ -188,17 +217,36 @@ func (r *reader) readUpvalueNames() []string {
 	return ss
 }
 
+// 读取列表的长度
+// 每个元素至少占1个字节,长度超过剩余字节数时说明chunk被截断,避免按错误的长度分配内存
+func (r *reader) readLength() uint32 {
+	length := r.readUint32()
+	if uint64(length) > uint64(len(r.data)) {
+		panic(undumpError("truncated"))
+	}
+	return length
+}
+
 type byteReader struct {
 	data []byte
 }
 
+// 剩余字节数不足n时抛出truncated错误
+func (br *byteReader) need(n uint) {
+	if uint(len(br.data)) < n {
+		panic(undumpError("truncated"))
+	}
+}
+
 func (br *byteReader) readByte() byte {
+	br.need(1)
 	b := br.data[0]
 	br.data = br.data[1:]
 	return b
 }
 
 func (br *byteReader) readBytes(n uint) []byte {
+	br.need(n)
 	bs := make([]byte, n)
 	copy(bs, br.data[:n])
 	br.data = br.data[n:]
@@ -206,12 +254,14 @@ func (br *byteReader) readBytes(n uint) []byte {
 }
 
 func (br *byteReader) readUint32() uint32 {
+	br.need(4)
 	i := binary.LittleEndian.Uint32(br.data) //小端序读取
 	br.data = br.data[4:]
 	return i
 }
 
 func (br *byteReader) readUint64() uint64 {
+	br.need(8)
 	i := binary.LittleEndian.Uint64(br.data)
 	br.data = br.data[8:]
 	return i
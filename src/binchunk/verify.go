@@ -0,0 +1,294 @@
+package binchunk
+
+import (
+	"fmt"
+	"strings"
+
+	"nskbz.cn/lua/instruction"
+)
+
+/*
+*	字节码校验
+*
+*	二进制chunk可能被篡改或由不可信来源构造,虚拟机执行指令时不会检查操作数
+*	所以在创建closure前检查每条指令的寄存器、常量、upvalue、子函数索引以及跳转目标是否越界
+*	寄存器上限为MaxRegisterSize,指令表必须以RETURN结束,保证pc不会越过指令表
+*	C为0的CALL与B为0的VARARG把结果留在栈顶,只能紧跟着B为0的CALL、TAILCALL、RETURN或SETLIST使用它们
+ */
+
+// 校验函数原型及其所有子函数原型,不合法时返回描述错误位置的error
+func Verify(p *Prototype) error {
+	return verifyProto(p, nil)
+}
+
+type verifier struct {
+	p  *Prototype
+	pc int
+}
+
+type verifyError string
+
+func (v *verifier) fail(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	panic(verifyError(fmt.Sprintf("function at line %d, pc %d: %s", v.p.LineStart, v.pc, msg)))
+}
+
+func verifyProto(p *Prototype, parent *Prototype) (err error) {
+	v := &verifier{p: p, pc: -1}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(verifyError)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", string(e))
+		}
+	}()
+	v.checkHeader(parent)
+	for v.pc = 0; v.pc < len(p.Codes); v.pc++ {
+		v.checkCode(p.Codes[v.pc])
+	}
+	v.pc = -1
+	for _, sub := range p.Protos {
+		if err := verifyProto(sub, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 检查指令表以外的字段
+func (v *verifier) checkHeader(parent *Prototype) {
+	p := v.p
+	if p.NumParams > p.MaxRegisterSize {
+		v.fail("%d parameters but only %d registers", p.NumParams, p.MaxRegisterSize)
+	}
+	if len(p.Codes) == 0 || p.Codes[len(p.Codes)-1].Opcode() != instruction.OP_RETURN {
+		v.fail("missing final RETURN")
+	}
+	if len(p.LineInfo) != 0 && len(p.LineInfo) != len(p.Codes) {
+		v.fail("line info size mismatch")
+	}
+	if len(p.UpvalueNames) != 0 && len(p.UpvalueNames) != len(p.Upvalues) {
+		v.fail("upvalue names size mismatch")
+	}
+	if parent == nil {
+		return //主函数的upvalue由Load设置
+	}
+	for i, uv := range p.Upvalues {
+		if uv.Instack > 1 {
+			v.fail("upvalue %d has invalid instack %d", i, uv.Instack)
+		}
+		if uv.Instack == 1 && uv.Idx >= parent.MaxRegisterSize ||
+			uv.Instack != 1 && int(uv.Idx) >= len(parent.Upvalues) {
+			v.fail("upvalue %d out of range", i)
+		}
+	}
+}
+
+// 寄存器R(r)到R(r+n-1)都在范围内
+func (v *verifier) checkReg(r, n int) {
+	if r < 0 || r+n > int(v.p.MaxRegisterSize) {
+		v.fail("register %d out of range", r+n-1)
+	}
+}
+
+func (v *verifier) checkRK(rk int) {
+	if rk >= instruction.ConstantBase {
+		v.checkConst(rk - instruction.ConstantBase)
+	} else {
+		v.checkReg(rk, 1)
+	}
+}
+
+func (v *verifier) checkConst(idx int) {
+	if idx >= len(v.p.Constants) {
+		v.fail("constant %d out of range", idx)
+	}
+}
+
+func (v *verifier) checkUpval(idx int) {
+	if idx >= len(v.p.Upvalues) {
+		v.fail("upvalue %d out of range", idx)
+	}
+}
+
+// 跳转后执行的下一条指令为pc+1+sbx
+// 使用栈顶的指令依赖前一条指令设置的栈顶,不能作为跳转目标
+func (v *verifier) checkJump(sbx int) {
+	dest := v.pc + 1 + sbx
+	if dest < 0 || dest >= len(v.p.Codes) {
+		v.fail("jump target %d out of range", dest)
+	}
+	if usesOpenTop(v.p.Codes[dest]) {
+		v.fail("jump into an open call at pc %d", dest)
+	}
+}
+
+// C为0的CALL、TAILCALL(结果总是全部返回)以及B为0的VARARG把所有结果留在栈顶
+func setsOpenTop(code instruction.Instruction) bool {
+	_, b, c := code.ABC()
+	switch code.Opcode() {
+	case instruction.OP_CALL:
+		return c == 0
+	case instruction.OP_TAILCALL:
+		return true
+	case instruction.OP_VARARG:
+		return b == 0
+	}
+	return false
+}
+
+// B为0的CALL、TAILCALL、RETURN、SETLIST使用从寄存器到栈顶的所有值
+func usesOpenTop(code instruction.Instruction) bool {
+	_, b, _ := code.ABC()
+	switch code.Opcode() {
+	case instruction.OP_CALL, instruction.OP_TAILCALL, instruction.OP_RETURN, instruction.OP_SETLIST:
+		return b == 0
+	}
+	return false
+}
+
+// 栈顶的结果必须由下一条指令使用
+func (v *verifier) checkOpenResults() {
+	if v.pc+1 >= len(v.p.Codes) || !usesOpenTop(v.p.Codes[v.pc+1]) {
+		v.fail("multiple results of %s not used", strings.TrimSpace(v.p.Codes[v.pc].Name()))
+	}
+}
+
+// 使用的值从R(first)开始,前一条指令必须把结果留在栈顶且结果位于R(first)及之后
+// 否则虚拟机取得的值的数量为负数
+func (v *verifier) checkOpenTop(first int) {
+	if v.pc == 0 || !setsOpenTop(v.p.Codes[v.pc-1]) {
+		v.fail("%s with B=0 does not follow an open call", strings.TrimSpace(v.p.Codes[v.pc].Name()))
+	}
+	if prev, _, _ := v.p.Codes[v.pc-1].ABC(); prev < first {
+		v.fail("open results start at register %d before %d", prev, first)
+	}
+}
+
+// 下一条指令必须存在且操作码为op
+func (v *verifier) checkNext(op int) instruction.Instruction {
+	if v.pc+1 >= len(v.p.Codes) || v.p.Codes[v.pc+1].Opcode() != op {
+		name := strings.TrimSpace(instruction.Instruction(op).Name())
+		v.fail("missing %s after %s", name, strings.TrimSpace(v.p.Codes[v.pc].Name()))
+	}
+	return v.p.Codes[v.pc+1]
+}
+
+func (v *verifier) checkCode(code instruction.Instruction) {
+	op := code.Opcode()
+	if op > instruction.OP_TBC {
+		v.fail("invalid opcode %d", op)
+	}
+	a, b, c := code.ABC()
+	if code.OpMode() != instruction.IAx && code.ModArgA(instruction.ArgR&^instruction.ArgU) {
+		v.checkReg(a, 1)
+	}
+	if code.OpMode() == instruction.IABC { //ArgK与ArgRK的参数都可能是常量表索引或寄存器
+		if code.ModArgB(instruction.ArgK &^ instruction.ArgU) {
+			v.checkRK(b)
+		} else if code.ModArgB(instruction.ArgR &^ instruction.ArgU) {
+			v.checkReg(b, 1)
+		}
+		if code.ModArgC(instruction.ArgK &^ instruction.ArgU) {
+			v.checkRK(c)
+		} else if code.ModArgC(instruction.ArgR &^ instruction.ArgU) {
+			v.checkReg(c, 1)
+		}
+	}
+
+	switch op {
+	case instruction.OP_LOADK:
+		_, bx := code.ABx()
+		v.checkConst(bx)
+	case instruction.OP_LOADKX:
+		v.checkConst(v.checkNext(instruction.OP_EXTRAARG).Ax())
+		v.pc++
+	case instruction.OP_LOADBOOL:
+		if c != 0 {
+			v.checkJump(1)
+		}
+	case instruction.OP_LOADNIL:
+		v.checkReg(a, b+1)
+	case instruction.OP_GETUPVAL, instruction.OP_SETUPVAL, instruction.OP_GETTABUP:
+		v.checkUpval(b)
+	case instruction.OP_SETTABUP:
+		v.checkUpval(a)
+	case instruction.OP_SETTABLE:
+		v.checkReg(a, 1)
+	case instruction.OP_SELF:
+		v.checkReg(a, 2)
+	case instruction.OP_CONCAT:
+		if b > c {
+			v.fail("invalid CONCAT range")
+		}
+	case instruction.OP_JMP:
+		_, sbx := code.AsBx()
+		v.checkJump(sbx)
+		if a > 0 {
+			v.checkReg(a-1, 1)
+		}
+	case instruction.OP_TEST:
+		v.checkReg(a, 1)
+		v.checkNext(instruction.OP_JMP)
+	case instruction.OP_EQ, instruction.OP_LT, instruction.OP_LE, instruction.OP_TESTSET:
+		v.checkNext(instruction.OP_JMP)
+	case instruction.OP_CALL:
+		if b > 0 {
+			v.checkReg(a, b)
+		} else {
+			v.checkOpenTop(a + 1)
+		}
+		if c > 1 {
+			v.checkReg(a, c-1)
+		} else if c == 0 {
+			v.checkOpenResults()
+		}
+	case instruction.OP_TAILCALL:
+		if b > 0 {
+			v.checkReg(a, b)
+		} else {
+			v.checkOpenTop(a + 1)
+		}
+		v.checkOpenResults()
+	case instruction.OP_RETURN:
+		v.checkReg(a, max(b-1, 0))
+		if b == 0 {
+			v.checkOpenTop(a)
+		}
+	case instruction.OP_FORLOOP, instruction.OP_FORPREP:
+		_, sbx := code.AsBx()
+		v.checkReg(a, 4)
+		v.checkJump(sbx)
+	case instruction.OP_TFORCALL:
+		v.checkReg(a, 3+c)
+		v.checkNext(instruction.OP_TFORLOOP)
+	case instruction.OP_TFORLOOP:
+		_, sbx := code.AsBx()
+		v.checkReg(a, 2)
+		v.checkJump(sbx)
+	case instruction.OP_SETLIST:
+		v.checkReg(a, b+1)
+		if b == 0 {
+			v.checkOpenTop(a + 1)
+		}
+		if c == 0 {
+			v.checkNext(instruction.OP_EXTRAARG)
+			v.pc++
+		}
+	case instruction.OP_CLOSURE:
+		_, bx := code.ABx()
+		if bx >= len(v.p.Protos) {
+			v.fail("function prototype %d out of range", bx)
+		}
+	case instruction.OP_VARARG:
+		if b > 1 {
+			v.checkReg(a, b-1)
+		} else if b == 0 {
+			v.checkOpenResults()
+		}
+	case instruction.OP_EXTRAARG:
+		v.fail("unexpected EXTRAARG") //EXTRAARG只能跟在LOADKX或SETLIST之后
+	}
+}
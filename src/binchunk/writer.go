@@ -0,0 +1,183 @@
+package binchunk
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// 短字符串的最大长度,超过则作为长字符串(TAG_LONG_STR)存储,与官方LUAI_MAXSHORTLEN一致
+const LUAI_MAXSHORTLEN = 40
+
+// Dump 将proto序列化为LUA5.3标准的二进制chunk,是Undump的逆过程
+func Dump(proto *Prototype) []byte {
+	w := &writer{}
+	w.writeHeader()
+	w.writeByte(byte(len(proto.Upvalues))) //sizeUpvalues字段
+	w.writeProto(proto, "")
+	return w.data
+}
+
+type writer struct {
+	byteWriter
+}
+
+func (w *writer) writeHeader() {
+	w.writeBytes([]byte(LUA_SIGNATURE))
+	w.writeByte(LUAC_VERSION)
+	w.writeByte(LUAC_FORMAT)
+	w.writeBytes([]byte(LUAC_DATA))
+	w.writeByte(CINT_SIZE)
+	w.writeByte(CSIZET_SIZE)
+	w.writeByte(INSTRUCTION_SIZE)
+	w.writeByte(LUA_INTEGER_SIZE)
+	w.writeByte(LUA_FLOAT_SIZE)
+	w.writeLuaInteger(LUAC_INT)
+	w.writeLuaFloat(LUAC_FLOAT)
+}
+
+// 与官方ldump.c一致,子函数的源文件名与父函数相同时不写入,读取时由父函数补全
+func (w *writer) writeProto(proto *Prototype, parentSource string) {
+	if proto.Source == parentSource {
+		w.writeNullString()
+	} else {
+		w.writeString(proto.Source)
+	}
+	w.writeUint32(proto.LineStart)
+	w.writeUint32(proto.LineEnd)
+	w.writeByte(proto.NumParams)
+	w.writeByte(proto.IsVararg)
+	w.writeByte(proto.MaxRegisterSize)
+	w.writeCodes(proto)
+	w.writeConstants(proto)
+	w.writeUpvalues(proto)
+	w.writeProtos(proto)
+	w.writeLineInfo(proto)
+	w.writeLocVars(proto)
+	w.writeUpvalueNames(proto)
+}
+
+func (w *writer) writeLuaInteger(i int64) {
+	w.writeUint64(uint64(i))
+}
+
+func (w *writer) writeLuaFloat(f float64) {
+	w.writeUint64(math.Float64bits(f))
+}
+
+// NULL字符串(与空字符串""区分),只用于source字段
+func (w *writer) writeNullString() {
+	w.writeByte(0)
+}
+
+// 字符串长度+1后小于0xFF的用1B记录,否则用0xFF+size_t记录
+func (w *writer) writeString(s string) {
+	size := len(s) + 1
+	if size < 0xFF {
+		w.writeByte(byte(size))
+	} else {
+		w.writeByte(0xFF)
+		w.writeUint64(uint64(size))
+	}
+	w.writeBytes([]byte(s))
+}
+
+func (w *writer) writeCodes(proto *Prototype) {
+	w.writeUint32(uint32(len(proto.Codes)))
+	for _, code := range proto.Codes {
+		w.writeUint32(uint32(code))
+	}
+}
+
+func (w *writer) writeConstants(proto *Prototype) {
+	w.writeUint32(uint32(len(proto.Constants)))
+	for _, c := range proto.Constants {
+		w.writeConstant(c)
+	}
+}
+
+func (w *writer) writeConstant(c interface{}) {
+	switch v := c.(type) {
+	case nil:
+		w.writeByte(TAG_NIL)
+	case bool:
+		w.writeByte(TAG_BOOLEAN)
+		if v {
+			w.writeByte(1)
+		} else {
+			w.writeByte(0)
+		}
+	case int64:
+		w.writeByte(TAG_INTEGER)
+		w.writeLuaInteger(v)
+	case float64:
+		w.writeByte(TAG_FLOAT)
+		w.writeLuaFloat(v)
+	case string:
+		if len(v) <= LUAI_MAXSHORTLEN {
+			w.writeByte(TAG_SHORT_STR)
+		} else {
+			w.writeByte(TAG_LONG_STR)
+		}
+		w.writeString(v)
+	default:
+		panic("error constant type")
+	}
+}
+
+func (w *writer) writeUpvalues(proto *Prototype) {
+	w.writeUint32(uint32(len(proto.Upvalues)))
+	for _, uv := range proto.Upvalues {
+		w.writeByte(uv.Instack)
+		w.writeByte(uv.Idx)
+	}
+}
+
+func (w *writer) writeProtos(proto *Prototype) {
+	w.writeUint32(uint32(len(proto.Protos)))
+	for _, p := range proto.Protos {
+		w.writeProto(p, proto.Source)
+	}
+}
+
+func (w *writer) writeLineInfo(proto *Prototype) {
+	w.writeUint32(uint32(len(proto.LineInfo)))
+	for _, line := range proto.LineInfo {
+		w.writeUint32(line)
+	}
+}
+
+func (w *writer) writeLocVars(proto *Prototype) {
+	w.writeUint32(uint32(len(proto.LocVars)))
+	for _, lv := range proto.LocVars {
+		w.writeString(lv.VarName)
+		w.writeUint32(lv.StartPC)
+		w.writeUint32(lv.EndPC)
+	}
+}
+
+func (w *writer) writeUpvalueNames(proto *Prototype) {
+	w.writeUint32(uint32(len(proto.UpvalueNames)))
+	for _, name := range proto.UpvalueNames {
+		w.writeString(name)
+	}
+}
+
+type byteWriter struct {
+	data []byte
+}
+
+func (bw *byteWriter) writeByte(b byte) {
+	bw.data = append(bw.data, b)
+}
+
+func (bw *byteWriter) writeBytes(bs []byte) {
+	bw.data = append(bw.data, bs...)
+}
+
+func (bw *byteWriter) writeUint32(i uint32) {
+	bw.data = binary.LittleEndian.AppendUint32(bw.data, i) //小端序写入
+}
+
+func (bw *byteWriter) writeUint64(i uint64) {
+	bw.data = binary.LittleEndian.AppendUint64(bw.data, i)
+}
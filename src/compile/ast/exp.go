@@ -92,9 +92,9 @@ exp ::=functiondef | tableconstructor
 //	    [5] = "five"    -- 显式指定索引5
 //	}
 type TableConstructExp struct {
-	Line     int // line of '{',for debug
-	LastLine int // line of  '}',for debug
-	Keys     []Exp
+	Line     int   // line of '{',for debug
+	LastLine int   // line of  '}',for debug
+	Keys     []Exp // 列表式字段(没有显式的键)的键为nil
 	Vals     []Exp
 }
 
@@ -167,7 +167,7 @@ type TableAccessExp struct {
 // 所以可知在lua中函数调用的参数列表可以是标准的以'('开头
 // 也可以直接以字符串或表构造式的形式开头
 type FuncCallExp struct {
-	Line     int   // line of '('
+	Line     int   //前缀表达式开始的行号,与官方一致作为CALL指令的行号
 	LastLine int   // line of ')'
 	Method   Exp   //可以是普通方法，也可以是类方法
 	Exps     []Exp //参数表达式列表
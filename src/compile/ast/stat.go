@@ -37,6 +37,8 @@ type BreakStat struct {
 // end
 type LabelStat struct {
 	Name string //标签的值
+
+	Line int //用于错误信息
 }
 
 // stat ::=goto
@@ -162,6 +164,7 @@ type ForInStat struct {
 	Block    *Block
 
 	LineOfFor int
+	LineOfIn  int //in之后第一个表达式的行号,与官方一致作为TFORCALL与TFORLOOP的行号
 	LineOfDo  int //for in语句循环体开始的行号，用于debug记录作用域
 	LineOfEnd int //同上
 }
@@ -170,14 +173,21 @@ type ForInStat struct {
 // namelist ::=Name { ',' Name}
 // explist ::=exp { ',' exp}
 //
+// 5.4:
+// stat ::=local attnamelist [‘=’ explist]
+// attnamelist ::=Name attrib { ',' Name attrib}
+// attrib ::=[‘<’ Name ‘>’]
+//
 // for example:
 // local a
 // local b, c, d = 1, 2, 3
+// local e <const>, f <close> = 4, io.open("x")
 //
 // "区别与AssignStat作用于赋值，LocalVarStat则作用于定义"
 type LocalVarStat struct {
 	LastLine     int      //局部变量语句末尾行号，用于debug记录局部变量的作用范围行数
 	LocalVarList []string //localVar,区别var,localVar不存在OOP的那种层级
+	AttribList   []string //与LocalVarList一一对应的属性,"const"、"close"或空串;没有任何属性时为nil
 	ExpList      []Exp    //explist
 }
 
@@ -5,8 +5,24 @@ import (
 )
 
 func cgBlock(fi *funcInfo, block *ast.Block) {
-	for _, stat := range block.Stats {
-		cgStat(fi, stat, block.LastLine)
+	_cgBlock(fi, block, true)
+}
+
+// endLabel表示块末尾的label是否视为局部变量已离开作用域,repeat循环体的末尾之后还有until表达式所以不是
+func _cgBlock(fi *funcInfo, block *ast.Block, endLabel bool) {
+	//从end开始的语句都是位于块末尾的label
+	end := len(block.Stats)
+	if endLabel && block.RetExps == nil {
+		for end > 0 && _isLabelStat(block.Stats[end-1]) {
+			end--
+		}
+	}
+	for i, stat := range block.Stats {
+		if _isLabelStat(stat) {
+			cgLabelStat(fi, stat, i >= end)
+			continue
+		}
+		cgStat(fi, stat)
 	}
 	if block.RetExps != nil {
 		cgRetStat(fi, block.RetExps, block.LastLine)
@@ -16,7 +32,7 @@ func cgBlock(fi *funcInfo, block *ast.Block) {
 // 生成方法返回指令
 func cgRetStat(fi *funcInfo, exps []ast.Exp, blockLastLine int) {
 	//没有返回值，直接return
-	if exps == nil {
+	if len(exps) == 0 {
 		fi.RETURN(0, 0)
 		fi.recordInsLine(blockLastLine)
 		return
@@ -28,12 +44,16 @@ func cgRetStat(fi *funcInfo, exps []ast.Exp, blockLastLine int) {
 		idx := fi.allocReg()          //预留返回值的位置，模拟但不会真的存值，就是一个占位的，模拟真实的函数调用栈中的寄存器行为
 		if i == retNum-1 && multRet { //最后一个返回值表达式如果存在多个返回的情况要特殊处理
 			cgExp(fi, exp, idx, -1)
+		} else {
+			cgExp(fi, exp, idx, 1)
 		}
-		cgExp(fi, exp, idx, 1)
 	}
 	fi.freeRegs(retNum) //释放占位的寄存器
 	a := fi.usedRegs    //返回值寄存器的起始索引
 	if multRet {        //如果包含vararg或函数调用的返回语句，需要特殊处理
+		if _, ok := exps[0].(*ast.FuncCallExp); ok && retNum == 1 && !fi.insideTBC() {
+			fi.fixTailCall(fi.pc()) //return f(args)为尾调用,之后的RETURN只在被调函数为Go函数时执行
+		}
 		fi.RETURN(a, -1) //-1即返回所有返回值
 		fi.recordInsLine(blockLastLine)
 		return
@@ -49,3 +69,8 @@ func _isVarargOrFuncCall(exp ast.Exp) bool {
 	}
 	return false
 }
+
+func _isLabelStat(stat ast.Stat) bool {
+	_, ok := stat.(*ast.LabelStat)
+	return ok
+}
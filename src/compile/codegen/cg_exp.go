@@ -78,7 +78,7 @@ func cgExp(fi *funcInfo, exp ast.Exp, a, n int) int {
 
 func cgVarargExp(fi *funcInfo, exp *ast.VarargExp, a, n int) {
 	if !fi.isVararg {
-		panic("vararg paramter must be inside of vararg function")
+		fi.syntaxError(exp.Line, "cannot use '...' outside a vararg function near '...'")
 	}
 	fi.Vararg(a, n)
 	fi.recordInsLine(exp.Line)
@@ -94,7 +94,7 @@ func cgFuncDefExp(fi *funcInfo, funcName string, exp *ast.FuncDefExp, a int) {
 
 	//将子函数的参数添加入"它自身"的局部变量中
 	for _, v := range exp.ArgList {
-		subFunc.newLocalVar(v, exp.DefLine, exp.LastLine)
+		subFunc.newLocalVar(v)
 	}
 	//生成子函数的指令
 	subFunc.enterScope(false) //进入函数作用域,即作用域0
@@ -109,28 +109,64 @@ func cgFuncDefExp(fi *funcInfo, funcName string, exp *ast.FuncDefExp, a int) {
 	fi.recordInsLine(exp.DefLine)
 }
 
+// 列表式字段的值依次放在表之后的寄存器中,每满LFIELDS_PER_FLUSH个使用SETLIST写入表的数组部分
+// 最后一个列表式字段为函数调用或vararg时展开其所有返回值,此时SETLIST的B为0
+// 其余字段通过SETTABLE写入
 func cgTableConstructExp(fi *funcInfo, exp *ast.TableConstructExp, a int) {
-	//NEWTABLE,创建表,计算其表创建所需的数组长度和字典长度
-	var nArrs int64 = 0 //记住table的数组部分索引是从1开始的
-	nKeys := len(exp.Keys)
+	if fi.usedRegs != a+1 { //SETLIST要求列表式字段的值紧跟在表之后,先在新的寄存器中构造再移动至a
+		t := fi.allocReg()
+		cgTableConstructExp(fi, exp, t)
+		fi.MOVE(a, t)
+		fi.recordInsLine(exp.Line)
+		fi.freeReg()
+		return
+	}
+
+	nArr := 0
 	for _, key := range exp.Keys {
-		if i, ok := key.(*ast.IntExp); ok && i.Val > nArrs {
-			nArrs = i.Val
+		if key == nil {
+			nArr++
 		}
 	}
-	fi.NEWTABLE(a, int(nArrs), nKeys-int(nArrs)) //由于外部allocReg从而传入的a所以这里无需allocReg
+	nExps := len(exp.Keys)
+	multRet := nExps > 0 && exp.Keys[nExps-1] == nil && _isVarargOrFuncCall(exp.Vals[nExps-1])
+
+	fi.NEWTABLE(a, instruction.Int2fb(nArr), instruction.Int2fb(nExps-nArr)) //由于外部allocReg从而传入的a所以这里无需allocReg
 	fi.recordInsLine(exp.Line)
-	//添加元素,包括数组部分和字典部分,利用SETTABLE指令
-	for i := 0; i < nKeys; i++ {
-		b := fi.allocReg()
-		cgExp(fi, exp.Keys[i], b, 1)
-		c := fi.allocReg()
-		cgExp(fi, exp.Vals[i], c, 1)
-		fi.SETTABLE(a, b, c)
-		fi.recordInsLine(exp.Line)
-		fi.freeRegs(2)
-	}
 
+	arrIdx := 0 //已经处理的列表式字段个数
+	for i, key := range exp.Keys {
+		val := exp.Vals[i]
+		if key != nil { //{[k]=v}
+			b := fi.allocReg()
+			cgExp(fi, key, b, 1)
+			c := fi.allocReg()
+			cgExp(fi, val, c, 1)
+			fi.SETTABLE(a, b, c)
+			fi.recordInsLine(exp.Line)
+			fi.freeRegs(2)
+			continue
+		}
+
+		arrIdx++
+		r := fi.allocReg()
+		if i == nExps-1 && multRet {
+			cgExp(fi, val, r, -1)
+		} else {
+			cgExp(fi, val, r, 1)
+		}
+		if arrIdx%instruction.LFIELDS_PER_FLUSH == 0 || arrIdx == nArr { //写入一批
+			n := fi.usedRegs - (a + 1)
+			c := (arrIdx-1)/instruction.LFIELDS_PER_FLUSH + 1
+			if i == nExps-1 && multRet {
+				fi.SETLIST(a, 0, c)
+			} else {
+				fi.SETLIST(a, n, c)
+			}
+			fi.recordInsLine(exp.Line)
+			fi.freeRegs(n)
+		}
+	}
 }
 
 func cgConcatExp(fi *funcInfo, exp *ast.ConcatExp, a int) {
@@ -170,15 +206,15 @@ func cgNameExp(fi *funcInfo, exp *ast.NameExp, a int) {
 	}, a)
 }
 
+// 表直接生成到目标寄存器R(A)中,避免a.b.c.d这样的长链每一层都占用一个寄存器
 func cgTableAccessExp(fi *funcInfo, exp *ast.TableAccessExp, a int) {
-	b := fi.allocReg() //R(B)
-	cgExp(fi, exp.PrefixExp, b, 1)
+	cgExp(fi, exp.PrefixExp, a, 1)
 	c := fi.allocReg() //RK(C)
 	cgExp(fi, exp.CurrentExp, c, 1)
 	//生成获取table键值对指令
-	fi.GETTABLE(a, b, c)
+	fi.GETTABLE(a, a, c)
 	fi.recordInsLine(exp.LastLine)
-	fi.freeRegs(2)
+	fi.freeReg()
 }
 
 // n>0则返回n个返回值,n==0则不返回,n<=-1则返回所有返回值
@@ -186,6 +222,8 @@ func cgFuncCallExp(fi *funcInfo, exp *ast.FuncCallExp, a, n int) {
 	//1.将函数调用所需的方法及其参数的装载指令生成
 	lastArgIsVarargOrFuncCall := false
 	nArgs := 0 //记录方法一共传递的参数
+	//SELF和参数都需要额外的寄存器空间,所以记录开始usedRegs好方便后续释放
+	oldUsed := fi.usedRegs
 	//1.1生成装载函数指令
 	//OOP类型的函数调用需要特殊处理
 	if ta, ok := exp.Method.(*ast.TableAccessExp); ok {
@@ -206,8 +244,6 @@ func cgFuncCallExp(fi *funcInfo, exp *ast.FuncCallExp, a, n int) {
 		cgExp(fi, exp.Method, a, 1)
 	}
 	//1.2生成装载参数指令,按顺序压入栈
-	//这里可能需要多个寄存器空间,所以记录开始usedRegs好方便后续释放
-	oldUsed := fi.usedRegs
 	for i, arg := range exp.Exps {
 		a := fi.allocReg()
 		if i == len(exp.Exps)-1 && _isVarargOrFuncCall(arg) { //最后参数为vararg或funcCall
@@ -226,48 +262,41 @@ func cgFuncCallExp(fi *funcInfo, exp *ast.FuncCallExp, a, n int) {
 
 	//2.生成函数调用指令
 	fi.CALL(a, nArgs, n)
-	fi.recordInsLine(exp.LastLine)
+	fi.recordInsLine(exp.Line)
 }
 
 func cgUnitaryOpExp(fi *funcInfo, exp *ast.UnitaryOpExp, a int) {
-	b := fi.allocReg()
-	cgExp(fi, exp.A, b, 1)
-	fi.UnitaryOP(exp.Line, exp.Op, a, b)
-	fi.freeReg()
+	cgExp(fi, exp.A, a, 1)
+	fi.UnitaryOP(exp.Line, exp.Op, a, a)
 }
 
 func cgDualOpExp(fi *funcInfo, exp *ast.DualOpExp, a int) {
 	switch exp.Op {
 	case lexer.TOKEN_OP_OR, lexer.TOKEN_OP_AND:
-		b := fi.allocReg()
-		cgExp(fi, exp.A, b, 1)
-		fi.freeReg()
+		//第一个表达式的值直接作为结果保存在R(A)中
+		cgExp(fi, exp.A, a, 1)
 		if exp.Op == lexer.TOKEN_OP_OR {
-			fi.TESTSET(a, b, 1) //or:第一个表达式为真就为真
+			fi.TEST(a, 1) //or:第一个表达式为真就为真
 		} else {
-			fi.TESTSET(a, b, 0) //and:第一个表达式为假就为假
+			fi.TEST(a, 0) //and:第一个表达式为假就为假
 		}
 		fi.recordInsLine(exp.Line)
 
 		//or和and具有短路效应
 		jmpToEnd := fi.JMP(0, 0)
 		fi.recordInsLine(exp.Line)
-		//经过第一个表达式的TESTSET判断到这里
+		//经过第一个表达式的TEST判断到这里
 		//则说明影响最后真假的只需看第二个表达式的值
 		//即 false or exp2 & true and exp2
-		c := fi.allocReg()
-		cgExp(fi, exp.B, c, 1)
-		fi.freeReg()
-		fi.MOVE(a, c) //直接将表达式2的真假传给R(A)
-		fi.recordInsLine(exp.Line)
+		cgExp(fi, exp.B, a, 1)
 
 		fi.fixSbx(jmpToEnd, fi.pc()-jmpToEnd)
 	default:
-		b := fi.allocReg()
-		cgExp(fi, exp.A, b, 1)
+		//左操作数直接生成到R(A)中,使得a+b+c+...这样左结合的长表达式只占用固定数量的寄存器
+		cgExp(fi, exp.A, a, 1)
 		c := fi.allocReg()
 		cgExp(fi, exp.B, c, 1)
-		fi.DualOP(exp.Line, exp.Op, a, b, c)
-		fi.freeRegs(2)
+		fi.DualOP(exp.Line, exp.Op, a, a, c)
+		fi.freeReg()
 	}
 }
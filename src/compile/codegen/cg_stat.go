@@ -1,22 +1,17 @@
 package codegen
 
 import (
-	"fmt"
-	"strings"
-
 	"nskbz.cn/lua/compile/ast"
-	"nskbz.cn/lua/instruction"
 )
 
-// blockLastLine用于debug生成变量作用域信息
-func cgStat(fi *funcInfo, stat ast.Stat, blockLastLine int) {
+func cgStat(fi *funcInfo, stat ast.Stat) {
 	switch stat.(type) {
 	case *ast.BreakStat:
 		cgBreakStat(fi, stat)
 	case *ast.GotoStat:
 		cgGotoStat(fi, stat)
 	case *ast.LabelStat:
-		cgLabelStat(fi, stat)
+		cgLabelStat(fi, stat, false)
 	case *ast.FuncCallStat:
 		cgFuncCallStat(fi, stat)
 	case *ast.DoStat:
@@ -32,7 +27,7 @@ func cgStat(fi *funcInfo, stat ast.Stat, blockLastLine int) {
 	case *ast.ForNumStat:
 		cgForNumStat(fi, stat)
 	case *ast.LocalVarStat:
-		cgLocalVarStat(fi, stat, blockLastLine)
+		cgLocalVarStat(fi, stat)
 	case *ast.AssignStat:
 		cgAssignStat(fi, stat)
 	case *ast.LocalFuncDefStat:
@@ -50,21 +45,42 @@ func cgBreakStat(fi *funcInfo, stat ast.Stat) {
 	pc := fi.JMP(0, 0)
 	fi.recordInsLine(breakStat.Line) //debug
 	//记录对应break语句的pc信息
-	fi.breakMap.add(pc)
+	if !fi.breakMap.add(pc) {
+		fi.syntaxError(breakStat.Line, "<break> at line %d not inside a loop", breakStat.Line)
+	}
 }
 
 func cgGotoStat(fi *funcInfo, stat ast.Stat) {
 	gotoStat := stat.(*ast.GotoStat)
-	//先用JMP占位，后续填充
+	//先用JMP占位，label已经出现时直接填充,否则等待后续的label或退出作用域时填充
 	pc := fi.JMP(0, 0)
 	fi.recordInsLine(gotoStat.Line)
-	fi.gotoMap.add(gotoStat.Name, pc)
+	g := gotoInfo{name: gotoStat.Name, pc: pc, line: gotoStat.Line, level: fi.usedRegs, scope: fi.scope}
+	if !fi.resolveGoto(g) {
+		fi.gotoMap.add(g)
+	}
 }
 
-func cgLabelStat(fi *funcInfo, stat ast.Stat) {
+// atEnd表示label位于块的末尾,此时块中的局部变量视为已离开作用域
+func cgLabelStat(fi *funcInfo, stat ast.Stat, atEnd bool) {
 	labelStat := stat.(*ast.LabelStat)
-	pc := fi.pc() + 1 //记录label指向的PC，即当前指令的下一位置
-	fi.labelMap.add(labelStat.Name, pc)
+	label := labelInfo{
+		name:  labelStat.Name,
+		pc:    fi.pc() + 1, //记录label指向的PC，即当前指令的下一位置
+		line:  labelStat.Line,
+		level: fi.usedRegs,
+		scope: fi.scope,
+	}
+	if atEnd {
+		label.level = fi.labelMap.base()
+	}
+	if old, ok := fi.labelMap.add(label); !ok {
+		fi.syntaxError(labelStat.Line, "label '%s' already defined on line %d", labelStat.Name, old.line)
+	}
+	//填充当前作用域中先于label出现的goto
+	for _, g := range fi.gotoMap.pop(label.name) {
+		fi.patchGoto(g, label)
+	}
 }
 
 // 语句只会以调用形式单独出现,不需要返回值
@@ -132,7 +148,7 @@ func cgRepeatStat(fi *funcInfo, stat ast.Stat) {
 	repeatStat := stat.(*ast.RepeatStat)
 	//repeat先执行循环体，所以先填充循环体
 	fi.enterScope(true)
-	cgBlock(fi, repeatStat.Block)
+	_cgBlock(fi, repeatStat.Block, false) //until表达式仍可以访问循环体中的局部变量
 	//解析条件表达式
 	idx := fi.allocReg()
 	cgExp(fi, repeatStat.Exp, idx, 1)
@@ -178,7 +194,8 @@ func cgIfStat(fi *funcInfo, stat ast.Stat) {
 		//R(A)==false
 		//else块没有下一个跳转点，按理最后一个条件表达式不需要填充JMP，但是为了配合TEST指令必须要有一个占位不然PC执行顺序就乱了
 		//特殊考虑只有if块的语句,需要跳转至结束
-		jmpToNextExp[i] = fi.JMP(fi.getJmpArgA(), 0)
+		//条件为假时跳过整个块,没有离开任何作用域,所以不需要关闭upvalue
+		jmpToNextExp[i] = fi.JMP(0, 0)
 		fi.recordInsLine(insLine)
 		//R(A)==true  ,else块结束后也就到达IF STAT结尾,也不需要填充JMP跳转结尾
 		fi.enterScope(false)
@@ -216,8 +233,8 @@ func cgForNumStat(fi *funcInfo, stat ast.Stat) {
 		LastLine:     forNumStat.LineOfDo,
 		LocalVarList: []string{"(for init)", "(for limit)", "(for step)"}, //这里就对应前面funcInfo.getJmpArgA()方法
 		ExpList:      []ast.Exp{forNumStat.Init, forNumStat.Limit, forNumStat.Step},
-	}, forNumStat.LineOfFor)
-	fi.newLocalVar(forNumStat.Name, forNumStat.LineOfDo, forNumStat.LineOfEnd) //将循环变量添加为局部变量，其使用的是R(A+3)而非R(A)
+	})
+	fi.newLocalVar(forNumStat.Name) //将循环变量添加为局部变量，其使用的是R(A+3)而非R(A)
 	//获取R(A)的索引
 	idx := fi.usedRegs - 4
 	//设置循环初始化指令
@@ -278,11 +295,13 @@ func cgForInStat(fi *funcInfo, stat ast.Stat) {
 		LastLine:     forInStat.LineOfFor,
 		LocalVarList: []string{"(for_iterator)", "(for_state)", "(for_controlVar)"},
 		ExpList:      forInStat.ExpList,
-	}, forInStat.LineOfDo)
+	})
 	//添加用户局部变量
 	for _, v := range forInStat.NameList {
-		fi.newLocalVar(v, forInStat.LineOfDo, forInStat.LineOfEnd)
+		fi.newLocalVar(v)
 	}
+	//循环体中的局部变量与循环变量位于同一作用域,所以需要在生成循环体之前获取R(A)的索引
+	idx := fi.usedRegs - len(forInStat.NameList) - 3
 
 	jmpToTFORCALL := fi.JMP(0, 0)
 	fi.recordInsLine(forInStat.LineOfFor)
@@ -294,41 +313,33 @@ func cgForInStat(fi *funcInfo, stat ast.Stat) {
 	fi.fixSbx(jmpToTFORCALL, fi.pc()-jmpToTFORCALL)
 
 	//设置TFORCALL: R(A+3), ... ,R(A+2+C) := R(A)(R(A+1), R(A+2))
-	//作者写法：fi.indexOfLocalVar("(for_iterator)")
-	idx := fi.usedRegs - len(forInStat.NameList) - 3 //todo 待验证
-	if fi.indexOfLocalVar("(for_iterator)") != idx {
-		panic("error idx")
-	}
 	//len(forInStat.NameList)=((A+2+C)-(A+3))+1 , 即变量个数=R(A+3)到R(A+2+C)的个数，注意计算个数要加1
 	c := len(forInStat.NameList)
 	fi.TFORCALL(idx, c)
-	fi.recordInsLine(forInStat.LineOfFor)
+	fi.recordInsLine(forInStat.LineOfIn)
 	//设置TFORLOOP: if R(A+1) ~= nil then { R(A)=R(A+1); pc += sBx } , 这里的R(A)是controlVar
 	fi.TFORLOOP(idx+2, loopBegin-fi.pc()-1)
-	fi.recordInsLine(forInStat.LineOfFor)
+	fi.recordInsLine(forInStat.LineOfIn)
 
 	fi.exitScope()
 }
 
 // 这里对于局部变量需要申请寄存器allocReg()进行存储，当退出作用域的时候exitScope()时会自动释放其寄存器空间
 // 局部变量用于方法的执行所以只能在离开作用域的时候freeReg()
-func cgLocalVarStat(fi *funcInfo, stat ast.Stat, scopeLastLine int) {
+func cgLocalVarStat(fi *funcInfo, stat ast.Stat) {
 	localValStat := stat.(*ast.LocalVarStat)
 	nVars := len(localValStat.LocalVarList)
 	nExps := len(localValStat.ExpList)
 
 	oldUsed := fi.usedRegs //记录第一个变量的位置
 
-	//依次绑定变量，使得一个Var对应一个Exp，上面只是开辟了寄存器空间并没有绑定
-	//如果最后申请的寄存器空间个数小于变量数，后续多出来的变量则赋值为nil
-	for _, v := range localValStat.LocalVarList {
-		fi.newLocalVar(v, localValStat.LastLine, scopeLastLine)
+	//与官方一致,在生成表达式之前检查局部变量的数量
+	if oldUsed+nVars > MAXVARS {
+		fi.errorLimit(localValStat.LastLine, MAXVARS, "local variables")
 	}
-	varUsed := fi.usedRegs //记录所有变量分配完后的位置
 
-	//上面只是依次预先分配了变量的位置,后续需要表达式的生成才能赋值于变量;
-	//而表达式生成需要知道对应变量的idx,所以将usedRegs还原至分配给第一个变量的位置,后续的表达式依次生成
-	fi.usedRegs = oldUsed
+	//表达式依次生成到从oldUsed开始的寄存器中,即各个变量将要占用的位置
+	//变量在表达式之后才声明,使得 local x = x 中右侧的x仍引用外层的同名变量
 
 	//当nVars==nExps时，一个Var对应一个Exp
 	if nVars == nExps {
@@ -369,10 +380,22 @@ func cgLocalVarStat(fi *funcInfo, stat ast.Stat, scopeLastLine int) {
 
 	//cgExp生成对应idx变量的表达式,一般情况cgExp只需要使用一个寄存器位置
 	//但如果是 funcCall | vararg 则可能使用多个寄存器位置
-	//cgExp并不会去维护usedRegs,只关心起始的idx,所以我们cgExp前使usedRegs=oldUsed
-	//但最后需要还原成varUsed,即分配完所有的变量
-	fi.usedRegs = varUsed
+	//cgExp并不会去维护usedRegs,只关心起始的idx,所以还原usedRegs=oldUsed后再依次为变量分配寄存器
+	fi.usedRegs = oldUsed
 
+	//局部变量在表达式赋值之后才生效
+	newVars := make([]*localVarInfo, nVars)
+	for i, v := range localValStat.LocalVarList {
+		fi.newLocalVar(v)
+		newVars[i] = fi.localVars[len(fi.localVars)-1]
+	}
+	for i, attrib := range localValStat.AttribList {
+		newVars[i].attrib = attrib
+		if attrib == "close" {
+			fi.TBC(newVars[i].slot)
+			fi.recordInsLine(localValStat.LastLine)
+		}
+	}
 }
 
 // explist ::=exp { ',' exp}
@@ -388,6 +411,12 @@ func cgAssignStat(fi *funcInfo, stat ast.Stat) {
 	kRegs := make([]int, nVars)
 	//为处理VarList中可能存在的表访问,先将操作表所需的寄存器索引生成
 	for i, v := range assignStat.VarList {
+		if nameExp, ok := v.(*ast.NameExp); ok && fi.indexOfLocalVar(nameExp.Name) < 0 {
+			//与官方实现一致,按照源码中出现的顺序捕获upvalue(被赋值的变量先于表达式中的变量)
+			if fi.indexOfUpvalue(nameExp.Name) < 0 && fi.indexOfLocalVar("_ENV") < 0 {
+				fi.indexOfUpvalue("_ENV")
+			}
+		}
 		if ta, ok := v.(*ast.TableAccessExp); ok {
 			tRegs[i] = fi.allocReg()
 			cgExp(fi, ta.PrefixExp, tRegs[i], 1)
@@ -417,6 +446,7 @@ func cgAssignStat(fi *funcInfo, stat ast.Stat) {
 				multiRet = true
 				n := nVars - nExps + 1
 				cgExp(fi, exp, a, n)
+				fi.allocRegs(n - 1) //多出的返回值存放在a之后的寄存器中
 			} else {
 				cgExp(fi, exp, a, 1)
 			}
@@ -430,6 +460,10 @@ func cgAssignStat(fi *funcInfo, stat ast.Stat) {
 			fi.LOADNIL(a, n)
 			fi.recordInsLine(assignStat.LastLine)
 		}
+		//多出的变量依次对应最后一个表达式之后的寄存器(funcCall | vararg 的返回值或nil)
+		for i := nExps; i < nVars; i++ {
+			vRegs[i] = vRegs[i-1] + 1
+		}
 	}
 
 	//将临时存储的变量装载到真正的变量中
@@ -439,12 +473,7 @@ func cgAssignStat(fi *funcInfo, stat ast.Stat) {
 			fi.SETTABLE(tRegs[i], kRegs[i], vRegs[i])
 			fi.recordInsLine(assignStat.LastLine)
 		} else if nameExp, ok := v.(*ast.NameExp); ok {
-			//如果vRegs[i]==0则说明该var属于 funcCall | vararg 的返回值
-			//所以分配的寄存器索引应当基于多返回值变量集合的第一个变量的idx依次往后
-			if vRegs[i] == 0 && i-1 > 0 {
-				vRegs[i] = vRegs[i-1] + 1
-			}
-
+			fi.checkAssign(nameExp.Name, assignStat.LastLine)
 			//局部变量
 			if idx := fi.indexOfLocalVar(nameExp.Name); idx >= 0 {
 				fi.MOVE(idx, vRegs[i])
@@ -457,10 +486,17 @@ func cgAssignStat(fi *funcInfo, stat ast.Stat) {
 				fi.recordInsLine(assignStat.LastLine)
 				continue
 			}
-			//global var:全局变量,是指无需声明即可直接使用、且在任何作用域中均可访问的变量
-			a := fi.indexOfUpvalue("_ENV")
+			//global var:全局变量,是指无需声明即可直接使用、且在任何作用域中均可访问的变量 => _ENV['x']=v
 			b := 0x100 + fi.indexOfConstant(nameExp.Name)
-			fi.SETTABLE(a, b, vRegs[i])
+			if b > 0x1FF { //常量索引超出RK范围则先装载到寄存器
+				b = fi.allocReg()
+				fi.LOADK(b, nameExp.Name)
+			}
+			if idx := fi.indexOfLocalVar("_ENV"); idx >= 0 {
+				fi.SETTABLE(idx, b, vRegs[i])
+			} else {
+				fi.SETTABUP(fi.indexOfUpvalue("_ENV"), b, vRegs[i])
+			}
 			fi.recordInsLine(assignStat.LastLine)
 		}
 	}
@@ -477,40 +513,29 @@ func cgAssignStat(fi *funcInfo, stat ast.Stat) {
 // end
 func cgLocalFuncDefStat(fi *funcInfo, stat ast.Stat) {
 	localFuncDefStat := stat.(*ast.LocalFuncDefStat)
-	a := fi.newLocalVar(localFuncDefStat.Name, localFuncDefStat.DefLine, localFuncDefStat.Body.LastLine)
+	a := fi.newLocalVar(localFuncDefStat.Name)
 	cgFuncDefExp(fi, localFuncDefStat.Name, localFuncDefStat.Body, a)
 }
 
+// function a.b.c:f() end => a.b.c.f = function(self) end
+// 与表元素的赋值相同:先求出表与键,再将闭包写入表中
 func cgOopFuncDefStat(fi *funcInfo, stat ast.Stat) {
 	oopFuncDefStat := stat.(*ast.OopFuncDefStat)
-	funcPath := _cgOopFuncName(oopFuncDefStat.Name)
-	funcIdx := fi.newLocalVar(funcPath, oopFuncDefStat.DefLine, oopFuncDefStat.Body.Block.LastLine) //获取存储方法的索引
-	cgFuncDefExp(fi, funcPath, oopFuncDefStat.Body, funcIdx)                                        //生成clousure
-
-	//将对象方法与对象绑定
-	dotIdx := strings.LastIndex(funcPath, ".")
-	varName := funcPath[:dotIdx]    //获取对象名,本质是table
-	funcName := funcPath[dotIdx+1:] //获取方法名,即表中元素的key
-	varIdx := -1
-	if idx := fi.indexOfLocalVar(varName); idx > -1 {
-		varIdx = idx
-	} else if idx := fi.indexOfUpvalue(varName); idx > -1 {
-		//todo 这里应当用SETTABUP
-		varIdx = idx
-	}
-	if varIdx == -1 {
-		panic(fmt.Sprintf("bound OopFunc error,there is no var called %s", varName))
-	}
-	funcNameIdx := -1
-	if idx := fi.indexOfConstant(funcName); idx > -1 {
-		funcNameIdx = instruction.ConstantBase + idx
-	}
-	if funcNameIdx == -1 {
-		panic(fmt.Sprintf("bound OopFunc error,there is no constant called %s", funcName))
-	}
+	name := oopFuncDefStat.Name.(*ast.TableAccessExp)
+	oldUsed := fi.usedRegs
 
-	fi.SETTABLE(varIdx, funcNameIdx, funcIdx) //绑定指令
+	t := fi.allocReg()
+	cgExp(fi, name.PrefixExp, t, 1)
+	k := fi.allocReg()
+	cgExp(fi, name.CurrentExp, k, 1)
+	f := fi.allocReg()
+	cgFuncDefExp(fi, _cgOopFuncName(name), oopFuncDefStat.Body, f) //生成clousure
+
+	fi.SETTABLE(t, k, f) //绑定指令
 	fi.recordInsLine(oopFuncDefStat.DefLine)
+
+	//释放寄存器空间
+	fi.usedRegs = oldUsed
 }
 
 func _cgOopFuncName(name ast.Exp) string {
@@ -15,7 +15,8 @@ func GenProto(chunck *ast.Block, chunckName string) *binchunk.Prototype {
 		Block:    chunck,
 	} //主函数
 	fi := newFuncInfo(nil, "", fd)
-	fi.newLocalVar("_ENV", 0, chunck.LastLine) //全局变量实质为最上层的局部变量
+	fi.source = chunckName
+	fi.newLocalVar("_ENV") //全局变量实质为最上层的局部变量
 	idx := fi.allocReg()
 	cgFuncDefExp(fi, chunckName, fd, idx) //指令生成过程中已经记录了Regs的最大使用数量
 	fi.freeReg()
@@ -17,7 +17,7 @@ func toProto(fi *funcInfo) *binchunk.Prototype {
 		Constants:       _getConstants(fi),
 		Upvalues:        nil,
 		Protos:          _getProtos(fi),
-		Source:          fi.funcName,       //debug
+		Source:          fi.source,         //debug
 		LineInfo:        fi.lineOfIns,      //debug
 		LocVars:         _getLocalVars(fi), //debug
 		UpvalueNames:    nil,               //debug
@@ -35,12 +35,12 @@ func _getConstants(fi *funcInfo) []interface{} {
 	return constants
 }
 
+// upvalue需按照其在函数中的索引排列,指令中通过该索引访问upvalue
 func _getUpvalues(fi *funcInfo) ([]binchunk.Upvalue, []string) {
-	upvalues := []binchunk.Upvalue{}
-	upvalueNames := []string{}
-	var inStack byte
-	var idx byte
+	upvalues := make([]binchunk.Upvalue, len(fi.upvalVars))
+	upvalueNames := make([]string, len(fi.upvalVars))
 	for k, v := range fi.upvalVars {
+		var inStack, idx byte
 		if v.localVarSlot >= 0 {
 			inStack = 1
 			idx = byte(v.localVarSlot)
@@ -49,11 +49,11 @@ func _getUpvalues(fi *funcInfo) ([]binchunk.Upvalue, []string) {
 			inStack = 0
 			idx = byte(v.upvalIndex)
 		}
-		upvalues = append(upvalues, binchunk.Upvalue{
+		upvalues[v.index] = binchunk.Upvalue{
 			Instack: inStack,
 			Idx:     idx,
-		})
-		upvalueNames = append(upvalueNames, k)
+		}
+		upvalueNames[v.index] = k
 	}
 	return upvalues, upvalueNames
 }
@@ -71,10 +71,14 @@ func _getProtos(fi *funcInfo) []*binchunk.Prototype {
 func _getLocalVars(fi *funcInfo) []binchunk.LocVar {
 	locVars := []binchunk.LocVar{}
 	for _, v := range fi.localVars {
+		endPC := v.endPC
+		if endPC < 0 { //直到函数结束都没有释放的局部变量
+			endPC = len(fi.instructions)
+		}
 		locVars = append(locVars, binchunk.LocVar{
-			VarName:   v.name,
-			StartLine: uint32(v.startLine),
-			EndLine:   uint32(v.endLine),
+			VarName: v.name,
+			StartPC: uint32(v.startPC),
+			EndPC:   uint32(endPC),
 		})
 	}
 	return locVars
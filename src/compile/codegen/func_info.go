@@ -23,9 +23,16 @@ var arithAndBitwiseMapping = map[int]int{
 	TOKEN_OP_SHR:  OP_SHR,
 }
 
+// 与官方一致的编译限制
+const (
+	MAXVARS  = 200 //一个函数中同时有效的局部变量的最大数量
+	MAXUPVAL = 255 //一个函数的upvalue的最大数量
+)
+
 type funcInfo struct {
 	parent *funcInfo //上层函数的指针,用于捕获upvalue
 
+	source   string          //所属chunk的名称,用于错误信息和debug
 	funcName string          //用于debug
 	exp      *ast.FuncDefExp //用于debug
 
@@ -71,11 +78,12 @@ func newFuncInfo(parent *funcInfo, funcName string, funcDef *ast.FuncDefExp) *fu
 		numParams:    len(funcDef.ArgList),
 		isVararg:     funcDef.IsVararg,
 	}
+	if parent != nil {
+		fi.source = parent.source //同一chunk中的所有函数共享chunkname
+	}
 	fi.breakMap.breaks = [][]int{}
 	fi.breakMap.scope = &fi.scope
-	fi.labelMap.labels = []map[string]int{}
 	fi.labelMap.scope = &fi.scope
-	fi.gotoMap.gotos = []map[string][]int{}
 	fi.gotoMap.scope = &fi.scope
 	return fi
 }
@@ -96,7 +104,7 @@ func (fi *funcInfo) indexOfConstant(constant interface{}) int {
 func (fi *funcInfo) allocReg() int {
 	fi.usedRegs++
 	if fi.usedRegs >= 255 {
-		panic("regs out of number")
+		fi.syntaxError(fi.currentLine(), "function or expression needs too many registers")
 	}
 	if fi.maxRegs < fi.usedRegs {
 		fi.maxRegs = fi.usedRegs
@@ -127,17 +135,20 @@ func (fi *funcInfo) freeRegs(n int) {
 // 进入新的作用域
 func (fi *funcInfo) enterScope(isLoopScope bool) {
 	fi.scope++
-	fi.breakMap.create(isLoopScope)
-	fi.labelMap.create()
-	fi.gotoMap.create()
+	fi.breakMap.create(isLoopScope, len(fi.localVars))
+	fi.labelMap.create(fi.usedRegs)
 }
 
 // 退出当前作用域，需要释放不用的信息
 func (fi *funcInfo) exitScope() {
 	// 退出作用域就意味着Break语句的跳转位置知道了，就需要填充Break语句的JMP占位指令的参数
 	// 然后释放掉使用过的break信息
-	pendingBreaks := fi.breakMap.pop()
+	pendingBreaks, firstVar := fi.breakMap.pop()
 	a := fi.getJmpArgA() //获取JMP指令需要释放的起始寄存器空间
+	if a == 0 && len(pendingBreaks) > 0 && fi.needClose(firstVar) {
+		//break可能位于内层作用域中,内层作用域被捕获或待关闭的变量也需要关闭
+		a = fi.labelMap.base() + 1
+	}
 	for _, breakPc := range pendingBreaks {
 		sBx := fi.pc() - breakPc
 		sBx += MAX_SBX //????why????
@@ -145,15 +156,9 @@ func (fi *funcInfo) exitScope() {
 		fi.instructions[breakPc] = Instruction(i) //填充break的跳转位置
 	}
 
-	//处理goto
-	labels := fi.labelMap.pop()
-	for label, labelPc := range labels {
-		gotoPCs := fi.gotoMap.pop(label)
-		for _, v := range gotoPCs {
-			fi.fixSbx(v, labelPc-v-1) //填充goto语句的JMP跳转位置
-		}
-
-	}
+	//当前作用域的label不再可见
+	fi.labelMap.pop()
+	base := fi.labelMap.base()
 
 	//释放局部变量，即释放其分配的寄存器
 	fi.scope--
@@ -162,22 +167,73 @@ func (fi *funcInfo) exitScope() {
 			fi.freeLocalVar(v)
 		}
 	}
+
+	//未匹配的goto移动到上层作用域,并尝试匹配上层作用域中已经出现的label
+	//与官方movegotosout一致,离开的作用域中有被捕获或待关闭的变量时,goto需要关闭它们
+	gotos := fi.gotoMap.gotos
+	fi.gotoMap.gotos = nil
+	for _, g := range gotos {
+		if g.scope > fi.scope {
+			g.scope = fi.scope
+			if g.level > base {
+				g.close = g.close || fi.needClose(firstVar)
+				g.level = base
+			}
+		}
+		if fi.scope < 0 { //已经退出函数作用域
+			fi.syntaxError(int(fi.exp.LastLine), "no visible label '%s' for <goto> at line %d", g.name, g.line)
+		}
+		if !fi.resolveGoto(g) {
+			fi.gotoMap.add(g)
+		}
+	}
+}
+
+// 在当前作用域中查找goto的label并填充JMP指令,label还未出现时返回false
+func (fi *funcInfo) resolveGoto(g gotoInfo) bool {
+	label, ok := fi.labelMap.find(g.name)
+	if !ok {
+		return false
+	}
+	fi.patchGoto(g, label)
+	return true
+}
+
+// 将goto的JMP指令指向label
+func (fi *funcInfo) patchGoto(g gotoInfo, label labelInfo) {
+	if g.level < label.level {
+		name := "?"
+		for i := len(fi.localVars) - 1; i >= 0; i-- { //goto之后声明的第一个局部变量
+			if v := fi.localVars[i]; v.endPC < 0 && v.slot == g.level {
+				name = v.name
+				break
+			}
+		}
+		fi.syntaxError(label.line, "<goto %s> at line %d jumps into the scope of local '%s'", g.name, g.line, name)
+	}
+	a := 0
+	if g.level > label.level || g.close {
+		a = label.level + 1 //关闭离开作用域的局部变量的upvalue,即R(label.level)及之后的寄存器
+	}
+	sBx := label.pc - g.pc - 1
+	fi.instructions[g.pc] = Instruction((sBx+MAX_SBX)<<14 | a<<6 | OP_JMP)
 }
 
 // 获取需要释放的寄存器空间的起始索引
+// 待关闭变量与被捕获的变量一样需要在离开作用域时通过JMP指令的A参数关闭
 func (fi *funcInfo) getJmpArgA() int {
 	hasCapturedVar := false
 	regIndex := fi.maxRegs
 	//从当前作用域中
 	for _, localVar := range fi.scopeVars {
 		for v := localVar; v != nil && v.scope == fi.scope; v = v.prev { //作用域下的所有同名局部变量都需要释放寄存器空间
-			if localVar.captured {
+			if v.captured || v.attrib == "close" {
 				hasCapturedVar = true
 			}
 			// v.name[0] != '(' why???? ===> 对于一些语言层面的变量我们约定用"(...)"为名字，区别用户变量名
 			// for example：对于生成forNum语句，我们需要R(A)~R(A+2)的变量来辅助语言的实现，而这三个局部变量名分别为{"(for init)","(for limit)","(for step)"}
-			if localVar.slot < regIndex && v.name[0] != '(' {
-				regIndex = localVar.slot
+			if v.slot < regIndex && v.name[0] != '(' {
+				regIndex = v.slot
 			}
 		}
 	}
@@ -188,9 +244,30 @@ func (fi *funcInfo) getJmpArgA() int {
 	return 0
 }
 
+// localVars[first:]中是否有被捕获或待关闭的变量
+func (fi *funcInfo) needClose(first int) bool {
+	for _, v := range fi.localVars[first:] {
+		if v.captured || v.attrib == "close" {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否处于待关闭变量的作用域中,此时return f()不能作为尾调用,函数返回时还需要关闭该变量
+func (fi *funcInfo) insideTBC() bool {
+	for _, v := range fi.localVars {
+		if v.attrib == "close" && v.endPC < 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // 释放当前作用域下的局部变量
 func (fi *funcInfo) freeLocalVar(v *localVarInfo) {
-	fi.freeReg()       //释放一个寄存器位置,,,,这里释放最上面的寄存器是否存在问题？
+	fi.freeReg() //释放一个寄存器位置,,,,这里释放最上面的寄存器是否存在问题？
+	v.endPC = len(fi.instructions)
 	if v.prev == nil { //该局部变量上层没有同名的则删除该变量名
 		delete(fi.scopeVars, v.name)
 	} else if v.prev.scope == v.scope { //同一作用域下同名的局部变量都要删除
@@ -201,15 +278,18 @@ func (fi *funcInfo) freeLocalVar(v *localVarInfo) {
 }
 
 // 添加局部变量到当前作用域并返回其对应的寄存器索引
-func (fi *funcInfo) newLocalVar(name string, startLine, endLine int) int {
+func (fi *funcInfo) newLocalVar(name string) int {
+	if fi.usedRegs >= MAXVARS { //局部变量依次占用从0开始的寄存器
+		fi.errorLimit(fi.currentLine(), MAXVARS, "local variables")
+	}
 	lv := &localVarInfo{
-		prev:      nil,
-		name:      name,
-		scope:     fi.scope,
-		slot:      fi.allocReg(),
-		captured:  false,
-		startLine: startLine,
-		endLine:   endLine,
+		prev:     nil,
+		name:     name,
+		scope:    fi.scope,
+		slot:     fi.allocReg(),
+		captured: false,
+		startPC:  len(fi.instructions),
+		endPC:    -1,
 	}
 	//当前作用域下有同名的变量
 	if v, ok := fi.scopeVars[name]; ok {
@@ -220,6 +300,19 @@ func (fi *funcInfo) newLocalVar(name string, startLine, endLine int) int {
 	return lv.slot
 }
 
+// 对name赋值前检查其是否为<const>或<close>变量
+// name可能是当前函数的局部变量,也可能是外层函数的局部变量(upvalue)
+func (fi *funcInfo) checkAssign(name string, line int) {
+	for f := fi; f != nil; f = f.parent {
+		if v, ok := f.scopeVars[name]; ok {
+			if v.attrib != "" {
+				fi.syntaxError(line, "attempt to assign to const variable '%s'", name)
+			}
+			return
+		}
+	}
+}
+
 // 获取当前作用域下局部变量名为name的寄存器索引
 func (fi *funcInfo) indexOfLocalVar(name string) int {
 	if v, ok := fi.scopeVars[name]; ok {
@@ -228,6 +321,15 @@ func (fi *funcInfo) indexOfLocalVar(name string) int {
 	return -1
 }
 
+// 为新捕获的upvalue分配索引
+func (fi *funcInfo) newUpvalIndex() int {
+	idx := len(fi.upvalVars)
+	if idx >= MAXUPVAL {
+		fi.errorLimit(fi.currentLine(), MAXUPVAL, "upvalues")
+	}
+	return idx
+}
+
 // 获取name的Upvalue索引，如果是才遇见的Upvalue则尝试进行捕获，捕获失败返回-1
 func (fi *funcInfo) indexOfUpvalue(name string) int {
 	//如果是已经绑定了的upvalue直接返回其索引
@@ -238,7 +340,7 @@ func (fi *funcInfo) indexOfUpvalue(name string) int {
 	if fi.parent != nil {
 		//在上层函数中找到了对应的需要捕获的局部变量，则进行绑定
 		if v, ok := fi.parent.scopeVars[name]; ok {
-			idx := len(fi.upvalVars)
+			idx := fi.newUpvalIndex()
 			fi.upvalVars[name] = &upvalInfo{
 				localVarSlot: v.slot,
 				upvalIndex:   -1,
@@ -250,7 +352,7 @@ func (fi *funcInfo) indexOfUpvalue(name string) int {
 		//上层函数中没有捕获到变量，则说明需捕获的变量在更上层
 		//这里的向上递归有点层层捕获的意思，即最下层如果要捕获第一层的变量x，则必须先由第二层捕获x，再由第三层捕获x，依次到最下层
 		if uvIdx := fi.parent.indexOfUpvalue(name); uvIdx > -1 {
-			idx := len(fi.upvalVars)
+			idx := fi.newUpvalIndex()
 			fi.upvalVars[name] = &upvalInfo{
 				localVarSlot: -1,
 				upvalIndex:   uvIdx,
@@ -274,6 +376,28 @@ func (fi *funcInfo) recordInsLine(line int) {
 	fi.lineOfIns = append(fi.lineOfIns, uint32(line))
 }
 
+// 最近生成的指令对应的行号,还没有指令时为函数定义的行号
+func (fi *funcInfo) currentLine() int {
+	if n := len(fi.lineOfIns); n > 0 {
+		return int(fi.lineOfIns[n-1])
+	}
+	return fi.exp.DefLine
+}
+
+// 抛出代码生成阶段发现的语法错误
+func (fi *funcInfo) syntaxError(line int, format string, a ...interface{}) {
+	ThrowSyntaxError(fi.source, line, format, a...)
+}
+
+// 超出编译限制时抛出错误,移植自官方errorlimit
+func (fi *funcInfo) errorLimit(line, limit int, what string) {
+	where := "main function"
+	if fi.exp.DefLine != 0 {
+		where = fmt.Sprintf("function at line %d", fi.exp.DefLine)
+	}
+	fi.syntaxError(line, "too many %s (limit is %d) in %s", what, limit, where)
+}
+
 /*
 	装载各种虚拟机指令的方法,只有这些方法才会影响PC
 */
@@ -294,7 +418,7 @@ func (fi *funcInfo) addInstructionOfBx(opcode, a, bx int) {
 // iAsBx:|sBx     				   18bit|A 		8bit|Opcode 	  6bit|
 func (fi *funcInfo) addInstructionOfsBx(opcode, a, sbx int) {
 	if sbx > MAX_SBX || sbx < -int(MAX_SBX) {
-		panic(fmt.Sprintf("sbx %d out of [-%d,%d)", sbx, MAX_SBX, MAX_SBX))
+		fi.syntaxError(fi.currentLine(), "control structure too long")
 	}
 	i := (sbx+MAX_SBX)<<14 | a<<6 | opcode //todo=>为什么加MAX_SBX???
 	// fmt.Printf("i+\t\t = %032b\n", uint32(i))
@@ -318,6 +442,12 @@ func (fi *funcInfo) fixSbx(pc, sbx int) {
 	fi.instructions[pc] = Instruction(i)
 }
 
+// 将pc处的CALL指令改为TAILCALL,用于return f(args)
+func (fi *funcInfo) fixTailCall(pc int) {
+	a, b, _ := fi.instructions[pc].ABC()
+	fi.instructions[pc] = Instruction(b<<23 | a<<6 | OP_TAILCALL)
+}
+
 // R(A) := R(B)
 func (fi *funcInfo) MOVE(a, b int) {
 	fi.addInstructionOfABC(OP_MOVE, a, b, 0)
@@ -394,8 +524,14 @@ func (fi *funcInfo) Vararg(a, nReturn int) {
 }
 
 // R(A)[(C-1)*FPF+i] := R(A+i), 1 <= i <= B
+// C超出范围时置为0,由紧随其后的EXTRAARG指令给出
 func (fi *funcInfo) SETLIST(a, b, c int) {
-	fi.addInstructionOfABC(OP_SETLIST, a, b, c)
+	if c <= MAX_C {
+		fi.addInstructionOfABC(OP_SETLIST, a, b, c)
+		return
+	}
+	fi.addInstructionOfABC(OP_SETLIST, a, b, 0)
+	fi.addInstructionOfAx(OP_EXTRAARG, c)
 }
 
 // R(A) := closure(KPROTO[Bx])
@@ -466,6 +602,11 @@ func (fi *funcInfo) TFORLOOP(a, sbx int) {
 	fi.addInstructionOfsBx(OP_TFORLOOP, a, sbx)
 }
 
+// mark R(A) as to-be-closed
+func (fi *funcInfo) TBC(a int) {
+	fi.addInstructionOfABC(OP_TBC, a, 0, 0)
+}
+
 // r[a] = op r[b]
 func (fi *funcInfo) UnitaryOP(line, opcode, a, b int) {
 	//这里的opcode是通过词法分析的，所以应当使用lexer包下定义的常量
@@ -542,10 +683,11 @@ type localVarInfo struct {
 	scope    int           //作用域
 	slot     int           //寄存器的索引
 	captured bool          //是否被捕获
+	attrib   string        //5.4的属性,"const"、"close"或空串
 
-	//localvar scope
-	startLine int
-	endLine   int
+	//localvar scope,用于debug
+	startPC int //局部变量生效的第一条指令
+	endPC   int //局部变量失效的第一条指令,-1表示还未失效
 }
 
 // 由于break语句用于打断循环，基于跳转JMP指令的实现
@@ -570,18 +712,21 @@ type localVarInfo struct {
 //
 // 2.通常break语句在作用域结束前出现，由于顺序处理的缘故所以处理break的时候并不知晓最后跳转的具体位置，所以先使用一条JMP指令占着位置等待后续填充参数
 type breakMap struct {
-	scope  *int    //指向funcInfo.scope
-	breaks [][]int //breaks[scope][pc]，同一作用域下可以有多个break，记录每条break语句(JMP指令)的pc位置方便后续填充
+	scope     *int    //指向funcInfo.scope
+	breaks    [][]int //breaks[scope][pc]，同一作用域下可以有多个break，记录每条break语句(JMP指令)的pc位置方便后续填充
+	firstVars []int   //firstVars[scope]为进入该作用域时funcInfo.localVars的长度,即该作用域中第一个局部变量的索引
 }
 
 // 创建一条表项用于记录该新作用域的break信息
-func (b *breakMap) create(isLoop bool) {
+func (b *breakMap) create(isLoop bool, nVars int) {
 	if *b.scope >= len(b.breaks) {
 		for i := len(b.breaks); i <= *b.scope; i++ {
 			b.breaks = append(b.breaks, nil)
+			b.firstVars = append(b.firstVars, 0)
 		}
 		//b.breaks = append(b.breaks, nil)
 	}
+	b.firstVars[*b.scope] = nVars
 	if isLoop {
 		b.breaks[*b.scope] = []int{}
 	} else {
@@ -591,104 +736,121 @@ func (b *breakMap) create(isLoop bool) {
 
 // 添加当前作用域下break的pc到[对应退出后的作用域]下
 // 一开始并不知道break的结束地方在哪，需要后面的信息才能填充，所以先用JMP占位并记录PC用于后面退出作用域时填充
-func (b *breakMap) add(pc int) {
+// 不在循环内时返回false
+func (b *breakMap) add(pc int) bool {
 	//break跳出循环作用域,当前作用域可能不是,所以需要找最近的循环作用域
 	for i := *b.scope; i >= 0; i-- {
 		if v := b.breaks[i]; v != nil {
 			b.breaks[i] = append(b.breaks[i], pc)
-			return
+			return true
 		}
 	}
-	panic(fmt.Sprintf("there is no loop to break from bottom scope[%d] to top scope[0]", *b.scope))
+	return false
 }
 
-// 弹出最深作用域的break信息
-func (b *breakMap) pop() []int {
+// 弹出最深作用域的break信息,同时返回该作用域中第一个局部变量的索引
+func (b *breakMap) pop() ([]int, int) {
 	breaks := b.breaks[*b.scope]
 	if breaks != nil {
 		b.breaks[*b.scope] = nil
 	}
-	return breaks
+	return breaks, b.firstVars[*b.scope]
 }
 
 // label和goto与break类似
 // goto到label的时候，label可以在goto语句出现之前也能是之后，所以goto的时候先生成JMP占位后续填充
-// 但不同于break，当退出当前作用域时，goto的目的label或许还未出现，所以我们每次退出作用域的时候都需要进行goto的填充
+// 但不同于break，当退出当前作用域时，goto的目的label或许还未出现，所以每次退出作用域时未匹配的goto都会移动到上层作用域继续等待
+//
+// 与官方一致的规则:
+// 1.goto只能跳转到可见的label,即当前作用域及外层作用域(同一函数内)中的label,不能跳入内层作用域
+// 2.同一作用域中不能有同名的label
+// 3.goto不能向前跳入局部变量的作用域,除非label位于块的末尾(其后只有label),此时视为块中的局部变量已离开作用域
+// 4.goto跳出作用域或向后跳转时,需要关闭离开作用域的局部变量的upvalue,即设置JMP指令的A参数
+// 5.函数结束时仍未匹配的goto报错"no visible label"
+type labelInfo struct {
+	name  string
+	pc    int //label指向的pc
+	line  int
+	level int //label处活跃的局部变量数,即已使用的寄存器数
+	scope int
+}
+
 type labelMap struct {
 	scope  *int
-	labels []map[string]int //labels[scope]map[label]pc
+	labels []labelInfo //当前作用域及外层作用域中的label,按定义顺序排列
+	bases  []int       //bases[scope]为进入该作用域时活跃的局部变量数
 }
 
-func (l *labelMap) create() {
-	if *l.scope >= len(l.labels) {
-		for i := len(l.labels); i <= *l.scope; i++ {
-			l.labels = append(l.labels, nil)
-		}
+func (l *labelMap) create(base int) {
+	for len(l.bases) <= *l.scope {
+		l.bases = append(l.bases, 0)
 	}
-	l.labels[*l.scope] = make(map[string]int)
+	l.bases[*l.scope] = base
+}
+
+// 进入当前作用域时活跃的局部变量数
+func (l *labelMap) base() int {
+	return l.bases[*l.scope]
 }
 
-func (l *labelMap) add(label string, pc int) {
-	if len(l.labels) >= *l.scope && l.labels[*l.scope] != nil {
-		// if l.labels[*l.scope] == nil {
-		// 	l.labels[*l.scope] = make(map[string]int)
-		// }
-		lm := l.labels[*l.scope]
-		if _, ok := lm[label]; ok {
-			panic("duplicate label in the same scope") //同一作用域不能有重复的label
+// 查找当前作用域中名为name的label
+func (l *labelMap) find(name string) (labelInfo, bool) {
+	for i := len(l.labels) - 1; i >= 0 && l.labels[i].scope == *l.scope; i-- {
+		if l.labels[i].name == name {
+			return l.labels[i], true
 		}
-		lm[label] = pc
-		return
 	}
-	panic("add label error")
+	return labelInfo{}, false
 }
 
-// 弹出当前作用域内的所有label标签
-func (l *labelMap) pop() map[string]int {
-	ls := l.labels[*l.scope]
-	l.labels[*l.scope] = nil
-	return ls
+// 同一作用域中已经存在该label时返回已存在的label及false
+func (l *labelMap) add(label labelInfo) (labelInfo, bool) {
+	if old, ok := l.find(label.name); ok {
+		return old, false //同一作用域不能有重复的label
+	}
+	l.labels = append(l.labels, label)
+	return label, true
 }
 
-type gotoMap struct {
-	scope *int
-	gotos []map[string][]int
+// 弹出当前作用域内的所有label标签
+func (l *labelMap) pop() {
+	i := len(l.labels)
+	for i > 0 && l.labels[i-1].scope == *l.scope {
+		i--
+	}
+	l.labels = l.labels[:i]
 }
 
-func (g *gotoMap) create() {
-	if *g.scope >= len(g.gotos) {
-		for i := len(g.gotos); i <= *g.scope; i++ {
-			g.gotos = append(g.gotos, nil)
-		}
-	}
-	g.gotos[*g.scope] = make(map[string][]int, 0)
+type gotoInfo struct {
+	name  string
+	pc    int //JMP指令的pc
+	line  int
+	level int //goto处活跃的局部变量数,跳出作用域后为外层作用域的局部变量数
+	scope int
+	close bool //跳出的作用域中有被捕获或待关闭的变量,跳转时需要关闭
 }
 
-func (g *gotoMap) add(label string, pc int) {
-	if *g.scope <= len(g.gotos) && g.gotos[*g.scope] != nil {
-		gm := g.gotos[*g.scope]
-		if _, ok := gm[label]; !ok {
-			gm[label] = make([]int, 0)
-		}
-		gm[label] = append(gm[label], pc)
-		return
-	}
-	panic("add goto error")
+type gotoMap struct {
+	scope *int
+	gotos []gotoInfo //还未匹配label的goto
 }
 
-// 弹出到目前作用域的所有跳转标签为label的goto
-func (g *gotoMap) pop(label string) []int {
-	result := []int{}
-	nGotos := len(g.gotos)
+func (g *gotoMap) add(info gotoInfo) {
+	g.gotos = append(g.gotos, info)
+}
 
-	for i := nGotos - 1; i >= *g.scope; i-- {
-		gm := g.gotos[i]
-		if pc, ok := gm[label]; ok {
-			result = append(result, pc...)
-			delete(gm, label) //删除查找到的gotoPC
+// 弹出当前作用域中跳转到label的所有goto
+func (g *gotoMap) pop(label string) []gotoInfo {
+	result := []gotoInfo{}
+	rest := g.gotos[:0]
+	for _, v := range g.gotos {
+		if v.scope == *g.scope && v.name == label {
+			result = append(result, v)
+		} else {
+			rest = append(rest, v)
 		}
 	}
-
+	g.gotos = rest
 	return result
 }
 
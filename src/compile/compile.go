@@ -1,13 +1,28 @@
 package compile
 
 import (
+	"fmt"
+
 	"nskbz.cn/lua/binchunk"
 	"nskbz.cn/lua/compile/codegen"
+	"nskbz.cn/lua/compile/lexer"
 	"nskbz.cn/lua/compile/parser"
 )
 
-func Compile(chunck []byte, chunckname string) *binchunk.Prototype {
-	block := parser.Parse(chunck, chunckname) //词法分析+语法分析=>AST(抽象语法树)
-	proto := codegen.GenProto(block, chunckname)
-	return proto
+// 编译lua源代码,出现语法错误时返回*lexer.SyntaxError
+// version为接受的语法版本(lexer.LUA_VERSION_53或lexer.LUA_VERSION_54),<=0时为5.3
+func Compile(chunck []byte, chunckname string, version int) (proto *binchunk.Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*lexer.SyntaxError); ok {
+				err = e
+				return
+			}
+			//编译器内部错误同样作为error返回,不终止宿主程序
+			err = fmt.Errorf("%s: %v", lexer.ChunkID(chunckname), r)
+		}
+	}()
+	block := parser.Parse(chunck, chunckname, version) //词法分析+语法分析=>AST(抽象语法树)
+	proto = codegen.GenProto(block, chunckname)
+	return proto, nil
 }
@@ -0,0 +1,97 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+*	语法错误
+*
+*	词法分析、语法分析及代码生成阶段发现的错误统一通过panic(*SyntaxError)抛出
+*	由compile.Compile拦截并作为error返回,错误信息格式为"chunkname:line: message near 'token'"
+ */
+
+const LUA_IDSIZE = 60 //chunkname描述的最大长度(包括结尾)
+
+type SyntaxError struct {
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Msg
+}
+
+// 抛出带有"chunkname:line:"前缀的语法错误
+func ThrowSyntaxError(source string, line int, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	panic(&SyntaxError{fmt.Sprintf("%s:%d: %s", ChunkID(source), line, msg)})
+}
+
+// 词法错误,near为出错位置的源代码片段
+func (l *Lexer) error(msg, near string) {
+	ThrowSyntaxError(l.sourceName, l.line, "%s near %s", msg, near)
+}
+
+// 语法错误,出错位置为下一个TOKEN
+func (l *Lexer) SyntaxError(format string, a ...interface{}) {
+	t := l.LookToken()
+	msg := fmt.Sprintf(format, a...)
+	ThrowSyntaxError(l.sourceName, t.line, "%s near %s", msg, l.tokenText(&t))
+}
+
+// 语义错误,出错位置为当前行,错误信息不包含near部分
+func (l *Lexer) SemanticError(format string, a ...interface{}) {
+	ThrowSyntaxError(l.sourceName, l.line, format, a...)
+}
+
+// 下一个TOKEN不是期望的kind类型
+func (l *Lexer) ErrorExpected(kind int) {
+	l.SyntaxError("'%s' expected", tokenNames[kind])
+}
+
+// TOKEN在错误信息中的描述,字符串与数字使用其源代码文本
+// t必须是最近扫描的TOKEN(即LookToken的结果)
+func (l *Lexer) tokenText(t *Token) string {
+	switch t.kind {
+	case TOKEN_EOF:
+		return "<eof>"
+	case TOKEN_STRING, TOKEN_NUMBER:
+		return fmt.Sprintf("'%s'", l.data[l.start:t.i])
+	}
+	return fmt.Sprintf("'%s'", t.value)
+}
+
+// 将chunkname转换为便于输出的描述,移植自官方luaO_chunkid
+//
+// "=xxx"	原样输出xxx
+// "@xxx"	文件名xxx,过长时保留结尾部分
+// 其他		字符串代码块,输出为[string "xxx"]
+func ChunkID(source string) string {
+	const bufflen = LUA_IDSIZE - 1
+	if strings.HasPrefix(source, "=") {
+		if len(source)-1 <= bufflen {
+			return source[1:]
+		}
+		return source[1 : 1+bufflen]
+	}
+	if strings.HasPrefix(source, "@") {
+		if len(source)-1 <= bufflen {
+			return source[1:]
+		}
+		return "..." + source[len(source)-(bufflen-3):]
+	}
+	const pre, post, dots = `[string "`, `"]`, "..."
+	maxLen := bufflen - len(pre) - len(dots) - len(post)
+	line := source
+	if nl := strings.IndexByte(source, '\n'); nl >= 0 {
+		line = source[:nl]
+	}
+	if len(line) == len(source) && len(source) <= maxLen {
+		return pre + source + post
+	}
+	if len(line) > maxLen {
+		line = line[:maxLen]
+	}
+	return pre + line + dots + post
+}
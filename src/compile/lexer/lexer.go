@@ -6,6 +6,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"nskbz.cn/lua/number"
 )
 
 var reNewLine = regexp.MustCompile("\r\n|\n\r|\r|\n")
@@ -13,9 +15,8 @@ var reNewLine = regexp.MustCompile("\r\n|\n\r|\r|\n")
 var reDecEscapeSeq = regexp.MustCompile(`^\\[0-9]{1,3}`)          //十进制ASCII码
 var reHexEscapeSeq = regexp.MustCompile(`^\\x[0-9a-fA-F]{2}`)     //十六进制ASCII码
 var reUnicodeEscapeSeq = regexp.MustCompile(`^\\u{[0-9a-fA-F]+}`) //unicode码
-var reNumber = regexp.MustCompile(`^0[xX][0-9a-fA-F]*(\.[0-9a-fA-F]*)?([pP][+\-]?[0-9]+)?|^[0-9]*(\.[0-9]*)?([eE][+\-]?[0-9]+)?`)
 var reIdentifier = regexp.MustCompile(`^[_\d\w]+`)
-var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)
+var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\r\n?|\\\n\r?|\\z\s*|[^'\r\n])*')|(^"(\\\\|\\"|\\\r\n?|\\\n\r?|\\z\s*|[^"\r\n])*")`)
 
 type Token struct {
 	kind  int //TOKEN类型
@@ -29,11 +30,20 @@ func (t *Token) Kind() int   { return t.kind }
 func (t *Token) Line() int   { return t.line }
 func (t *Token) Val() string { return t.value }
 
+// 语言版本,取值与api.LUA_VERSION_53/api.LUA_VERSION_54相同
+const (
+	LUA_VERSION_53 = 503
+	LUA_VERSION_54 = 504
+)
+
 type Lexer struct {
 	sourceName string //源文件名
-	data       []byte //源代码
+	data       string //源代码,转换为string后截取剩余部分不需要复制
 	i          int
+	start      int //最近扫描的TOKEN在data中的起始位置,错误信息中使用其源代码文本
 	line       int //当前行号
+	version    int //接受的语法版本
+	level      int //语法分析的递归嵌套层数,见EnterLevel
 
 	//下一token缓存
 	cache *Token
@@ -42,19 +52,40 @@ type Lexer struct {
 func NewLexer(chunk []byte, name string) *Lexer {
 	return &Lexer{
 		sourceName: name,
-		data:       chunk,
+		data:       string(chunk),
 		i:          0,
 		line:       1,
+		version:    LUA_VERSION_53,
 		cache:      nil,
 	}
 }
 
 func (l *Lexer) Line() int { return l.line }
 
-func (l *Lexer) error(format string, err ...interface{}) {
-	info := fmt.Sprintf(format, err...)
-	errInfo := fmt.Sprintf("file:[%s] line:[%d] err[%s]", l.sourceName, l.line, info)
-	panic(errInfo)
+// 设置接受的语法版本,v<=0时为默认的5.3
+func (l *Lexer) SetVersion(v int) {
+	if v <= 0 {
+		v = LUA_VERSION_53
+	}
+	l.version = v
+}
+
+func (l *Lexer) Version() int { return l.version }
+
+// 与官方LUAI_MAXCCALLS一致,语句与表达式的最大嵌套层数
+const LUAI_MAXCCALLS = 200
+
+// 语法分析器递归解析语句和表达式时调用,与LeaveLevel成对使用
+// 嵌套过深时抛出语法错误,避免不可信的代码耗尽Go的调用栈使宿主程序崩溃
+func (l *Lexer) EnterLevel() {
+	l.level++
+	if l.level > LUAI_MAXCCALLS {
+		l.SemanticError("chunk has too many C levels")
+	}
+}
+
+func (l *Lexer) LeaveLevel() {
+	l.level--
 }
 
 func (l *Lexer) char() byte {
@@ -66,7 +97,21 @@ func (l *Lexer) empty() bool {
 }
 
 func (l *Lexer) string() string {
-	return string(l.data[l.i:])
+	return l.data[l.i:]
+}
+
+// 当前位置起n个字节的源代码片段,用于错误信息
+func (l *Lexer) near(n int) string {
+	if l.empty() {
+		return "<eof>"
+	}
+	if l.i+n > len(l.data) {
+		n = len(l.data) - l.i
+	}
+	if n == 1 && !checkPrint(l.data[l.i]) { //与官方一致,不可打印的字符输出为其编码
+		return fmt.Sprintf("'<\\%d>'", l.data[l.i])
+	}
+	return fmt.Sprintf("'%s'", l.data[l.i:l.i+n])
 }
 
 func (l *Lexer) next(n int) {
@@ -76,7 +121,10 @@ func (l *Lexer) next(n int) {
 // 判断data是否以s开头
 func (l *Lexer) test(s string) bool {
 	length := len(s)
-	return string(l.data[l.i:l.i+length]) == s
+	if l.i+length > len(l.data) {
+		return false
+	}
+	return l.data[l.i:l.i+length] == s
 }
 
 // 判断是否是空白字符
@@ -97,7 +145,7 @@ func (l *Lexer) scanLongString() string {
 	format := false //记录长字符串格式是否正确
 	sb := strings.Builder{}
 	if !l.test("[") {
-		l.error("prefix error")
+		l.error("invalid long string delimiter", l.near(1))
 	}
 	l.next(1)
 	sb.WriteByte(']')
@@ -125,7 +173,7 @@ func (l *Lexer) scanLongString() string {
 	}
 
 	if !format {
-		l.error("suffix error")
+		l.error("unfinished long string", "<eof>")
 	}
 
 	//处理换行序列
@@ -148,7 +196,11 @@ func (l *Lexer) scanShortString() string {
 		}
 		return found
 	}
-	l.error("scan short string error")
+	str := l.string()
+	if nl := strings.IndexAny(str, "\r\n"); nl >= 0 { //字符串中出现了未转义的换行
+		l.error("unfinished string", fmt.Sprintf("'%s'", str[:nl]))
+	}
+	l.error("unfinished string", "<eof>") //直到结尾都没有找到结束的引号
 	return ""
 }
 
@@ -165,7 +217,7 @@ func (l *Lexer) escape(str string) string {
 		}
 		//转义
 		if len(str) <= 1 {
-			l.error("error format string")
+			l.error("invalid escape sequence", "'\\'")
 		}
 		switch str[1] { //str[0] == '\'
 		case 'a':
@@ -198,13 +250,20 @@ func (l *Lexer) escape(str string) string {
 		case '\\':
 			buf.WriteByte('\\')
 			str = str[2:]
+		case '\n', '\r': // '\'后紧跟换行,\r\n与\n\r视为一个换行
+			buf.WriteByte('\n')
+			if len(str) > 2 && (str[2] == '\n' || str[2] == '\r') && str[2] != str[1] {
+				str = str[3:]
+			} else {
+				str = str[2:]
+			}
 		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': // \nnn
 			if found := reDecEscapeSeq.FindString(str); found != "" {
 				if i, err := strconv.ParseInt(found[1:], 10, 32); err == nil && i <= 0xFF {
 					buf.WriteByte(byte(i))
 					str = str[len(found):]
 				} else {
-					l.error("[%s] decimal parse error , %s ,%d", found, err, i)
+					l.error("decimal escape too large", fmt.Sprintf("'%s'", found))
 				}
 			}
 		case 'x': // \xFF
@@ -213,17 +272,22 @@ func (l *Lexer) escape(str string) string {
 					buf.WriteByte(byte(i))
 					str = str[len(found):]
 				} else {
-					l.error("[%s] hex parse error , %s", found, err)
+					l.error("hexadecimal digit expected", fmt.Sprintf("'%s'", found))
 				}
+			} else {
+				l.error("hexadecimal digit expected", fmt.Sprintf("'%s'", escapeNear(str, 4)))
 			}
 		case 'u': // \u{FFF}
 			if found := reUnicodeEscapeSeq.FindString(str); found != "" {
-				if i, err := strconv.ParseInt(found[3:len(found)-1], 16, 32); err == nil && i <= 0x10FFFF {
-					buf.WriteRune(rune(i))
+				//与官方一致接受最大0x7FFFFFFF的码点,按扩展的UTF-8编码(最多6字节)
+				if i, err := strconv.ParseInt(found[3:len(found)-1], 16, 64); err == nil && i <= 0x7FFFFFFF {
+					buf.WriteString(number.UTF8Encode(uint32(i)))
 					str = str[len(found):]
 				} else {
-					l.error("[%s] UTF-8 parse error , %s , %d", found, err, i)
+					l.error("UTF-8 value too large", fmt.Sprintf("'%s'", found))
 				}
+			} else {
+				l.error("missing '{' or '}' in \\u{xxxx}", fmt.Sprintf("'%s'", escapeNear(str, 3)))
 			}
 		case 'z':
 			i := 2
@@ -231,6 +295,8 @@ func (l *Lexer) escape(str string) string {
 				i++
 			}
 			str = str[i:]
+		default:
+			l.error("invalid escape sequence", fmt.Sprintf("'%s'", str[:2]))
 		}
 	}
 
@@ -281,11 +347,11 @@ func (l *Lexer) NextToken() Token {
 	}
 
 	l.skipWhiteSpaces() //跳过空白和注释
+	l.start = l.i
 	if l.empty() {
 		return Token{TOKEN_EOF, "EOF", l.line, l.i}
 	}
 
-	errMsg := fmt.Sprintf("Can't resolve [%c]", l.char())
 	switch l.char() {
 	case ';':
 		l.next(1)
@@ -395,6 +461,8 @@ func (l *Lexer) NextToken() Token {
 		} else if l.test("..") {
 			l.next(2)
 			return Token{TOKEN_OP_CONCAT, "..", l.line, l.i} //连接
+		} else if str := l.string(); len(str) > 1 && checkNumber(str[1]) {
+			break //.5之类的数字字面量
 		}
 		l.next(1)
 		return Token{TOKEN_SEP_DOT, ".", l.line, l.i}
@@ -404,12 +472,10 @@ func (l *Lexer) NextToken() Token {
 	}
 
 	//数字字面量
-	if checkNumber(l.char()) {
-		if found := reNumber.FindString(l.string()); found != "" {
-			l.next(len(found))
-			return Token{TOKEN_NUMBER, found, l.line, l.i}
-		}
-		errMsg = fmt.Sprintf("Can't find number.near by [%c]", l.char())
+	if checkNumber(l.char()) || l.char() == '.' {
+		found := l.scanNumber()
+		l.next(len(found))
+		return Token{TOKEN_NUMBER, found, l.line, l.i}
 	}
 
 	//关键字或标识符
@@ -421,10 +487,10 @@ func (l *Lexer) NextToken() Token {
 			}
 			return Token{TOKEN_IDENTIFIER, found, l.line, l.i} //identifier
 		}
-		errMsg = fmt.Sprintf("Can't find keyword or identifier.near by [%c]", l.char())
 	}
 
-	return Token{0, errMsg, 0, 0}
+	l.error("unexpected symbol", l.near(1))
+	return Token{}
 }
 
 // 查看下一个TOKEN但不改变词法分析器的状态
@@ -446,7 +512,7 @@ func (l *Lexer) LookToken() Token {
 func (l *Lexer) AssertToken(kind int) *Token {
 	t := l.LookToken()
 	if t.kind != kind {
-		panic(fmt.Sprintf("real type[%d] != excepted type[%d]", t.kind, kind))
+		l.ErrorExpected(kind)
 	}
 	return &t
 }
@@ -466,6 +532,14 @@ func (l *Lexer) AssertAndSkipToken(kind int) *Token {
 	return t
 }
 
+// 转义序列开头至多n个字节,用于错误信息
+func escapeNear(str string, n int) string {
+	if len(str) < n {
+		return str
+	}
+	return str[:n]
+}
+
 func checkWhiteSpace(c byte) bool {
 	switch c {
 	case '\n', '\r', '\t', '\v', '\f', ' ':
@@ -474,10 +548,47 @@ func checkWhiteSpace(c byte) bool {
 	return false
 }
 
+// 与官方read_numeral一致,先读入数字可能包含的所有字符再检查格式
+// 所以"1e"、"0x"、"1..2"等都报告为malformed number
+func (l *Lexer) scanNumber() string {
+	str := l.string()
+	expo, i := "Ee", 0
+	if strings.HasPrefix(str, "0x") || strings.HasPrefix(str, "0X") {
+		expo, i = "Pp", 2
+	}
+	for i < len(str) {
+		if c := str[i]; strings.IndexByte(expo, c) >= 0 { //指数部分,可以带符号
+			i++
+			if i < len(str) && (str[i] == '+' || str[i] == '-') {
+				i++
+			}
+		} else if checkHexNumber(c) || c == '.' {
+			i++
+		} else {
+			break
+		}
+	}
+	found := str[:i]
+	if _, ok := number.ParseInteger(found); !ok {
+		if _, ok := number.ParseFloat(found); !ok {
+			l.error("malformed number", fmt.Sprintf("'%s'", found))
+		}
+	}
+	return found
+}
+
 func checkNumber(c byte) bool {
 	return c >= '0' && c <= '9'
 }
 
+func checkHexNumber(c byte) bool {
+	return checkNumber(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
 func checkLetter(c byte) bool {
 	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
+
+func checkPrint(c byte) bool {
+	return c >= ' ' && c <= '~'
+}
@@ -10,9 +10,10 @@ import (
 // namelist ::=Name { ',' Name}
 // Name为普通的变量名
 func parseIdentifierList(l *lexer.Lexer) []string {
-	names, hasVararg := parseParamList(l)
-	if hasVararg {
-		panic("here are vararg!error!")
+	names := []string{l.AssertAndSkipToken(lexer.TOKEN_IDENTIFIER).Val()}
+	for l.CheckToken(lexer.TOKEN_SEP_COMMA) {
+		l.NextToken() //skip ','
+		names = append(names, l.AssertAndSkipToken(lexer.TOKEN_IDENTIFIER).Val())
 	}
 	return names
 }
@@ -24,23 +25,23 @@ func parseIdentifierList(l *lexer.Lexer) []string {
 func parseVarList(l *lexer.Lexer) []ast.Exp {
 	exps := []ast.Exp{}
 	v0 := parsePrefixExp(l) //解析第一个var
-	exps = append(exps, _checkVar(v0))
+	exps = append(exps, _checkVar(l, v0))
 	for l.CheckToken(lexer.TOKEN_SEP_COMMA) {
 		l.NextToken() //skip ','
 		v := parsePrefixExp(l)
-		exps = append(exps, _checkVar(v))
+		exps = append(exps, _checkVar(l, v))
 	}
 	return exps
 }
 
 // var ::=Name | prefixexp '[' exp ']' | prefixexp '.' Name
-func _checkVar(exp ast.Exp) ast.Exp {
+func _checkVar(l *lexer.Lexer, exp ast.Exp) ast.Exp {
 	switch exp.(type) {
 	case *ast.NameExp, *ast.TableAccessExp:
 		return exp
 	}
-	// l.AssertAndSkipToken(-1) ???????
-	panic("unreachable")
+	l.SyntaxError("syntax error")
+	return nil
 }
 
 // 解析参数列表
@@ -101,15 +102,10 @@ func parseFuncDefExp(l *lexer.Lexer, lineForFunc int) ast.Exp {
 }
 
 func parseExpList(l *lexer.Lexer) []ast.Exp {
-	exps := []ast.Exp{}
-	if exp := parseExp(l); exp != nil {
-		exps = append(exps, exp)
-	}
+	exps := []ast.Exp{parseExp(l)}
 	for l.CheckToken(lexer.TOKEN_SEP_COMMA) {
 		l.NextToken() //skip ','
-		if exp := parseExp(l); exp != nil {
-			exps = append(exps, exp)
-		}
+		exps = append(exps, parseExp(l))
 	}
 	return exps
 }
@@ -135,6 +131,8 @@ exp1  ::= exp0 {‘^’ exp2}
 exp0  ::= nil | false | true | Numeral | LiteralString| ‘...’ | functiondef | prefixexp | tableconstructor
 */
 func parseExp(l *lexer.Lexer) ast.Exp {
+	l.EnterLevel()
+	defer l.LeaveLevel()
 	return _parseExp12(l)
 }
 
@@ -314,31 +312,19 @@ func _parseExp3(l *lexer.Lexer) ast.Exp {
 // exp2  ::= {(‘not’ | ‘#’ | ‘-’ | ‘~’)} exp1
 // 这些都是右结合的运算符
 func _parseExp2(l *lexer.Lexer) ast.Exp {
-	exp := _parseExp1(l)
-	//如果exp不为空则说明应当为减法而非负数
-	if exp != nil {
-		return exp
-	}
-
-	// if exp!=nil &&l.CheckToken(lexer.TOKEN_OP_SUB){
-	// 	t := l.NextToken()//skip '-'
-	// 	exp =&ast.DualOpExp{
-	// 		Line: t.Line(),
-	// 		Op: t.Kind(),
-	// 		A: exp,
-	// 		B: _parseExp2(l),
-	// 	}
-	// }
+	//表达式以一元运算符开头时'-'为负数,否则在_parseExp4中作为减法
 	if l.CheckToken(lexer.TOKEN_OP_NOT) || l.CheckToken(lexer.TOKEN_OP_LEN) ||
 		l.CheckToken(lexer.TOKEN_OP_UNM) || l.CheckToken(lexer.TOKEN_OP_BNOT) {
 		t := l.NextToken()
-		exp = &ast.UnitaryOpExp{
+		l.EnterLevel() //一元运算符可以任意嵌套
+		defer l.LeaveLevel()
+		return &ast.UnitaryOpExp{
 			Line: t.Line(),
 			Op:   t.Kind(),
 			A:    _parseExp2(l),
 		}
 	}
-	return exp
+	return _parseExp1(l)
 }
 
 // exp1  ::= exp0 {‘^’ exp2}
@@ -347,6 +333,8 @@ func _parseExp1(l *lexer.Lexer) ast.Exp {
 	exp := _parseExp0(l)
 	if l.CheckToken(lexer.TOKEN_OP_POW) {
 		t := l.NextToken()
+		l.EnterLevel() //右结合的'^'同样会递归嵌套
+		defer l.LeaveLevel()
 		exp = &ast.DualOpExp{
 			Line: t.Line(),
 			Op:   t.Kind(),
@@ -390,6 +378,7 @@ func _parseExp0(l *lexer.Lexer) ast.Exp {
 	}
 }
 
+// 数字的格式已由词法分析器检查
 func parseNumberExp(l *lexer.Lexer) ast.Exp {
 	t := l.NextToken()
 	if i, ok := number.ParseInteger(t.Val()); ok {
@@ -404,7 +393,7 @@ func parseNumberExp(l *lexer.Lexer) ast.Exp {
 			Val:  f,
 		}
 	}
-	panic("parse number error:" + t.Val())
+	return nil
 }
 
 // 数组式表
@@ -457,13 +446,9 @@ func parseTableConstructorExp(l *lexer.Lexer) ast.Exp {
 	keys := []ast.Exp{}
 	values := []ast.Exp{}
 
+	//列表式字段的key为nil,由codegen按顺序分配索引
 	if !l.CheckToken(lexer.TOKEN_SEP_RCURLY) {
-		i := 1
 		key, value := _parseTableField(l)
-		if key == nil {
-			key = &ast.IntExp{Line: l.Line(), Val: int64(i)}
-			i++
-		}
 		keys = append(keys, key)
 		values = append(values, value)
 		for _checkTableFieldEnd(l) {
@@ -472,10 +457,6 @@ func parseTableConstructorExp(l *lexer.Lexer) ast.Exp {
 			if key == nil && value == nil { //都为空则直接跳出
 				break
 			}
-			if key == nil {
-				key = &ast.IntExp{Line: l.Line(), Val: int64(i)}
-				i++
-			}
 			keys = append(keys, key)
 			values = append(values, value)
 		}
@@ -523,15 +504,15 @@ func _parseTableField(l *lexer.Lexer) (k, v ast.Exp) {
 	// {x=y}
 	// 将语法糖转换成{["x"]=y}
 	if l.CheckToken(lexer.TOKEN_IDENTIFIER) {
+		context := *l //保护现场
 		identifier := l.NextToken()
 		if l.CheckToken(lexer.TOKEN_OP_ASSIGN) {
 			k = &ast.StringExp{Line: identifier.Line(), Str: identifier.Val()}
 			l.NextToken() //skip '='
 			v = parseExp(l)
+			return
 		}
-		// t := l.NextToken()
-		// v = &ast.StringExp{Line: t.Line(), Str: t.Val()}
-		return
+		*l = context //不是Name '=' exp的形式则返回现场作为表达式解析
 	}
 
 	// {x}
@@ -10,6 +10,8 @@ import (
 // functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
 func parsePrefixExp(l *lexer.Lexer) ast.Exp {
 	var first ast.Exp
+	t := l.LookToken()
+	line := t.Line() //前缀表达式开始的行号,作为其中函数调用的行号
 	if l.CheckToken(lexer.TOKEN_IDENTIFIER) {
 		t := l.NextToken()
 		first = &ast.NameExp{
@@ -18,15 +20,14 @@ func parsePrefixExp(l *lexer.Lexer) ast.Exp {
 		}
 	} else if l.CheckToken(lexer.TOKEN_SEP_LPAREN) {
 		first = parseParenExp(l)
+	} else {
+		l.SyntaxError("unexpected symbol")
 	}
-	//else {
-	// 	panic("no support this prefix class")
-	// }
 
-	return _finishPrefixExp(l, first)
+	return _finishPrefixExp(l, first, line)
 }
 
-func _finishPrefixExp(l *lexer.Lexer, exp ast.Exp) ast.Exp {
+func _finishPrefixExp(l *lexer.Lexer, exp ast.Exp, line int) ast.Exp {
 	//exp进来的类型为NameExp | ParenExp
 	for {
 		if l.CheckToken(lexer.TOKEN_SEP_DOT) {
@@ -62,11 +63,11 @@ func _finishPrefixExp(l *lexer.Lexer, exp ast.Exp) ast.Exp {
 				HasColon: true,
 			}
 		} else if l.CheckToken(lexer.TOKEN_SEP_LPAREN) { // myfunc ("hello")
-			exp = _parseStandardFuncCallExp(l, exp)
+			exp = _parseStandardFuncCallExp(l, exp, line)
 		} else if l.CheckToken(lexer.TOKEN_SEP_LCURLY) { //	myfunc {"hello"}
-			exp = _parseTableFuncCallExp(l, exp)
+			exp = _parseTableFuncCallExp(l, exp, line)
 		} else if l.CheckToken(lexer.TOKEN_STRING) { //	myfunc "hello"
-			exp = _parseStringFuncCallExp(l, exp)
+			exp = _parseStringFuncCallExp(l, exp, line)
 		} else {
 			return exp
 		}
@@ -86,9 +87,12 @@ func parseParenExp(l *lexer.Lexer) ast.Exp {
 	return exp
 }
 
-func _parseStandardFuncCallExp(l *lexer.Lexer, method ast.Exp) ast.Exp {
-	line := l.AssertAndSkipToken(lexer.TOKEN_SEP_LPAREN).Line() //skip '('
-	exps := parseExpList(l)
+func _parseStandardFuncCallExp(l *lexer.Lexer, method ast.Exp, line int) ast.Exp {
+	l.AssertAndSkipToken(lexer.TOKEN_SEP_LPAREN) //skip '('
+	var exps []ast.Exp
+	if !l.CheckToken(lexer.TOKEN_SEP_RPAREN) {
+		exps = parseExpList(l)
+	}
 	l.AssertAndSkipToken(lexer.TOKEN_SEP_RPAREN) //skip ')'
 	return &ast.FuncCallExp{
 		Line:     line,
@@ -98,28 +102,26 @@ func _parseStandardFuncCallExp(l *lexer.Lexer, method ast.Exp) ast.Exp {
 	}
 }
 
-func _parseStringFuncCallExp(l *lexer.Lexer, method ast.Exp) ast.Exp {
+func _parseStringFuncCallExp(l *lexer.Lexer, method ast.Exp, line int) ast.Exp {
 	t := l.AssertAndSkipToken(lexer.TOKEN_STRING)
 	exps := []ast.Exp{&ast.StringExp{
 		Line: t.Line(),
 		Str:  t.Val(),
 	}}
 	return &ast.FuncCallExp{
-		Line:     t.Line(),
+		Line:     line,
 		LastLine: t.Line(),
 		Method:   method,
 		Exps:     exps,
 	}
 }
 
-func _parseTableFuncCallExp(l *lexer.Lexer, method ast.Exp) ast.Exp {
-	t := l.AssertAndSkipToken(lexer.TOKEN_SEP_LCURLY) //skip '{'
-	table := parseTableConstructorExp(l)
+func _parseTableFuncCallExp(l *lexer.Lexer, method ast.Exp, line int) ast.Exp {
+	table := parseTableConstructorExp(l) //'{'与'}'由表构造表达式处理
 	exps := []ast.Exp{table}
-	l.AssertAndSkipToken(lexer.TOKEN_SEP_RCURLY) //skip '}'
 	return &ast.FuncCallExp{
-		Line:     t.Line(),
-		LastLine: t.Line(),
+		Line:     line,
+		LastLine: l.Line(),
 		Method:   method,
 		Exps:     exps,
 	}
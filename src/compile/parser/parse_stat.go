@@ -6,6 +6,8 @@ import (
 )
 
 func parseStat(l *lexer.Lexer) ast.Stat {
+	l.EnterLevel()
+	defer l.LeaveLevel()
 	var stat ast.Stat
 	nt := l.LookToken()
 	switch nt.Kind() {
@@ -53,7 +55,7 @@ func parseLabelStat(l *lexer.Lexer) ast.Stat {
 	l.NextToken() //skip '::'
 	identifier := l.AssertAndSkipToken(lexer.TOKEN_IDENTIFIER)
 	l.AssertAndSkipToken(lexer.TOKEN_SEP_LABEL)
-	return &ast.LabelStat{Name: identifier.Val()}
+	return &ast.LabelStat{Name: identifier.Val(), Line: identifier.Line()}
 }
 
 func parseGotoStat(l *lexer.Lexer) ast.Stat {
@@ -146,11 +148,12 @@ func parseForStat(l *lexer.Lexer) ast.Stat {
 	} else if l.CheckToken(lexer.TOKEN_SEP_COMMA) {
 		l.NextToken()
 		names = append(names, parseIdentifierList(l)...)
-		return _parseForInStat(l, names, exps)
+		return _parseForInStat(l, f.Line(), names, exps)
 	} else if l.CheckToken(lexer.TOKEN_KW_IN) { //forIn只有一个var的情况
-		return _parseForInStat(l, names, exps)
+		return _parseForInStat(l, f.Line(), names, exps)
 	}
-	panic("error parse ForStat")
+	l.SyntaxError("'=' or 'in' expected")
+	return nil
 }
 
 func _parseForNumStat(l *lexer.Lexer, lineOfFor int, names []string, exps []ast.Exp) ast.Stat {
@@ -185,15 +188,18 @@ func _parseForNumStat(l *lexer.Lexer, lineOfFor int, names []string, exps []ast.
 	}
 }
 
-func _parseForInStat(l *lexer.Lexer, names []string, exps []ast.Exp) ast.Stat {
+func _parseForInStat(l *lexer.Lexer, lineOfFor int, names []string, exps []ast.Exp) ast.Stat {
 
 	l.AssertAndSkipToken(lexer.TOKEN_KW_IN)
-
+	t := l.LookToken()
+	lineOfIn := t.Line()
 	exps = append(exps, parseExpList(l)...) //todo 这里会不会有问题,for in后面可以是函数调用表达式或是三元组
 	lineForDo := l.AssertAndSkipToken(lexer.TOKEN_KW_DO).Line()
 	block := parseBlock(l)
 	lineForEnd := l.AssertAndSkipToken(lexer.TOKEN_KW_END).Line()
 	return &ast.ForInStat{
+		LineOfFor: lineOfFor,
+		LineOfIn:  lineOfIn,
 		LineOfDo:  lineForDo,
 		LineOfEnd: lineForEnd,
 		NameList:  names,
@@ -211,7 +217,12 @@ func parseLocalValOrFuncStat(l *lexer.Lexer) ast.Stat {
 }
 
 func _parseLocalVal(l *lexer.Lexer) ast.Stat {
-	names := parseIdentifierList(l) //因为是local开头所以这里都是定义变量，不存在有前缀表达式的变量，所以就直接解析为标识符
+	var names, attribs []string
+	if l.Version() >= lexer.LUA_VERSION_54 {
+		names, attribs = _parseAttNameList(l)
+	} else {
+		names = parseIdentifierList(l) //因为是local开头所以这里都是定义变量，不存在有前缀表达式的变量，所以就直接解析为标识符
+	}
 	var exps []ast.Exp
 	if l.CheckToken(lexer.TOKEN_OP_ASSIGN) {
 		l.NextToken()
@@ -220,10 +231,47 @@ func _parseLocalVal(l *lexer.Lexer) ast.Stat {
 	return &ast.LocalVarStat{
 		LastLine:     l.Line(),
 		LocalVarList: names,
+		AttribList:   attribs,
 		ExpList:      exps,
 	}
 }
 
+// attnamelist ::=Name attrib { ',' Name attrib}
+// attrib ::=[‘<’ Name ‘>’]
+// 没有任何变量带属性时attribs为nil
+func _parseAttNameList(l *lexer.Lexer) (names, attribs []string) {
+	hasAttrib, hasClose := false, false
+	for {
+		names = append(names, l.AssertAndSkipToken(lexer.TOKEN_IDENTIFIER).Val())
+		attrib := ""
+		if l.CheckToken(lexer.TOKEN_OP_LT) {
+			l.NextToken() //skip '<'
+			attrib = l.AssertAndSkipToken(lexer.TOKEN_IDENTIFIER).Val()
+			l.AssertAndSkipToken(lexer.TOKEN_OP_GT)
+			switch attrib {
+			case "const":
+			case "close":
+				if hasClose {
+					l.SemanticError("multiple to-be-closed variables in local list")
+				}
+				hasClose = true
+			default:
+				l.SemanticError("unknown attribute '%s'", attrib)
+			}
+			hasAttrib = true
+		}
+		attribs = append(attribs, attrib)
+		if !l.CheckToken(lexer.TOKEN_SEP_COMMA) {
+			break
+		}
+		l.NextToken() //skip ','
+	}
+	if !hasAttrib {
+		attribs = nil
+	}
+	return
+}
+
 func _parseLocalFunc(l *lexer.Lexer) ast.Stat {
 	t := l.AssertAndSkipToken(lexer.TOKEN_KW_FUNCTION)
 	funcName := l.AssertIdentifier()
@@ -250,6 +298,9 @@ func parseAssignOrFuncCallStat(l *lexer.Lexer) ast.Stat {
 func _parseAssignStat(l *lexer.Lexer, prefixExp ast.Exp) *ast.AssignStat {
 	//解析为varlist，区别_parseLocalVal中解析为identifier list，因为赋值语句都是使用已经定义好的变量所以可能会有前缀变量
 	vars := parseVarList(l)
+	if len(vars) == 1 && !l.CheckToken(lexer.TOKEN_OP_ASSIGN) { //既不是函数调用也不是赋值语句
+		l.SyntaxError("syntax error")
+	}
 	l.AssertAndSkipToken(lexer.TOKEN_OP_ASSIGN) //skip '='
 	exps := parseExpList(l)
 	return &ast.AssignStat{
@@ -267,11 +318,11 @@ func parseFuncDefStat(l *lexer.Lexer) ast.Stat {
 		// v:name(args) => v.name(self, args)
 		funcDef.ArgList = append([]string{"self"}, funcDef.ArgList...)
 	}
-	if name, ok := funcNameExp.(*ast.NameExp); ok {
-		return &ast.LocalFuncDefStat{
-			Name:    name.Name,
-			Body:    funcDef,
-			DefLine: t.Line(),
+	if _, ok := funcNameExp.(*ast.NameExp); ok { //function f() end => f = function() end,f为全局变量(或已声明的局部变量)
+		return &ast.AssignStat{
+			VarList:  []ast.Exp{funcNameExp},
+			ExpList:  []ast.Exp{funcDef},
+			LastLine: t.Line(),
 		}
 	}
 	return &ast.OopFuncDefStat{
@@ -5,9 +5,11 @@ import (
 	"nskbz.cn/lua/compile/lexer"
 )
 
-func Parse(chunk []byte, chunkName string) *ast.Block {
+// version为接受的语法版本(lexer.LUA_VERSION_53或lexer.LUA_VERSION_54),<=0时为5.3
+func Parse(chunk []byte, chunkName string, version int) *ast.Block {
 	l := lexer.NewLexer(chunk, chunkName) //词法分析
-	block := parseBlock(l)                //语法分析
+	l.SetVersion(version)
+	block := parseBlock(l) //语法分析
 	l.AssertAndSkipToken(lexer.TOKEN_EOF)
 	return block
 }
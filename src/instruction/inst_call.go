@@ -30,7 +30,9 @@ func _pushFuncAndArgs(a, b int, vm api.LuaVM) int {
 		for i := a; i < x; i++ {
 			vm.PushValue(i)
 		}
-		vm.Rotate(vm.RegisterCount()+1, x-a) //调整参数的顺序=>[其他参数，被调函数返回值]
+		if x > a { //被调函数没有返回值且没有其他参数时无需调整
+			vm.Rotate(vm.RegisterCount()+1, x-a) //调整参数的顺序=>[其他参数，被调函数返回值]
+		}
 		nArgs = vm.GetTop() - vm.RegisterCount() - 1
 	}
 	return nArgs
@@ -62,8 +64,10 @@ func call(i Instruction, vm api.LuaVM) {
 	//将参数压入
 	nArgs := _pushFuncAndArgs(a, b, vm)
 
-	//执行函数
-	vm.Call(nArgs, c-1)
+	//执行函数,lua函数由执行循环继续执行,返回后由Finish设置返回值
+	if vm.PreCall(nArgs, c-1) {
+		return
+	}
 
 	//设置返回值
 	_popResults(a, c, vm)
@@ -73,7 +77,10 @@ func tailcall(i Instruction, vm api.LuaVM) {
 	a, b, _ := i.ABC()
 	a += 1
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, -1)
+	//lua函数替换当前函数栈由执行循环继续执行,Go函数的返回值由之后的RETURN指令返回
+	if vm.PreTailCall(nArgs) {
+		return
+	}
 	_popResults(a, 0, vm)
 }
 
@@ -100,7 +107,9 @@ func luaReturn(i Instruction, vm api.LuaVM) {
 		for i := a; i < x; i++ {
 			vm.PushValue(i)
 		}
-		vm.Rotate(vm.RegisterCount()+1, x-a)
+		if x > a {
+			vm.Rotate(vm.RegisterCount()+1, x-a)
+		}
 	}
 }
 
@@ -1,6 +1,9 @@
 package instruction
 
 import (
+	"fmt"
+	"math"
+
 	"nskbz.cn/lua/api"
 )
 
@@ -38,10 +41,39 @@ import (
 func forPrep(i Instruction, vm api.LuaVM) {
 	a, sbx := i.AsBx()
 	a += 1
-	vm.PushValue(a)
-	vm.PushValue(a + 2)
-	vm.Arith(api.ArithOp_SUB)
-	vm.Replace(a)
+	if vm.Version() >= api.LUA_VERSION_54 {
+		forPrep54(a, sbx, vm)
+		return
+	}
+	forPrep53(a, sbx, vm)
+}
+
+// 5.3的数值for循环(参考lvm.c中的OP_FORPREP)
+// init与step都为整数并且limit可以转换为整数(浮点数向循环方向取整,超出范围时截断)时为整数循环,否则三个值都转换为浮点数
+// 字符串形式的数字同样会被转换
+func forPrep53(a, sbx int, vm api.LuaVM) {
+	if vm.IsIntegerSubtype(a) && vm.IsIntegerSubtype(a+2) {
+		init, step := vm.ToInteger(a), vm.ToInteger(a+2)
+		limit, skip := _forLimit(vm, a+1, init, step)
+		if skip { //循环一次也不执行,直接跳过FORLOOP
+			vm.AddPC(sbx + 1)
+			return
+		}
+		vm.PushInteger(limit)
+		vm.Replace(a + 1)
+		vm.PushInteger(init - step)
+		vm.Replace(a)
+		vm.AddPC(sbx)
+		return
+	}
+
+	limit := _forNumber(vm, a+1, "limit")
+	step := _forNumber(vm, a+2, "step")
+	init := _forNumber(vm, a, "initial value")
+	for i, f := range []float64{init - step, limit, step} {
+		vm.PushFloat(f)
+		vm.Replace(a + i)
+	}
 	vm.AddPC(sbx)
 }
 
@@ -57,6 +89,18 @@ func forPrep(i Instruction, vm api.LuaVM) {
 func forLoop(i Instruction, vm api.LuaVM) {
 	a, sbx := i.AsBx()
 	a += 1
+	if vm.Version() >= api.LUA_VERSION_54 && vm.IsIntegerSubtype(a) {
+		//5.4整数循环,R(A+1)为剩余的循环次数
+		if count := uint64(vm.ToInteger(a + 1)); count > 0 {
+			vm.PushInteger(int64(count - 1))
+			vm.Replace(a + 1)
+			vm.PushInteger(vm.ToInteger(a) + vm.ToInteger(a+2))
+			vm.Replace(a)
+			vm.Copy(a, a+3)
+			vm.AddPC(sbx)
+		}
+		return
+	}
 	vm.PushValue(a + 2)
 	vm.PushValue(a)
 	vm.Arith(api.ArithOp_ADD)
@@ -78,6 +122,104 @@ func forLoop(i Instruction, vm api.LuaVM) {
 	vm.AddPC(sbx)
 }
 
+// 5.4的数值for循环
+// init与step都为整数时为整数循环,limit为浮点数时向循环方向取整,超出整数范围时截断为最大/最小整数
+// FORPREP预先计算循环次数存放于R(A+1),之后FORLOOP只需递减次数,所以循环变量不会因溢出而回绕导致无法结束
+// 否则三个值都转换为浮点数,与5.3相同
+// step为0时报错;循环需要执行时FORPREP直接进入循环体,否则跳过FORLOOP
+func forPrep54(a, sbx int, vm api.LuaVM) {
+	if vm.IsIntegerSubtype(a) && vm.IsIntegerSubtype(a+2) {
+		init, step := vm.ToInteger(a), vm.ToInteger(a+2)
+		if step == 0 {
+			_forError(vm, "'for' step is zero")
+		}
+		limit, skip := _forLimit(vm, a+1, init, step)
+		if skip {
+			vm.AddPC(sbx + 1)
+			return
+		}
+		var count uint64 //除第一次外的循环次数
+		if step > 0 {
+			count = uint64(limit) - uint64(init)
+			if step != 1 {
+				count /= uint64(step)
+			}
+		} else {
+			count = uint64(init) - uint64(limit)
+			count /= uint64(-(step + 1)) + 1 //-step可能溢出
+		}
+		vm.PushInteger(int64(count))
+		vm.Replace(a + 1)
+		vm.Copy(a, a+3)
+		return
+	}
+
+	limit := _forNumber(vm, a+1, "limit")
+	step := _forNumber(vm, a+2, "step")
+	init := _forNumber(vm, a, "initial value")
+	if step == 0 {
+		_forError(vm, "'for' step is zero")
+	}
+	if step > 0 && !(init <= limit) || step < 0 && !(limit <= init) {
+		vm.AddPC(sbx + 1)
+		return
+	}
+	for i, f := range []float64{init, limit, step} {
+		vm.PushFloat(f)
+		vm.Replace(a + i)
+	}
+	vm.Copy(a, a+3)
+}
+
+// 将整数循环的limit转换为整数,返回转换后的limit以及是否跳过循环
+func _forLimit(vm api.LuaVM, idx int, init, step int64) (int64, bool) {
+	limit := vm.ToInteger(idx)
+	if !vm.IsIntegerSubtype(idx) {
+		f := _forNumber(vm, idx, "limit")
+		if step < 0 {
+			f = math.Ceil(f)
+		} else {
+			f = math.Floor(f)
+		}
+		switch {
+		case math.IsNaN(f):
+			return 0, true
+		case f >= -math.MinInt64: //大于最大整数
+			if step < 0 {
+				return 0, true
+			}
+			limit = math.MaxInt64
+		case f < math.MinInt64:
+			if step > 0 {
+				return 0, true
+			}
+			limit = math.MinInt64
+		default:
+			limit = int64(f)
+		}
+	}
+	if step > 0 {
+		return limit, init > limit
+	}
+	return limit, init < limit
+}
+
+func _forNumber(vm api.LuaVM, idx int, what string) float64 {
+	f, ok := vm.ToFloatX(idx)
+	if !ok {
+		_forError(vm, fmt.Sprintf("'for' %s must be a number", what))
+	}
+	return f
+}
+
+// 抛出带有当前lua函数位置信息的错误
+func _forError(vm api.LuaVM, msg string) {
+	vm.Where(0)
+	vm.PushString(msg)
+	vm.Concat(2)
+	vm.Error()
+}
+
 /*
 	TFORCALL和TFORLOOP指令协同工作共同实现lua中通用for的功能
 	TFORCALL 负责调用迭代器即pairs方法并获取返回值 | R(A+3), ... ,R(A+2+C) := R(A)(R(A+1), R(A+2))
@@ -30,6 +30,13 @@ func jump(i Instruction, vm api.LuaVM) {
 	}
 }
 
+// mark R(A) as to-be-closed
+// local x <close> = v,离开x的作用域时调用v的__close元方法
+func tbc(i Instruction, vm api.LuaVM) {
+	a, _, _ := i.ABC()
+	vm.ToClose(a + 1)
+}
+
 func valLen(i Instruction, vm api.LuaVM) {
 	a, b, _ := i.ABC()
 	vm.Len(b + 1)
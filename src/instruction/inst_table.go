@@ -42,11 +42,11 @@ func setList(i Instruction, vm api.LuaVM) {
 	a, b, c := i.ABC()
 	a += 1
 
-	//判断该命令是否使用拓展
+	//判断该命令是否使用拓展,两种情况下的批数都从1开始计数
 	if c > 0 {
 		c = c - 1
 	} else {
-		c = Instruction(vm.Fetch()).Ax()
+		c = Instruction(vm.Fetch()).Ax() - 1
 	}
 
 	bIsZero := b == 0
@@ -38,6 +38,7 @@ const (
 const ConstantBase = 0x100
 
 const MAX_BX = 1<<18 - 1
+const MAX_C = 1<<9 - 1
 const MAX_SBX = MAX_BX >> 1
 
 func (code Instruction) opcode() opcode {
@@ -48,6 +49,16 @@ func (code Instruction) opcode() opcode {
 	return instructions[op]
 }
 
+// 获取指令的操作码,即OP_XXX
+func (code Instruction) Opcode() int {
+	return int(code & 0x3F)
+}
+
+// 指令是否会修改R(A),用于debug时推断寄存器中值的来源
+func (code Instruction) SetsA() bool {
+	return code.opcode().argAMode == ArgR && code.Opcode() != OP_SETUPVAL
+}
+
 // 获取uint32 code对应的指令结构类型
 func (code Instruction) OpMode() int {
 	return code.opcode().opMode
@@ -62,6 +73,40 @@ func (code Instruction) Execute(vm api.LuaVM) {
 	h(code, vm)
 }
 
+// 完成被中断的指令
+//
+// 指令调用的函数(CALL的被调函数、元方法或迭代器)没有在指令中返回时(被调函数为lua函数或者在其中yield),
+// 该函数返回后其返回值已经压入栈顶,由Finish完成指令剩余的部分
+func (code Instruction) Finish(vm api.LuaVM) {
+	a, _, c := code.ABC()
+	switch code.Opcode() {
+	case OP_CALL:
+		_popResults(a+1, c, vm)
+	case OP_TAILCALL:
+		_popResults(a+1, 0, vm)
+	case OP_TFORCALL:
+		for i := a + c + 3; i >= a+4; i-- {
+			vm.Replace(i)
+		}
+	case OP_GETTABUP, OP_GETTABLE, OP_SELF, OP_LEN, OP_UNM, OP_BNOT,
+		OP_ADD, OP_SUB, OP_MUL, OP_MOD, OP_POW, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR:
+		vm.Replace(a + 1)
+	case OP_CONCAT:
+		//栈中剩余的值(包括元方法的结果)还需要继续连接
+		vm.Concat(vm.GetTop() - vm.RegisterCount())
+		vm.Replace(a + 1)
+	case OP_EQ, OP_LT, OP_LE:
+		//栈顶为元方法的结果,其下为两个比较的值
+		res := vm.ToBoolean(0)
+		vm.Pop(3)
+		if res != (a != 0) {
+			vm.AddPC(1)
+		}
+	}
+	//OP_SETTABUP、OP_SETTABLE的元方法没有返回值,不需要再处理
+}
+
 func printStack(s api.LuaVM) {
 	for i := 1; i <= s.RegisterCount(); i++ {
 		tp := s.Type(i)
@@ -48,6 +48,7 @@ const (
 	OP_CLOSURE
 	OP_VARARG
 	OP_EXTRAARG
+	OP_TBC //5.4的待关闭变量,5.3的指令集中没有该指令
 )
 
 // 数组索引与上面一一对应
@@ -116,4 +117,5 @@ var instructions = []opcode{
 	// EXTRAARG指令与其他指令搭配使用的且是第二顺位的指令，
 	// 所以可以在第一顺位的指令处理中就处理了，因此不需要额外的处理函数
 	{0, ArgU, ArgU, ArgU, IAx, "EXTRAARG", nil},
+	{0, ArgR, ArgN, ArgN, IABC, "TBC     ", tbc}, // mark R(A) as to-be-closed
 }
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
+	"nskbz.cn/lua/api"
 	"nskbz.cn/lua/binchunk"
 	"nskbz.cn/lua/compile"
 	"nskbz.cn/lua/compile/lexer"
@@ -15,17 +18,110 @@ import (
 	"nskbz.cn/lua/tool"
 )
 
+const (
+	LUA_PROGNAME = "lua"
+	LUA_VERSION  = "Lua 5.3"
+	LUA_PROMPT   = "> "  //REPL的默认提示符
+	LUA_PROMPT2  = ">> " //REPL中多行输入时的提示符
+	EOFMARK      = "<eof>"
+)
+
+var progname = LUA_PROGNAME //输出错误信息时的前缀,REPL中不使用前缀
+
+var version = api.LUA_VERSION_53 //-std指定的语言版本
+
+// -e与-l需要按照在命令行中出现的顺序执行
+type action struct {
+	opt, val string
+}
+
+type actionFlag struct {
+	opt     string
+	actions *[]action
+}
+
+func (f actionFlag) String() string { return "" }
+
+func (f actionFlag) Set(val string) error {
+	*f.actions = append(*f.actions, action{f.opt, val})
+	return nil
+}
+
 func main() {
 
-	var c bool
+	var c, j, i, v bool
+	var o, std string
+	var actions []action
 	flag.BoolVar(&c, "c", false, "是否只是编译")
+	flag.StringVar(&o, "o", "luac.out", "编译输出的二进制文件名")
+	flag.BoolVar(&j, "j", false, "只编译并于stdout输出json格式的函数原型")
 	flag.IntVar(&tool.LogLevel, "d", tool.LOG_DEFAULT, "log输出信息级别")
+	flag.Var(actionFlag{"e", &actions}, "e", "执行字符串stat")
+	flag.Var(actionFlag{"l", &actions}, "l", "加载库mod,即require(mod)并将结果赋给全局变量mod")
+	flag.BoolVar(&i, "i", false, "执行完script后进入交互模式")
+	flag.BoolVar(&v, "v", false, "输出版本信息")
+	flag.StringVar(&std, "std", "5.3", "语言版本,5.3或5.4")
 	flag.Parse()
-	if len(flag.Args()) == 0 {
-		panic("no specified file!!!")
+
+	switch std {
+	case "5.3":
+	case "5.4":
+		version = api.LUA_VERSION_54
+	default:
+		fmt.Fprintf(os.Stderr, "%s: unsupported language version '%s'\n", progname, std)
+		os.Exit(1)
+	}
+
+	if c || j {
+		if len(flag.Args()) == 0 {
+			panic("no specified file!!!")
+		}
+		compileOnly(flag.Arg(0), c, o)
+		return
+	}
+
+	vm := state.New() //Main协程才会通过new生成vm
+	vm.SetVersion(version)
+	vm.OpenLibs()
+	vm.PushGoFunction(msgHandler, 0) //错误处理函数位于索引1
+	createArgTable(vm)
+
+	if v || i { //与lua.c一样,-i同样先输出版本信息
+		printVersion()
+	}
+	for _, a := range actions {
+		var status int
+		if a.opt == "e" {
+			status = doString(vm, a.val, "=(command line)")
+		} else {
+			status = doLibrary(vm, a.val)
+		}
+		if status != api.LUA_OK {
+			os.Exit(1)
+		}
+	}
+	if len(flag.Args()) > 0 { //第一个非'-'参数为script,其后为script的参数
+		if handleScript(vm, flag.Args()) != api.LUA_OK {
+			os.Exit(1)
+		}
 	}
-	chunk := flag.Arg(0) //第一个非'-'参数必须为文件名
 
+	if i {
+		doREPL(vm)
+	} else if len(flag.Args()) == 0 && len(actions) == 0 && !v {
+		if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+			printVersion()
+			doREPL(vm)
+		} else {
+			if dochunk(vm, loadFile(vm, "")) != api.LUA_OK { //标准输入不是终端则将其作为文件执行
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// 只进行编译操作
+func compileOnly(chunk string, c bool, o string) {
 	f, err := os.Open(chunk)
 	if err != nil {
 		panic(err.Error())
@@ -35,28 +131,249 @@ func main() {
 		panic(err.Error())
 	}
 
-	if c { //只进行编译操作，则于stdout输出json格式
-		proto := compile.Compile(data, chunk)
-		// to do 生成可供LUA虚拟机执行的二进制文件
-		protoInfo := binchunk.ProtoToProtoInfo(proto)
-		fmt.Printf("chunck file====>[%s]\n\n", chunk)
-		s, err := json.Marshal(protoInfo)
+	if c { //生成可供LUA虚拟机执行的二进制文件
+		proto, err := compile.Compile(data, "@"+chunk, version)
 		if err != nil {
+			fmt.Fprintf(os.Stderr, "luac: %s\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(o, proto.ToBytes(), 0644); err != nil {
 			panic(err.Error())
 		}
-		fmt.Println(string(s))
 		return
 	}
 
-	//没有-c参数,则文件有可能是lua二进制文件,也有可能是lua源文件
-	vm := state.New() //Main协程才会通过new生成vm
-	vm.OpenLibs()
-	vm.LoadFile(chunk)
-	vm.Call(0, -1)
+	//于stdout输出json格式
+	proto, err := compile.Compile(data, chunk, version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "luac: %s\n", err)
+		os.Exit(1)
+	}
+	protoInfo := binchunk.ProtoToProtoInfo(proto)
+	fmt.Printf("chunck file====>[%s]\n\n", chunk)
+	s, err := json.Marshal(protoInfo)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println(string(s))
+}
+
+func printVersion() {
+	if version == api.LUA_VERSION_54 {
+		fmt.Println("Lua 5.4")
+		return
+	}
+	fmt.Println(LUA_VERSION)
+}
+
+// 创建全局表arg,script位于索引0,其参数依次位于正索引,解释器及选项位于负索引
+// 没有script时解释器位于索引0
+func createArgTable(vm api.LuaVM) {
+	script := len(os.Args) - len(flag.Args())
+	if script == len(os.Args) {
+		script = 0
+	}
+	vm.CreateTable(len(os.Args)-script-1, script+1)
+	for i, arg := range os.Args {
+		vm.PushString(arg)
+		vm.SetI(-1, int64(i-script))
+	}
+	vm.SetGlobal("arg")
+}
+
+// 输出错误信息
+func report(vm api.LuaVM, status int) int {
+	if status != api.LUA_OK {
+		msg := vm.ToString(0)
+		if progname != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", progname, msg)
+		} else {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		vm.Pop(1)
+	}
+	return status
+}
+
+// 以保护模式执行栈顶的函数及其nArgs个参数,错误处理函数msgHandler位于索引1
+func docall(vm api.LuaVM, nArgs, nResults int) int {
+	return vm.PCall(nArgs, nResults, true)
+}
+
+// 执行已加载的代码块
+func dochunk(vm api.LuaVM, status int) int {
+	if status == api.LUA_OK {
+		status = docall(vm, 0, 0)
+	}
+	return report(vm, status)
+}
+
+func doString(vm api.LuaVM, str, name string) int {
+	return dochunk(vm, loadChunk(vm, []byte(str), name))
+}
+
+// 加载代码块,语法错误时错误信息位于栈顶
+func loadChunk(vm api.LuaVM, chunk []byte, name string) int {
+	return vm.Load(chunk, name, "bt")
+}
+
+// 以保护模式加载文件,filename为空时读取标准输入
+func loadFile(vm api.LuaVM, filename string) int {
+	vm.PushGoFunction(func(vm api.LuaVM) int {
+		if status := vm.LoadFile(filename); status == api.LUA_ERR_SYNTAX {
+			return vm.Error() //错误信息已位于栈顶
+		} else if status != api.LUA_OK {
+			vm.PushString(fmt.Sprintf("cannot load %s", filename))
+			return vm.Error()
+		}
+		return 1
+	}, 0)
+	return vm.PCall(0, 1, false)
+}
+
+// 调用require(name)并将结果赋给全局变量name
+func doLibrary(vm api.LuaVM, name string) int {
+	vm.GetGlobal("require")
+	vm.PushString(name)
+	status := docall(vm, 1, 1)
+	if status == api.LUA_OK {
+		vm.SetGlobal(name)
+	}
+	return report(vm, status)
+}
+
+// 执行script,args[0]为script名称("-"表示标准输入),其余为script的参数
+func handleScript(vm api.LuaVM, args []string) int {
+	fname := args[0]
+	if fname == "-" {
+		fname = ""
+	}
+	status := loadFile(vm, fname)
+	if status == api.LUA_OK {
+		for _, arg := range args[1:] {
+			vm.PushString(arg)
+		}
+		status = docall(vm, len(args)-1, api.LUA_MULTRET)
+	}
+	return report(vm, status)
+}
+
+/*
+*	交互模式(REPL)
+ */
+
+// 获取提示符,可以通过全局变量_PROMPT与_PROMPT2修改
+func getPrompt(vm api.LuaVM, firstLine bool) string {
+	name, prompt := "_PROMPT", LUA_PROMPT
+	if !firstLine {
+		name, prompt = "_PROMPT2", LUA_PROMPT2
+	}
+	vm.GetGlobal(name)
+	if p, ok := vm.ToStringX(0); ok {
+		prompt = p
+	}
+	vm.Pop(1)
+	return prompt
+}
+
+// 读取一行输入,输入结束时返回false
+func readLine(vm api.LuaVM, in *bufio.Reader, firstLine bool) (string, bool) {
+	fmt.Print(getPrompt(vm, firstLine))
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// 语法错误是否由于代码块不完整,即错误出现在输入的结尾
+func incomplete(vm api.LuaVM, status int) bool {
+	if status == api.LUA_ERR_SYNTAX {
+		if msg, ok := vm.ToStringX(0); ok && strings.HasSuffix(msg, EOFMARK) {
+			vm.Pop(1)
+			return true
+		}
+	}
+	return false
+}
+
+// 读取并加载一条输入,以"="开头或者是表达式时打印其值,代码块不完整时继续读取下一行
+// 输入结束时返回false
+func loadLine(vm api.LuaVM, in *bufio.Reader) (int, bool) {
+	line, ok := readLine(vm, in, true)
+	if !ok {
+		return 0, false
+	}
+	if strings.HasPrefix(line, "=") { //"=expr"兼容Lua 5.2之前的用法
+		line = "return " + line[1:]
+	}
+	//首先尝试作为表达式加载
+	if status := loadChunk(vm, []byte("return "+line), "=stdin"); status == api.LUA_OK {
+		return status, true
+	}
+	vm.Pop(1)
+	for {
+		status := loadChunk(vm, []byte(line), "=stdin")
+		if !incomplete(vm, status) {
+			return status, true
+		}
+		more, ok := readLine(vm, in, false)
+		if !ok { //输入结束时报告不完整的错误
+			return loadChunk(vm, []byte(line), "=stdin"), true
+		}
+		line += "\n" + more
+	}
+}
+
+// 调用print输出栈中的所有值
+func printResults(vm api.LuaVM) {
+	if n := vm.GetTop() - 1; n > 0 {
+		vm.CheckStack(api.LUA_MIN_STACK)
+		vm.GetGlobal("print")
+		vm.Insert(2)
+		if vm.PCall(n, 0, false) != api.LUA_OK {
+			fmt.Fprintf(os.Stderr, "%s: error calling 'print' (%s)\n", LUA_PROGNAME, vm.ToString(0))
+		}
+	}
+}
+
+func doREPL(vm api.LuaVM) {
+	progname = ""
+	in := bufio.NewReader(os.Stdin)
+	for {
+		status, ok := loadLine(vm, in)
+		if !ok {
+			break
+		}
+		if status == api.LUA_OK {
+			status = docall(vm, 0, api.LUA_MULTRET)
+		}
+		if status == api.LUA_OK {
+			printResults(vm)
+		} else {
+			report(vm, status)
+		}
+		vm.SetTop(1) //只保留错误处理函数
+	}
+	fmt.Println()
+	progname = LUA_PROGNAME
+}
+
+// 为错误信息添加调用栈回溯信息
+func msgHandler(vm api.LuaVM) int {
+	msg, ok := vm.ToStringX(1)
+	if !ok { //错误值不是字符串
+		if vm.CallMeta(1, "__tostring") && vm.Type(0) == api.LUAVALUE_STRING {
+			return 1 //有__tostring元方法则直接使用其结果
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", vm.TypeName2(1))
+	}
+	vm.Traceback(vm, msg, 1)
+	return 1
 }
 
 func testParser(data []byte, name string) {
-	ast := parser.Parse(data, name)
+	ast := parser.Parse(data, name, version)
 	b, err := json.MarshalIndent(ast, "", "  ")
 	if err != nil {
 		panic(err)
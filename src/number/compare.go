@@ -0,0 +1,66 @@
+package number
+
+import "math"
+
+/*
+*	整数与浮点数之间的比较(参考lvm.c中的LTintfloat等)
+*
+*	不能简单地将整数转换为浮点数再比较,超过2^53的整数转换时会丢失精度,例如math.maxinteger < 2^63
+*	浮点数可以转换为整数时按照整数比较,否则浮点数超出了整数的范围(或为NaN),由其符号决定结果
+ */
+
+// 整数可以精确表示为浮点数的范围
+const maxIntFitsFloat = 1 << 53
+
+func _fitsFloat(i int64) bool {
+	return -maxIntFitsFloat <= i && i <= maxIntFitsFloat
+}
+
+func EqIntFloat(i int64, f float64) bool {
+	fi, ok := FloatToInteger(f)
+	return ok && fi == i
+}
+
+// i < f
+func LtIntFloat(i int64, f float64) bool {
+	if _fitsFloat(i) {
+		return float64(i) < f
+	}
+	if fi, ok := FloatToInteger(math.Ceil(f)); ok { //i < f <=> i < ceil(f)
+		return i < fi
+	}
+	return f > 0
+}
+
+// i <= f
+func LeIntFloat(i int64, f float64) bool {
+	if _fitsFloat(i) {
+		return float64(i) <= f
+	}
+	if fi, ok := FloatToInteger(math.Floor(f)); ok { //i <= f <=> i <= floor(f)
+		return i <= fi
+	}
+	return f > 0
+}
+
+// f < i
+func LtFloatInt(f float64, i int64) bool {
+	if _fitsFloat(i) {
+		return f < float64(i)
+	}
+	if fi, ok := FloatToInteger(math.Floor(f)); ok { //f < i <=> floor(f) < i
+		return fi < i
+	}
+	return f < 0
+}
+
+// f <= i
+func LeFloatInt(f float64, i int64) bool {
+	if _fitsFloat(i) {
+		return f <= float64(i)
+	}
+	if fi, ok := FloatToInteger(math.Ceil(f)); ok { //f <= i <=> ceil(f) <= i
+		return fi <= i
+	}
+	return f < 0
+}
@@ -0,0 +1,61 @@
+package number
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 与官方实现LUAI_NUMFFORMAT一致的浮点数格式
+const FLOAT_FORMAT = "%.14g"
+
+const UTF8BUFFSZ = 8 //一个码点编码后的最大字节数
+
+func IntegerToString(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+// 按照%.14g格式化浮点数,看起来像整数时追加".0"(如3.0、1e+100不追加)
+// 无穷大为inf/-inf,NaN根据符号位为nan/-nan
+func FloatToString(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+	s := fmt.Sprintf(FLOAT_FORMAT, f)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// 将码点编码为UTF-8,移植自官方luaO_utf8esc
+// 与Go的utf8.EncodeRune不同,代理区的码点也会被编码,x最大为0x7FFFFFFF(最多6字节)
+func UTF8Encode(x uint32) string {
+	buff := [UTF8BUFFSZ]byte{}
+	n := 1 //已写入的字节数,从后往前写
+	if x < 0x80 {
+		buff[UTF8BUFFSZ-1] = byte(x)
+	} else {
+		mfb := uint32(0x3f) //首字节能容纳的最大值
+		for {
+			buff[UTF8BUFFSZ-n] = byte(0x80 | (x & 0x3f))
+			n++
+			x >>= 6
+			mfb >>= 1
+			if x <= mfb {
+				break
+			}
+		}
+		buff[UTF8BUFFSZ-n] = byte((^mfb << 1) | x) //首字节
+	}
+	return string(buff[UTF8BUFFSZ-n:])
+}
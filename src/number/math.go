@@ -11,30 +11,47 @@ func AbsInt(n int) int {
 
 // 整除 向下取整 a/b
 func IntegerDiv(a, b int64) int64 {
-	if a%b == 0 || a*b > 0 {
-		return a / b
+	if a%b != 0 && (a^b) < 0 { //不能整除且符号相反时向下取整,不用a*b判断符号以免溢出
+		return a/b - 1
 	}
-	return a/b - 1
+	return a / b
 }
 func FloatDiv(a, b float64) float64 {
 	return math.Floor(a / b)
 }
 
 // 取模 向下取整 a%b
-func IntegerMod(a, b int64) int64   { return a - b*IntegerDiv(a, b) }
-func FloatMod(a, b float64) float64 { return a - b*FloatDiv(a, b) }
+func IntegerMod(a, b int64) int64 {
+	m := a % b
+	if m != 0 && (m^b) < 0 { //余数与除数符号相反时修正
+		m += b
+	}
+	return m
+}
 
+// 与官方luai_nummod一致,避免a - b*floor(a/b)在inf等边界情况下的误差
+func FloatMod(a, b float64) float64 {
+	m := math.Mod(a, b)
+	if m != 0 && (m > 0) != (b > 0) {
+		m += b
+	}
+	return m
+}
+
+// 与官方luaV_shiftl一致,n为负数时逻辑右移,位移量不小于64时结果为0
 func ShiftLeft(a, n int64) int64 {
-	if n > 0 {
+	switch {
+	case n <= -64 || n >= 64:
+		return 0
+	case n >= 0:
 		return a << uint64(n)
+	default:
+		return int64(uint64(a) >> uint64(-n))
 	}
-	return ShiftRight(a, -n)
 }
 
+// 右移n位即左移-n位,n为math.MinInt64时-n仍为负数且不小于64位,结果同样为0
 func ShiftRight(a, n int64) int64 {
-	if n > 0 {
-		return int64(uint64(a) >> uint64(n))
-	}
 	return ShiftLeft(a, -n)
 }
 
@@ -42,6 +59,9 @@ func ShiftRight(a, n int64) int64 {
 类型转换
 */
 func FloatToInteger(f float64) (int64, bool) {
+	if !(f >= -(1<<63) && f < 1<<63) { //超出int64范围(包括NaN)时转换的结果是未定义的
+		return 0, false
+	}
 	i := int64(f)
 	return i, float64(i) == f
 }
@@ -1,12 +1,46 @@
 package number
 
 import (
+	"errors"
 	"strconv"
+	"strings"
 
 	"nskbz.cn/lua/tool"
 )
 
+/*
+*	lua数字的字符串形式(参考lobject.c中的l_str2int与l_str2d)
+*
+*	整数:十进制或0x开头的十六进制,十六进制超出范围时回绕,十进制超出范围时不是整数(转而按浮点数处理)
+*	浮点数:十进制形式以及0x开头、可选p指数的十六进制形式,不接受inf与nan
+*	均可以带有一个符号,调用者负责去除首尾的空白字符
+ */
+
+// 字符串转换为数字时允许首尾存在的空白字符
+const SPACES = " \f\n\r\t\v"
+
 func ParseInteger(str string) (int64, bool) {
+	s, neg := _cutSign(str)
+	if hex, ok := _cutHexPrefix(s); ok {
+		if hex == "" {
+			return 0, false
+		}
+		var u uint64
+		for i := 0; i < len(hex); i++ {
+			d, ok := _hexDigit(hex[i])
+			if !ok {
+				return 0, false
+			}
+			u = u<<4 | uint64(d) //溢出时回绕
+		}
+		if neg {
+			u = -u
+		}
+		return int64(u), true
+	}
+	if s == "" || s[0] == '+' || s[0] == '-' {
+		return 0, false
+	}
 	i, err := strconv.ParseInt(str, 10, 64)
 	if err != nil {
 		tool.Debug("%s", err.Error())
@@ -15,9 +49,51 @@ func ParseInteger(str string) (int64, bool) {
 }
 
 func ParseFloat(str string) (float64, bool) {
+	//strconv还接受inf、nan以及下划线分隔的数字
+	if strings.ContainsAny(str, "nN_") {
+		return 0, false
+	}
+	s, _ := _cutSign(str)
+	if hex, ok := _cutHexPrefix(s); ok {
+		if hex == "" || hex[0] == '+' || hex[0] == '-' {
+			return 0, false
+		}
+		if !strings.ContainsAny(hex, "pP") { //strconv要求十六进制浮点数必须有p指数
+			str += "p0"
+		}
+	}
 	f, err := strconv.ParseFloat(str, 64)
+	if errors.Is(err, strconv.ErrRange) { //与strtod一致,溢出时为±HUGE_VAL
+		return f, true
+	}
 	if err != nil {
 		tool.Debug("%s", err.Error())
 	}
 	return f, err == nil
 }
+
+func _cutSign(str string) (string, bool) {
+	if str != "" && (str[0] == '-' || str[0] == '+') {
+		return str[1:], str[0] == '-'
+	}
+	return str, false
+}
+
+func _cutHexPrefix(str string) (string, bool) {
+	if len(str) >= 2 && str[0] == '0' && (str[1] == 'x' || str[1] == 'X') {
+		return str[2:], true
+	}
+	return str, false
+}
+
+func _hexDigit(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
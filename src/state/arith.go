@@ -11,21 +11,16 @@ type intFunc func(int64, int64) int64
 type floatFunc func(float64, float64) float64
 
 var (
-	iadd = func(a, b int64) int64 { return a + b }
-	fadd = func(a, b float64) float64 { return a + b }
-	isub = func(a, b int64) int64 { return a - b }
-	fsub = func(a, b float64) float64 { return a - b }
-	imul = func(a, b int64) int64 { return a * b }
-	fmul = func(a, b float64) float64 { return a * b }
-	imod = number.IntegerMod
-	fmod = number.FloatMod
-	pow  = math.Pow
-	div  = func(a, b float64) float64 {
-		if b == 0 {
-			panic("The dividend cannot be zero")
-		}
-		return a / b
-	}
+	iadd      = func(a, b int64) int64 { return a + b }
+	fadd      = func(a, b float64) float64 { return a + b }
+	isub      = func(a, b int64) int64 { return a - b }
+	fsub      = func(a, b float64) float64 { return a - b }
+	imul      = func(a, b int64) int64 { return a * b }
+	fmul      = func(a, b float64) float64 { return a * b }
+	imod      = number.IntegerMod
+	fmod      = number.FloatMod
+	pow       = math.Pow
+	div       = func(a, b float64) float64 { return a / b } //浮点除以0结果为inf或nan
 	iidiv     = number.IntegerDiv
 	fidiv     = number.FloatDiv
 	and       = func(a, b int64) int64 { return a & b }
@@ -47,53 +42,53 @@ type operation struct {
 func doUnitaryArith(val luaValue, op operation, ls *luaState) (luaValue, bool) {
 	if op.f == nil { //位运算 not
 		if x, ok := convertToInteger(val); ok {
-			return op.i(x, 0), true
+			return intValue(op.i(x, 0)), true
 		}
 	} else { //数字运算 opposite
 		if op.i != nil {
-			if x, ok := val.(int64); ok {
-				return op.i(x, 0), true
+			if x, ok := val.asInteger(); ok {
+				return intValue(op.i(x, 0)), true
 			}
 		}
 		if x, ok := convertToFloat(val); ok {
-			return op.f(x, 0), true
+			return floatValue(op.f(x, 0)), true
 		}
 	}
 
 	//上面转换不行则执行该类型的元方法
-	if c := getMetaClosure(ls, op.m, val); c != nil {
+	if c := getMetaClosure(ls, op.m, val); !c.isNil() {
 		return callMetaClosure(ls, c, 1, val)[0], true
 	}
-	return nil, false
+	return nilValue, false
 }
 
 func doDualArith(a, b luaValue, op operation, ls *luaState) (luaValue, bool) {
 	if op.f == nil { //位运算
 		if x, ok := convertToInteger(a); ok {
 			if y, ok := convertToInteger(b); ok {
-				return op.i(x, y), true
+				return intValue(op.i(x, y)), true
 			}
 		}
 	} else { //数字运算
-		if op.i != nil { //add,sub,mul,mod,idiv,opposite
-			if x, ok := a.(int64); ok {
-				if y, ok := b.(int64); ok {
-					return op.i(x, y), true
-				}
+		if op.i != nil && a.is(TAG_INTEGER) && b.is(TAG_INTEGER) { //add,sub,mul,mod,idiv,opposite
+			x, y := a.integer(), b.integer()
+			if y == 0 && (op.m == META_MOD || op.m == META_IDIV) {
+				ls.runError("attempt to perform 'n%s0'", map[string]string{META_MOD: "%", META_IDIV: "//"}[op.m])
 			}
+			return intValue(op.i(x, y)), true
 		}
 		if x, ok := convertToFloat(a); ok {
 			if y, ok := convertToFloat(b); ok {
-				return op.f(x, y), true
+				return floatValue(op.f(x, y)), true
 			}
 		}
 	}
 
 	//上面转换不行则执行该类型的元方法
-	if c := getMetaClosure(ls, op.m, a, b); c != nil {
+	if c := getMetaClosure(ls, op.m, a, b); !c.isNil() {
 		return callMetaClosure(ls, c, 1, a, b)[0], true
 	}
-	return nil, false
+	return nilValue, false
 }
 
 var arith_operation = map[api.ArithOp]operation{
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 
 	"nskbz.cn/lua/api"
 	"nskbz.cn/lua/stdlib"
@@ -15,14 +14,69 @@ import (
 	Error-report functions
 */
 
+// 错误信息前会添加调用该Go函数的lua函数的位置信息
 func (s *luaState) Error2(format string, a ...interface{}) int {
-	s.PushString(fmt.Sprintf(format, a...))
+	s.PushString(s.where(1) + fmt.Sprintf(format, a...))
 	return s.Error()
 }
 
+// bad argument #arg to 'funcname' (extramsg),与官方luaL_argerror一致
+// 以方法形式调用时不计算self参数;无法从调用指令得到函数名时在已加载的库中查找
 func (s *luaState) ArgError(arg int, extraMsg string) int {
-	// bad argument #arg to 'funcname' (extramsg)
-	return s.Error2("bad argument %d to (%s)", arg, extraMsg)
+	ar := s.GetStack(0)
+	if ar == nil { //没有调用信息
+		return s.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+	s.GetInfo("n", ar)
+	if ar.NameWhat == "method" {
+		arg--
+		if arg == 0 { //self错误
+			return s.Error2("calling '%s' on bad self (%s)", ar.Name, extraMsg)
+		}
+	}
+	name := ar.Name
+	if name == "" {
+		if name = s.globalFuncName(s.stack.closure); name == "" {
+			name = "?"
+		}
+	}
+	return s.Error2("bad argument #%d to '%s' (%s)", arg, name, extraMsg)
+}
+
+// 在package.loaded中查找函数c,返回"模块名.函数名"形式的名称,全局函数不带"_G."前缀,找不到时返回""
+func (s *luaState) globalFuncName(c *closure) string {
+	loaded, ok := s.registry.getStr(api.LUA_LOADED_TABLE).asTable()
+	if !ok || c == nil {
+		return ""
+	}
+	for modName, mod := range loaded._map {
+		lib, ok := mod.asTable()
+		if !ok {
+			continue
+		}
+		for k, v := range lib._map {
+			if fn, ok := v.asClosure(); ok && fn == c {
+				if name, ok := k.asString(); ok {
+					if modName == stringValue("_G") {
+						return name
+					}
+					return fmt.Sprintf("%v.%s", modName, name)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// 参数类型错误:"typename expected, got typename"
+func (s *luaState) typeError(arg int, tname string) int {
+	got := "no value"
+	if s.IsLightUserdata(arg) { //与完整的userdata区分开
+		got = "light userdata"
+	} else if !s.IsNone(arg) {
+		got = s.TypeName2(arg)
+	}
+	return s.ArgError(arg, fmt.Sprintf("%s expected, got %s", tname, got))
 }
 
 /*
@@ -37,14 +91,14 @@ func (s *luaState) ArgCheck(cond bool, arg int, extraMsg string) {
 
 func (s *luaState) CheckAny(arg int) {
 	if api.LUAVALUE_NONE == s.Type(arg) {
-		s.ArgError(arg, fmt.Sprintf("arg[%d] is not a LuaValueType!", arg))
+		s.ArgError(arg, "value expected")
 	}
 }
 
 func (s *luaState) CheckType(arg int, t api.LuaValueType) {
 	at := s.Type(arg)
 	if t != at {
-		s.ArgError(arg, fmt.Sprintf("arg[%d](%s) is not LuaValue (%s) !", arg, s.TypeName(at), s.TypeName(t)))
+		s.typeError(arg, s.TypeName(t))
 	}
 }
 
@@ -61,29 +115,65 @@ func (s *luaState) CheckNumber(idx int) bool {
 }
 
 func (s *luaState) CheckInteger(arg int) int64 {
+	if s.IsNone(arg) {
+		s.typeError(arg, s.TypeName(api.LUAVALUE_NUMBER))
+	}
 	i, ok := s.ToIntegerX(arg)
 	if !ok {
-		s.CheckType(arg, api.LUAVALUE_NUMBER)
+		if _, isNum := s.ToFloatX(arg); isNum {
+			s.ArgError(arg, "number has no integer representation") //浮点数无法无损转换为整数
+		}
+		s.typeError(arg, s.TypeName(api.LUAVALUE_NUMBER))
 	}
 	return i
 }
 
 func (s *luaState) CheckFloat(arg int) float64 {
+	if s.IsNone(arg) {
+		s.typeError(arg, s.TypeName(api.LUAVALUE_NUMBER))
+	}
 	i, ok := s.ToFloatX(arg)
 	if !ok {
-		s.CheckType(arg, api.LUAVALUE_NUMBER)
+		s.typeError(arg, s.TypeName(api.LUAVALUE_NUMBER))
 	}
 	return i
 }
 
 func (s *luaState) CheckString(arg int) string {
+	if s.IsNone(arg) {
+		s.typeError(arg, s.TypeName(api.LUAVALUE_STRING))
+	}
 	i, ok := s.ToStringX(arg)
 	if !ok {
-		s.CheckType(arg, api.LUAVALUE_STRING)
+		s.typeError(arg, s.TypeName(api.LUAVALUE_STRING))
 	}
 	return i
 }
 
+func (s *luaState) CheckUdata(arg int, tname string) interface{} {
+	if u := s.TestUdata(arg, tname); u != nil {
+		return u
+	}
+	s.typeError(arg, tname)
+	return nil
+}
+
+func (s *luaState) TestUdata(arg int, tname string) interface{} {
+	if s.IsNone(arg) || s.Type(arg) != api.LUAVALUE_USERDATA {
+		return nil
+	}
+	if !s.GetMetaTable(arg) { //没有元表
+		return nil
+	}
+	s.GetMetatableByName(tname)
+	same := s.RawEqual(-1, 0) //元表是否为registry[tname]
+	s.Pop(2)
+	if !same {
+		return nil
+	}
+	return s.ToUserdata(arg)
+}
+
 func (s *luaState) OptInteger(arg int, d int64) int64 {
 	if s.IsNoneOrNil(arg) {
 		return d
@@ -136,9 +226,12 @@ func (s *luaState) LoadFileX(filename, mode string) int {
 	}
 
 	//如果文件中的第一行以#开头，则忽略它
-	if data[0] == '#' {
+	if len(data) > 0 && data[0] == '#' {
 		return api.LUA_ERR_FILE
 	}
+	if len(filename) == 0 {
+		return s.Load(data, "=stdin", mode)
+	}
 	return s.Load(data, "@"+filename, mode)
 }
 
@@ -147,12 +240,8 @@ func (s *luaState) DoString(str string) bool {
 	return s.LoadString(str) != api.LUA_OK || s.PCall(0, api.LUA_MULTRET, false) != api.LUA_OK
 }
 
-var loadStringIdx int = 0
-
 func (s *luaState) LoadString(str string) int {
-	result := s.Load([]byte(str), "@string"+strconv.Itoa(loadStringIdx), "bt")
-	loadStringIdx++
-	return result
+	return s.Load([]byte(str), str, "bt") //与官方一致,以代码本身作为chunkname
 }
 
 /*
@@ -176,7 +265,8 @@ func (s *luaState) Len2(idx int) int64 {
 // 将给定索引的LuaValue转换字符串型。结果字符串压入堆栈，并由函数返回。如果该LuaValue存在元方法"__tostring",则应调用元方法
 func (s *luaState) ToString2(idx int) string {
 	idx = s.AbsIndex(idx)
-	if s.CallMeta(idx, META_TOSTRING) && s.Type(idx) == api.LUAVALUE_TABLE { //tostring方法只对table生效
+	//tostring方法只对table和userdata生效
+	if tp := s.Type(idx); (tp == api.LUAVALUE_TABLE || tp == api.LUAVALUE_USERDATA) && s.CallMeta(idx, META_TOSTRING) {
 		if str, ok := s.ToStringX(0); !ok {
 			s.Error2(META_TOSTRING + " must return a string")
 		} else {
@@ -202,13 +292,20 @@ func (s *luaState) ToString2(idx int) string {
 		}
 		s.PushString("false")
 	case api.LUAVALUE_NUMBER:
-		if i, ok := s.ToIntegerX(idx); ok {
-			s.PushString(fmt.Sprintf("%d", i))
-		} else if f, ok := s.ToFloatX(idx); ok {
-			s.PushString(fmt.Sprintf("%g", f))
-		}
+		s.PushString(s.ToString(idx))
 	case api.LUAVALUE_STRING:
 		s.PushValue(idx)
+	case api.LUAVALUE_LIGHTUSERDATA:
+		s.PushString(fmt.Sprintf("userdata: %p", s.ToUserdata(idx)))
+	case api.LUAVALUE_USERDATA:
+		name := "userdata"
+		if tp := s.GetMetafield(idx, META_NAME); tp != api.LUAVALUE_NIL {
+			if tp == api.LUAVALUE_STRING { //优先使用元表中的__name作为类型名
+				name = s.ToString(0)
+			}
+			s.Pop(1)
+		}
+		s.PushString(fmt.Sprintf("%s: %p", name, s.ToPointer(idx)))
 	default:
 		s.PushString(fmt.Sprintf("%s: %p", s.Type(idx).String(), s.ToPointer(idx)))
 
@@ -272,17 +369,22 @@ func (s *luaState) CallMeta(obj int, e string) bool {
 	return false
 }
 
+// 所有标准库的加载方法,key为库名
+var stdLibs = map[string]api.GoFunc{
+	"_G":        stdlib.OpenBaseLib,
+	"math":      stdlib.OpenMathLib,
+	"table":     stdlib.OpenTableLib,
+	"string":    stdlib.OpenStringLib,
+	"os":        stdlib.OpenOsLib,
+	"package":   stdlib.OpenPackageLib,
+	"coroutine": stdlib.OpenCoroutineLib,
+	"io":        stdlib.OpenIoLib,
+	"debug":     stdlib.OpenDebugLib,
+	"utf8":      stdlib.OpenUtf8Lib,
+}
+
 func (s *luaState) OpenLibs() {
-	libs := map[string]api.GoFunc{
-		"_G":        stdlib.OpenBaseLib,
-		"math":      stdlib.OpenMathLib,
-		"table":     stdlib.OpenTableLib,
-		"string":    stdlib.OpenStringLib,
-		"os":        stdlib.OpenOsLib,
-		"package":   stdlib.OpenPackageLib,
-		"coroutine": stdlib.OpenCoroutineLib,
-	}
-	for lib, funcs := range libs {
+	for lib, funcs := range stdLibs {
 		s.RequireF(lib, funcs, true) //golbal==true,即所有库都会加入全局表'_G'中
 		s.Pop(1)
 	}
@@ -314,6 +416,30 @@ func (s *luaState) RequireF(modname string, openf api.GoFunc, glb bool) {
 	}
 }
 
+// 如果registry[tname]已存在则压入该值并返回false
+// 否则创建带有__name=tname的元表,存入registry[tname]并压入栈,返回true
+func (s *luaState) NewMetatable(tname string) bool {
+	if s.GetMetatableByName(tname) != api.LUAVALUE_NIL {
+		return false //元表已存在
+	}
+	s.Pop(1)
+	s.CreateTable(0, 2)
+	s.PushString(tname)
+	s.SetField(-1, META_NAME) //mt.__name = tname
+	s.PushValue(0)
+	s.SetField(api.LUA_REGISTRY_INDEX, tname) //registry[tname] = mt
+	return true
+}
+
+func (s *luaState) GetMetatableByName(tname string) api.LuaValueType {
+	return s.GetField(api.LUA_REGISTRY_INDEX, tname)
+}
+
+func (s *luaState) SetMetatableByName(tname string) {
+	s.GetMetatableByName(tname)
+	s.SetMetaTable(-1)
+}
+
 // 通过funcs创建一个在栈顶的模块(table)
 func (s *luaState) NewLib(funcs map[string]api.GoFunc) {
 	s.NewTable()
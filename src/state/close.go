@@ -0,0 +1,116 @@
+package state
+
+import (
+	"nskbz.cn/lua/api"
+)
+
+/*
+*	待关闭变量(5.4)
+*
+*	local x <close> = v 通过TBC指令将x所在的寄存器记录到函数栈的tbcs中
+*	离开x的作用域(JMP指令的A参数)、函数返回以及出错时按照与标记相反的顺序调用v的__close(v, err)
+*	正常离开作用域时err为nil;出错时err为错误值,__close本身出错时以新的错误替换err并继续关闭剩余的变量
+*
+*	coroutine.close关闭挂起的协程时,以nil为错误值展开该协程的调用栈,关闭途经的捕获变量与待关闭变量
+ */
+
+func (s *luaState) ToClose(idx int) {
+	absIdx := s.AbsIndex(idx)
+	val := s.stack.get(absIdx)
+	if !convertToBoolean(val) {
+		return
+	}
+	if getMetaClosure(s, META_CLOSE, val).isNil() {
+		name := "?"
+		if c := s.stack.closure; c != nil && c.proto != nil {
+			if n := getLocalName(c.proto, absIdx, s.stack.currentPC()); n != "" {
+				name = n
+			}
+		}
+		s.runError("variable '%s' got a non-closable value", name)
+	}
+	s.stack.tbcs = append(s.stack.tbcs, absIdx)
+}
+
+// 关闭当前函数栈中索引>=level的待关闭变量,后标记的先关闭
+func (s *luaState) closeTBC(level int, err luaValue) {
+	stack := s.stack
+	for n := len(stack.tbcs); n > 0 && stack.tbcs[n-1] >= level; n = len(stack.tbcs) {
+		val := stack.slots[stack.tbcs[n-1]]
+		stack.tbcs = stack.tbcs[:n-1] //先移除,__close出错时不会被再次关闭
+		mc := getMetaClosure(s, META_CLOSE, val)
+		if _, ok := mc.asClosure(); !ok {
+			s.runError("metamethod 'close' is not callable (a %s value)", s.TypeName(typeOf(mc)))
+		}
+		s.nny++ //__close中不能yield
+		callMetaClosure(s, mc, 0, val, err)
+		s.nny--
+	}
+}
+
+// 出错时关闭当前函数栈的所有待关闭变量,返回最终的错误值
+func (s *luaState) closeTBCOnError(err luaValue) luaValue {
+	stack, nny, nCcalls := s.stack, s.nny, s.nCcalls
+	for len(stack.tbcs) > 0 {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err = s.errorValue(r)
+					s.nny, s.nCcalls = nny, nCcalls
+					for s.stack != stack { //恢复至出错的函数栈
+						s.popContext()
+					}
+				}
+			}()
+			s.closeTBC(0, err)
+		}()
+	}
+	return err
+}
+
+// 展开调用栈至caller,依次关闭途经函数栈的捕获变量与待关闭变量,返回最终的错误值
+// 捕获变量关闭后不再引用被弹出的函数栈,闭包仍可以正常读写它们
+func (s *luaState) unwind(caller *luaStack, err luaValue) luaValue {
+	for s.stack != caller {
+		s.closeUpvalues(1)
+		if len(s.stack.tbcs) > 0 {
+			err = s.closeTBCOnError(err)
+		}
+		s.popContext()
+	}
+	return err
+}
+
+func (s *luaState) CloseCoroutine(co api.LuaState) int {
+	ls := co.(*luaState)
+	switch status := ls.coStatus; status {
+	case api.LUA_RUNNING, api.LUA_NORMAL:
+		s.runError("cannot close a %s coroutine", map[int]string{api.LUA_RUNNING: "running", api.LUA_NORMAL: "normal"}[status])
+	case api.LUA_SUSPENDED:
+		if ls.stack == ls.base { //还未开始执行
+			ls.SetTop(0)
+			break
+		}
+		ls.coFather = s
+		ls.nCcalls = s.nCcalls + 1
+		ls.coStatus = api.LUA_RUNNING
+		s.coStatus = api.LUA_NORMAL
+		err := ls.unwind(ls.base, nilValue) //展开挂起的调用栈并关闭途经的待关闭变量
+		ls.nny = 0
+		ls.yielding = false
+		ls.SetTop(0)
+		s.coStatus = api.LUA_RUNNING
+		ls.coStatus = api.LUA_DEAD
+		if !err.isNil() {
+			ls.stack.push(err)
+			return api.LUA_ERR_RUN
+		}
+		return api.LUA_OK
+	case api.LUA_DEAD, api.LUA_OK:
+	default: //因出错而死亡,错误值仍位于栈顶
+		ls.coStatus = api.LUA_DEAD
+		return status
+	}
+	ls.coStatus = api.LUA_DEAD
+	return api.LUA_OK
+}
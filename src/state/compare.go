@@ -1,37 +1,29 @@
 package state
 
+import "nskbz.cn/lua/number"
+
 func doEq(a, b luaValue, ls *luaState) bool {
-	switch x := a.(type) {
-	case nil:
-		return b == nil
-	case bool:
-		y, ok := b.(bool)
-		return ok && y == x
-	case string:
-		y, ok := b.(string)
-		return ok && y == x
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x == y
-		case float64:
-			return float64(x) == y
-		default:
-			return false
+	switch a.tag() {
+	case TAG_INTEGER:
+		switch b.tag() {
+		case TAG_INTEGER:
+			return a.n == b.n
+		case TAG_FLOAT:
+			return number.EqIntFloat(a.integer(), b.float())
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x == y
-		case int64:
-			return int64(x) == y
-		default:
-			return false
+		return false
+	case TAG_FLOAT:
+		switch b.tag() {
+		case TAG_FLOAT:
+			return a.float() == b.float()
+		case TAG_INTEGER:
+			return number.EqIntFloat(b.integer(), a.float())
 		}
-	case *table: //表类型支持元方法
+		return false
+	case TAG_TABLE, TAG_USERDATA: //表和userdata支持元方法
 		//ls!=nil用于判断是否采用元方法。当ls不为nil时采用元方法;ls为nil时不采用元方法
-		if y, ok := b.(*table); ok && x != y && ls != nil {
-			if c := getMetaClosure(ls, META_EQ, a, b); c != nil {
+		if a.tag() == b.tag() && a != b && ls != nil {
+			if c := getMetaClosure(ls, META_EQ, a, b); !c.isNil() {
 				result := callMetaClosure(ls, c, 1, a, b)
 				return convertToBoolean(result[0])
 			}
@@ -40,71 +32,67 @@ func doEq(a, b luaValue, ls *luaState) bool {
 	return a == b
 }
 
-// 能比较大小的只有string,int64,float64
+// 能直接比较大小的只有string,int64,float64,其余类型使用元方法
 func doLt(a, b luaValue, ls *luaState) bool {
-	switch x := a.(type) {
-	case string:
-		if y, ok := b.(string); ok {
-			return x < y
+	switch a.tag() {
+	case TAG_STRING:
+		if y, ok := b.asString(); ok {
+			return a.o.(string) < y
 		}
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x < y
-		case float64:
-			return float64(x) < y
+	case TAG_INTEGER:
+		switch b.tag() {
+		case TAG_INTEGER:
+			return a.integer() < b.integer()
+		case TAG_FLOAT:
+			return number.LtIntFloat(a.integer(), b.float())
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x < y
-		case int64:
-			return int64(x) < y
-		}
-	case *table:
-		//ls!=nil用于判断是否采用元方法。当ls不为nil时采用元方法;ls为nil时不采用元方法
-		if y, ok := b.(*table); ok && x != y && ls != nil {
-			if c := getMetaClosure(ls, META_LT, a, b); c != nil {
-				result := callMetaClosure(ls, c, 1, a, b)
-				return convertToBoolean(result[0])
-			}
+	case TAG_FLOAT:
+		switch b.tag() {
+		case TAG_FLOAT:
+			return a.float() < b.float()
+		case TAG_INTEGER:
+			return number.LtFloatInt(a.float(), b.integer())
 		}
 	}
-	panic("can't compare_lt")
+	if c := getMetaClosure(ls, META_LT, a, b); !c.isNil() {
+		result := callMetaClosure(ls, c, 1, a, b)
+		return convertToBoolean(result[0])
+	}
+	ls.compareError(a, b)
+	return false
 }
 
 func doLe(a, b luaValue, ls *luaState) bool {
-	switch x := a.(type) {
-	case string:
-		if y, ok := b.(string); ok {
-			return x <= y
+	switch a.tag() {
+	case TAG_STRING:
+		if y, ok := b.asString(); ok {
+			return a.o.(string) <= y
 		}
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x <= y
-		case float64:
-			return float64(x) <= y
+	case TAG_INTEGER:
+		switch b.tag() {
+		case TAG_INTEGER:
+			return a.integer() <= b.integer()
+		case TAG_FLOAT:
+			return number.LeIntFloat(a.integer(), b.float())
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x <= y
-		case int64:
-			return int64(x) <= y
-		}
-	case *table:
-		//ls!=nil用于判断是否采用元方法。当ls不为nil时采用元方法;ls为nil时不采用元方法
-		if y, ok := b.(*table); ok && x != y && ls != nil {
-			if c := getMetaClosure(ls, META_LE, a, b); c != nil {
-				result := callMetaClosure(ls, c, 1, a, b)
-				return convertToBoolean(result[0])
-			} else if c := getMetaClosure(ls, META_LT, a, b); c != nil {
-				//a<=b equal !(b<a)
-				result := callMetaClosure(ls, c, 1, b, a)
-				return !convertToBoolean(result[0])
-			}
+	case TAG_FLOAT:
+		switch b.tag() {
+		case TAG_FLOAT:
+			return a.float() <= b.float()
+		case TAG_INTEGER:
+			return number.LeFloatInt(a.float(), b.integer())
 		}
 	}
-	panic("can't compare_le")
+	if c := getMetaClosure(ls, META_LE, a, b); !c.isNil() {
+		result := callMetaClosure(ls, c, 1, a, b)
+		return convertToBoolean(result[0])
+	} else if c := getMetaClosure(ls, META_LT, a, b); !c.isNil() {
+		//a<=b equal !(b<a),在__lt中yield时由finishOp对结果取反
+		ls.stack.lessEqual = true
+		result := callMetaClosure(ls, c, 1, b, a)
+		ls.stack.lessEqual = false
+		return !convertToBoolean(result[0])
+	}
+	ls.compareError(a, b)
+	return false
 }
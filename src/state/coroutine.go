@@ -0,0 +1,269 @@
+package state
+
+import (
+	"fmt"
+
+	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/instruction"
+	"nskbz.cn/lua/tool"
+)
+
+/*
+*	协程支持
+*
+*	所有协程运行在同一个goroutine中,每个协程拥有自己的函数栈链表,resume只是切换到另一条链表继续执行
+*	yield时协程的函数栈链表保持不变,只需要让Go的调用栈回到resume:
+*	由resume直接驱动的执行循环调用的Go函数yield时,沿着Go函数、CALL指令、执行循环依次正常返回;
+*	在元方法、迭代器或者Go函数调用的函数中yield时,通过panic(yieldSignal)展开中间的Go调用栈
+*
+*	协程恢复执行时由unroll依次完成被中断的函数:
+*	Go函数调用continuation(没有continuation时以resume的参数作为返回值),lua函数完成被中断的指令后继续执行
+*	没有continuation的Go函数(如table.sort、string.gsub)调用的函数不能yield
+ */
+
+// yield时用于展开Go调用栈的panic值
+type coroutineYield struct{}
+
+var yieldSignal = &coroutineYield{}
+
+// Go函数的continuation,由CallK、PCallK、YieldK设置
+type continuation struct {
+	k      api.KFunc
+	ctx    int
+	status int        //调用k时传入的状态
+	saved  []luaValue //YieldK时栈中不作为返回值的值,恢复执行时放回栈底
+
+	//PCallK的错误恢复信息,出错时由resume在该函数栈中恢复
+	pcall   bool //是否为PCallK的调用
+	base    int  //被调函数之下的栈顶
+	errh    bool //是否有错误处理函数(位于索引1)
+	nCcalls int  //调用时Go调用栈上嵌套的调用层数
+}
+
+func (s *luaState) isMainCoroutine() bool {
+	return s.registry.getInt(api.LUA_MAIN_COROUTING_RIDX) == coroutineValue(s)
+}
+
+// 创建coroutine,与创建该coroutine的协程共享registry,全局表也是属于registry的所以全局变量也是共享的
+func (s *luaState) NewCoroutine() api.LuaState {
+	ls := &luaState{registry: s.registry, limit: s.limit, version: s.version}
+	ls.stack = newLuaStack(api.LUA_MIN_STACK, ls)
+	ls.base = ls.stack
+	ls.coStatus = api.LUA_SUSPENDED  //新创建的coroutine初始状态为挂起
+	s.stack.push(coroutineValue(ls)) //将新创建的coroutine压入栈
+	//新协程继承创建者的hook
+	ls.SetHook(s.hook, s.hookMask, s.baseHookCount)
+	return ls
+}
+
+// 将当前coroutine推入栈,并返回是否为主coroutine
+func (s *luaState) PushCoroutine() bool {
+	s.stack.push(coroutineValue(s))
+	return s.isMainCoroutine()
+}
+
+// 将指定索引的LuaValue转换为coroutine返回,如果是其他类型则返回nil
+func (s *luaState) ToCoroutine(idx int) api.LuaState {
+	absIdx := s.AbsIndex(idx)
+	val := s.stack.get(absIdx)
+	if typeOf(val) != api.LUAVALUE_COROUTINE {
+		return nil
+	}
+	ls, ok := val.asCoroutine()
+	if !ok {
+		tool.Fatal(s, fmt.Sprintf("%v convert to luaState error!", ls))
+	}
+	return ls
+}
+
+// 从当前coroutine弹出n个元素压入to(coroutine)中
+func (s *luaState) XMove(to api.LuaState, n int) {
+	if n < 0 {
+		tool.Error("n must over 0!")
+	} else if n == 0 {
+		return
+	}
+	values := s.stack.popN(n)
+	to.(*luaState).stack.pushN(values, n)
+}
+
+func (s *luaState) IsYieldable() bool {
+	if s.isMainCoroutine() { //主协程不能yield
+		return false
+	}
+	return s.coStatus == api.LUA_RUNNING && s.nny == 0
+}
+
+// 获取当前协程状态
+func (s *luaState) Status() int {
+	return s.coStatus
+}
+
+// 启动或恢复一个协程的控制权,当前协程会被挂起
+// co：要恢复的协程（由 coroutine.create 创建）
+// nArgs: 参数个数
+func (s *luaState) Resume(co api.LuaState, nArgs int) int {
+	pending := co.(*luaState)
+	//resume同样占用Go的调用栈,协程无限递归地resume其他协程时报错
+	if s.nCcalls >= api.LUAI_MAXCCALLS {
+		pending.stack.popN(nArgs)
+		pending.stack.push(stringValue("C stack overflow"))
+		return api.LUA_ERR_RUN
+	}
+	pending.nCcalls = s.nCcalls + 1
+	pending.coFather = s //当前协程应当为父协程
+
+	s.coStatus = api.LUA_NORMAL
+	status := pending.resume(nArgs)
+	s.coStatus = api.LUA_RUNNING
+	return status
+}
+
+// 在当前goroutine中启动或继续执行协程,直到协程yield、执行完毕或出错
+// 协程中的PCallK出错时由这里恢复至该调用并继续执行
+func (s *luaState) resume(nArgs int) int {
+	s.coStatus = api.LUA_RUNNING
+	status, err := s.runProtected(func() {
+		if s.stack != s.base {
+			s.unroll() //从yield处恢复执行,resume的参数位于yield的函数栈中
+			return
+		}
+		//首次执行,函数及其参数位于最底层的函数栈中
+		if s.PreCall(nArgs, api.LUA_MULTRET) && !s.yielding {
+			s.stack.fresh = true
+			s.execute(true)
+			s.unroll()
+		}
+	})
+	for status != api.LUA_OK && status != api.LUA_SUSPENDED && s.recoverPCall(status, err) {
+		status, err = s.runProtected(s.unroll)
+	}
+	s.yielding = false
+
+	switch status {
+	case api.LUA_SUSPENDED:
+	case api.LUA_OK: //执行完毕,返回值位于最底层的函数栈中
+	default: //出错,协程死亡,错误值位于栈顶
+		err = s.unwind(s.base, err)
+		s.nny = 0
+		s.SetTop(0)
+		s.stack.push(err)
+	}
+	s.coStatus = status
+	return status
+}
+
+// 执行f,拦截其中的yield与错误,返回执行完f之后协程的状态及错误值
+func (s *luaState) runProtected(f func()) (status int, err luaValue) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r == yieldSignal {
+				status = api.LUA_SUSPENDED
+				return
+			}
+			status, err = api.LUA_ERR_RUN, s.errorValue(r)
+		}
+	}()
+	f()
+	if s.yielding {
+		return api.LUA_SUSPENDED, nilValue
+	}
+	return api.LUA_OK, nilValue
+}
+
+// 依次完成被yield中断的函数,直到再次yield或者回到协程最底层的函数栈
+func (s *luaState) unroll() {
+	for s.stack != s.base && !s.yielding {
+		if s.stack.closure.proto == nil {
+			s.finishGoCall()
+		} else {
+			s.finishOp()
+			s.execute(true)
+		}
+	}
+}
+
+// 完成被中断的Go函数:有continuation时调用它,否则以栈中的值(resume的参数)作为该函数的返回值
+func (s *luaState) finishGoCall() {
+	stack := s.stack
+	nr := stack.top
+	if c := stack.cont; c != nil {
+		stack.cont = nil
+		if len(c.saved) > 0 {
+			vals := stack.popN(stack.top)
+			s.CheckStack(len(c.saved) + len(vals))
+			stack.pushN(c.saved, -1)
+			stack.pushN(vals, -1)
+		}
+		nr = c.k(s, c.status, c.ctx)
+		if s.yielding {
+			return
+		}
+	}
+	s.postCall(nr)
+}
+
+// 完成当前lua函数被中断的指令,被调函数的返回值位于栈顶
+func (s *luaState) finishOp() {
+	stack := s.stack
+	if stack.lessEqual { //a <= b以not (b < a)计算,对__lt的结果取反
+		stack.lessEqual = false
+		stack.slots[stack.top] = boolValue(!convertToBoolean(stack.slots[stack.top]))
+	}
+	code := stack.closure.proto.Codes[stack.currentPC()]
+	instruction.Instruction(code).Finish(s)
+}
+
+// 协程中能yield的PCallK没有自己拦截错误,出错时在最近的这类调用所在的函数栈中恢复:
+// 调用错误处理函数、恢复调用栈并压入错误值,之后由unroll以错误状态调用其continuation
+// 没有这类调用时返回false
+func (s *luaState) recoverPCall(status int, err luaValue) bool {
+	stack := s.stack
+	for stack != nil && (stack.cont == nil || !stack.cont.pcall) {
+		stack = stack.prev
+	}
+	if stack == nil {
+		return false
+	}
+	c := stack.cont
+	if c.errh {
+		err, status = s.callErrHandler(stack.get(1), err)
+	}
+	err = s.unwind(stack, err)
+	s.nny, s.nCcalls = 0, c.nCcalls
+	s.yielding = false
+	s.SetTop(c.base)
+	s.stack.push(err)
+	c.pcall = false
+	c.status = status
+	return true
+}
+
+// Yield implements api.LuaVM.
+func (s *luaState) Yield() int {
+	return s.YieldK(s.GetTop(), 0, nil)
+}
+
+func (s *luaState) YieldK(nResults, ctx int, k api.KFunc) int {
+	if !s.IsYieldable() {
+		if s.isMainCoroutine() {
+			s.runError("attempt to yield from outside a coroutine")
+		}
+		s.runError("attempt to yield across a C-call boundary")
+	}
+	stack := s.stack
+	//只保留栈顶的nResults个值作为resume的返回值
+	if n := stack.top - nResults; n > 0 {
+		vals := stack.popN(nResults)
+		saved := stack.popN(n)
+		stack.pushN(vals, -1)
+		if k != nil {
+			stack.cont = &continuation{k: k, ctx: ctx, status: api.LUA_SUSPENDED, saved: saved}
+		}
+	} else if k != nil {
+		stack.cont = &continuation{k: k, ctx: ctx, status: api.LUA_SUSPENDED}
+	}
+	s.coStatus = api.LUA_SUSPENDED
+	s.yielding = true
+	return 0
+}
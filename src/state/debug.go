@@ -0,0 +1,465 @@
+package state
+
+import (
+	"strings"
+
+	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/binchunk"
+	"nskbz.cn/lua/compile/lexer"
+	"nskbz.cn/lua/instruction"
+)
+
+/*
+*	调试支持
+*
+*	函数栈通过prev串联成调用链,level 0为当前运行的函数
+*	局部变量与函数名的推断参考官方ldebug.c
+ */
+
+// 当前执行的指令,pc指向的是下一条指令所以当前指令为pc-1
+// 函数刚被调用还未执行指令时为0
+func (stack *luaStack) currentPC() int {
+	if stack.pc > 0 {
+		return stack.pc - 1
+	}
+	return 0
+}
+
+func (s *luaState) GetStack(level int) *api.DebugInfo {
+	if stack := s.getStack(level); stack != nil {
+		return &api.DebugInfo{CallInfo: stack}
+	}
+	return nil
+}
+
+func (s *luaState) GetInfo(what string, ar *api.DebugInfo) bool {
+	var c *closure
+	var stack *luaStack
+	if strings.HasPrefix(what, ">") { //目标为栈顶的函数
+		what = what[1:]
+		c, _ = s.stack.pop().asClosure()
+	} else if ar != nil {
+		if stack, _ = ar.CallInfo.(*luaStack); stack != nil {
+			c = stack.closure
+		}
+	}
+	if c == nil {
+		return false
+	}
+
+	ok := true
+	for _, ch := range what {
+		switch ch {
+		case 'S':
+			funcInfo(ar, c)
+		case 'l':
+			ar.CurrentLine = -1
+			if stack != nil && c.proto != nil {
+				ar.CurrentLine = stack.currentLine()
+			}
+		case 'u':
+			ar.NUps = len(c.upvals)
+			if c.proto == nil {
+				ar.NParams, ar.IsVararg = 0, true
+			} else {
+				ar.NParams, ar.IsVararg = int(c.proto.NumParams), c.proto.IsVararg == 1
+			}
+		case 't':
+			ar.IsTailCall = stack != nil && stack.tailcall
+		case 'n':
+			ar.Name, ar.NameWhat = "", ""
+			if stack != nil && !stack.tailcall { //尾调用替换了调用者的函数栈,无法推断
+				ar.Name, ar.NameWhat = funcNameFromCall(stack.prev)
+			}
+		case 'f', 'L': //在后面压入栈
+		default:
+			ok = false
+		}
+	}
+	if strings.ContainsRune(what, 'f') {
+		s.stack.push(closureValue(c))
+	}
+	if strings.ContainsRune(what, 'L') {
+		if c.proto == nil {
+			s.stack.push(nilValue)
+		} else { //有效行号表,即有指令对应的行号
+			lines := newTable(0, len(c.proto.LineInfo))
+			for _, line := range c.proto.LineInfo {
+				lines.put(intValue(int64(line)), boolValue(true))
+			}
+			s.stack.push(tableValue(lines))
+		}
+	}
+	return ok
+}
+
+func funcInfo(ar *api.DebugInfo, c *closure) {
+	if c.proto == nil { //Go函数对应官方的C函数
+		ar.Source, ar.ShortSrc, ar.What = "=[C]", "[C]", "C"
+		ar.LineDefined, ar.LastLineDefined = -1, -1
+		return
+	}
+	p := c.proto
+	ar.Source = p.Source
+	ar.ShortSrc = lexer.ChunkID(p.Source)
+	ar.LineDefined, ar.LastLineDefined = int(p.LineStart), int(p.LineEnd)
+	ar.What = "Lua"
+	if p.LineStart == 0 {
+		ar.What = "main"
+	}
+}
+
+func (s *luaState) GetLocal(ar *api.DebugInfo, n int) string {
+	if ar == nil { //获取栈顶函数的参数名称
+		if c, ok := s.stack.get(s.stack.top).asClosure(); ok && c.proto != nil {
+			return getLocalName(c.proto, n, 0)
+		}
+		return ""
+	}
+	stack, _ := ar.CallInfo.(*luaStack)
+	name, val := findLocal(stack, n)
+	if name != "" {
+		s.stack.push(*val)
+	}
+	return name
+}
+
+func (s *luaState) SetLocal(ar *api.DebugInfo, n int) string {
+	v := s.stack.pop()
+	stack, _ := ar.CallInfo.(*luaStack)
+	name, val := findLocal(stack, n)
+	if name != "" {
+		*val = v
+	}
+	return name
+}
+
+// 获取函数栈中第n个局部变量的名称与其位置
+// n<0时获取第-n个可变参数
+func findLocal(stack *luaStack, n int) (string, *luaValue) {
+	if stack == nil {
+		return "", nil
+	}
+	if p := stack.closure.proto; p != nil {
+		if n < 0 {
+			if -n <= len(stack.varargs) {
+				return "(*vararg)", &stack.varargs[-n-1]
+			}
+			return "", nil
+		}
+		if name := getLocalName(p, n, stack.currentPC()); name != "" {
+			return name, &stack.slots[n]
+		}
+	}
+	if n > 0 && n <= stack.tempLimit() { //没有名称的寄存器或Go函数的栈
+		return "(*temporary)", &stack.slots[n]
+	}
+	return "", nil
+}
+
+// 函数栈中可以作为临时变量访问的寄存器上限
+// 正在调用其他函数的lua函数只到被调函数所在的寄存器为止
+func (stack *luaStack) tempLimit() int {
+	if p := stack.closure.proto; p != nil && stack.state.stack != stack {
+		i := p.Codes[stack.currentPC()]
+		if op := i.Opcode(); op == instruction.OP_CALL || op == instruction.OP_TAILCALL || op == instruction.OP_TFORCALL {
+			a, _, _ := i.ABC()
+			return a
+		}
+	}
+	return stack.top
+}
+
+// 获取pc处第n个生效的局部变量名称,局部变量依次存放在寄存器中,所以第n个局部变量即R(n-1)
+func getLocalName(p *binchunk.Prototype, n, pc int) string {
+	for _, lv := range p.LocVars {
+		if int(lv.StartPC) > pc {
+			break
+		}
+		if pc < int(lv.EndPC) {
+			n--
+			if n == 0 {
+				return lv.VarName
+			}
+		}
+	}
+	return ""
+}
+
+func (s *luaState) getClosure(funcIdx int) *closure {
+	c, _ := s.stack.get(s.AbsIndex(funcIdx)).asClosure()
+	return c
+}
+
+// 获取upvalue名称,Go函数的upvalue没有名称
+func upvalueName(c *closure, n int) string {
+	if c.proto == nil {
+		return ""
+	}
+	if n < len(c.proto.UpvalueNames) && c.proto.UpvalueNames[n] != "" {
+		return c.proto.UpvalueNames[n]
+	}
+	return "(*no name)" //调试信息被去除
+}
+
+func (s *luaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	c := s.getClosure(funcIdx)
+	if c == nil || n < 1 || n > len(c.upvals) {
+		return "", false
+	}
+	if uv := c.upvals[n-1]; uv != nil && uv.val != nil {
+		s.stack.push(*uv.val)
+	} else {
+		s.stack.push(nilValue)
+	}
+	return upvalueName(c, n-1), true
+}
+
+func (s *luaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	c := s.getClosure(funcIdx)
+	v := s.stack.pop()
+	if c == nil || n < 1 || n > len(c.upvals) {
+		return "", false
+	}
+	if c.upvals[n-1] == nil || c.upvals[n-1].val == nil {
+		c.upvals[n-1] = &upvalue{&v}
+	} else {
+		*c.upvals[n-1].val = v
+	}
+	return upvalueName(c, n-1), true
+}
+
+// upvalue通过指针共享,所以以指针作为其唯一标识
+// 关闭upvalue后其val会指向新的位置,所以使用*upvalue而不是val
+func (s *luaState) UpvalueId(funcIdx, n int) interface{} {
+	c := s.getClosure(funcIdx)
+	if c == nil || n < 1 || n > len(c.upvals) || c.upvals[n-1] == nil {
+		return nil
+	}
+	return c.upvals[n-1]
+}
+
+func (s *luaState) UpvalueJoin(f1, n1, f2, n2 int) {
+	c1, c2 := s.getClosure(f1), s.getClosure(f2)
+	c1.upvals[n1-1] = c2.upvals[n2-1]
+}
+
+/*
+*	函数名推断
+ */
+
+// 根据调用者正在执行的指令推断被调函数的名称
+func funcNameFromCall(caller *luaStack) (string, string) {
+	if caller == nil || caller.closure == nil || caller.closure.proto == nil {
+		return "", "" //由Go函数调用则无法推断
+	}
+	if caller.hooked {
+		return "?", "hook"
+	}
+	p := caller.closure.proto
+	pc := caller.currentPC()
+	i := p.Codes[pc]
+	var tm string
+	switch i.Opcode() {
+	case instruction.OP_CALL, instruction.OP_TAILCALL:
+		a, _, _ := i.ABC()
+		return getObjName(p, pc, a)
+	case instruction.OP_TFORCALL:
+		return "for iterator", "for iterator"
+	case instruction.OP_SELF, instruction.OP_GETTABUP, instruction.OP_GETTABLE:
+		tm = "index"
+	case instruction.OP_SETTABUP, instruction.OP_SETTABLE:
+		tm = "newindex"
+	case instruction.OP_UNM:
+		tm = "unm"
+	case instruction.OP_BNOT:
+		tm = "bnot"
+	case instruction.OP_LEN:
+		tm = "len"
+	case instruction.OP_CONCAT:
+		tm = "concat"
+	case instruction.OP_EQ:
+		tm = "eq"
+	case instruction.OP_LT:
+		tm = "lt"
+	case instruction.OP_LE:
+		tm = "le"
+	default:
+		if op := i.Opcode(); op >= instruction.OP_ADD && op <= instruction.OP_SHR {
+			tm = [...]string{"add", "sub", "mul", "mod", "pow", "div", "idiv", "band", "bor", "bxor", "shl", "shr"}[op-instruction.OP_ADD]
+		} else {
+			return "", ""
+		}
+	}
+	return tm, "metamethod" //由元方法调用
+}
+
+// 推断lastpc处寄存器reg中值的名称
+func getObjName(p *binchunk.Prototype, lastpc, reg int) (string, string) {
+	if name := getLocalName(p, reg+1, lastpc); name != "" {
+		return name, "local"
+	}
+	pc := findSetReg(p, lastpc, reg)
+	if pc == -1 {
+		return "", ""
+	}
+	i := p.Codes[pc]
+	switch i.Opcode() {
+	case instruction.OP_MOVE:
+		if a, b, _ := i.ABC(); b < a {
+			return getObjName(p, pc, b) //R(A)的值来自于R(B)
+		}
+	case instruction.OP_GETTABUP:
+		_, b, c := i.ABC()
+		return rkName(p, pc, c), globalOrField(upvalName(p, b))
+	case instruction.OP_GETTABLE:
+		_, b, c := i.ABC()
+		vn := getLocalName(p, b+1, pc)
+		if vn == "" { //表可能是由GETUPVAL获取的upvalue,例如_ENV
+			if setPc := findSetReg(p, pc, b); setPc != -1 && p.Codes[setPc].Opcode() == instruction.OP_GETUPVAL {
+				_, ub, _ := p.Codes[setPc].ABC()
+				vn = upvalName(p, ub)
+			}
+		}
+		return rkName(p, pc, c), globalOrField(vn)
+	case instruction.OP_GETUPVAL:
+		_, b, _ := i.ABC()
+		return upvalName(p, b), "upvalue"
+	case instruction.OP_LOADK:
+		_, bx := i.ABx()
+		if str, ok := p.Constants[bx].(string); ok {
+			return str, "constant"
+		}
+	case instruction.OP_SELF:
+		_, _, c := i.ABC()
+		return rkName(p, pc, c), "method"
+	}
+	return "", ""
+}
+
+func globalOrField(tableName string) string {
+	if tableName == "_ENV" {
+		return "global"
+	}
+	return "field"
+}
+
+func upvalName(p *binchunk.Prototype, n int) string {
+	if n < len(p.UpvalueNames) {
+		return p.UpvalueNames[n]
+	}
+	return "?"
+}
+
+// 获取RK(c)作为键的名称,只有字符串常量才有名称
+func rkName(p *binchunk.Prototype, pc, c int) string {
+	if c >= instruction.ConstantBase {
+		if str, ok := p.Constants[c-instruction.ConstantBase].(string); ok {
+			return str
+		}
+	} else if name, what := getObjName(p, pc, c); what == "constant" {
+		return name
+	}
+	return "?"
+}
+
+// 查找lastpc之前最后一次修改寄存器reg的指令,找不到或不确定(存在跳转)时返回-1
+func findSetReg(p *binchunk.Prototype, lastpc, reg int) int {
+	setreg := -1
+	jmptarget := 0 //跳转的最远目标,在此之前的修改不能确定是否被执行
+	filterPc := func(pc int) int {
+		if pc < jmptarget {
+			return -1
+		}
+		return pc
+	}
+	for pc := 0; pc < lastpc; pc++ {
+		i := p.Codes[pc]
+		switch i.Opcode() {
+		case instruction.OP_LOADNIL:
+			if a, b, _ := i.ABC(); a <= reg && reg <= a+b {
+				setreg = filterPc(pc)
+			}
+		case instruction.OP_TFORCALL:
+			if a, _, _ := i.ABC(); reg >= a+2 {
+				setreg = filterPc(pc)
+			}
+		case instruction.OP_CALL, instruction.OP_TAILCALL:
+			if a, _, _ := i.ABC(); reg >= a {
+				setreg = filterPc(pc)
+			}
+		case instruction.OP_JMP:
+			_, sbx := i.AsBx()
+			if dest := pc + 1 + sbx; pc < dest && dest <= lastpc && dest > jmptarget {
+				jmptarget = dest
+			}
+		default:
+			if a, _, _ := i.ABC(); i.SetsA() && reg == a {
+				setreg = filterPc(pc)
+			}
+		}
+	}
+	return setreg
+}
+
+/*
+*	hook支持
+ */
+
+func (s *luaState) SetHook(f api.HookFunc, mask, count int) {
+	if f == nil || mask == 0 {
+		f, mask = nil, 0
+	}
+	if count <= 0 {
+		mask &^= api.LUA_MASKCOUNT
+	}
+	s.hook = f
+	s.hookMask = mask
+	s.baseHookCount = count
+	s.hookCount = count
+}
+
+func (s *luaState) GetHook() api.HookFunc { return s.hook }
+func (s *luaState) GetHookMask() int      { return s.hookMask }
+func (s *luaState) GetHookCount() int     { return s.baseHookCount }
+
+// 在当前函数栈中调用hook函数,hook函数执行期间不会再触发hook
+func (s *luaState) callHook(event, line int) {
+	if s.hook == nil || s.inHook {
+		return
+	}
+	stack := s.stack
+	top := stack.top
+	ar := &api.DebugInfo{Event: event, CurrentLine: line, CallInfo: stack}
+	s.inHook, stack.hooked = true, true
+	nny := s.nny
+	s.nny++ //hook中不能yield
+	defer func() {
+		s.inHook, stack.hooked = false, false
+		s.nny = nny
+	}()
+	s.CheckStack(api.LUA_MIN_STACK)
+	s.hook(s, ar)
+	stack.top = top //丢弃hook函数遗留在栈中的值
+}
+
+// 执行lua函数的下一条指令之前调用,触发LUA_HOOKCOUNT与LUA_HOOKLINE
+// oldPc为该函数上一条执行的指令,返回即将执行的指令
+func (s *luaState) traceExec(oldPc int) int {
+	pc := s.stack.pc
+	if s.hookMask&api.LUA_MASKCOUNT != 0 {
+		s.hookCount--
+		if s.hookCount == 0 {
+			s.hookCount = s.baseHookCount
+			s.callHook(api.LUA_HOOKCOUNT, -1)
+		}
+	}
+	if s.hookMask&api.LUA_MASKLINE != 0 {
+		lineInfo := s.stack.closure.proto.LineInfo
+		//进入新函数、刚设置hook、向后跳转(循环)或进入新的一行时触发
+		if pc == 0 || oldPc < 0 || pc <= oldPc || lineInfo[pc] != lineInfo[oldPc] {
+			s.callHook(api.LUA_HOOKLINE, int(lineInfo[pc]))
+		}
+	}
+	return pc
+}
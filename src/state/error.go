@@ -0,0 +1,206 @@
+package state
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/compile/lexer"
+)
+
+/*
+*	错误值支持
+*
+*	lua中的错误可以是任意LuaValue,通过panic(*luaError)抛出,由PCall拦截
+*	运行时错误(算术、索引、调用等)统一通过runError抛出,错误信息带有"chunkname:line:"前缀
+ */
+
+const (
+	LEVELS1 = 10 //traceback中前段显示的层数
+	LEVELS2 = 11 //traceback中后段显示的层数
+)
+
+type luaError struct {
+	value luaValue //错误值,可以是任意LuaValue
+}
+
+// 当错误值不是字符串时也需要能作为Go error输出
+func (e *luaError) Error() string {
+	if str, ok := convertToString(e.value); ok {
+		return str
+	}
+	return fmt.Sprintf("(error object is a %s value)", typeOf(e.value).String())
+}
+
+// 抛出运行时错误,当前执行的是lua函数时添加"chunkname:line:"前缀
+func (s *luaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if s.stack.closure != nil && s.stack.closure.proto != nil {
+		msg = s.where(0) + msg
+	}
+	panic(&luaError{stringValue(msg)})
+}
+
+// 获取调用栈中第level层的函数栈,level 0为当前运行的函数,level 1为调用当前函数的函数,依次类推
+// 不存在则返回nil
+func (s *luaState) getStack(level int) *luaStack {
+	if level < 0 {
+		return nil
+	}
+	stack := s.stack
+	for ; level > 0 && stack != nil; level-- {
+		stack = stack.prev
+	}
+	if stack == nil || stack.closure == nil { //最底层的栈不属于任何函数
+		return nil
+	}
+	return stack
+}
+
+// 获取第level层函数的位置信息"chunkname:currentline: ",如果该层不是lua函数或没有行号信息(如strip后的chunk)则返回""
+func (s *luaState) where(level int) string {
+	if stack := s.getStack(level); stack != nil && stack.closure.proto != nil {
+		if line := stack.currentLine(); line > 0 {
+			return fmt.Sprintf("%s:%d: ", lexer.ChunkID(stack.closure.proto.Source), line)
+		}
+	}
+	return ""
+}
+
+// 返回lua函数当前执行的行号
+func (stack *luaStack) currentLine() int {
+	lineInfo := stack.closure.proto.LineInfo
+	if pc := stack.currentPC(); pc < len(lineInfo) {
+		return int(lineInfo[pc])
+	}
+	return -1
+}
+
+// 将PCall拦截到的panic转换为lua错误值
+// 除了luaError外,其余的panic(包括Go运行时错误)都转换为带有位置信息的字符串
+func (s *luaState) errorValue(r interface{}) luaValue {
+	var msg string
+	switch x := r.(type) {
+	case *luaError:
+		return x.value
+	case string:
+		msg = x
+	case runtime.Error:
+		msg = x.Error()
+	case error:
+		msg = x.Error()
+	default:
+		msg = fmt.Sprint(x)
+	}
+	//以最近的lua函数的当前行作为错误位置
+	for level := 0; ; level++ {
+		stack := s.getStack(level)
+		if stack == nil {
+			return stringValue(msg)
+		}
+		if stack.closure.proto != nil {
+			return stringValue(s.where(level) + msg)
+		}
+	}
+}
+
+// 描述函数栈所属的函数及其当前行,用于traceback,格式与luaL_traceback一致
+func (s *luaState) funcDescription(stack *luaStack) string {
+	proto := stack.closure.proto
+	where := "[C]: in "
+	if proto != nil {
+		src := lexer.ChunkID(proto.Source)
+		if line := stack.currentLine(); line > 0 {
+			where = fmt.Sprintf("%s:%d: in ", src, line)
+		} else {
+			where = src + ": in "
+		}
+	}
+	//优先使用已加载模块中的全局名,其次由调用者的指令推断名字
+	if name := s.globalFuncName(stack.closure); name != "" {
+		return where + fmt.Sprintf("function '%s'", name)
+	}
+	if !stack.tailcall {
+		if name, namewhat := funcNameFromCall(stack.prev); namewhat != "" {
+			return where + fmt.Sprintf("%s '%s'", namewhat, name)
+		}
+	}
+	switch {
+	case proto == nil:
+		return where + "?"
+	case proto.LineStart == 0:
+		return where + "main chunk"
+	default:
+		return where + fmt.Sprintf("function <%s:%d>", lexer.ChunkID(proto.Source), proto.LineStart)
+	}
+}
+
+/*
+*	AuxLib
+ */
+
+// 压入第level层函数的位置信息"chunkname:currentline: "
+func (s *luaState) Where(level int) {
+	s.PushString(s.where(level))
+}
+
+// 压入co的调用栈回溯信息,从第level层开始,msg不为空时作为开头
+func (s *luaState) Traceback(co api.LuaState, msg string, level int) {
+	ls := co.(*luaState)
+	sb := strings.Builder{}
+	if msg != "" {
+		sb.WriteString(msg)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("stack traceback:")
+
+	//层数过多时只显示开头LEVELS1层与结尾LEVELS2层
+	//最底层的函数栈不属于任何函数,所以共有depth层
+	first, last := level, ls.stack.depth
+	for ; level < last; level++ {
+		if last-level > LEVELS2 && level-first == LEVELS1 {
+			sb.WriteString("\n\t...")
+			level = last - LEVELS2 - 1
+			continue
+		}
+		stack := ls.getStack(level)
+		sb.WriteString("\n\t")
+		sb.WriteString(s.funcDescription(stack))
+		if stack.tailcall {
+			sb.WriteString("\n\t(...tail calls...)")
+		}
+	}
+	//主协程的最底层是调用Lua的宿主程序,与lua.c一样以一个Go层结尾
+	if level == last && ls.isMainCoroutine() {
+		sb.WriteString("\n\t[C]: in ?")
+	}
+	s.PushString(sb.String())
+}
+
+// 算术运算失败时抛出错误,a为nil表示一元运算
+func (s *luaState) arithError(op api.ArithOp, a, b luaValue) {
+	operands := []luaValue{b}
+	if op < api.ArithOp_OPPOSITE {
+		operands = []luaValue{a, b}
+	}
+	isBitwise := arith_operation[op].f == nil
+	for _, v := range operands {
+		if _, ok := convertToFloat(v); !ok {
+			if isBitwise {
+				s.runError("attempt to perform bitwise operation on a %s value", s.TypeName(typeOf(v)))
+			}
+			s.runError("attempt to perform arithmetic on a %s value", s.TypeName(typeOf(v)))
+		}
+	}
+	//操作数都是数字但不能转换为整数
+	s.runError("number has no integer representation")
+}
+
+func (s *luaState) compareError(a, b luaValue) {
+	t1, t2 := s.TypeName(typeOf(a)), s.TypeName(typeOf(b))
+	if t1 == t2 {
+		s.runError("attempt to compare two %s values", t1)
+	}
+	s.runError("attempt to compare %s with %s", t1, t2)
+}
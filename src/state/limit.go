@@ -0,0 +1,112 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"nskbz.cn/lua/api"
+)
+
+/*
+*	执行限制
+*
+*	宿主程序可以通过指令数上限或context.Context限制不受信任脚本的执行
+*	每执行一条lua指令前检查一次,超出限制时抛出运行时错误,可以被PCall捕获
+*	超出限制后每条指令都会再次抛出错误,所以脚本无法通过pcall忽略该错误继续执行
+*	loadMode限制所有Load能够加载的chunk类型,用于拒绝来自不可信来源的二进制chunk
+*
+*	调用链的层数与占用的栈空间(不超过LUA_MAX_STACK)受到限制,无限递归时抛出"stack overflow"而不是耗尽内存
+*	由Go代码发起的调用会占用Go的调用栈,嵌套超过LUAI_MAXCCALLS层时抛出"C stack overflow",防止Go的调用栈溢出使进程退出
+*	与官方一致,错误处理函数可以在溢出之后额外使用一部分调用栈,再次溢出时抛出"error in error handling"
+ */
+
+const CONTEXT_CHECK_INTERVAL = 1024 //每执行多少条指令检查一次ctx是否被取消
+
+const (
+	ERROR_EXTRA_CALLS = 200                                       //错误处理函数可以额外使用的调用层数
+	ERROR_EXTRA_STACK = ERROR_EXTRA_CALLS * 2 * api.LUA_MIN_STACK //错误处理函数可以额外使用的栈空间
+)
+
+type execLimit struct {
+	ctx      context.Context
+	done     <-chan struct{} //ctx.Done(),为nil表示ctx永远不会被取消
+	maxSteps int64           //指令数上限,<=0表示不限制
+	steps    int64           //已执行的指令数
+	loadMode string          //允许加载的chunk类型,空串表示不限制
+	maxDepth int             //调用链的层数上限,<=0表示使用api.LUAI_MAXCALLS
+}
+
+// 执行一条lua指令前调用
+func (l *execLimit) step(s *luaState) {
+	l.steps++
+	if l.maxSteps > 0 && l.steps > l.maxSteps {
+		s.runError("instruction limit exceeded")
+	}
+	if l.done != nil && l.steps%CONTEXT_CHECK_INTERVAL == 0 {
+		select {
+		case <-l.done:
+			s.runError("%s", l.ctx.Err())
+		default:
+		}
+	}
+}
+
+func (s *luaState) SetContext(ctx context.Context) {
+	s.limit.ctx, s.limit.done = ctx, nil
+	if ctx != nil {
+		s.limit.done = ctx.Done()
+	}
+}
+
+func (s *luaState) SetInstructionLimit(n int64) {
+	s.limit.maxSteps = n
+	s.limit.steps = 0
+}
+
+func (s *luaState) InstructionCount() int64 {
+	return s.limit.steps
+}
+
+func (s *luaState) SetCallDepthLimit(n int) {
+	s.limit.maxDepth = n
+}
+
+// 进入第depth层调用,调用链共占用size个栈空间之前检查是否溢出
+func (s *luaState) checkStackOverflow(depth, size int) {
+	maxDepth := s.limit.maxDepth
+	if maxDepth <= 0 {
+		maxDepth = api.LUAI_MAXCALLS
+	}
+	if depth <= maxDepth && size <= api.LUA_MAX_STACK {
+		return
+	}
+	if s.errh == 0 {
+		s.runError("stack overflow")
+	}
+	if depth > maxDepth+ERROR_EXTRA_CALLS || size > api.LUA_MAX_STACK+ERROR_EXTRA_STACK {
+		panic(&luaError{stringValue("error in error handling")})
+	}
+}
+
+// 由Go代码发起调用之后检查Go调用栈上嵌套的调用层数
+// 与官方一致,恰好达到上限时抛出错误,错误处理函数可以继续使用超出的部分
+func (s *luaState) checkCStackOverflow() {
+	if s.nCcalls == api.LUAI_MAXCCALLS {
+		s.runError("C stack overflow")
+	} else if s.nCcalls >= api.LUAI_MAXCCALLS+api.LUAI_MAXCCALLS>>3 {
+		panic(&luaError{stringValue("error in error handling")})
+	}
+}
+
+func (s *luaState) SetLoadMode(mode string) {
+	s.limit.loadMode = mode
+}
+
+// 检查mode是否允许加载kind("binary"或"text")类型的chunk,空串表示不限制
+func checkLoadMode(mode, kind string) error {
+	if mode != "" && !strings.Contains(mode, kind[:1]) {
+		return fmt.Errorf("attempt to load a %s chunk (mode is '%s')", kind, mode)
+	}
+	return nil
+}
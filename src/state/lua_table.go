@@ -1,11 +1,11 @@
 package state
 
 import (
-	"math"
-
 	"nskbz.cn/lua/number"
 )
 
+const MAX_SIZE_HINT = 1 << 16 //创建表时预留的数组部分与哈希部分的最大大小
+
 type table struct {
 	metaTable *table                //元方法表
 	_arr      []luaValue            //顺序数组下标的key,注意数组的索引是从1开始的
@@ -14,10 +14,12 @@ type table struct {
 	keys map[luaValue]luaValue //key的顺序
 }
 
+// nArr与nPair只是预留空间的提示,可能来自被篡改的NEWTABLE指令,超过MAX_SIZE_HINT时只预留MAX_SIZE_HINT
 func newTable(nArr, nPair int) *table {
+	nArr, nPair = min(nArr, MAX_SIZE_HINT), min(nPair, MAX_SIZE_HINT)
 	t := table{}
 	if nArr > 0 {
-		t._arr = make([]luaValue, nArr)
+		t._arr = make([]luaValue, 0, nArr) //只预留容量,数组部分的长度即是表的长度
 	}
 	if nPair > 0 {
 		t._map = make(map[luaValue]luaValue, nPair)
@@ -27,31 +29,45 @@ func newTable(nArr, nPair int) *table {
 
 // key不存在则返回nil
 func (t *table) get(key luaValue) luaValue {
-	idx := keyToInt(key)
+	key = normalizeKey(key)
+	if key.is(TAG_INTEGER) {
+		return t.getInt(key.integer())
+	}
+	return t._map[key]
+}
+
+func (t *table) getInt(idx int64) luaValue {
 	if idx >= 1 && idx <= int64(t.len()) {
 		return t._arr[idx-1]
 	}
-	return t._map[key]
+	return t._map[intValue(idx)]
 }
 
-// 返回0表示key不能转换为int
-func keyToInt(key luaValue) int64 {
-	var idx int64 = 0
-	if v, ok := key.(float64); ok {
-		if math.IsNaN(v) {
-			panic("error table key [NaN]")
-		}
-		if i, ok := number.FloatToInteger(v); ok {
-			idx = i
+func (t *table) getStr(key string) luaValue {
+	return t._map[stringValue(key)]
+}
+
+// 值为整数的浮点数键转换为整数键,使得t[1.0]与t[1]、t[2^53]与t[math.tointeger(2^53)]是同一个键
+// NaN不能转换为整数,写入NaN键的错误由调用者检查
+func normalizeKey(key luaValue) luaValue {
+	if key.is(TAG_FLOAT) {
+		if i, ok := number.FloatToInteger(key.float()); ok {
+			return intValue(i)
 		}
 	}
-	if v, ok := key.(int64); ok {
-		idx = v
+	return key
+}
+
+// 返回0表示key不能转换为int
+func keyToInt(key luaValue) int64 {
+	if key.is(TAG_INTEGER) {
+		return key.integer()
 	}
-	return idx
+	return 0
 }
 
 func (t *table) put(key, value luaValue) {
+	key = normalizeKey(key)
 	//
 	//存数组中的
 	k := keyToInt(key)
@@ -59,7 +75,7 @@ func (t *table) put(key, value luaValue) {
 		//lua表是1为起始所以映射为数组时要减1
 		t._arr[k-1] = value
 		//如果put的元素刚好为arr的末尾且value等于nil进行shrink
-		if k == int64(t.len()) && value == nil {
+		if k == int64(t.len()) && value.isNil() {
 			t.shrink()
 		}
 		return
@@ -67,7 +83,7 @@ func (t *table) put(key, value luaValue) {
 	//如果刚好put的是arr末尾的下一索引且value不为nil则扩充arr并进行expand
 	if k == int64(t.len())+1 {
 		delete(t._map, key) //此时两种情况1.map中有该键值(之前put过的)2.map中没有该键值(从未put过的)。为了保持数据一致性，这里需要对map中该键值进行删除
-		if value != nil {
+		if !value.isNil() {
 			t._arr = append(t._arr, value)
 			t.expand()
 		}
@@ -77,7 +93,7 @@ func (t *table) put(key, value luaValue) {
 	//存map中的1.key不为整数2.key为整数但超过_arr的长度n个(n>1)
 	//
 	//table中不存在val为nil的键值对
-	if value == nil {
+	if value.isNil() {
 		delete(t._map, key) //节省空间
 		return
 	}
@@ -86,32 +102,34 @@ func (t *table) put(key, value luaValue) {
 	if t._map == nil {
 		t._map = make(map[luaValue]luaValue, 8)
 	}
+	if _, ok := t._map[key]; !ok {
+		//加入了新的key,key的顺序可能发生变化需要重新生成顺序;只修改已有key的值时遍历可以继续
+		t.keys = nil
+	}
 	t._map[key] = value
-
-	//改变table结构后key的顺序可能发生变化需要重新生成顺序
-	t.keys = nil
 }
 
 // 消除arr尾部nil元素
 func (t *table) shrink() {
 	i := t.len() - 1
-	for i >= 0 && t._arr[i] == nil {
+	for i >= 0 && t._arr[i].isNil() {
 		i--
 	}
 	t._arr = t._arr[0 : i+1]
 }
 
+// 将map中紧接着arr末尾的整数键依次移入arr
 func (t *table) expand() {
-	i := t.len()
 	for {
-		//lua表是1为起始所以要加1查询
-		if v, ok := t._map[i+1]; ok {
-			delete(t._map, i)
-			t._arr = append(t._arr, v)
-		} else {
+		//lua表是1为起始所以要加1查询,map中的整数键均为int64
+		k := int64(t.len()) + 1
+		v, ok := t._map[intValue(k)]
+		if !ok {
 			break
 		}
-		i++
+		delete(t._map, intValue(k))
+		t._arr = append(t._arr, v)
+		t.keys = nil
 	}
 }
 
@@ -123,31 +141,40 @@ func (t *table) hasMetaFunc(key string) bool {
 	if t.metaTable == nil {
 		return false
 	}
-	if val := t.metaTable.get(key); val != nil {
-		return true
-	}
-	return false
+	return !t.metaTable.getStr(key).isNil()
 }
 
-func (t *table) nextKey(k luaValue) luaValue {
+// 返回k的下一个值不为nil的key,没有下一个key时返回nil
+// 遍历期间可以将已有的key赋值为nil,k不是表中(或遍历开始时表中)的key时返回false
+func (t *table) nextKey(k luaValue) (luaValue, bool) {
 	//table结构发生改变需要重新init
 	if t.keys == nil {
 		keys := make(map[luaValue]luaValue)
-		var key luaValue = nil
+		key := nilValue
 		for i, v := range t._arr {
-			if v != nil {
-				keys[key] = int64(i + 1)
-				key = int64(i + 1)
+			if !v.isNil() {
+				keys[key] = intValue(int64(i + 1))
+				key = intValue(int64(i + 1))
 			}
 		}
 		for k, v := range t._map {
-			if v != nil {
+			if !v.isNil() {
 				keys[key] = k
 				key = k
 			}
 		}
-
+		keys[key] = nilValue //最后一个key
 		t.keys = keys
 	}
-	return t.keys[k]
+	k = normalizeKey(k) //浮点数形式的整数键以整数形式存储
+	for {
+		nk, ok := t.keys[k]
+		if !ok {
+			return nilValue, false
+		}
+		if nk.isNil() || !t.get(nk).isNil() { //跳过遍历期间被删除的key
+			return nk, true
+		}
+		k = nk
+	}
 }
@@ -2,6 +2,8 @@ package state
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"nskbz.cn/lua/api"
 	"nskbz.cn/lua/number"
@@ -36,59 +38,261 @@ const (
 	META_CALL      = "__call"
 	META_TOSTRING  = "__tostring"
 	META_NAME      = "__name"
+	META_METATABLE = "__metatable"
+	META_CLOSE     = "__close"
+	META_PAIRS     = "__pairs"
 )
 
-type luaValue interface{}
+// __index与__newindex元表链的最大长度,超过时认为存在循环,与官方MAXTAGLOOP一致
+const MAXTAGLOOP = 2000
 
-func typeOf(val luaValue) api.LuaValueType {
-	switch val.(type) {
+/*
+*	lua值
+*
+*	luaValue由64位的n与一个interface{}组成:boolean与number的值直接保存在n中,o保存其类型标记valueTag,
+*	单字节的值装入interface{}时不会分配内存,所以运算结果压栈、存入表等都不需要分配内存
+*	其余类型的值保存在o中,字符串与各种引用类型本身就是指针或者包含指针,类型由o的动态类型决定
+*	类型标记不单独占用字段,luaValue只有24字节,函数栈的寄存器与表的数组部分都直接保存luaValue
+*	零值即为nil;luaValue可以直接比较相等并作为map的键,
+*	boolean与number比较n(浮点数比较位模式,所以数值比较需要使用doEq),其余类型比较o(字符串比较内容,引用类型比较地址)
+ */
+
+type valueTag uint8
+
+const (
+	TAG_NIL valueTag = iota
+	TAG_BOOLEAN
+	TAG_INTEGER
+	TAG_FLOAT
+	TAG_STRING
+	TAG_TABLE
+	TAG_FUNCTION
+	TAG_COROUTINE
+	TAG_USERDATA
+	TAG_LIGHTUSERDATA
+)
+
+var tagTypes = [...]api.LuaValueType{
+	TAG_NIL:           api.LUAVALUE_NIL,
+	TAG_BOOLEAN:       api.LUAVALUE_BOOLEAN,
+	TAG_INTEGER:       api.LUAVALUE_NUMBER,
+	TAG_FLOAT:         api.LUAVALUE_NUMBER,
+	TAG_STRING:        api.LUAVALUE_STRING,
+	TAG_TABLE:         api.LUAVALUE_TABLE,
+	TAG_FUNCTION:      api.LUAVALUE_FUNCTION,
+	TAG_COROUTINE:     api.LUAVALUE_COROUTINE,
+	TAG_USERDATA:      api.LUAVALUE_USERDATA,
+	TAG_LIGHTUSERDATA: api.LUAVALUE_LIGHTUSERDATA,
+}
+
+type luaValue struct {
+	n uint64      //boolean(0或1)、integer与float(IEEE 754位模式)的值
+	o interface{} //boolean与number的valueTag,或者string、*table、*closure、*luaState、*userdata、lightUserdata
+}
+
+var nilValue = luaValue{}
+
+func boolValue(b bool) luaValue {
+	if b {
+		return luaValue{n: 1, o: TAG_BOOLEAN}
+	}
+	return luaValue{o: TAG_BOOLEAN}
+}
+
+func intValue(i int64) luaValue {
+	return luaValue{n: uint64(i), o: TAG_INTEGER}
+}
+
+func floatValue(f float64) luaValue {
+	return luaValue{n: math.Float64bits(f), o: TAG_FLOAT}
+}
+
+func stringValue(str string) luaValue {
+	return luaValue{o: str}
+}
+
+func tableValue(t *table) luaValue {
+	return luaValue{o: t}
+}
+
+func closureValue(c *closure) luaValue {
+	return luaValue{o: c}
+}
+
+func coroutineValue(ls *luaState) luaValue {
+	return luaValue{o: ls}
+}
+
+func userdataValue(u *userdata) luaValue {
+	return luaValue{o: u}
+}
+
+// 将Go值转换为luaValue,用于常量表、PushBasic等类型不确定的值
+// 已经装箱的字符串直接复用,不会再次分配内存
+func valueOf(v interface{}) luaValue {
+	switch x := v.(type) {
 	case nil:
-		return api.LUAVALUE_NIL
+		return nilValue
+	case luaValue:
+		return x
 	case bool:
-		return api.LUAVALUE_BOOLEAN
-	case int64, float64:
-		return api.LUAVALUE_NUMBER
+		return boolValue(x)
+	case int64:
+		return intValue(x)
+	case float64:
+		return floatValue(x)
 	case string:
-		return api.LUAVALUE_STRING
+		return luaValue{o: v}
 	case *table:
-		return api.LUAVALUE_TABLE
+		return luaValue{o: v}
 	case *closure:
-		return api.LUAVALUE_FUNCTION
+		return luaValue{o: v}
 	case *luaState:
-		return api.LUAVALUE_COROUTINE
+		return luaValue{o: v}
+	case *userdata:
+		return luaValue{o: v}
+	case lightUserdata:
+		return luaValue{o: v}
 	}
-	return api.LUAVALUE_NONE
+	panic(fmt.Sprintf("not support %v", v))
 }
 
-func convertToBoolean(val luaValue) bool {
-	switch v := val.(type) {
+// 与valueOf相反,返回对应的Go值(nil、bool、int64、float64、string或引用类型的指针)
+func (v luaValue) goValue() interface{} {
+	switch v.tag() {
+	case TAG_NIL:
+		return nil
+	case TAG_BOOLEAN:
+		return v.n != 0
+	case TAG_INTEGER:
+		return int64(v.n)
+	case TAG_FLOAT:
+		return math.Float64frombits(v.n)
+	}
+	return v.o
+}
+
+// 值的类型标记
+func (v luaValue) tag() valueTag {
+	switch o := v.o.(type) {
 	case nil:
+		return TAG_NIL
+	case valueTag:
+		return o
+	case string:
+		return TAG_STRING
+	case *table:
+		return TAG_TABLE
+	case *closure:
+		return TAG_FUNCTION
+	case *luaState:
+		return TAG_COROUTINE
+	case *userdata:
+		return TAG_USERDATA
+	}
+	return TAG_LIGHTUSERDATA
+}
+
+// v是否为tag类型的boolean或number,只需要一次类型断言
+func (v luaValue) is(tag valueTag) bool {
+	t, ok := v.o.(valueTag)
+	return ok && t == tag
+}
+
+func (v luaValue) isNil() bool {
+	return v.o == nil
+}
+
+// 以下方法在类型不符时返回false
+
+func (v luaValue) asBoolean() (bool, bool) {
+	return v.n != 0, v.is(TAG_BOOLEAN)
+}
+
+func (v luaValue) asInteger() (int64, bool) {
+	return int64(v.n), v.is(TAG_INTEGER)
+}
+
+func (v luaValue) asFloat() (float64, bool) {
+	if !v.is(TAG_FLOAT) {
+		return 0, false
+	}
+	return math.Float64frombits(v.n), true
+}
+
+func (v luaValue) asString() (string, bool) {
+	str, ok := v.o.(string)
+	return str, ok
+}
+
+func (v luaValue) asTable() (*table, bool) {
+	t, ok := v.o.(*table)
+	return t, ok
+}
+
+func (v luaValue) asClosure() (*closure, bool) {
+	c, ok := v.o.(*closure)
+	return c, ok
+}
+
+func (v luaValue) asCoroutine() (*luaState, bool) {
+	ls, ok := v.o.(*luaState)
+	return ls, ok
+}
+
+func (v luaValue) asUserdata() (*userdata, bool) {
+	u, ok := v.o.(*userdata)
+	return u, ok
+}
+
+// 以下方法不检查类型,调用者需要保证v的类型
+
+func (v luaValue) integer() int64 {
+	return int64(v.n)
+}
+
+func (v luaValue) float() float64 {
+	return math.Float64frombits(v.n)
+}
+
+func (v luaValue) String() string {
+	return fmt.Sprint(v.goValue())
+}
+
+func typeOf(val luaValue) api.LuaValueType {
+	return tagTypes[val.tag()]
+}
+
+func convertToBoolean(val luaValue) bool {
+	switch val.tag() {
+	case TAG_NIL:
 		return false
-	case bool:
-		return v
+	case TAG_BOOLEAN:
+		return val.n != 0
 	}
 	return true
 }
 
 func convertToFloat(val luaValue) (float64, bool) {
-	switch v := val.(type) {
-	case float64:
-		return v, true
-	case int64:
-		return float64(v), true
-	case string:
-		return number.ParseFloat(v)
+	switch val.tag() {
+	case TAG_FLOAT:
+		return val.float(), true
+	case TAG_INTEGER:
+		return float64(val.integer()), true
+	case TAG_STRING:
+		return number.ParseFloat(strings.Trim(val.o.(string), number.SPACES))
 	}
 	return 0, false
 }
 
 func convertToInteger(val luaValue) (int64, bool) {
-	switch v := val.(type) {
-	case int64:
-		return v, true
-	case float64:
-		return number.FloatToInteger(v)
-	case string:
+	switch val.tag() {
+	case TAG_INTEGER:
+		return val.integer(), true
+	case TAG_FLOAT:
+		return number.FloatToInteger(val.float())
+	case TAG_STRING:
+		v := strings.Trim(val.o.(string), number.SPACES)
 		//如果字符串可以转换为整数
 		if i, ok := number.ParseInteger(v); ok {
 			return i, ok
@@ -103,12 +307,13 @@ func convertToInteger(val luaValue) (int64, bool) {
 
 // 只尝试转换Number类型
 func convertToString(val luaValue) (string, bool) {
-	switch v := val.(type) {
-	case string:
-		return v, true
-	case float64, int64:
-		sv := fmt.Sprintf("%v", v)
-		return sv, true
+	switch val.tag() {
+	case TAG_STRING:
+		return val.o.(string), true
+	case TAG_INTEGER:
+		return number.IntegerToString(val.integer()), true
+	case TAG_FLOAT:
+		return number.FloatToString(val.float()), true
 	}
 	return "", false
 }
@@ -120,24 +325,36 @@ func convertToString(val luaValue) (string, bool) {
 // 设置类型元表
 func setMetaTable(target luaValue, mt *table, ls *luaState) {
 	//如果target是table
-	if t, ok := target.(*table); ok {
+	if t, ok := target.asTable(); ok {
 		t.metaTable = mt
 		return
 	}
+	//userdata同table一样每个值拥有自己的元表
+	if u, ok := target.asUserdata(); ok {
+		u.metaTable = mt
+		return
+	}
 	//如果target是非table类型,则每一种类型对应一个mt
 	key := metaKey(target)
-	ls.registry.put(key, mt)
+	if mt == nil {
+		ls.registry.put(key, nilValue)
+		return
+	}
+	ls.registry.put(key, tableValue(mt))
 }
 
 // 获取类型元表
 func getMetaTable(target luaValue, ls *luaState) *table {
 	//如果target是table
-	if t, ok := target.(*table); ok {
+	if t, ok := target.asTable(); ok {
 		return t.metaTable
 	}
+	if u, ok := target.asUserdata(); ok {
+		return u.metaTable
+	}
 	//如果target是非table类型
 	key := metaKey(target)
-	if t, ok := ls.registry.get(key).(*table); ok {
+	if t, ok := ls.registry.get(key).asTable(); ok {
 		return t
 	}
 	return nil
@@ -145,36 +362,40 @@ func getMetaTable(target luaValue, ls *luaState) *table {
 
 // 从vals中依次尝试获取元方法，如若vals中都没有元方法则返回nil
 func getMetaClosure(ls *luaState, key string, vals ...luaValue) luaValue {
+	//与官方一致,所有类型都可以通过类型元表提供元方法(如debug.setmetatable(10, mt))
 	for _, v := range vals {
-		switch typeOf(v) {
-		case api.LUAVALUE_STRING, api.LUAVALUE_NUMBER: //这些类型能直接转换为string类型,不应当使用元方法所以跳过
-			continue
-		default:
-			mt := getMetaTable(v, ls)
-			if mt != nil {
-				return mt.get(key)
+		//第一个值没有对应的元方法时尝试第二个值
+		if mt := getMetaTable(v, ls); mt != nil {
+			if c := mt.getStr(key); !c.isNil() {
+				return c
 			}
 		}
 	}
-	return nil
+	return nilValue
 }
 
-func metaKey(val luaValue) string {
-	return fmt.Sprintf("_MT_%s", typeOf(val).String())
+// 类型元表在注册表中的键
+var metaKeys = func() map[api.LuaValueType]luaValue {
+	keys := make(map[api.LuaValueType]luaValue)
+	for _, tp := range tagTypes {
+		keys[tp] = stringValue(fmt.Sprintf("_MT_%s", tp.String()))
+	}
+	return keys
+}()
+
+func metaKey(val luaValue) luaValue {
+	return metaKeys[typeOf(val)]
 }
 
 // 调用元方法并弹出nResult个返回值
 func callMetaClosure(ls *luaState, function luaValue, nResult int, params ...luaValue) []luaValue {
-	var c *closure
 	//如果function为nil或其他不为*closure的类型panic
-	if closure, ok := function.(*closure); !ok {
+	if _, ok := function.asClosure(); !ok {
 		panic("call meta func error!")
-	} else {
-		c = closure
 	}
 	nArgs := len(params)
 	ls.CheckStack(1 + nArgs)
-	ls.stack.push(c)           //推入元方法
+	ls.stack.push(function)    //推入元方法
 	for _, v := range params { //推入参数
 		ls.stack.push(v)
 	}
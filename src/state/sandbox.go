@@ -0,0 +1,112 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"nskbz.cn/lua/api"
+)
+
+/*
+*	沙箱
+*
+*	宿主程序通过api.LibProfile选择打开的标准库并移除危险的函数,用于运行不受信任的脚本
+*	只读全局表通过代理实现:代理本身为空表,读取时经__index访问原全局表,赋值时经__newindex抛出错误
+*	代理的元表设置了__metatable,脚本无法通过getmetatable取得原全局表,也无法修改代理的元表
+*	pairs(_G)经__pairs遍历原全局表;next(_G)与rawget(_G, k)直接访问空的代理,不会返回任何内容
+ */
+
+func (s *luaState) OpenLibsWith(profile *api.LibProfile) {
+	if profile == nil {
+		s.OpenLibs()
+		return
+	}
+	libs := profile.Libs
+	if libs == nil {
+		for lib := range stdLibs {
+			libs = append(libs, lib)
+		}
+	}
+	for _, lib := range libs {
+		openf, ok := stdLibs[lib]
+		if !ok {
+			panic(fmt.Sprintf("unknown standard library '%s'", lib))
+		}
+		s.RequireF(lib, openf, true)
+		s.Pop(1)
+	}
+	for _, name := range profile.Strip {
+		s.stripGlobal(name)
+	}
+	if profile.ReadOnlyGlobal {
+		s.protectGlobals()
+	}
+	s.SetLoadMode(profile.LoadMode)
+}
+
+// 移除全局变量name或库字段lib.name
+// 移除整个库时同时将其从package.loaded中移除,避免通过require重新获得
+func (s *luaState) stripGlobal(name string) {
+	if lib, field, ok := strings.Cut(name, "."); ok {
+		if s.GetGlobal(lib) == api.LUAVALUE_TABLE {
+			s.PushNil()
+			s.SetField(-1, field)
+		}
+		s.Pop(1)
+		return
+	}
+	s.PushNil()
+	s.SetGlobal(name)
+	s.GetSubTable(api.LUA_REGISTRY_INDEX, api.LUA_LOADED_TABLE)
+	s.PushNil()
+	s.SetField(-1, name)
+	s.Pop(1)
+}
+
+// 以只读代理替换注册表中的全局表,此后加载的chunk都以代理作为_ENV
+// 原全局表及package.loaded中的"_G"同样指向代理,避免脚本通过_G取得原全局表
+func (s *luaState) protectGlobals() {
+	global := s.registry.getInt(api.LUA_GLOBALS_RIDX)
+	proxy := newTable(0, 0)
+	mt := newTable(0, 4)
+	mt.put(stringValue(META_INDEX), global)
+	mt.put(stringValue(META_NEW_INDEX), closureValue(newGoClosure(readOnlyNewIndex, 0)))
+	next := newGoClosure(readOnlyNext, 1)
+	next.upvals[0] = &upvalue{&global}
+	iter := closureValue(next)
+	pairs := newGoClosure(readOnlyPairs, 1)
+	pairs.upvals[0] = &upvalue{&iter}
+	mt.put(stringValue(META_PAIRS), closureValue(pairs))
+	mt.put(stringValue(META_METATABLE), boolValue(false))
+	proxy.metaTable = mt
+
+	global.o.(*table).put(stringValue("_G"), tableValue(proxy))
+	if loaded, ok := s.registry.getStr(api.LUA_LOADED_TABLE).asTable(); ok && !loaded.getStr("_G").isNil() {
+		loaded.put(stringValue("_G"), tableValue(proxy))
+	}
+	s.registry.put(intValue(api.LUA_GLOBALS_RIDX), tableValue(proxy))
+}
+
+// 只读全局表的__newindex(t, k, v)
+func readOnlyNewIndex(vm api.LuaVM) int {
+	return vm.Error2("attempt to modify read-only global '%s'", vm.ToString2(2))
+}
+
+// 只读全局表的__pairs(t),返回遍历原全局表的迭代函数
+// 状态参数仍为代理,原全局表只保存在迭代函数的upvalue中,不会暴露给脚本
+func readOnlyPairs(vm api.LuaVM) int {
+	vm.PushValue(vm.UpvalueIndex(1)) //迭代函数
+	vm.PushValue(1)
+	vm.PushNil()
+	return 3
+}
+
+// 遍历原全局表的迭代函数(t, k),忽略状态参数t
+func readOnlyNext(vm api.LuaVM) int {
+	vm.SetTop(2)
+	if vm.Next(vm.UpvalueIndex(1)) {
+		return 2
+	}
+	vm.PushNil()
+	return 1
+}
@@ -16,19 +16,28 @@ type luaStack struct {
 	prev    *luaStack
 	closure *closure
 	varargs []luaValue
-	openuvs map[int]upvalue //记录当前函数栈中捕获的外部变量，防止重复捕获
-	pc      int             //下一条指令的pc值
+	openuvs map[int]*upvalue //记录当前函数栈中捕获的外部变量，防止重复捕获,首次捕获时创建
+	tbcs    []int            //待关闭变量的索引,按标记顺序排列
+	pc      int              //下一条指令的pc值
+	hooked  bool             //是否正在该函数栈中执行hook函数
+
+	nResults  int           //调用者期望的返回值数量,函数返回时使用
+	fresh     bool          //是否由Go代码调用,为true时该函数返回后执行循环随之返回
+	tailcall  bool          //是否由尾调用进入,此时它替换了调用者的函数栈
+	depth     int           //在调用链中的层数,协程最底层的函数栈为0
+	base      int           //调用链中位于该函数栈之下的函数栈占用的栈空间
+	lessEqual bool          //正在以not (b < a)计算a <= b,yield之后需要对__lt的结果取反
+	cont      *continuation //Go函数的continuation,yield之后由它代替Go函数继续执行
 
 	state *luaState
 }
 
 func newLuaStack(size int, state *luaState) *luaStack {
 	return &luaStack{
-		slots:   make([]luaValue, size+1),
-		top:     0,
-		pc:      0,
-		openuvs: make(map[int]upvalue),
-		state:   state,
+		slots: make([]luaValue, size+1),
+		top:   0,
+		pc:    0,
+		state: state,
 	}
 }
 
@@ -47,7 +56,7 @@ func (s *luaStack) full() bool {
 // 扩容
 func (s *luaStack) expand(n int) {
 	for i := 0; i < n; i++ {
-		s.slots = append(s.slots, nil)
+		s.slots = append(s.slots, nilValue)
 	}
 }
 
@@ -70,13 +79,13 @@ func (s *luaStack) checkIdx(absidx int) {
 func (s *luaStack) get(absidx int) luaValue {
 	s.checkIdx(absidx)
 	if absidx == api.LUA_REGISTRY_INDEX {
-		return s.state.registry
+		return tableValue(s.state.registry)
 	}
 	if absidx < api.LUA_REGISTRY_INDEX { //UpValue
 		uvIdx := api.LUA_REGISTRY_INDEX - absidx - 1
 		c := s.closure
 		if c == nil || uvIdx >= len(c.upvals) {
-			return nil
+			return nilValue
 		}
 		return *(c.upvals[uvIdx].val)
 	}
@@ -86,7 +95,7 @@ func (s *luaStack) get(absidx int) luaValue {
 func (s *luaStack) set(absidx int, val luaValue) {
 	s.checkIdx(absidx)
 	if absidx == api.LUA_REGISTRY_INDEX {
-		s.state.registry = val.(*table)
+		s.state.registry, _ = val.asTable()
 		return
 	}
 	if absidx < api.LUA_REGISTRY_INDEX { //Upvalue
@@ -107,7 +116,7 @@ func (s *luaStack) pop() luaValue {
 		panic("stack empty")
 	}
 	topval := s.slots[s.top]
-	s.slots[s.top] = nil
+	s.slots[s.top] = nilValue
 	s.top--
 	return topval
 }
@@ -140,7 +149,7 @@ func (s *luaStack) pushN(vals []luaValue, n int) {
 			s.push(vals[i])
 			continue
 		}
-		s.push(nil)
+		s.push(nilValue)
 	}
 }
 
@@ -1,7 +1,10 @@
 package state
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"reflect"
 
 	"nskbz.cn/lua/api"
 	"nskbz.cn/lua/binchunk"
@@ -19,23 +22,53 @@ type luaState struct {
 	//LuaState与LuaThread是1对1的关系
 	coStatus int       //当前协程的状态
 	coFather *luaState //执行当前协程的父协程,注意是执行而非定义即调用resume执行该协程的协程为父协程
-	coChan   chan int  //用于控制协程的执行
+	base     *luaStack //协程最底层的函数栈,不属于任何函数
+	nny      int       //不能yield的调用层数(Go函数调用的函数、hook函数等),大于0时不能yield
+	yielding bool      //协程正在yield,执行循环与调用者应当依次返回至resume
+	nCcalls  int       //Go调用栈上嵌套的调用层数(由Go代码发起的调用以及resume),用于防止Go的调用栈溢出
+	errh     int       //正在执行的错误处理函数的层数,大于0时可以额外使用一部分调用栈
+
+	//hook支持
+	hook          api.HookFunc //hook函数
+	hookMask      int          //hook事件掩码
+	baseHookCount int          //每执行baseHookCount条指令触发一次LUA_HOOKCOUNT
+	hookCount     int          //距离下一次LUA_HOOKCOUNT剩余的指令数
+	inHook        bool         //是否正在执行hook函数,hook函数执行期间不再触发hook
+
+	limit   *execLimit //执行限制,所有coroutine共享
+	version int        //语言版本,api.LUA_VERSION_53或api.LUA_VERSION_54
 }
 
 // 该方法只会被调用一次,即作为主协程执行
 func New() api.LuaVM {
 	r := newTable(0, 0) //新建注册表
 
-	ls := &luaState{registry: r}
+	ls := &luaState{registry: r, limit: &execLimit{}, version: api.LUA_VERSION_53}
 	ls.stack = newLuaStack(api.LUA_MIN_STACK, ls)
+	ls.base = ls.stack
 	ls.coStatus = api.LUA_RUNNING
 	ls.coFather = nil //主协程没有父协程
 
-	r.put(api.LUA_MAIN_COROUTING_RIDX, ls)       //将主协程放入注册表,其key=1
-	r.put(api.LUA_GLOBALS_RIDX, newTable(0, 20)) //添加全局环境表进注册表,其key=2
+	r.put(intValue(api.LUA_MAIN_COROUTING_RIDX), coroutineValue(ls))   //将主协程放入注册表,其key=1
+	r.put(intValue(api.LUA_GLOBALS_RIDX), tableValue(newTable(0, 20))) //添加全局环境表进注册表,其key=2
 	return ls
 }
 
+func (s *luaState) SetVersion(v int) {
+	switch v {
+	case 0, api.LUA_VERSION_53:
+		s.version = api.LUA_VERSION_53
+	case api.LUA_VERSION_54:
+		s.version = api.LUA_VERSION_54
+	default:
+		panic(fmt.Sprintf("unsupported lua version %d", v))
+	}
+}
+
+func (s *luaState) Version() int {
+	return s.version
+}
+
 func (s *luaState) GetTop() int {
 	return s.stack.top
 }
@@ -45,7 +78,7 @@ func (s *luaState) SetTop(idx int) {
 	count := newtop - s.stack.top
 	if newtop > s.stack.top {
 		for i := 0; i < count; i++ {
-			s.stack.push(nil)
+			s.stack.push(nilValue)
 		}
 		return
 	}
@@ -83,6 +116,14 @@ func (s *luaState) UpvalueIndex(i int) int {
 
 // 由于Upvalue是对LocalVar的引用，所以当LocalVar要释放时应当通过这个方法关闭引用它的Upvalue
 func (s *luaState) CloseUpvalues(a int) {
+	s.closeUpvalues(a)
+	if len(s.stack.tbcs) > 0 {
+		s.closeTBC(a, nilValue)
+	}
+}
+
+// 关闭当前函数栈中寄存器R(a-1)及之后的捕获变量
+func (s *luaState) closeUpvalues(a int) {
 	//to do 这里不是很懂
 	//这里为什么要将拷贝Upvalue估计是为了支持并发操作，即使LocalVar释放了，但仍可以操作Upvalue不过无法影响原LocalVar
 	for i, v := range s.stack.openuvs {
@@ -98,7 +139,7 @@ func (s *luaState) isValidIdx(absidx int) bool {
 	if absidx == api.LUA_REGISTRY_INDEX {
 		return true
 	}
-	if absidx < 0 || absidx >= s.stack.len() {
+	if absidx < 0 || absidx > s.stack.len() {
 		return false
 	}
 	return true
@@ -108,10 +149,19 @@ func (s *luaState) CheckStack(n int) {
 	if n < 0 {
 		tool.Fatal(s, fmt.Sprintf("stack can not expand to %d", n))
 	}
+	s.checkStackOverflow(s.stack.depth, s.stack.base+s.stack.top+n)
 	available := s.stack.len() - s.stack.top
 	s.stack.expand(n - available)
 }
 
+func (s *luaState) CheckStackX(n int) bool {
+	if n < 0 || s.stack.base+s.stack.top+n > api.LUA_MAX_STACK {
+		return false
+	}
+	s.CheckStack(n)
+	return true
+}
+
 func (s *luaState) Pop(n int) {
 	s.SetTop(-n)
 }
@@ -169,7 +219,7 @@ func (s *luaState) Rotate(idx, n int) {
 func (s *luaState) doRotate(vals []luaValue, times int) []luaValue {
 	size := len(vals)
 	for i := 0; i < times; i++ {
-		vals = append(vals, nil)
+		vals = append(vals, nilValue)
 	}
 	for i := size - 1; i >= 0; i-- {
 		vals[i+times] = vals[i]
@@ -191,20 +241,39 @@ func (s *luaState) Remove(idx int) {
 /*
 *	压栈操作
  */
-func (s *luaState) PushNil()              { s.stack.push(nil) }
-func (s *luaState) PushBoolean(b bool)    { s.stack.push(b) }
-func (s *luaState) PushInteger(n int64)   { s.stack.push(n) }
-func (s *luaState) PushFloat(n float64)   { s.stack.push(n) }
-func (s *luaState) PushString(str string) { s.stack.push(str) }
+func (s *luaState) PushNil()              { s.stack.push(nilValue) }
+func (s *luaState) PushBoolean(b bool)    { s.stack.push(boolValue(b)) }
+func (s *luaState) PushInteger(n int64)   { s.stack.push(intValue(n)) }
+func (s *luaState) PushFloat(n float64)   { s.stack.push(floatValue(n)) }
+func (s *luaState) PushString(str string) { s.stack.push(stringValue(str)) }
 func (s *luaState) PushBasic(v interface{}) {
-	switch v := v.(type) {
-	case int64, float64, bool, string, nil:
-		s.stack.push(v)
+	switch v.(type) {
+	case int64, float64, bool, string, nil, luaValue: //luaValue为SortI传给compare的值
+		s.stack.push(valueOf(v))
 	default:
 		panic(fmt.Sprintf("not support %v", v))
 	}
 }
 
+func (s *luaState) PushUserdata(v interface{}) {
+	s.stack.push(userdataValue(newUserdata(v)))
+}
+
+func (s *luaState) NewUserdata(size int) []byte {
+	block := make([]byte, size)
+	s.stack.push(userdataValue(newUserdata(block)))
+	return block
+}
+
+// light userdata可以作为table的键并通过==比较,所以只接受可比较的Go值
+// 切片、map、函数(以及包含它们的结构体)应当传入指向它们的指针
+func (s *luaState) PushLightUserdata(p interface{}) {
+	if p != nil && !reflect.ValueOf(p).Comparable() {
+		s.runError("light userdata must wrap a comparable value, got %T", p)
+	}
+	s.stack.push(luaValue{o: lightUserdata{p}})
+}
+
 /*
 *	栈元素访问
  */
@@ -216,7 +285,7 @@ func (s *luaState) TypeName(tp api.LuaValueType) string {
 	case api.LUAVALUE_NIL:
 		return "nil"
 	case api.LUAVALUE_BOOLEAN:
-		return "bool"
+		return "boolean"
 	case api.LUAVALUE_NUMBER:
 		return "number"
 	case api.LUAVALUE_STRING:
@@ -226,12 +295,15 @@ func (s *luaState) TypeName(tp api.LuaValueType) string {
 	case api.LUAVALUE_FUNCTION:
 		return "function"
 	case api.LUAVALUE_COROUTINE:
-		return "coroutine"
+		return "thread"
 	}
 	return "userdata"
 }
 
 func (s *luaState) Type(idx int) api.LuaValueType {
+	if s.IsNone(idx) { //有效但不存在值的索引
+		return api.LUAVALUE_NONE
+	}
 	absidx := s.AbsIndex(idx)
 	return typeOf(s.stack.get(absidx))
 }
@@ -258,6 +330,14 @@ func (s *luaState) IsInteger(idx int) bool {
 	return b
 }
 
+func (s *luaState) IsIntegerSubtype(idx int) bool {
+	if s.IsNone(idx) {
+		return false
+	}
+	absidx := s.AbsIndex(idx)
+	return s.stack.get(absidx).is(TAG_INTEGER)
+}
+
 func (s *luaState) IsFloat(idx int) bool {
 	_, b := s.ToFloatX(idx)
 	return b
@@ -269,15 +349,15 @@ func (s *luaState) IsString(idx int) bool {
 }
 
 func (s *luaState) IsTable(idx int) bool {
-	return true
+	return !s.IsNone(idx) && s.Type(idx) == api.LUAVALUE_TABLE
 }
 
 func (s *luaState) IsCoroutine(idx int) bool {
-	return true
+	return !s.IsNone(idx) && s.Type(idx) == api.LUAVALUE_COROUTINE
 }
 
 func (s *luaState) IsFunction(idx int) bool {
-	return true
+	return !s.IsNone(idx) && s.Type(idx) == api.LUAVALUE_FUNCTION
 }
 
 func (s *luaState) ToBoolean(idx int) bool {
@@ -321,7 +401,33 @@ func (s *luaState) ToStringX(idx int) (string, bool) {
 
 func (s *luaState) ToPointer(idx int) interface{} {
 	absidx := s.AbsIndex(idx)
-	return s.stack.get(absidx)
+	switch v := s.stack.get(absidx).o.(type) {
+	case *table, *closure, *luaState, *userdata:
+		return v
+	case lightUserdata:
+		return v.p
+	}
+	return nil
+}
+
+func (s *luaState) IsUserdata(idx int) bool {
+	tp := s.Type(idx)
+	return tp == api.LUAVALUE_USERDATA || tp == api.LUAVALUE_LIGHTUSERDATA
+}
+
+func (s *luaState) IsLightUserdata(idx int) bool {
+	return s.Type(idx) == api.LUAVALUE_LIGHTUSERDATA
+}
+
+func (s *luaState) ToUserdata(idx int) interface{} {
+	absidx := s.AbsIndex(idx)
+	switch u := s.stack.get(absidx).o.(type) {
+	case *userdata:
+		return u.value
+	case lightUserdata:
+		return u.p
+	}
+	return nil
 }
 
 /*
@@ -334,17 +440,18 @@ func (s *luaState) Arith(op api.ArithOp) {
 	}
 	var result luaValue
 	var success bool
+	var a luaValue
 	b := s.stack.pop()
 	//区分一元运算与二元运算
 	if op >= api.ArithOp_OPPOSITE {
 		result, success = doUnitaryArith(b, operation, s)
 	} else {
-		a := s.stack.pop()
+		a = s.stack.pop()
 		result, success = doDualArith(a, b, operation, s)
 	}
 
 	if !success {
-		panic(fmt.Sprintf("Arith error =>%s", op.String()))
+		s.arithError(op, a, b)
 	}
 	s.stack.push(result) //结果压入栈
 }
@@ -366,18 +473,24 @@ func (s *luaState) Compare(idx1, idx2 int, op api.CompareOp) bool {
 func (s *luaState) Len(idx int) {
 	absidx := s.AbsIndex(idx)
 	val := s.stack.get(absidx)
-	switch x := val.(type) {
+	switch x := val.o.(type) {
 	case string:
-		s.stack.push(int64(len(x)))
+		s.stack.push(intValue(int64(len(x))))
 	case *table:
-		if c := getMetaClosure(s, META_LEN, val); c != nil {
+		if c := getMetaClosure(s, META_LEN, val); !c.isNil() {
 			result := callMetaClosure(s, c, 1, val)
 			s.stack.push(result[0])
 			break
 		}
-		s.stack.push(int64(x.len()))
+		s.stack.push(intValue(int64(x.len())))
 	default:
-		panic(fmt.Sprintf("no supported length for %v", x))
+		//其他类型只能通过元方法获取长度
+		if c := getMetaClosure(s, META_LEN, val); !c.isNil() {
+			result := callMetaClosure(s, c, 1, val)
+			s.stack.push(result[0])
+			break
+		}
+		s.runError("attempt to get length of a %s value", s.TypeName(typeOf(val)))
 	}
 }
 
@@ -386,27 +499,30 @@ func (s *luaState) Concat(n int) {
 		return
 	}
 	if n == 0 {
-		s.stack.push("")
+		s.stack.push(stringValue(""))
 		return
 	}
-	from := s.AbsIndex(-(n - 1))
-	s.stack.reverse(from, s.stack.top)
+	//连接是右结合的,与官方一致从栈顶的两个值开始依次向下连接
 	for i := n; i > 1; i-- { //当n==1时只剩一个元素故结束
-		a := s.stack.pop()
 		b := s.stack.pop()
+		a := s.stack.pop()
 		if x, ok := convertToString(a); ok {
 			if y, ok := convertToString(b); ok {
-				s.stack.push(x + y)
+				s.stack.push(stringValue(x + y))
 				continue
 			}
 		}
 
 		//如果不能转换为string,则调用元方法
-		if c := getMetaClosure(s, META_CONCAT, a, b); c != nil {
+		if c := getMetaClosure(s, META_CONCAT, a, b); !c.isNil() {
 			result := callMetaClosure(s, c, 1, a, b)
 			s.stack.push(result[0])
 		} else {
-			panic("do not found meta function for " + META_CONCAT)
+			//a为左操作数,b为右操作数
+			if _, ok := convertToString(a); ok {
+				a = b
+			}
+			s.runError("attempt to concatenate a %s value", s.TypeName(typeOf(a)))
 		}
 	}
 }
@@ -419,8 +535,7 @@ func (s *luaState) NewTable() {
 }
 
 func (s *luaState) CreateTable(nArr, nPair int) {
-	table := newTable(nArr, nPair)
-	s.stack.push(table)
+	s.stack.push(tableValue(newTable(nArr, nPair)))
 }
 
 func (s *luaState) GetTable(idx int) api.LuaValueType {
@@ -435,41 +550,45 @@ func (s *luaState) GetTable(idx int) api.LuaValueType {
 func (s *luaState) GetField(idx int, k string) api.LuaValueType {
 	absidx := s.AbsIndex(idx)
 	t := s.stack.get(absidx)
-	return s.getTableVal(t, k, false)
+	return s.getTableVal(t, stringValue(k), false)
 }
 
 func (s *luaState) GetI(idx int, i int64) api.LuaValueType {
 	absidx := s.AbsIndex(idx)
 	t := s.stack.get(absidx)
-	return s.getTableVal(t, i, false)
+	return s.getTableVal(t, intValue(i), false)
 }
 
 // 获取t中键k的val的类型，并将val压入栈顶
 func (s *luaState) getTableVal(t luaValue, k luaValue, raw bool) api.LuaValueType {
-	if api.LUAVALUE_TABLE == typeOf(t) {
-		tb := t.(*table)
-		//是否采用元方法||t是表但t[k]是否存在||t没有META_INDEX元方法
-		if raw || tb.get(k) != nil || !tb.hasMetaFunc(META_INDEX) {
-			val := tb.get(k)
-			s.stack.push(val)
-			return typeOf(val) //如果k不存在且没有元方法则返回nil
+	for loop := 0; loop < MAXTAGLOOP; loop++ {
+		if tb, ok := t.asTable(); ok {
+			//是否采用元方法||t是表但t[k]是否存在||t没有META_INDEX元方法
+			if val := tb.get(k); raw || !val.isNil() || !tb.hasMetaFunc(META_INDEX) {
+				s.stack.push(val)
+				return typeOf(val) //如果k不存在且没有元方法则返回nil
+			}
 		}
-	}
 
-	//不采用元方法,t不是表或k在表中的值不存在且没有__index元方法
-	if !raw {
-		if val := getMetaClosure(s, META_INDEX, t); val != nil {
-			switch x := val.(type) {
-			case *table: //t是表但不存在k的值
-				return s.getTableVal(x, k, false)
-			case *closure: //t拥有META_INDEX函数
-				result := callMetaClosure(s, val, 1, t, k)
-				s.stack.push(result[0])
-				return typeOf(result[0])
+		//不采用元方法,t不是表或k在表中的值不存在且没有__index元方法
+		if !raw {
+			if mt := getMetaTable(t, s); mt != nil {
+				val := mt.getStr(META_INDEX)
+				switch val.tag() {
+				case TAG_TABLE: //t是表但不存在k的值,沿着__index继续查找
+					t = val
+					continue
+				case TAG_FUNCTION: //t拥有META_INDEX函数
+					result := callMetaClosure(s, val, 1, t, k)
+					s.stack.push(result[0])
+					return typeOf(result[0])
+				}
 			}
 		}
+		s.runError("attempt to index a %s value", s.TypeName(typeOf(t)))
 	}
-	panic(fmt.Sprintf("type[%s] is not a table", typeOf(t).String()))
+	s.runError("'__index' chain too long; possibly a loop")
+	return api.LUAVALUE_NIL
 }
 
 func (s *luaState) SetTable(idx int) {
@@ -484,40 +603,47 @@ func (s *luaState) SetField(idx int, k string) {
 	absidx := s.AbsIndex(idx)
 	t := s.stack.get(absidx)
 	val := s.stack.pop()
-	s.setTableKV(t, k, val, false)
+	s.setTableKV(t, stringValue(k), val, false)
 }
 
 func (s *luaState) SetI(idx int, i int64) {
 	absidx := s.AbsIndex(idx)
 	t := s.stack.get(absidx)
 	val := s.stack.pop()
-	s.setTableKV(t, i, val, false)
+	s.setTableKV(t, intValue(i), val, false)
 }
 
 func (s *luaState) setTableKV(t luaValue, k, v luaValue, raw bool) {
-	if api.LUAVALUE_TABLE == typeOf(t) {
-		tb := t.(*table)
-		if raw || tb.get(k) != nil || !tb.hasMetaFunc(META_NEW_INDEX) {
-			tb.put(k, v)
-			return
+	for loop := 0; loop < MAXTAGLOOP; loop++ {
+		if tb, ok := t.asTable(); ok {
+			if raw || !tb.get(k).isNil() || !tb.hasMetaFunc(META_NEW_INDEX) {
+				if k.isNil() {
+					s.runError("table index is nil")
+				} else if f, ok := k.asFloat(); ok && math.IsNaN(f) {
+					s.runError("table index is NaN")
+				}
+				tb.put(k, v)
+				return
+			}
 		}
-	}
 
-	//采用元方法,t不是表或k在表中的值不存在
-	if !raw {
-		if val := getMetaClosure(s, META_NEW_INDEX, t); val != nil {
-			switch x := val.(type) {
-			case *table: //t是表但不存在k的值
-				s.setTableKV(x, k, v, false)
-				return
-			case *closure: //t拥有META_NEW_INDEX函数
-				callMetaClosure(s, x, 0, val, t, k, v)
-				return
+		//采用元方法,t不是表或k在表中的值不存在
+		if !raw {
+			if val := getMetaClosure(s, META_NEW_INDEX, t); !val.isNil() {
+				switch val.tag() {
+				case TAG_TABLE: //t是表但不存在k的值,沿着__newindex继续设置
+					t = val
+					continue
+				case TAG_FUNCTION: //t拥有META_NEW_INDEX函数
+					callMetaClosure(s, val, 0, t, k, v)
+					return
+				}
 			}
 		}
-	}
 
-	panic(fmt.Sprintf("type[%d] is not a table", typeOf(t)))
+		s.runError("attempt to index a %s value", s.TypeName(typeOf(t)))
+	}
+	s.runError("'__newindex' chain too long; possibly a loop")
 }
 
 // 删除指定idx的table中arr数组中索引为i的值,并重新复制一份新的数组替换,最后将删除的值压入栈顶
@@ -529,7 +655,7 @@ func (s *luaState) RemoveI(idx int, i int64) {
 	if typeOf(t) != api.LUAVALUE_TABLE {
 		s.Error2("expected table!")
 	}
-	table := t.(*table)
+	table := t.o.(*table)
 	newArr := make([]luaValue, len(table._arr)-1)
 	for j, k := 0, 0; j < len(newArr); k++ {
 		if k == int(i)-1 {
@@ -557,21 +683,11 @@ func (s *luaState) SortI(idx int, compare func(interface{}, interface{}) bool) {
 	if typeOf(t) != api.LUAVALUE_TABLE {
 		s.Error2("expected table!")
 	}
-	table := t.(*table)
-	//如果compare为空,默认用生序
+	table := t.o.(*table)
+	//如果compare为空,默认用升序,与lua中的a < b一致(支持字符串以及__lt元方法)
 	if compare == nil {
 		compare = func(lv1, lv2 interface{}) bool {
-			if typeOf(lv1) == api.LUAVALUE_NUMBER {
-				switch a := lv1.(type) {
-				case int64:
-					return int(lv2.(int64)-a) > 0
-				case float64:
-					return int(lv2.(float64)-a) > 0
-				default:
-					tool.Fatal(s, fmt.Sprintf("sort not support %s", typeOf(lv1).String()))
-				}
-			}
-			return false
+			return doLt(lv1.(luaValue), lv2.(luaValue), s)
 		}
 	}
 
@@ -610,6 +726,9 @@ func (s *luaState) SortI(idx int, compare func(interface{}, interface{}) bool) {
 *	call stack: 	nil	(means obver)
  */
 func (s *luaState) pushContext(f *luaStack) {
+	f.depth = s.stack.depth + 1
+	f.base = s.stack.base + s.stack.top
+	s.checkStackOverflow(f.depth, f.base+f.len())
 	f.prev = s.stack
 	s.stack = f //切换执行函数
 }
@@ -625,10 +744,30 @@ func (s *luaState) LoadWithEnv(chunk []byte, chunkName string, mode string, env
 		return api.LUA_ERR_RUN
 	}
 	var proto *binchunk.Prototype
-	if binchunk.LUA_SIGNATURE == string(chunk[:4]) {
-		proto = binchunk.Undump(chunk)
+	kind := "text"
+	if bytes.HasPrefix(chunk, []byte(binchunk.LUA_SIGNATURE)) {
+		kind = "binary"
+	}
+	for _, m := range []string{mode, s.limit.loadMode} { //同时满足参数与LuaState的限制
+		if err := checkLoadMode(m, kind); err != nil {
+			s.stack.push(stringValue(err.Error()))
+			return api.LUA_ERR_SYNTAX
+		}
+	}
+	if kind == "binary" {
+		var err error
+		proto, err = binchunk.Undump(chunk, chunkName)
+		if err != nil {
+			s.stack.push(stringValue(err.Error())) //chunk格式错误或指令不合法
+			return api.LUA_ERR_SYNTAX
+		}
 	} else {
-		proto = compile.Compile(chunk, chunkName) //如果不是LUA二进制形式,则采取源代码模式,即对其进行编译
+		var err error
+		proto, err = compile.Compile(chunk, chunkName, s.version) //如果不是LUA二进制形式,则采取源代码模式,即对其进行编译
+		if err != nil {
+			s.stack.push(stringValue(err.Error())) //语法错误时将错误信息压入栈
+			return api.LUA_ERR_SYNTAX
+		}
 	}
 	c := newLuaClosure(proto)
 	if len(proto.Upvalues) > 0 {
@@ -636,18 +775,42 @@ func (s *luaState) LoadWithEnv(chunk []byte, chunkName string, mode string, env
 		if typeOf(et) != api.LUAVALUE_TABLE {
 			tool.Fatal(s, fmt.Sprintf("env expected a table,no %s", typeOf(et).String()))
 		}
-		c.upvals[0] = upvalue{&et}
+		c.upvals[0] = &upvalue{&et}
+		for i := 1; i < len(c.upvals); i++ { //其余的upvalue(例如string.dump得到的chunk)初始化为nil
+			var v luaValue
+			c.upvals[i] = &upvalue{&v}
+		}
 	}
-	s.stack.push(c)
+	s.stack.push(closureValue(c))
 	return api.LUA_OK
 }
 
+func (s *luaState) Dump(strip bool) ([]byte, bool) {
+	c, ok := s.stack.get(s.stack.top).asClosure()
+	if !ok || c.proto == nil {
+		return nil, false
+	}
+	if strip {
+		return c.proto.Strip().ToBytes(), true
+	}
+	return c.proto.ToBytes(), true
+}
+
 func (s *luaState) Load(chunk []byte, chunckName, mode string) int {
-	env := s.registry.get(api.LUA_GLOBALS_RIDX) //默认环境为"_G"全局表
+	env := s.registry.getInt(api.LUA_GLOBALS_RIDX) //默认环境为"_G"全局表
 	return s.LoadWithEnv(chunk, chunckName, mode, env)
 }
 
-func (s *luaState) doLuaFunc(nResults int, c *closure, args []luaValue) {
+/*
+*	函数调用
+*
+*	lua函数之间的调用不占用Go的调用栈:CALL指令只为被调的lua函数创建调用帧,由同一个执行循环继续执行,
+*	被调函数返回时执行循环切换回调用者的函数栈,并由Finish完成调用者被中断的CALL指令
+*	Go代码(Go函数、元方法等)调用lua函数时才会开始新的执行循环(fresh),该函数返回时执行循环随之返回
+ */
+
+// 创建lua函数的调用帧并切换至该函数栈,tail表示由尾调用进入
+func (s *luaState) pushLuaFrame(nResults int, c *closure, args []luaValue, tail bool) {
 	stackSize := int(c.proto.MaxRegisterSize)
 	numParams := int(c.proto.NumParams)
 	isVararg := c.proto.IsVararg == 1
@@ -662,81 +825,188 @@ func (s *luaState) doLuaFunc(nResults int, c *closure, args []luaValue) {
 	//  寄存器空间			   临时空间
 	stack := newLuaStack(api.LUA_MIN_STACK+stackSize, s)
 	stack.closure = c
+	stack.nResults = nResults
+	stack.tailcall = tail
 	if isVararg && len(args) > numParams {
 		stack.varargs = args[numParams:]
 	}
 	stack.pushN(args, numParams)
 	stack.top = stackSize //这一步必须放在push参数后位置才对
 
-	//切换上下文并调用函数
+	//切换上下文
 	s.pushContext(stack)
-	s.doLuaFuncCall()
-	s.popContext()
-
-	//保存返回值至主调函数栈
-	//nResults==0则不返回任何值
-	//nResults<0则返回值全部压入,nResults>0则返回nResults个返回值
-	if nResults != 0 {
-		results := stack.popN(stack.top - stackSize) //将临时空间的方法返回值弹出
-		s.CheckStack(len(results))
-		s.stack.pushN(results, nResults) //nResults<0则返回值全部压入,nResults>0则返回nResults个返回值
+	if s.hookMask&api.LUA_MASKCALL != 0 {
+		if tail {
+			s.callHook(api.LUA_HOOKTAILCALL, -1)
+		} else {
+			s.callHook(api.LUA_HOOKCALL, -1)
+		}
 	}
 }
 
-func (s *luaState) doLuaFuncCall() {
+// 执行当前的lua函数栈,直到fresh的函数栈返回
+// top为true表示由resume直接驱动,yield时正常返回;否则调用者为Go代码,yield时需要展开Go的调用栈
+func (s *luaState) execute(top bool) {
+	lastPc := s.stack.pc - 1 //上一条执行的指令,用于触发line hook
 	for {
+		s.limit.step(s)
+		if s.hookMask&(api.LUA_MASKLINE|api.LUA_MASKCOUNT) != 0 {
+			lastPc = s.traceExec(lastPc)
+		}
+		stack := s.stack
 		i := instruction.Instruction(s.Fetch()) //每次获取下一指令的同时会使PC++，即又指向下一个指令
-		tool.Trace(i.Info())
+		//Info的格式化开销较大,只在需要时调用
+		if tool.LogLevel == tool.LOG_TRACE {
+			tool.Trace(i.Info())
+		}
 		i.Execute(s)
-		if i.Name() == "RETURN  " {
-			break
+
+		if s.yielding { //CALL指令调用的Go函数yield
+			if !top {
+				panic(yieldSignal)
+			}
+			return
+		}
+		if s.stack != stack { //CALL指令进入了新的lua函数
+			lastPc = -1
+			continue
+		}
+		if i.Opcode() == instruction.OP_RETURN {
+			//返回值位于寄存器之上的临时空间
+			s.postCall(stack.top - int(stack.closure.proto.MaxRegisterSize))
+			if stack.fresh {
+				return
+			}
+			s.finishOp() //完成调用者的CALL指令
+			lastPc = s.stack.pc - 1
 		}
 	}
 }
 
+// 执行Go函数,yield时保留其函数栈直接返回
 func (s *luaState) doGoFunc(nResults int, c *closure, args []luaValue) {
 	//准备Go函数调用帧，Go函数的调用帧栈不需要寄存器所以无需设置top值
 	nArgs := len(args)
 	stack := newLuaStack(api.LUA_MIN_STACK+nArgs, s)
 	stack.pushN(args, nArgs)
 	stack.closure = c
+	stack.nResults = nResults
 
 	//Go函数调用执行
 	s.pushContext(stack)
+	if s.hookMask&api.LUA_MASKCALL != 0 {
+		s.callHook(api.LUA_HOOKCALL, -1)
+	}
 	nr := c.goFunc(s)
+	if s.yielding {
+		return
+	}
+	s.postCall(nr)
+}
+
+// 当前函数返回:关闭待关闭变量并切换回调用者的函数栈,将栈顶的nr个返回值按调用者的期望压入调用者的栈中
+func (s *luaState) postCall(nr int) {
+	stack := s.stack
+	if len(stack.tbcs) > 0 { //返回值已经位于栈顶,关闭待关闭变量不会影响返回值
+		s.closeTBC(0, nilValue)
+	}
+	if s.hookMask&api.LUA_MASKRET != 0 {
+		s.callHook(api.LUA_HOOKRET, -1)
+	}
 	s.popContext()
 
 	//nResults==0则不返回任何值
 	//nResults<0则返回值全部压入,nResults>0则返回nResults个返回值
-	if nResults != 0 {
+	if stack.nResults != 0 {
 		results := stack.popN(nr)
 		s.CheckStack(len(results))
-		s.stack.pushN(results, nResults) //nResults<0则返回值全部压入,nResults>0则返回nResults个返回值
+		s.stack.pushN(results, stack.nResults)
 	}
 }
 
-func (s *luaState) Call(nArgs, nResults int) {
+// 调用栈顶的函数:Go函数直接执行,lua函数只创建调用帧
+// 返回true表示调用尚未完成(进入了lua函数或者Go函数yield),此时返回值还不在调用者的栈中
+func (s *luaState) PreCall(nArgs, nResults int) bool {
+	c, args := s.popFuncAndArgs(nArgs)
+	if c.goFunc != nil {
+		s.doGoFunc(nResults, c, args)
+		return s.yielding //yield时CALL指令不能设置返回值
+	}
+	s.pushLuaFrame(nResults, c, args, false)
+	return true
+}
+
+// 尾调用栈顶的函数:lua函数的调用帧替换当前的函数栈,返回值直接返回给当前函数的调用者,因此尾递归不会使调用链增长
+// Go函数以及当前函数还有待关闭变量时(二进制chunk可能不满足编译器的限制)与PreCall相同
+func (s *luaState) PreTailCall(nArgs int) bool {
+	caller := s.stack
+	if len(caller.tbcs) > 0 {
+		return s.PreCall(nArgs, api.LUA_MULTRET)
+	}
+	c, args := s.popFuncAndArgs(nArgs)
+	if c.goFunc != nil {
+		s.doGoFunc(api.LUA_MULTRET, c, args)
+		return s.yielding
+	}
+	s.popContext()
+	s.pushLuaFrame(caller.nResults, c, args, true)
+	s.stack.fresh = caller.fresh
+	return true
+}
+
+// 弹出栈顶的函数及其nArgs个参数,不是函数时使用其__call元方法,原值作为元方法的第一个参数
+func (s *luaState) popFuncAndArgs(nArgs int) (*closure, []luaValue) {
 	vals := s.stack.popN(nArgs + 1) //弹出1个func和nArgs个参数
-	c, ok := vals[0].(*closure)
-	//如若不能转换为函数则寻找该值的META_CALL元方法
-	if !ok {
-		if mc := getMetaClosure(s, META_CALL, vals[0]); mc == nil {
+	c, ok := vals[0].asClosure()
+	//如若不能转换为函数则寻找该值的META_CALL元方法,元方法本身也可能需要通过META_CALL调用
+	for !ok {
+		mc := getMetaClosure(s, META_CALL, vals[0])
+		if mc.isNil() {
 			//不是函数且没有META_CALL元方法报错
 			//load装载函数中env如果没有对应的方法也会使得该错误发生
-			tool.Fatal(s, fmt.Sprintf("[%s] is not a closure", typeOf(vals[0]).String()))
-		} else {
-			if _c, ok := mc.(*closure); ok {
-				c = _c
-				vals = append([]luaValue{nil}, vals...)
-			}
+			s.runError("attempt to call a %s value", s.TypeName(typeOf(vals[0])))
 		}
+		vals = append([]luaValue{mc}, vals...) //原值作为元方法的第一个参数
+		c, ok = mc.asClosure()
 	}
+	return c, vals[1:]
+}
 
-	if c.goFunc != nil {
-		s.doGoFunc(nResults, c, vals[1:])
-	} else {
-		s.doLuaFunc(nResults, c, vals[1:])
+// 由Go代码调用函数,lua函数以新的执行循环执行直到其返回
+func (s *luaState) call(nArgs, nResults int) {
+	s.nCcalls++
+	s.checkCStackOverflow()
+	if s.PreCall(nArgs, nResults) {
+		if s.yielding { //Go函数yield,不能回到调用者的Go代码中
+			panic(yieldSignal)
+		}
+		s.stack.fresh = true
+		s.execute(false)
 	}
+	s.nCcalls--
+}
+
+func (s *luaState) Call(nArgs, nResults int) {
+	s.CallK(nArgs, nResults, 0, nil)
+}
+
+func (s *luaState) CallK(nArgs, nResults, ctx int, k api.KFunc) {
+	caller := s.stack
+	if caller.closure != nil && caller.closure.proto != nil {
+		//lua指令发起的调用(元方法、迭代器等),yield之后由Finish完成该指令
+		s.call(nArgs, nResults)
+		return
+	}
+	if k != nil && s.IsYieldable() {
+		caller.cont = &continuation{k: k, ctx: ctx, status: api.LUA_SUSPENDED}
+		s.call(nArgs, nResults)
+		caller.cont = nil
+		return
+	}
+	//没有continuation的Go函数不能在被调函数yield之后继续执行
+	s.nny++
+	s.call(nArgs, nResults)
+	s.nny--
 }
 
 /*
@@ -746,15 +1016,15 @@ func (s *luaState) PushGoFunction(gf api.GoFunc, n int) {
 	gc := newGoClosure(gf, n)
 	for i := 0; i < n; i++ {
 		val := s.stack.pop()
-		gc.upvals[n-i-1] = upvalue{&val} //捕获变量
+		gc.upvals[n-i-1] = &upvalue{&val} //捕获变量
 	}
-	s.stack.push(gc)
+	s.stack.push(closureValue(gc))
 }
 
 func (s *luaState) IsGoFunction(idx int) bool {
 	absidx := s.AbsIndex(idx)
 	gf := s.stack.get(absidx)
-	if c, ok := gf.(*closure); ok {
+	if c, ok := gf.asClosure(); ok {
 		return c.goFunc != nil
 	}
 	return false
@@ -763,7 +1033,7 @@ func (s *luaState) IsGoFunction(idx int) bool {
 func (s *luaState) ToGoFunction(idx int) api.GoFunc {
 	absidx := s.AbsIndex(idx)
 	gf := s.stack.get(absidx)
-	if c, ok := gf.(*closure); ok {
+	if c, ok := gf.asClosure(); ok {
 		return c.goFunc
 	}
 	panic(fmt.Sprintf("[%s] is not a closure", typeOf(gf).String()))
@@ -773,23 +1043,22 @@ func (s *luaState) ToGoFunction(idx int) api.GoFunc {
 *	全局环境支持
  */
 func (s *luaState) PushGlobalTable() {
-	s.stack.push(s.registry.get(api.LUA_GLOBALS_RIDX))
+	s.stack.push(s.registry.getInt(api.LUA_GLOBALS_RIDX))
 }
 
 func (s *luaState) GetGlobal(key string) api.LuaValueType {
-	s.PushGlobalTable()
-	s.PushString(key)
-	return s.GetTable(-1)
+	global := s.registry.getInt(api.LUA_GLOBALS_RIDX)
+	return s.getTableVal(global, stringValue(key), false)
 }
 
 func (s *luaState) SetGlobal(key string) {
-	global := s.registry.get(api.LUA_GLOBALS_RIDX)
+	global := s.registry.getInt(api.LUA_GLOBALS_RIDX)
 	val := s.stack.pop()
-	s.setTableKV(global, key, val, true)
+	s.setTableKV(global, stringValue(key), val, true)
 }
 
 func (s *luaState) Register(key string, gf api.GoFunc) {
-	s.stack.push(newGoClosure(gf, 0))
+	s.stack.push(closureValue(newGoClosure(gf, 0)))
 	s.SetGlobal(key)
 }
 
@@ -800,7 +1069,7 @@ func (s *luaState) GetMetaTable(idx int) bool {
 	absidx := s.AbsIndex(idx)
 	val := s.stack.get(absidx)
 	if mt := getMetaTable(val, s); mt != nil {
-		s.stack.push(mt)
+		s.stack.push(tableValue(mt))
 		return true
 	}
 	return false
@@ -810,19 +1079,38 @@ func (s *luaState) SetMetaTable(idx int) {
 	absidx := s.AbsIndex(idx)
 	val := s.stack.get(absidx)
 	mt := s.stack.pop()
-	if mt == nil {
+	if mt.isNil() {
 		setMetaTable(val, nil, s)
-	} else if t, ok := mt.(*table); ok {
+	} else if t, ok := mt.asTable(); ok {
 		setMetaTable(val, t, s)
 	} else {
 		panic("table expected!")
 	}
 }
 
+func (s *luaState) GetUserValue(idx int) api.LuaValueType {
+	absidx := s.AbsIndex(idx)
+	u, ok := s.stack.get(absidx).asUserdata()
+	if !ok {
+		panic("full userdata expected!")
+	}
+	s.stack.push(u.userValue)
+	return typeOf(u.userValue)
+}
+
+func (s *luaState) SetUserValue(idx int) {
+	absidx := s.AbsIndex(idx)
+	u, ok := s.stack.get(absidx).asUserdata()
+	if !ok {
+		panic("full userdata expected!")
+	}
+	u.userValue = s.stack.pop()
+}
+
 func (s *luaState) RawLen(idx int) int {
 	absidx := s.AbsIndex(idx)
 	val := s.stack.get(absidx)
-	switch x := val.(type) {
+	switch x := val.o.(type) {
 	case string:
 		return len(x)
 	case *table:
@@ -857,14 +1145,14 @@ func (s *luaState) RawSet(idx int) {
 func (s *luaState) RawGetI(idx int, i int64) api.LuaValueType {
 	absidx := s.AbsIndex(idx)
 	t := s.stack.get(absidx)
-	return s.getTableVal(t, i, true)
+	return s.getTableVal(t, intValue(i), true)
 }
 
 func (s *luaState) RawSetI(idx int, i int64) {
 	absidx := s.AbsIndex(idx)
 	t := s.stack.get(absidx)
 	v := s.stack.pop()
-	s.setTableKV(t, i, v, true)
+	s.setTableKV(t, intValue(i), v, true)
 }
 
 /*
@@ -874,13 +1162,16 @@ func (s *luaState) Next(idx int) bool {
 	absidx := s.AbsIndex(idx)
 	val := s.stack.get(absidx)
 
-	if t, ok := val.(*table); ok {
+	if t, ok := val.asTable(); ok {
 		k := s.stack.pop()
-		nk := t.nextKey(k)
-		nv := t.get(nk)
-		if nk == nil {
+		nk, ok := t.nextKey(k)
+		if !ok {
+			s.runError("invalid key to 'next'")
+		}
+		if nk.isNil() {
 			return false
 		}
+		nv := t.get(nk)
 		s.stack.push(nk)
 		s.stack.push(nv)
 		return true
@@ -894,29 +1185,43 @@ func (s *luaState) Next(idx int) bool {
  */
 func (s *luaState) Error() int {
 	err := s.stack.pop() //默认错误在栈顶
-	panic(err)
+	panic(&luaError{err})
 }
 
 // 以保护模式执行方法,调用期间如果出现panic并不会停止运行而是立马抛出异常
 // 如果errhandler==true则表明有错误处理函数且位于索引1位置
+//
+// 出错时调用栈会恢复至被调函数所在位置,并压入错误值(有错误处理函数时为其返回值)
+// 恢复调用栈的同时关闭途经函数栈中的待关闭变量
 func (s *luaState) PCall(nArgs, nResults int, hasErrhandler bool) (status int) {
+	return s.PCallK(nArgs, nResults, hasErrhandler, 0, nil)
+}
+
+// 能yield时被调函数不在这里拦截错误,而是由resume找到该函数栈并以错误状态调用k
+func (s *luaState) PCallK(nArgs, nResults int, hasErrhandler bool, ctx int, k api.KFunc) (status int) {
+	caller := s.stack              //存储调用函数栈
+	base := caller.top - nArgs - 1 //被调函数之下的栈顶
+	if k != nil && s.IsYieldable() {
+		caller.cont = &continuation{k: k, ctx: ctx, status: api.LUA_SUSPENDED, pcall: true, base: base, errh: hasErrhandler, nCcalls: s.nCcalls}
+		s.call(nArgs, nResults)
+		caller.cont = nil
+		return api.LUA_OK
+	}
+
 	status = api.LUA_ERR_RUN
-	caller := s.stack //存储调用函数栈
+	nny, nCcalls := s.nny, s.nCcalls
 	defer func() {
 		//Call过程中如果panic了，会被这里拦截下来并存放一个err至栈顶
-		if err := recover(); err != nil {
-			for s.stack != caller {
-				s.popContext()
-			} //恢复至调用函数上下文
+		if r := recover(); r != nil {
+			err := s.errorValue(r)
 			if hasErrhandler {
-				s.SetTop(1)       //只保留errhandler
-				s.stack.push(err) //在调用函数栈中压入err
-				s.Call(1, api.LUA_MULTRET)
-				return
-			} else {
-				s.SetTop(0)
-				s.stack.push(err) //在调用函数栈中压入err
+				//错误处理函数需要在调用栈恢复之前执行,这样才能获取出错位置的调用栈信息
+				err, status = s.callErrHandler(caller.get(1), err)
 			}
+			err = s.unwind(caller, err) //恢复至调用函数上下文
+			s.nny, s.nCcalls = nny, nCcalls
+			s.SetTop(base)
+			s.stack.push(err) //在调用函数栈中压入err
 		}
 		tool.Warning("\npcall status = %d\n\n", status)
 	}()
@@ -925,101 +1230,34 @@ func (s *luaState) PCall(nArgs, nResults int, hasErrhandler bool) (status int) {
 	return
 }
 
-/*
-* 协程支持
- */
-
-func (s *luaState) isMainCoroutine() bool {
-	return s.registry.get(api.LUA_MAIN_COROUTING_RIDX) == s
-}
-
-// 创建coroutine,与创建该coroutine的协程共享registry,全局表也是属于registry的所以全局变量也是共享的
-func (s *luaState) NewCoroutine() api.LuaState {
-	ls := &luaState{registry: s.registry}
-	ls.stack = newLuaStack(api.LUA_MIN_STACK, ls)
-	ls.coStatus = api.LUA_SUSPENDED //新创建的coroutine初始状态为挂起
-	s.stack.push(ls)                //将新创建的coroutine压入栈
-	return ls
-}
-
-// 将当前coroutine推入栈,并返回是否为主coroutine
-func (s *luaState) PushCoroutine() bool {
-	s.stack.push(s)
-	return s.isMainCoroutine()
-}
-
-// 将指定索引的LuaValue转换为coroutine返回,如果是其他类型则返回nil
-func (s *luaState) ToCoroutine(idx int) api.LuaState {
-	absIdx := s.AbsIndex(idx)
-	val := s.stack.get(absIdx)
-	if typeOf(val) != api.LUAVALUE_COROUTINE {
-		return nil
-	}
-	ls, ok := val.(*luaState)
-	if !ok {
-		tool.Fatal(s, fmt.Sprintf("%v convert to luaState error!", ls))
-	}
-	return ls
-}
-
-// 从当前coroutine弹出n个元素压入to(coroutine)中
-func (s *luaState) XMove(to api.LuaState, n int) {
-	if n < 0 {
-		tool.Error("n must over 0!")
-	} else if n == 0 {
-		return
-	}
-	values := s.stack.popN(n)
-	to.(*luaState).stack.pushN(values, n)
-}
-
-func (s *luaState) IsYieldable() bool {
-	if s.isMainCoroutine() { //主协程不能yield
-		return false
-	}
-	return s.coStatus == api.LUA_RUNNING
-}
-
-// 获取当前协程状态
-func (s *luaState) Status() int {
-	return s.coStatus
-}
-
-// 启动或恢复一个协程的控制权,当前协程会被挂起
-// co：要恢复的协程（由 coroutine.create 创建）
-// nArgs: 参数个数
-func (s *luaState) Resume(co api.LuaState, nArgs int) int {
-	pending := co.(*luaState)
-	if s.coChan == nil {
-		s.coChan = make(chan int)
-	}
-
-	//首次执行,args应当压入待执行coroutine的函数栈中
-	if pending.coChan == nil {
-		pending.coChan = make(chan int)
-		pending.coFather = s //当前协程应当为父协程
-		go func() {
-			pending.coStatus = api.LUA_RUNNING
-			pending.coStatus = pending.PCall(nArgs, api.LUA_MULTRET, false)
-			//fmt.Println(pending.coStatus)
-			s.coChan <- pending.coStatus //通知父协程执行完毕
-		}()
-	} else {
-		//非首次执行
-		pending.coStatus = api.LUA_RUNNING
-		pending.coChan <- api.LUA_OK //唤醒pending协程
+// 在出错的函数栈中调用错误处理函数,返回处理后的错误值
+// 与官方一致,错误处理函数本身出错时以新的错误再次调用它,
+// 嵌套超过LUAI_MAXCCALLS层后放弃,返回"error in error handling"与LUA_ERR_ERR
+func (s *luaState) callErrHandler(handler, err luaValue) (luaValue, int) {
+	nny, nCcalls := s.nny, s.nCcalls
+	s.nny++ //错误处理函数中不能yield
+	s.errh++
+	defer func() { s.nny, s.nCcalls = nny, nCcalls; s.errh-- }()
+	for i := 0; i < api.LUAI_MAXCCALLS; i++ {
+		result, ok := s.tryErrHandler(handler, err)
+		if ok {
+			return result, api.LUA_ERR_RUN
+		}
+		err = result
 	}
-
-	s.coStatus = api.LUA_NORMAL
-	status := <-s.coChan //yeild唤醒;方法调用完唤醒
-	s.coStatus = api.LUA_RUNNING
-	return status
+	return stringValue("error in error handling"), api.LUA_ERR_ERR
 }
 
-// Yield implements api.LuaVM.
-func (s *luaState) Yield() int {
-	s.coStatus = api.LUA_SUSPENDED
-	s.coFather.coChan <- s.coStatus //唤醒父协程
-	<-s.coChan
-	return s.GetTop() //yield返回值个数
+// 调用一次错误处理函数,出错时返回新的错误值与false
+func (s *luaState) tryErrHandler(handler, err luaValue) (result luaValue, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, ok = s.errorValue(r), false
+		}
+	}()
+	s.CheckStack(2)
+	s.stack.push(handler)
+	s.stack.push(err)
+	s.Call(1, 1)
+	return s.stack.pop(), true
 }
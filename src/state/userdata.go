@@ -0,0 +1,19 @@
+package state
+
+// full userdata,用于将Go宿主对象暴露给lua脚本
+// 不同于其他非table类型共享类型元表,每个userdata都拥有自己的元表
+type userdata struct {
+	metaTable *table
+	value     interface{} //包装的Go值
+	userValue luaValue    //关联的user value
+}
+
+func newUserdata(value interface{}) *userdata {
+	return &userdata{value: value}
+}
+
+// light userdata,只是对Go值的引用,没有独立的元表和user value
+// 采用值类型保证包装同一个Go值的light userdata相等,可以作为table的键
+type lightUserdata struct {
+	p interface{}
+}
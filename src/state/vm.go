@@ -31,7 +31,7 @@ func (s *luaState) GetConst(idx int) {
 		panic(fmt.Sprintf("constant index[%d] out of range", idx))
 	}
 	c := s.stack.closure.proto.Constants[idx]
-	s.stack.push(c)
+	s.stack.push(valueOf(c))
 }
 
 func (s *luaState) GetRK(rk int) {
@@ -59,8 +59,11 @@ func (s *luaState) LoadProto(idx int) {
 		} else if v.Instack == 1 { //==1 表示该捕获变量属于函数的内部
 			//记录打开的捕获变量
 			if up, ok := s.stack.openuvs[uidx]; !ok {
-				uv := upvalue{&s.stack.slots[uidx+1]} //UpValue会被捕获,要想统一修改,就只能是指针
+				uv := &upvalue{&s.stack.slots[uidx+1]} //UpValue会被捕获,要想统一修改,就只能是指针
 				c.upvals[i] = uv
+				if s.stack.openuvs == nil {
+					s.stack.openuvs = make(map[int]*upvalue)
+				}
 				s.stack.openuvs[uidx] = uv
 			} else {
 				c.upvals[i] = up
@@ -68,7 +71,7 @@ func (s *luaState) LoadProto(idx int) {
 		}
 	}
 
-	s.stack.push(c)
+	s.stack.push(closureValue(c))
 }
 
 func (s *luaState) RegisterCount() int {
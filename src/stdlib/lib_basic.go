@@ -4,35 +4,36 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strconv"
+	"runtime"
 	"strings"
 
 	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/number"
 	"nskbz.cn/lua/tool"
 )
 
 var baseFuncs = map[string]api.GoFunc{
-	"print":        basePrint,
-	"assert":       baseAssert,
-	"error":        baseError,
-	"select":       baseSelect,
-	"ipairs":       baseIPairs,
-	"pairs":        basePairs,
-	"next":         baseNext,
-	"load":         baseLoad,
-	"loadfile":     baseLoadFile,
-	"dofile":       baseDoFile,
-	"pcall":        basePCall,
-	"xpcall":       baseXPCall,
-	"getmetatable": baseGetMetaTable,
-	"setmetatable": baseSetMetaTable,
-	"rawequal":     baseRawEqual,
-	"rawlen":       baseRawLen,
-	"rawget":       baseRawGet,
-	"rawset":       baseRawSet,
-	"type":         baseType,
-	"tostring":     baseToString,
-	"tonumber":     baseToNumber,
+	"print":          basePrint,
+	"assert":         baseAssert,
+	"error":          baseError,
+	"select":         baseSelect,
+	"pairs":          basePairs,
+	"next":           baseNext,
+	"load":           baseLoad,
+	"loadfile":       baseLoadFile,
+	"dofile":         baseDoFile,
+	"pcall":          basePCall,
+	"xpcall":         baseXPCall,
+	"getmetatable":   baseGetMetaTable,
+	"setmetatable":   baseSetMetaTable,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"collectgarbage": baseCollectGarbage,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"type":           baseType,
+	"tostring":       baseToString,
+	"tonumber":       baseToNumber,
 }
 
 func OpenBaseLib(vm api.LuaVM) int {
@@ -41,32 +42,42 @@ func OpenBaseLib(vm api.LuaVM) int {
 		vm.PushGoFunction(v, 0)
 		vm.SetGlobal(k)
 	}
+	vm.PushGoFunction(ipairsAux, 0)
+	vm.PushGoFunction(baseIPairs, 1)
+	vm.SetGlobal("ipairs")
 	//将全局表命名为'_G'放入注册中心
 	vm.PushGlobalTable()
 	vm.PushValue(0) //copy
 	vm.SetField(-1, "_G")
 	//注册全局版本
-	vm.PushString("LUA 5.3")
+	if vm.Version() >= api.LUA_VERSION_54 {
+		vm.PushString("Lua 5.4")
+	} else {
+		vm.PushString("Lua 5.3")
+	}
 	vm.SetField(-1, "_VERSION")
 	return 1 //只返回全局表
 }
 
+// print (···)
+//
+// 与官方实现一致,每个参数都通过全局函数tostring转换为字符串
 func basePrint(vm api.LuaVM) int {
 	nArgs := vm.GetTop()
+	vm.GetGlobal("tostring")
 	for i := 1; i <= nArgs; i++ {
-		switch vm.Type(i) {
-		case api.LUAVALUE_BOOLEAN:
-			fmt.Printf("%t", vm.ToBoolean(i))
-		case api.LUAVALUE_STRING, api.LUAVALUE_NUMBER:
-			fmt.Print(vm.ToString(i))
-		case api.LUAVALUE_COROUTINE, api.LUAVALUE_TABLE, api.LUAVALUE_FUNCTION:
-			fmt.Print(vm.TypeName2(i) + fmt.Sprintf(": %p", vm.ToPointer(i)))
-		default:
-			fmt.Print(vm.TypeName2(i))
+		vm.PushValue(0) //tostring
+		vm.PushValue(i)
+		vm.Call(1, 1)
+		str, ok := vm.ToStringX(0)
+		if !ok {
+			vm.Error2("'tostring' must return a string to 'print'")
 		}
-		if i < nArgs {
+		if i > 1 {
 			fmt.Print("\t")
 		}
+		fmt.Print(str)
+		vm.Pop(1)
 	}
 	fmt.Println()
 	return 0
@@ -78,16 +89,17 @@ func basePrint(vm api.LuaVM) int {
 // otherwise, returns all its arguments. In case of error, message is the error object;
 // when absent, it defaults to "assertion failed!"
 func baseAssert(vm api.LuaVM) int {
+	vm.CheckAny(1)
 	nArgs := vm.GetTop()
-	b := vm.ToBoolean(-(nArgs - 1)) //判断v的真假
+	b := vm.ToBoolean(1) //判断v的真假
 	if b {
 		return nArgs
 	}
-	errMsg := "assertion failed!"
-	if s, ok := vm.ToStringX(-(nArgs - 1) + 1); ok {
-		errMsg = s
+	if nArgs < 2 { //没有message时使用默认信息,并添加位置信息
+		return vm.Error2("%s", "assertion failed!")
 	}
-	return vm.Error2(errMsg)
+	vm.PushValue(2) //message原样作为错误值
+	return vm.Error()
 }
 
 // error (message [, level])
@@ -95,8 +107,11 @@ func baseAssert(vm api.LuaVM) int {
 // Terminates the last protected function called and returns message as the error object. Function error never returns.
 func baseError(vm api.LuaVM) int {
 	level := vm.OptInteger(2, 1)
+	vm.SetTop(1)
 	if vm.Type(1) == api.LUAVALUE_STRING && level > 0 {
-		//to do
+		vm.Where(int(level)) //在错误信息前添加第level层函数的位置信息
+		vm.PushValue(1)
+		vm.Concat(2)
 	}
 	return vm.Error()
 }
@@ -106,50 +121,57 @@ func baseError(vm api.LuaVM) int {
 // 当index是数字时，返回从index位置开始的所有参数
 // 当index是字符串"#"时，返回可变参数的总个数
 func baseSelect(vm api.LuaVM) int {
-	nArgs := vm.GetTop() - 1
-	if idx, ok := vm.ToIntegerX(1); ok {
-		if idx < 0 {
-			idx = int64(nArgs) + idx
-		} else if idx > int64(nArgs) {
-			idx = int64(nArgs)
-		}
-		return nArgs - int(idx) + 1
-	}
-	if str, ok := vm.ToStringX(1); ok && str == "#" {
-		vm.PushInteger(int64(nArgs)) //压入可变参数总个数
+	top := int64(vm.GetTop()) //包含index参数本身
+	if vm.Type(1) == api.LUAVALUE_STRING && vm.ToString(1) == "#" {
+		vm.PushInteger(top - 1) //压入可变参数总个数
 		return 1
 	}
-	return vm.Error2("the type of index parameter not support!!")
+	idx := vm.CheckInteger(1)
+	if idx < 0 { //负数从末尾开始计数
+		idx = top + idx
+	} else if idx > top {
+		idx = top
+	}
+	vm.ArgCheck(idx >= 1, 1, "index out of range")
+	return int(top - idx)
 }
 
 // 用于数组遍历
+// 迭代函数作为ipairs的upvalue,所以每次返回的都是同一个函数(与官方实现一致,ipairs{} == ipairs{})
 func baseIPairs(vm api.LuaVM) int {
-	vm.PushGoFunction(func(vm api.LuaVM) int {
-		i := vm.ToInteger(2) + 1 //index先+1,所以下标从1开始
-		vm.PushInteger(i)
-		vm.Replace(2)         //保存index
-		vm.PushInteger(i)     //压入index
-		val := vm.GetTable(1) //压入stack[index]
-		if val != api.LUAVALUE_NIL {
-			return 2
-		}
-		return 1
-	}, 0)
+	vm.CheckAny(1)
+	vm.PushValue(vm.UpvalueIndex(1)) //迭代函数
 	vm.PushValue(1)
 	vm.PushInteger(0) //index初始化为0
 	return 3
 }
 
-// 用于map遍历
+func ipairsAux(vm api.LuaVM) int {
+	i := vm.CheckInteger(2) + 1            //index先+1,所以下标从1开始
+	vm.PushInteger(i)                      //压入index
+	if vm.GetI(1, i) == api.LUAVALUE_NIL { //压入stack[index],遵循__index元方法
+		return 1
+	}
+	return 2
+}
+
+// 用于map遍历,存在__pairs元方法时返回其调用结果的前三个值
 func basePairs(vm api.LuaVM) int {
-	vm.PushGoFunction(baseNext, 0) //推入迭代函数
-	vm.PushValue(1)                //推入需遍历的目标
-	vm.PushNil()                   //推入迭代器第二个参数，初始值
+	vm.CheckAny(1)
+	if vm.GetMetafield(1, "__pairs") == api.LUAVALUE_NIL {
+		vm.PushGoFunction(baseNext, 0) //推入迭代函数
+		vm.PushValue(1)                //推入需遍历的目标
+		vm.PushNil()                   //推入迭代器第二个参数，初始值
+	} else {
+		vm.PushValue(1)
+		vm.Call(1, 3)
+	}
 	return 3
 }
 
 // 通用for循环的迭代器函数，迭代器返回2个参数，分别为key,value。如果key==nil则表示没有键值对了
 func baseNext(vm api.LuaVM) int {
+	vm.CheckType(1, api.LUAVALUE_TABLE)
 	vm.SetTop(2)
 	if vm.Next(1) {
 		return 2
@@ -182,51 +204,56 @@ func baseNext(vm api.LuaVM) int {
 func baseLoad(vm api.LuaVM) int {
 	chunkType := vm.Type(1)
 	mode := vm.OptString(3, "bt")
-	var chunk string
-	var chunkName string
-	var env interface{}
+	envIdx := 0
+	if !vm.IsNone(4) { //有env参数(可以是任意值,包括nil),作为第一个upvalue
+		envIdx = 4
+	}
 	switch chunkType {
 	case api.LUAVALUE_STRING: //chunk为字符串
-		chunk = vm.ToString(1)
+		chunk := vm.ToString(1)
+		if _doLoad(vm, []byte(chunk), vm.OptString(2, chunk), mode, envIdx) == api.LUA_OK {
+			return 1
+		}
 	case api.LUAVALUE_FUNCTION: //chunk为函数，会重复调用这个函数来获取代码片段("字符串")，直到函数返回nil或空字符串为止。
 		sb := strings.Builder{}
-		vm.PushValue(1) //压入需要load的方法
-		vm.Call(0, 1)
-		s := vm.ToString(0) //如果Call返回的是nil也会被解析为空字符串
-		//获取完整的动态代码
-		for s != "" {
+		//获取完整的动态代码,读取函数中的错误同样作为load的错误返回
+		for {
+			vm.PushValue(1)
+			if vm.PCall(0, 1, false) != api.LUA_OK {
+				break
+			}
+			if !vm.IsNil(0) && !vm.IsString(0) {
+				vm.Pop(1)
+				vm.PushString("reader function must return a string")
+				break
+			}
+			s := vm.ToString(0) //nil被视为空字符串
+			if s == "" {
+				vm.Pop(1)
+				if _doLoad(vm, []byte(sb.String()), vm.OptString(2, "=(load)"), mode, envIdx) == api.LUA_OK {
+					return 1
+				}
+				break
+			}
 			sb.WriteString(s)
 			vm.Pop(1)
-			vm.PushValue(1)
-			vm.Call(0, 1)
-			s = vm.ToString(0)
 		}
-		chunk = sb.String()
 	default:
-		tool.Fatal(vm, fmt.Sprintf("chunk prarmeter error: not support type[%s]!", chunkType.String()))
-	}
-	chunkName = vm.OptString(2, chunk)
-
-	if !vm.IsNone(4) { //有env参数,即存在Upvalue捕获变量
-		env = vm.ToPointer(4) //获取环境(luatable)
-	}
-
-	if _doLoad(vm, []byte(chunk), chunkName, mode, env) == api.LUA_OK {
-		return 1
+		vm.ArgError(1, fmt.Sprintf("string expected, got %s", vm.TypeName2(1)))
 	}
 	vm.PushNil()
-	vm.PushValue(2) //chunkname用作错误信息
-	return 2        //return nil,errmsg
+	vm.Insert(-1) //错误信息位于栈顶,将nil移到其下方
+	return 2      //return nil,errmsg
 }
 
 // 返回 -1 表示load失败
 // 返回 0(LUA_OK) 表示load成功
-func _doLoad(vm api.LuaVM, chunk []byte, chunkName string, mode string, env interface{}) int {
-	status := -1
-	if env == nil {
-		status = vm.Load([]byte(chunk), chunkName, mode) //没有环境,默认加载全局环境
-	} else {
-		status = vm.LoadWithEnv([]byte(chunk), chunkName, mode, env)
+// envIdx不为0时将该索引处的值设置为加载的函数的第一个upvalue(即_ENV)
+func _doLoad(vm api.LuaVM, chunk []byte, chunkName string, mode string, envIdx int) int {
+	status := vm.Load(chunk, chunkName, mode) //默认加载全局环境
+	if status == api.LUA_OK && envIdx != 0 {
+		vm.PushValue(envIdx)
+		vm.SetUpvalue(-1, 1)
 	}
 	return status
 }
@@ -244,7 +271,6 @@ func baseLoadFile(vm api.LuaVM) int {
 	mode := vm.OptString(2, "bt")
 	var f *os.File
 	var chunk []byte
-	var env interface{}
 	if vm.IsNone(1) {
 		filename = "stdIn"
 		f = os.Stdin
@@ -266,16 +292,17 @@ func baseLoadFile(vm api.LuaVM) int {
 		chunk = data
 	}
 
-	if !vm.IsNone(3) {
-		env = vm.ToPointer(3) //获取环境
+	envIdx := 0
+	if !vm.IsNone(3) { //有env参数
+		envIdx = 3
 	}
 
-	if _doLoad(vm, chunk, filename, mode, env) == api.LUA_OK {
+	if _doLoad(vm, chunk, filename, mode, envIdx) == api.LUA_OK {
 		return 1
 	}
 	vm.PushNil()
-	vm.PushString(fmt.Sprintf("loadfile[%s] error", filename)) //chunkname用作错误信息
-	return 2                                                   //return nil,errmsg
+	vm.Insert(-1) //错误信息位于栈顶,将nil移到其下方
+	return 2      //return nil,errmsg
 }
 
 // dofile ([filename])
@@ -286,25 +313,36 @@ func baseLoadFile(vm api.LuaVM) int {
 func baseDoFile(vm api.LuaVM) int {
 	if baseLoadFile(vm) == 1 { //baseLoadFile返回1说明执行load成功
 		start := vm.GetTop()
-		vm.Call(0, api.LUA_MULTRET) //文件调用后的返回值全部返回
-		n := 0
-		if vm.GetTop() != 0 {
-			n = vm.GetTop() - start + 1
-		}
-		return n
+		vm.CallK(0, api.LUA_MULTRET, start, finishDoFile) //文件调用后的返回值全部返回
+		return finishDoFile(vm, api.LUA_OK, start)
 	}
 	return 2
 }
 
+// dofile调用结束(包括yield之后恢复执行)时的continuation,start为被调函数的索引
+func finishDoFile(vm api.LuaVM, status, start int) int {
+	return vm.GetTop() - start + 1
+}
+
 // pcall (f [, arg1, ···])
 //
 // Calls function f with the given arguments in protected mode. This means that any error inside f is not propagated; instead, pcall catches the error and returns a status code.
 func basePCall(vm api.LuaVM) int {
 	nArgs := vm.GetTop() - 1
-	status := vm.PCall(nArgs, api.LUA_MULTRET, false)
-	vm.PushBoolean(status == api.LUA_OK)
-	vm.Insert(-1)
-	return vm.GetTop()
+	vm.PushBoolean(true) //调用成功时的第一个返回值
+	vm.Insert(1)
+	status := vm.PCallK(nArgs, api.LUA_MULTRET, false, 0, finishPCall)
+	return finishPCall(vm, status, 0)
+}
+
+// pcall与xpcall调用结束(包括yield之后恢复执行)时的continuation,extra为栈底不属于返回值的元素个数
+func finishPCall(vm api.LuaVM, status, extra int) int {
+	if status != api.LUA_OK && status != api.LUA_SUSPENDED { //出错,栈顶为错误值
+		vm.PushBoolean(false)
+		vm.PushValue(-1) //错误值
+		return 2
+	}
+	return vm.GetTop() - extra
 }
 
 // xpcall (func, errhandler [, arg1, ···])
@@ -319,26 +357,40 @@ func basePCall(vm api.LuaVM) int {
 // xpcall 是 Lua 中一个增强的错误处理函数，它比基础的 pcall 提供了更多的错误处理能力，允许你指定一个自定义的错误处理函数。
 func baseXPCall(vm api.LuaVM) int {
 	nArgs := vm.GetTop() - 2
-	//首先交换func和errhandler在栈中的位置,方便后续方法调用
+	//调整为errhandler,true,func,args...,errhandler位于索引1,true为调用成功时的第一个返回值
+	vm.PushBoolean(true)
+	vm.Insert(2)
 	vm.PushValue(1) //copy func
-	vm.PushValue(2) //copy errhandler
+	vm.PushValue(3) //copy errhandler
 	vm.Replace(1)
-	vm.Replace(2)
+	vm.Replace(3)
 	//进行pcall调用
-	status := vm.PCall(nArgs, api.LUA_MULTRET, true)
-	vm.PushBoolean(status == api.LUA_OK)
-	vm.Replace(1)
-	return vm.GetTop()
+	status := vm.PCallK(nArgs, api.LUA_MULTRET, true, 1, finishPCall)
+	return finishPCall(vm, status, 1)
 }
 
+// getmetatable (object)
+// 返回object的元表,元表中有__metatable字段时返回该字段的值
 func baseGetMetaTable(vm api.LuaVM) int {
+	vm.CheckAny(1)
 	if !vm.GetMetaTable(1) {
 		vm.PushNil()
+		return 1
 	}
+	vm.GetMetafield(1, "__metatable") //没有该字段时不压入任何值,返回元表本身
 	return 1
 }
 
+// setmetatable (table, metatable)
+// 设置table的元表并返回table,metatable为nil时移除元表;原元表中有__metatable字段时不允许修改
 func baseSetMetaTable(vm api.LuaVM) int {
+	t := vm.Type(2)
+	vm.CheckType(1, api.LUAVALUE_TABLE)
+	vm.ArgCheck(t == api.LUAVALUE_NIL || t == api.LUAVALUE_TABLE, 2, "nil or table expected")
+	if vm.GetMetafield(1, "__metatable") != api.LUAVALUE_NIL {
+		return vm.Error2("cannot change a protected metatable")
+	}
+	vm.SetTop(2)
 	vm.SetMetaTable(1)
 	return 1
 }
@@ -351,6 +403,38 @@ func baseRawEqual(vm api.LuaVM) int {
 	return 1
 }
 
+// collectgarbage ([opt [, arg]])
+//
+// 内存由Go的垃圾回收器管理,这里将各个选项映射到runtime上:
+// "collect"与"step"执行一次完整的回收,"count"返回当前堆内存的K字节数
+// "stop"、"restart"、"setpause"、"setstepmul"只为兼容而保留,不改变回收器的行为
+func baseCollectGarbage(vm api.LuaVM) int {
+	opt := vm.OptString(1, "collect")
+	switch opt {
+	case "collect", "stop", "restart":
+		if opt == "collect" {
+			runtime.GC()
+		}
+		vm.PushInteger(0)
+	case "step":
+		runtime.GC()
+		vm.PushBoolean(true)
+	case "count":
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		vm.PushFloat(float64(m.HeapAlloc) / 1024)
+	case "isrunning":
+		vm.PushBoolean(true)
+	case "setpause":
+		vm.PushInteger(200) //官方实现的默认值
+	case "setstepmul":
+		vm.PushInteger(100)
+	default:
+		vm.ArgError(1, fmt.Sprintf("invalid option '%s'", opt))
+	}
+	return 1
+}
+
 // rawlen (v)
 // Returns the length of the object v, which must be a table or a string, without invoking the __len metamethod. Returns an integer.
 func baseRawLen(vm api.LuaVM) int {
@@ -368,6 +452,7 @@ func baseRawGet(vm api.LuaVM) int {
 		return vm.Error2(fmt.Sprintf("the first parameter was expected to be a table,but got a %s", vm.Type(1).String()))
 	}
 	vm.CheckAny(2) //确保index参数存在
+	vm.SetTop(2)   //忽略多余的参数,使index位于栈顶
 	vm.RawGet(1)
 	return 1
 }
@@ -384,6 +469,7 @@ func baseRawSet(vm api.LuaVM) int {
 		return vm.Error2(fmt.Sprintf("the second parameter was %s which not support", vm.Type(2).String()))
 	}
 	vm.CheckAny(3)
+	vm.SetTop(3) //忽略多余的参数,使index与value位于栈顶
 	vm.RawSet(1)
 	vm.PushValue(1) //栈顶压入table方便返回
 	return 1
@@ -416,46 +502,65 @@ func baseToString(vm api.LuaVM) int {
 //
 // tonumber 是Lua中用于将值转换为数字的核心函数
 func baseToNumber(vm api.LuaVM) int {
-	vm.CheckAny(1)
-	var base int64 = 10
-	if !vm.IsNoneOrNil(2) { //如果没有base参数,默认转化为10进制
-		base = vm.ToInteger(2)
+	if vm.IsNoneOrNil(2) { //没有base参数时按照lua数字的语法转换
+		if vm.Type(1) == api.LUAVALUE_NUMBER {
+			vm.PushValue(1)
+			return 1
+		}
+		vm.CheckAny(1)
+		if vm.Type(1) == api.LUAVALUE_STRING {
+			str := strings.Trim(vm.ToString(1), number.SPACES)
+			if i, ok := number.ParseInteger(str); ok {
+				vm.PushInteger(i)
+				return 1
+			}
+			if f, ok := number.ParseFloat(str); ok {
+				vm.PushFloat(f)
+				return 1
+			}
+		}
+		vm.PushNil()
+		return 1
 	}
 
-	switch vm.Type(1) {
-	case api.LUAVALUE_NUMBER:
-		vm.PushValue(1)
-	case api.LUAVALUE_STRING:
-		str := vm.ToString(1)
-		if str == "" {
-			vm.PushNil()
-			break
-		}
-		_toNumber(vm, str, int(base)) //默认10进制
-	default:
+	base := vm.CheckInteger(2)
+	vm.CheckType(1, api.LUAVALUE_STRING) //指定base时只接受字符串
+	vm.ArgCheck(2 <= base && base <= 36, 2, "base out of range")
+	if i, ok := _toNumber(strings.Trim(vm.ToString(1), number.SPACES), base); ok {
+		vm.PushInteger(i)
+	} else {
 		vm.PushNil()
 	}
 	return 1
 }
 
-func _toNumber(vm api.LuaVM, str string, base int) {
-	str = strings.Trim(str, " ")
-	if len(strings.Split(str, ".")) == 2 || strings.Contains(str, "e") { //判断是否为小数形式或指数形式
-		f, err := strconv.ParseFloat(str, 64)
-		if err != nil {
-			vm.PushNil()
-			tool.Debug(fmt.Sprintf("parse %s to float error : ", str) + err.Error())
-		} else {
-			vm.PushFloat(f)
+// 将base进制的字符串转换为整数,溢出时回绕
+func _toNumber(str string, base int64) (int64, bool) {
+	neg := strings.HasPrefix(str, "-")
+	if neg || strings.HasPrefix(str, "+") {
+		str = str[1:]
+	}
+	if str == "" {
+		return 0, false
+	}
+	var n int64
+	for _, c := range strings.ToLower(str) {
+		var d int64
+		switch {
+		case '0' <= c && c <= '9':
+			d = int64(c - '0')
+		case 'a' <= c && c <= 'z':
+			d = int64(c-'a') + 10
+		default:
+			return 0, false
 		}
-		return
+		if d >= base {
+			return 0, false
+		}
+		n = n*base + d
 	}
-	//执行整数转换流程
-	i, err := strconv.ParseInt(str, base, 64)
-	if err != nil {
-		vm.PushNil()
-		tool.Debug(fmt.Sprintf("parse %s to int error : ", str) + err.Error())
-	} else {
-		vm.PushInteger(i)
+	if neg {
+		n = -n
 	}
+	return n, true
 }
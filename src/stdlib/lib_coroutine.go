@@ -10,6 +10,7 @@ var coroutineFuncs map[string]api.GoFunc = map[string]api.GoFunc{
 	"running":     coroutineRunning,
 	"isyieldable": coroutineIsYieldable,
 	"wrap":        coroutineWrap,
+	"close":       coroutineClose, //5.4新增,5.3中同样可用,便于回收不再需要的挂起协程
 }
 
 func OpenCoroutineLib(vm api.LuaVM) int {
@@ -44,7 +45,13 @@ func coroutineResume(vm api.LuaVM) int {
 	//已经死亡的coroutine不能再被恢复
 	if co.Status() >= api.LUA_OK && co.Status() <= api.LUA_DEAD {
 		vm.PushBoolean(false)
-		vm.PushString("can't resume a dead coroutine")
+		vm.PushString("cannot resume dead coroutine")
+		return 2
+	}
+	//正在运行或者处于normal状态的coroutine(包括主协程)不能被恢复
+	if co.Status() != api.LUA_SUSPENDED {
+		vm.PushBoolean(false)
+		vm.PushString("cannot resume non-suspended coroutine")
 		return 2
 	}
 
@@ -54,12 +61,19 @@ func coroutineResume(vm api.LuaVM) int {
 		vm.PushBoolean(true)
 	} else {
 		vm.PushBoolean(false)
+		co.PushValue(0) //错误值保留在co中,关闭co时需要返回
 		co.XMove(vm, 1) //errmsg
 		return 2
 	}
 	nRets := co.GetTop()
-	vm.CheckStack(nRets) //父协程装不下所有返回值
-	co.XMove(vm, nRets)  //将co的返回值转移到父协程中作为resume的返回值
+	if !vm.CheckStackX(nRets) { //父协程装不下所有返回值
+		co.Pop(nRets)
+		vm.Pop(1)
+		vm.PushBoolean(false)
+		vm.PushString("too many results to resume")
+		return 2
+	}
+	co.XMove(vm, nRets) //将co的返回值转移到父协程中作为resume的返回值
 	return nRets + 1
 }
 
@@ -72,6 +86,27 @@ func coroutineYield(vm api.LuaVM) int {
 	return vm.Yield()
 }
 
+// ok, err = coroutine.close(co)
+// 关闭挂起或死亡的协程co,关闭其所有捕获变量与待关闭变量并将其标记为死亡
+// 返回值：
+// ok: 没有出错时为true
+// err: co因出错而死亡或关闭待关闭变量时出错,返回false以及错误值
+func coroutineClose(vm api.LuaVM) int {
+	vm.CheckType(1, api.LUAVALUE_COROUTINE)
+	co := vm.ToCoroutine(1)
+	if status := co.Status(); status == api.LUA_RUNNING || status == api.LUA_NORMAL {
+		name := map[int]string{api.LUA_RUNNING: "running", api.LUA_NORMAL: "normal"}[status]
+		return vm.Error2("cannot close a %s coroutine", name)
+	}
+	if vm.CloseCoroutine(co) == api.LUA_OK {
+		vm.PushBoolean(true)
+		return 1
+	}
+	vm.PushBoolean(false)
+	co.XMove(vm, 1)
+	return 2
+}
+
 // coroutine.status(co)
 // co必须为coroutine
 // 获取co当前的状态信息
@@ -80,29 +115,25 @@ func coroutineStatus(vm api.LuaVM) int {
 	co := vm.ToCoroutine(1)
 	switch co.Status() {
 	case api.LUA_SUSPENDED:
-		vm.PushString("SUSPENDED")
+		vm.PushString("suspended")
 	case api.LUA_NORMAL:
-		vm.PushString("NORMAL")
+		vm.PushString("normal")
 	case api.LUA_RUNNING:
-		vm.PushString("RUNNING")
+		vm.PushString("running")
 	default:
-		vm.PushString("DEAD")
+		vm.PushString("dead")
 	}
 	return 1
 }
 
 // co, ismain = coroutine.running()
 // 返回值：
-// co: 当前运行的协程对象；如果在主协程中调用，返回nil 如果在协程中调用，返回该协程对象
+// co: 当前运行的协程对象,在主协程中调用时返回主协程
 // ismain: 布尔值，表示当前是否在主线程中运行
 //
 // 用于获取当前正在运行的协程信息
 func coroutineRunning(vm api.LuaVM) int {
 	isMain := vm.PushCoroutine()
-	if isMain {
-		vm.Pop(1)
-		vm.PushNil()
-	}
 	vm.PushBoolean(isMain)
 	return 2
 }
@@ -127,10 +158,14 @@ func coroutineWrap(vm api.LuaVM) int {
 		lv.PushValue(lv.UpvalueIndex(1))
 		lv.Insert(1)
 		nRets := coroutineResume(lv)
-		//如果外部是以pcall的方式调用wrap的方法,需要特殊处理因为resume里面有pcall的逻辑
-		//对于PCALL嵌套调用，这里需要将里层的err往上抛，暂时这样todo
+		//resume里面有pcall的逻辑,出错时需要将协程中的错误值继续往上抛
 		if !lv.ToBoolean(-(nRets - 1)) {
-			panic(lv.ToString(-(nRets - 2)))
+			if lv.Type(0) == api.LUAVALUE_STRING { //字符串错误添加调用者的位置信息
+				lv.Where(1)
+				lv.Insert(-1)
+				lv.Concat(2)
+			}
+			return lv.Error()
 		}
 		return nRets - 1
 	}, 1)
@@ -0,0 +1,404 @@
+package stdlib
+
+import (
+	"reflect"
+	"strings"
+
+	"nskbz.cn/lua/api"
+)
+
+const HOOKKEY = "_HKEY" //保存各coroutine的lua hook函数的表在注册表中的键
+
+var hookEvents = [...]string{"call", "return", "line", "count", "tail call"}
+
+var debugFuncs map[string]api.GoFunc = map[string]api.GoFunc{
+	"getinfo":      debugGetInfo,
+	"getlocal":     debugGetLocal,
+	"setlocal":     debugSetLocal,
+	"getupvalue":   debugGetUpvalue,
+	"setupvalue":   debugSetUpvalue,
+	"upvalueid":    debugUpvalueId,
+	"upvaluejoin":  debugUpvalueJoin,
+	"getmetatable": debugGetMetatable,
+	"setmetatable": debugSetMetatable,
+	"getuservalue": debugGetUservalue,
+	"setuservalue": debugSetUservalue,
+	"getregistry":  debugGetRegistry,
+	"traceback":    debugTraceback,
+	"sethook":      debugSetHook,
+	"gethook":      debugGetHook,
+}
+
+func OpenDebugLib(vm api.LuaVM) int {
+	vm.NewLib(debugFuncs)
+	return 1
+}
+
+// 大部分debug函数的第一个参数可以是可选的thread
+// 返回目标coroutine以及其后参数的起始索引
+func getThread(vm api.LuaVM) (api.LuaState, int) {
+	if vm.IsCoroutine(1) {
+		return vm.ToCoroutine(1), 1
+	}
+	return vm, 0
+}
+
+// 将目标coroutine栈顶的n个值移动到当前coroutine
+func moveFrom(vm api.LuaVM, co api.LuaState, n int) {
+	if co != api.LuaState(vm) {
+		co.XMove(vm, n)
+	}
+}
+
+// debug.getinfo ([thread,] f [, what])
+// 返回函数信息的表,f可以是函数或调用栈层数,层数超出范围时返回nil
+// what指定需要填充的字段,默认为全部"flnStu"
+func debugGetInfo(vm api.LuaVM) int {
+	co, arg := getThread(vm)
+	options := vm.OptString(arg+2, "flnStu")
+	var ar *api.DebugInfo
+	if vm.IsFunction(arg + 1) {
+		options = ">" + options
+		vm.PushValue(arg + 1)
+		vm.XMove(co, 1)
+		ar = &api.DebugInfo{}
+	} else {
+		if ar = co.GetStack(int(vm.CheckInteger(arg + 1))); ar == nil {
+			vm.PushNil() //层数超出范围
+			return 1
+		}
+	}
+	if !co.GetInfo(options, ar) {
+		return vm.ArgError(arg+2, "invalid option")
+	}
+	//GetInfo压入的'f'与'L'
+	nPushed := 0
+	if strings.ContainsRune(options, 'f') {
+		nPushed++
+	}
+	if strings.ContainsRune(options, 'L') {
+		nPushed++
+	}
+	moveFrom(vm, co, nPushed)
+
+	vm.CreateTable(0, 2)
+	if strings.ContainsRune(options, 'S') {
+		vm.PushString(ar.Source)
+		vm.SetField(-1, "source")
+		vm.PushString(ar.ShortSrc)
+		vm.SetField(-1, "short_src")
+		vm.PushInteger(int64(ar.LineDefined))
+		vm.SetField(-1, "linedefined")
+		vm.PushInteger(int64(ar.LastLineDefined))
+		vm.SetField(-1, "lastlinedefined")
+		vm.PushString(ar.What)
+		vm.SetField(-1, "what")
+	}
+	if strings.ContainsRune(options, 'l') {
+		vm.PushInteger(int64(ar.CurrentLine))
+		vm.SetField(-1, "currentline")
+	}
+	if strings.ContainsRune(options, 'u') {
+		vm.PushInteger(int64(ar.NUps))
+		vm.SetField(-1, "nups")
+		vm.PushInteger(int64(ar.NParams))
+		vm.SetField(-1, "nparams")
+		vm.PushBoolean(ar.IsVararg)
+		vm.SetField(-1, "isvararg")
+	}
+	if strings.ContainsRune(options, 'n') {
+		if ar.NameWhat != "" {
+			vm.PushString(ar.Name)
+			vm.SetField(-1, "name")
+		}
+		vm.PushString(ar.NameWhat)
+		vm.SetField(-1, "namewhat")
+	}
+	if strings.ContainsRune(options, 't') {
+		vm.PushBoolean(ar.IsTailCall)
+		vm.SetField(-1, "istailcall")
+	}
+	//栈结构:...,[func],[activelines],table
+	if strings.ContainsRune(options, 'L') {
+		vm.Insert(-1)
+		vm.SetField(-1, "activelines")
+	}
+	if strings.ContainsRune(options, 'f') {
+		vm.Insert(-1)
+		vm.SetField(-1, "func")
+	}
+	return 1
+}
+
+// debug.getlocal ([thread,] f, local)
+// 返回第f层函数中索引为local的局部变量的名称与值,local为负数时获取可变参数
+// f为函数时只返回其参数的名称
+func debugGetLocal(vm api.LuaVM) int {
+	co, arg := getThread(vm)
+	n := int(vm.CheckInteger(arg + 2))
+	if vm.IsFunction(arg + 1) {
+		vm.PushValue(arg + 1)
+		if name := vm.GetLocal(nil, n); name != "" {
+			vm.Pop(1)
+			vm.PushString(name)
+		} else {
+			vm.Pop(1)
+			vm.PushNil()
+		}
+		return 1
+	}
+	ar := co.GetStack(int(vm.CheckInteger(arg + 1)))
+	if ar == nil {
+		return vm.ArgError(arg+1, "level out of range")
+	}
+	name := co.GetLocal(ar, n)
+	if name == "" {
+		vm.PushNil()
+		return 1
+	}
+	moveFrom(vm, co, 1)
+	vm.PushString(name)
+	vm.Insert(-1)
+	return 2
+}
+
+// debug.setlocal ([thread,] level, local, value)
+// 将value赋给第level层函数中索引为local的局部变量,返回该变量名称,不存在则返回nil
+func debugSetLocal(vm api.LuaVM) int {
+	co, arg := getThread(vm)
+	ar := co.GetStack(int(vm.CheckInteger(arg + 1)))
+	if ar == nil {
+		return vm.ArgError(arg+1, "level out of range")
+	}
+	n := int(vm.CheckInteger(arg + 2))
+	vm.CheckAny(arg + 3)
+	vm.SetTop(arg + 3)
+	if co != api.LuaState(vm) {
+		vm.XMove(co, 1)
+	}
+	if name := co.SetLocal(ar, n); name != "" {
+		vm.PushString(name)
+	} else {
+		vm.PushNil()
+	}
+	return 1
+}
+
+// debug.getupvalue (f, up)
+// 返回函数f第up个upvalue的名称与值,不存在则返回nil
+func debugGetUpvalue(vm api.LuaVM) int {
+	n := int(vm.CheckInteger(2))
+	vm.CheckType(1, api.LUAVALUE_FUNCTION)
+	name, ok := vm.GetUpvalue(1, n)
+	if !ok {
+		return 0
+	}
+	vm.PushString(name)
+	vm.Insert(-1)
+	return 2
+}
+
+// debug.setupvalue (f, up, value)
+// 将value赋给函数f的第up个upvalue,返回其名称,不存在则返回nil
+func debugSetUpvalue(vm api.LuaVM) int {
+	vm.CheckAny(3)
+	n := int(vm.CheckInteger(2))
+	vm.CheckType(1, api.LUAVALUE_FUNCTION)
+	vm.SetTop(3)
+	name, ok := vm.SetUpvalue(1, n)
+	if !ok {
+		return 0
+	}
+	vm.PushString(name)
+	return 1
+}
+
+// 检查函数的第n个upvalue是否存在,返回其唯一标识
+func checkUpvalue(vm api.LuaVM, fArg, nArg int) interface{} {
+	n := int(vm.CheckInteger(nArg))
+	vm.CheckType(fArg, api.LUAVALUE_FUNCTION)
+	id := vm.UpvalueId(fArg, n)
+	vm.ArgCheck(id != nil, nArg, "invalid upvalue index")
+	return id
+}
+
+// debug.upvalueid (f, n)
+// 返回函数f第n个upvalue的唯一标识(light userdata),共享同一upvalue的函数返回的标识相等
+func debugUpvalueId(vm api.LuaVM) int {
+	vm.PushLightUserdata(checkUpvalue(vm, 1, 2))
+	return 1
+}
+
+// debug.upvaluejoin (f1, n1, f2, n2)
+// 让lua函数f1的第n1个upvalue引用lua函数f2的第n2个upvalue
+func debugUpvalueJoin(vm api.LuaVM) int {
+	checkUpvalue(vm, 1, 2)
+	checkUpvalue(vm, 3, 4)
+	vm.ArgCheck(!vm.IsGoFunction(1), 1, "Lua function expected")
+	vm.ArgCheck(!vm.IsGoFunction(3), 3, "Lua function expected")
+	vm.UpvalueJoin(1, int(vm.ToInteger(2)), 3, int(vm.ToInteger(4)))
+	return 0
+}
+
+// debug.getmetatable (value)
+// 返回value的元表,不会使用__metatable字段
+func debugGetMetatable(vm api.LuaVM) int {
+	vm.CheckAny(1)
+	if !vm.GetMetaTable(1) {
+		vm.PushNil()
+	}
+	return 1
+}
+
+// debug.setmetatable (value, table)
+// 将value的元表设置为table(可以为nil),返回value
+func debugSetMetatable(vm api.LuaVM) int {
+	t := vm.Type(2)
+	vm.ArgCheck(t == api.LUAVALUE_NIL || t == api.LUAVALUE_TABLE, 2, "nil or table expected")
+	vm.SetTop(2)
+	vm.SetMetaTable(1)
+	return 1
+}
+
+// debug.getuservalue (u)
+// 返回full userdata u关联的user value,u不是full userdata时返回nil
+func debugGetUservalue(vm api.LuaVM) int {
+	if vm.Type(1) != api.LUAVALUE_USERDATA {
+		vm.PushNil()
+	} else {
+		vm.GetUserValue(1)
+	}
+	return 1
+}
+
+// debug.setuservalue (udata, value)
+// 将value设置为udata关联的user value,返回udata
+func debugSetUservalue(vm api.LuaVM) int {
+	vm.CheckType(1, api.LUAVALUE_USERDATA)
+	vm.CheckAny(2)
+	vm.SetTop(2)
+	vm.SetUserValue(1)
+	return 1
+}
+
+// debug.getregistry ()
+// 返回注册表
+func debugGetRegistry(vm api.LuaVM) int {
+	vm.PushValue(api.LUA_REGISTRY_INDEX)
+	return 1
+}
+
+// debug.traceback ([thread,] [message [, level]])
+// 返回调用栈的回溯信息,message不是字符串且不为nil时直接返回message
+// level默认为1,指定thread时默认为0
+func debugTraceback(vm api.LuaVM) int {
+	co, arg := getThread(vm)
+	msg := ""
+	if !vm.IsNoneOrNil(arg + 1) {
+		var ok bool
+		if msg, ok = vm.ToStringX(arg + 1); !ok {
+			vm.PushValue(arg + 1)
+			return 1
+		}
+	}
+	level := int64(1)
+	if co != api.LuaState(vm) {
+		level = 0
+	}
+	vm.Traceback(co, msg, int(vm.OptInteger(arg+2, level)))
+	return 1
+}
+
+// 通过debug.sethook设置的hook,调用注册表中保存的lua hook函数
+// 以事件名称与行号(line事件)作为参数
+func hookf(ls api.LuaState, ar *api.DebugInfo) {
+	ls.GetSubTable(api.LUA_REGISTRY_INDEX, HOOKKEY)
+	ls.PushCoroutine()
+	if ls.RawGet(-1) == api.LUAVALUE_FUNCTION {
+		ls.PushString(hookEvents[ar.Event])
+		if ar.CurrentLine >= 0 {
+			ls.PushInteger(int64(ar.CurrentLine))
+		} else {
+			ls.PushNil()
+		}
+		ls.Call(2, 0)
+	}
+}
+
+// 将mask字符串转换为hook掩码
+func makeMask(smask string, count int) int {
+	mask := 0
+	if strings.ContainsRune(smask, 'c') {
+		mask |= api.LUA_MASKCALL
+	}
+	if strings.ContainsRune(smask, 'r') {
+		mask |= api.LUA_MASKRET
+	}
+	if strings.ContainsRune(smask, 'l') {
+		mask |= api.LUA_MASKLINE
+	}
+	if count > 0 {
+		mask |= api.LUA_MASKCOUNT
+	}
+	return mask
+}
+
+func unmakeMask(mask int) string {
+	smask := ""
+	if mask&api.LUA_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&api.LUA_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&api.LUA_MASKLINE != 0 {
+		smask += "l"
+	}
+	return smask
+}
+
+// debug.sethook ([thread,] hook, mask [, count])
+// 设置hook函数,mask可以包含'c'(调用时)、'r'(返回时)、'l'(进入新行时),count>0时每执行count条指令调用一次
+// 不传参数时关闭hook
+func debugSetHook(vm api.LuaVM) int {
+	co, arg := getThread(vm)
+	var f api.HookFunc
+	mask, count := 0, 0
+	if !vm.IsNoneOrNil(arg + 1) {
+		vm.CheckType(arg+1, api.LUAVALUE_FUNCTION)
+		count = int(vm.OptInteger(arg+3, 0))
+		f, mask = hookf, makeMask(vm.CheckString(arg+2), count)
+	}
+	//registry[HOOKKEY][thread]=hook
+	vm.GetSubTable(api.LUA_REGISTRY_INDEX, HOOKKEY)
+	if arg == 1 {
+		vm.PushValue(1)
+	} else {
+		vm.PushCoroutine()
+	}
+	vm.PushValue(arg + 1)
+	vm.RawSet(-2)
+	co.SetHook(f, mask, count)
+	return 0
+}
+
+// debug.gethook ([thread])
+// 返回当前的hook函数、mask与count,hook不是由debug.sethook设置时返回"external hook"
+func debugGetHook(vm api.LuaVM) int {
+	co, _ := getThread(vm)
+	hook := co.GetHook()
+	if hook == nil {
+		vm.PushNil()
+	} else if reflect.ValueOf(hook).Pointer() != reflect.ValueOf(hookf).Pointer() {
+		vm.PushString("external hook")
+	} else {
+		vm.GetSubTable(api.LUA_REGISTRY_INDEX, HOOKKEY)
+		co.PushCoroutine()
+		moveFrom(vm, co, 1)
+		vm.RawGet(-1)
+		vm.Remove(-1)
+	}
+	vm.PushString(unmakeMask(co.GetHookMask()))
+	vm.PushInteger(int64(co.GetHookCount()))
+	return 3
+}
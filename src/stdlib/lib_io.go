@@ -0,0 +1,765 @@
+package stdlib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"weak"
+
+	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/number"
+)
+
+const LUA_FILEHANDLE = "FILE*" //文件句柄元表在注册表中的键
+
+// 默认输入输出文件在注册表中的键
+const (
+	IO_INPUT  = "_IO_input"
+	IO_OUTPUT = "_IO_output"
+)
+
+const L_MAXLENNUM = 200 //io.read("n")读取数字的最大长度
+
+var ioFuncs map[string]api.GoFunc = map[string]api.GoFunc{
+	"close":   ioClose,
+	"flush":   ioFlush,
+	"input":   ioInput,
+	"lines":   ioLines,
+	"open":    ioOpen,
+	"output":  ioOutput,
+	"read":    ioRead,
+	"tmpfile": ioTmpfile,
+	"type":    ioType,
+	"write":   ioWrite,
+}
+
+// 文件句柄的方法
+var fileMethods map[string]api.GoFunc = map[string]api.GoFunc{
+	"close":   fileClose,
+	"flush":   fileFlush,
+	"lines":   fileLines,
+	"read":    fileRead,
+	"seek":    fileSeek,
+	"setvbuf": fileSetvbuf,
+	"write":   fileWrite,
+}
+
+// 文件句柄的元方法
+var fileMetaMethods map[string]api.GoFunc = map[string]api.GoFunc{
+	"__gc":       fileGC,
+	"__close":    fileGC,
+	"__tostring": fileToString,
+}
+
+func OpenIoLib(vm api.LuaVM) int {
+	vm.NewLib(ioFuncs)
+	//创建文件句柄的元表并放入注册表
+	vm.NewMetatable(LUA_FILEHANDLE)
+	vm.NewLib(fileMethods)
+	vm.SetField(-1, "__index") //mt.__index = fileMethods
+	for k, v := range fileMetaMethods {
+		vm.PushGoFunction(v, 0)
+		vm.SetField(-1, k)
+	}
+	vm.Pop(1) //弹出元表
+	//标准文件
+	createStdFile(vm, os.Stdin, IO_INPUT, "stdin")
+	createStdFile(vm, os.Stdout, IO_OUTPUT, "stdout")
+	createStdFile(vm, os.Stderr, "", "stderr")
+	return 1
+}
+
+// 创建标准文件并设置为io库的字段,如果key不为空还会设置为默认输入或输出文件
+func createStdFile(vm api.LuaVM, f *os.File, key, fname string) {
+	s := newFile(vm, f)
+	s.isStd = true
+	s.bufMode = "no" //标准文件不缓冲,保证与print的输出顺序一致
+	if key != "" {
+		vm.PushValue(0)
+		vm.SetField(api.LUA_REGISTRY_INDEX, key)
+	}
+	vm.SetField(-1, fname)
+}
+
+/*
+*	luaStream
+ */
+
+// 文件句柄,作为full userdata暴露给lua
+type luaStream struct {
+	f       *os.File
+	r       *bufio.Reader
+	w       *bufio.Writer
+	bufMode string //缓冲模式:"no","full","line"
+	isStd   bool   //标准文件不允许关闭
+	closed  bool
+}
+
+// 所有未关闭的文件句柄,用于os.exit退出前写入缓冲
+// 使用弱引用,不影响句柄不可达时的自动关闭
+var openStreams = struct {
+	sync.Mutex
+	m map[weak.Pointer[luaStream]]struct{}
+}{m: map[weak.Pointer[luaStream]]struct{}{}}
+
+// 创建文件句柄并压入栈顶
+func newFile(vm api.LuaVM, f *os.File) *luaStream {
+	s := &luaStream{f: f, r: bufio.NewReader(f), w: bufio.NewWriter(f), bufMode: "full"}
+	wp := weak.Make(s)
+	openStreams.Lock()
+	openStreams.m[wp] = struct{}{}
+	openStreams.Unlock()
+	//句柄不可达时关闭文件,防止文件描述符泄漏
+	runtime.SetFinalizer(s, func(s *luaStream) {
+		if !s.closed && !s.isStd {
+			s.close()
+		}
+		openStreams.Lock()
+		delete(openStreams.m, wp)
+		openStreams.Unlock()
+	})
+	vm.PushUserdata(s)
+	vm.SetMetatableByName(LUA_FILEHANDLE)
+	return s
+}
+
+// 读取前需要先将写缓冲写入文件
+func (s *luaStream) prepRead() error {
+	return s.w.Flush()
+}
+
+// 写入前需要丢弃读缓冲,并将文件位置回退到未读取的位置
+func (s *luaStream) prepWrite() {
+	if n := s.r.Buffered(); n > 0 {
+		s.f.Seek(int64(-n), io.SeekCurrent)
+		s.r.Reset(s.f)
+	}
+}
+
+func (s *luaStream) write(str string) error {
+	s.prepWrite()
+	if s.bufMode == "no" {
+		_, err := s.f.WriteString(str)
+		return err
+	}
+	if _, err := s.w.WriteString(str); err != nil {
+		return err
+	}
+	if s.bufMode == "line" && strings.IndexByte(str, '\n') >= 0 {
+		return s.w.Flush()
+	}
+	return nil
+}
+
+func (s *luaStream) flush() error {
+	return s.w.Flush()
+}
+
+func (s *luaStream) seek(offset int64, whence int) (int64, error) {
+	if err := s.w.Flush(); err != nil {
+		return 0, err
+	}
+	if whence == io.SeekCurrent {
+		offset -= int64(s.r.Buffered()) //读缓冲中的数据对lua而言还未读取
+	}
+	pos, err := s.f.Seek(offset, whence)
+	s.r.Reset(s.f)
+	return pos, err
+}
+
+// 写入所有未关闭文件的缓冲,closeAll为true时同时关闭非标准文件
+func flushAllStreams(closeAll bool) {
+	openStreams.Lock()
+	streams := make([]*luaStream, 0, len(openStreams.m))
+	for wp := range openStreams.m {
+		if s := wp.Value(); s != nil && !s.closed {
+			streams = append(streams, s)
+		}
+	}
+	openStreams.Unlock()
+	for _, s := range streams {
+		if closeAll && !s.isStd {
+			s.close()
+		} else {
+			s.flush()
+		}
+	}
+}
+
+func (s *luaStream) close() error {
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.closed = true
+	return err
+}
+
+/*
+*	辅助函数
+ */
+
+// 检查R(1)是否为文件句柄
+func toStream(vm api.LuaVM) *luaStream {
+	return vm.CheckUdata(1, LUA_FILEHANDLE).(*luaStream)
+}
+
+// 检查R(1)是否为未关闭的文件句柄
+func toFile(vm api.LuaVM) *luaStream {
+	s := toStream(vm)
+	if s.closed {
+		vm.Error2("attempt to use a closed file")
+	}
+	return s
+}
+
+// 将默认输入或输出文件压入栈顶并返回
+func getIOFile(vm api.LuaVM, key string) *luaStream {
+	vm.GetField(api.LUA_REGISTRY_INDEX, key)
+	s := vm.ToUserdata(0).(*luaStream)
+	if s.closed {
+		vm.Error2("standard %s file is closed", key[len("_IO_"):])
+	}
+	return s
+}
+
+// 按照lua的约定返回操作结果:成功返回true,失败返回nil,错误信息,错误码
+func pushFileResult(vm api.LuaVM, err error, fname string) int {
+	if err == nil {
+		vm.PushBoolean(true)
+		return 1
+	}
+	msg, errno := errorMessage(err)
+	if fname != "" {
+		msg = fname + ": " + msg
+	}
+	vm.PushNil()
+	vm.PushString(msg)
+	vm.PushInteger(errno)
+	return 3
+}
+
+// 获取与C的strerror一致的错误信息以及错误码
+func errorMessage(err error) (string, int64) {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		msg := errno.Error()
+		return strings.ToUpper(msg[:1]) + msg[1:], int64(errno)
+	}
+	return err.Error(), 0
+}
+
+// 检查模式是否符合[rwa]%+?b*
+func checkMode(mode string) bool {
+	if mode == "" || strings.IndexByte("rwa", mode[0]) < 0 {
+		return false
+	}
+	mode = mode[1:]
+	if mode != "" && mode[0] == '+' {
+		mode = mode[1:]
+	}
+	return strings.Trim(mode, "b") == ""
+}
+
+func openFile(filename, mode string) (*os.File, error) {
+	flag := 0
+	switch strings.ReplaceAll(mode, "b", "") {
+	case "r":
+		flag = os.O_RDONLY
+	case "w":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case "a":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	case "r+":
+		flag = os.O_RDWR
+	case "w+":
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	case "a+":
+		flag = os.O_RDWR | os.O_CREATE | os.O_APPEND
+	}
+	return os.OpenFile(filename, flag, 0666)
+}
+
+func checkOption(vm api.LuaVM, arg int, def string, opts ...string) string {
+	name := vm.OptString(arg, def)
+	for _, opt := range opts {
+		if opt == name {
+			return name
+		}
+	}
+	vm.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+	return ""
+}
+
+/*
+*	io库函数
+ */
+
+// io.open(filename [, mode])
+// mode与C的fopen一致:"r","w","a","r+","w+","a+",可以附加'b'
+func ioOpen(vm api.LuaVM) int {
+	filename := vm.CheckString(1)
+	mode := vm.OptString(2, "r")
+	vm.ArgCheck(checkMode(mode), 2, "invalid mode")
+	f, err := openFile(filename, mode)
+	if err != nil {
+		return pushFileResult(vm, err, filename)
+	}
+	newFile(vm, f)
+	return 1
+}
+
+// io.close([file])
+// 不指定file则关闭默认输出文件
+func ioClose(vm api.LuaVM) int {
+	if vm.IsNone(1) {
+		vm.GetField(api.LUA_REGISTRY_INDEX, IO_OUTPUT)
+	}
+	return fileClose(vm)
+}
+
+// io.flush()
+func ioFlush(vm api.LuaVM) int {
+	return pushFileResult(vm, getIOFile(vm, IO_OUTPUT).flush(), "")
+}
+
+// io.input([file])
+func ioInput(vm api.LuaVM) int {
+	return ioFile(vm, IO_INPUT, "r")
+}
+
+// io.output([file])
+func ioOutput(vm api.LuaVM) int {
+	return ioFile(vm, IO_OUTPUT, "w")
+}
+
+// 参数为文件名则以mode打开文件,参数为文件句柄则直接使用,设置为默认输入或输出文件
+// 无论是否有参数都返回当前的默认文件
+func ioFile(vm api.LuaVM, key, mode string) int {
+	if !vm.IsNoneOrNil(1) {
+		if filename, ok := vm.ToStringX(1); ok {
+			f, err := openFile(filename, mode)
+			if err != nil {
+				msg, _ := errorMessage(err)
+				vm.Error2("cannot open file '%s' (%s)", filename, msg)
+			}
+			newFile(vm, f)
+		} else {
+			toFile(vm)
+			vm.PushValue(1)
+		}
+		vm.SetField(api.LUA_REGISTRY_INDEX, key)
+	}
+	vm.GetField(api.LUA_REGISTRY_INDEX, key)
+	return 1
+}
+
+// io.lines([filename, ...])
+// 不指定文件名则遍历默认输入文件;指定文件名则遍历结束后自动关闭文件
+func ioLines(vm api.LuaVM) int {
+	if vm.IsNone(1) {
+		vm.PushNil()
+	}
+	if vm.IsNil(1) {
+		getIOFile(vm, IO_INPUT)
+		vm.Replace(1)
+		toFile(vm)
+		return linesAux(vm, false)
+	}
+	filename := vm.CheckString(1)
+	f, err := openFile(filename, "r")
+	if err != nil {
+		msg, _ := errorMessage(err)
+		vm.Error2("%s: %s", filename, msg)
+	}
+	newFile(vm, f)
+	vm.Replace(1)
+	return linesAux(vm, true)
+}
+
+// io.read(...)
+func ioRead(vm api.LuaVM) int {
+	getIOFile(vm, IO_INPUT)
+	vm.Insert(1)
+	return fileRead(vm)
+}
+
+// io.write(...)
+func ioWrite(vm api.LuaVM) int {
+	getIOFile(vm, IO_OUTPUT)
+	vm.Insert(1)
+	return fileWrite(vm)
+}
+
+// io.tmpfile()
+// 返回以"w+"模式打开的临时文件句柄,文件关闭或程序结束后自动删除
+func ioTmpfile(vm api.LuaVM) int {
+	f, err := os.CreateTemp("", "lua_")
+	if err != nil {
+		return pushFileResult(vm, err, "")
+	}
+	os.Remove(f.Name()) //文件仍处于打开状态,关闭后由系统回收
+	newFile(vm, f)
+	return 1
+}
+
+// io.type(obj)
+// obj为打开的文件句柄返回"file",已关闭的文件句柄返回"closed file",否则返回nil
+func ioType(vm api.LuaVM) int {
+	vm.CheckAny(1)
+	s, ok := vm.TestUdata(1, LUA_FILEHANDLE).(*luaStream)
+	if !ok {
+		vm.PushNil()
+	} else if s.closed {
+		vm.PushString("closed file")
+	} else {
+		vm.PushString("file")
+	}
+	return 1
+}
+
+/*
+*	文件句柄方法
+ */
+
+// file:close()
+func fileClose(vm api.LuaVM) int {
+	s := toFile(vm)
+	if s.isStd {
+		vm.PushNil()
+		vm.PushString("cannot close standard file")
+		return 2
+	}
+	return pushFileResult(vm, s.close(), "")
+}
+
+// file:flush()
+func fileFlush(vm api.LuaVM) int {
+	return pushFileResult(vm, toFile(vm).flush(), "")
+}
+
+// file:lines(...)
+// 与io.lines不同,遍历结束后不会关闭文件
+func fileLines(vm api.LuaVM) int {
+	toFile(vm)
+	return linesAux(vm, false)
+}
+
+// 创建遍历R(1)文件的迭代器,R(2)及之后的参数作为每次迭代的读取格式
+func linesAux(vm api.LuaVM, toClose bool) int {
+	n := vm.GetTop() - 1
+	vm.ArgCheck(n <= 250, 252, "too many arguments")
+	s := vm.ToUserdata(1).(*luaStream)
+	formats := make([]interface{}, n)
+	for i := range formats {
+		if vm.Type(i+2) == api.LUAVALUE_NUMBER {
+			formats[i] = vm.ToInteger(i + 2)
+		} else {
+			formats[i] = vm.CheckString(i + 2)
+		}
+	}
+	vm.PushGoFunction(func(vm api.LuaVM) int {
+		if s.closed {
+			vm.Error2("file is already closed")
+		}
+		vm.Pop(vm.GetTop())
+		vm.PushNil() //占位,读取格式从R(2)开始
+		for _, format := range formats {
+			if i, ok := format.(int64); ok {
+				vm.PushInteger(i)
+			} else {
+				vm.PushString(format.(string))
+			}
+		}
+		n := readFormats(vm, s, 2)
+		if vm.ToBoolean(-n + 1) { //第一个结果不为nil
+			return n
+		}
+		if n > 1 { //读取出错,抛出错误信息
+			vm.Error2("%s", vm.ToString(-n+2))
+		}
+		if toClose {
+			s.close()
+		}
+		return 0
+	}, 0)
+	return 1
+}
+
+// file:read(...)
+func fileRead(vm api.LuaVM) int {
+	return readFormats(vm, toFile(vm), 2)
+}
+
+// file:seek([whence [, offset]])
+// whence为"set","cur","end",返回相对于文件开头的最终位置
+func fileSeek(vm api.LuaVM) int {
+	s := toFile(vm)
+	whence := checkOption(vm, 2, "cur", "set", "cur", "end")
+	offset := vm.OptInteger(3, 0)
+	w := map[string]int{"set": io.SeekStart, "cur": io.SeekCurrent, "end": io.SeekEnd}[whence]
+	pos, err := s.seek(offset, w)
+	if err != nil {
+		return pushFileResult(vm, err, "")
+	}
+	vm.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf(mode [, size])
+// mode为"no","full","line"
+func fileSetvbuf(vm api.LuaVM) int {
+	s := toFile(vm)
+	mode := checkOption(vm, 2, "", "no", "full", "line")
+	size := vm.OptInteger(3, 4096)
+	err := s.w.Flush()
+	s.bufMode = mode
+	if size > 0 {
+		s.w = bufio.NewWriterSize(s.f, int(size))
+	}
+	return pushFileResult(vm, err, "")
+}
+
+// file:write(...)
+// 参数只能为字符串或数字,成功返回文件句柄
+func fileWrite(vm api.LuaVM) int {
+	s := toFile(vm)
+	for i := 2; i <= vm.GetTop(); i++ {
+		var str string
+		if vm.Type(i) == api.LUAVALUE_NUMBER {
+			//与tostring不同,浮点数直接按照%.14g写入,不追加".0"
+			if vm.IsIntegerSubtype(i) {
+				str = strconv.FormatInt(vm.ToInteger(i), 10)
+			} else {
+				str = fmt.Sprintf(number.FLOAT_FORMAT, vm.ToFloat(i))
+			}
+		} else {
+			str = vm.CheckString(i)
+		}
+		if err := s.write(str); err != nil {
+			return pushFileResult(vm, err, "")
+		}
+	}
+	vm.PushValue(1)
+	return 1
+}
+
+// __gc,__close
+func fileGC(vm api.LuaVM) int {
+	if s, ok := vm.ToUserdata(1).(*luaStream); ok && !s.closed && !s.isStd {
+		s.close()
+	}
+	return 0
+}
+
+// __tostring
+func fileToString(vm api.LuaVM) int {
+	s := toStream(vm)
+	if s.closed {
+		vm.PushString("file (closed)")
+	} else {
+		vm.PushString(fmt.Sprintf("file (%p)", s))
+	}
+	return 1
+}
+
+/*
+*	读取
+ */
+
+// 按R(first)及之后的格式依次读取,遇到失败则停止并以nil作为最后一个结果
+// "n":数字 "l":一行(不含换行符) "L":一行(包含换行符) "a":剩余全部内容 整数n:最多n个字节
+func readFormats(vm api.LuaVM, s *luaStream, first int) int {
+	if err := s.prepRead(); err != nil {
+		return pushFileResult(vm, err, "")
+	}
+	nArgs := vm.GetTop() - first + 1
+	var err error
+	success := true
+	n := 0
+	if nArgs == 0 { //默认读取一行
+		success, err = readLine(vm, s, false)
+		n = 1
+	} else {
+		vm.CheckStack(nArgs)
+		for i := first; i < first+nArgs && success && err == nil; i++ {
+			n++
+			if vm.Type(i) == api.LUAVALUE_NUMBER {
+				l := vm.CheckInteger(i)
+				if l == 0 {
+					success, err = testEOF(vm, s)
+				} else {
+					success, err = readChars(vm, s, l)
+				}
+				continue
+			}
+			p := strings.TrimPrefix(vm.CheckString(i), "*") //兼容lua5.2之前的格式
+			if p == "" {
+				vm.ArgError(i, "invalid format")
+			}
+			switch p[0] {
+			case 'n':
+				success, err = readNumber(vm, s)
+			case 'l':
+				success, err = readLine(vm, s, false)
+			case 'L':
+				success, err = readLine(vm, s, true)
+			case 'a':
+				success, err = readAll(vm, s)
+			default:
+				vm.ArgError(i, "invalid format")
+			}
+		}
+	}
+	if err != nil {
+		return pushFileResult(vm, err, "")
+	}
+	if !success {
+		vm.Pop(1)
+		vm.PushNil()
+	}
+	return n
+}
+
+func testEOF(vm api.LuaVM, s *luaStream) (bool, error) {
+	_, err := s.r.Peek(1)
+	vm.PushString("")
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func readChars(vm api.LuaVM, s *luaStream, n int64) (bool, error) {
+	buf := make([]byte, n)
+	k, err := io.ReadFull(s.r, buf)
+	vm.PushString(string(buf[:k]))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return k > 0, err
+}
+
+func readLine(vm api.LuaVM, s *luaStream, keepNL bool) (bool, error) {
+	line, err := s.r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		vm.PushString(line)
+		return false, err
+	}
+	if !keepNL {
+		line = strings.TrimSuffix(line, "\n")
+	}
+	vm.PushString(line)
+	return err == nil || line != "", nil
+}
+
+func readAll(vm api.LuaVM, s *luaStream) (bool, error) {
+	data, err := io.ReadAll(s.r)
+	vm.PushString(string(data))
+	return true, err
+}
+
+// 按lua数字的语法读取一个数字,移植自官方liolib.c
+type numReader struct {
+	r   *bufio.Reader
+	c   int //当前字符,-1表示EOF
+	buf []byte
+}
+
+// 将当前字符加入buf并读取下一个字符
+func (rn *numReader) nextc() bool {
+	if len(rn.buf) >= L_MAXLENNUM {
+		rn.buf = rn.buf[:0]
+		return false
+	}
+	rn.buf = append(rn.buf, byte(rn.c))
+	rn.readc()
+	return true
+}
+
+func (rn *numReader) readc() {
+	if b, err := rn.r.ReadByte(); err == nil {
+		rn.c = int(b)
+	} else {
+		rn.c = -1
+	}
+}
+
+// 当前字符属于set则接受
+func (rn *numReader) test2(set string) bool {
+	if rn.c != -1 && (rn.c == int(set[0]) || rn.c == int(set[1])) {
+		return rn.nextc()
+	}
+	return false
+}
+
+func (rn *numReader) readDigits(hex bool) int {
+	count := 0
+	for rn.c != -1 && (hex && isXDigit(byte(rn.c)) || isDigit(byte(rn.c))) && rn.nextc() {
+		count++
+	}
+	return count
+}
+
+func readNumber(vm api.LuaVM, s *luaStream) (bool, error) {
+	rn := &numReader{r: s.r}
+	for rn.readc(); rn.c != -1 && isSpace(byte(rn.c)); rn.readc() {
+	}
+	count := 0
+	hex := false
+	rn.test2("-+")
+	if rn.test2("00") {
+		if rn.test2("xX") {
+			hex = true
+		} else {
+			count = 1
+		}
+	}
+	count += rn.readDigits(hex)
+	if rn.test2("..") {
+		count += rn.readDigits(hex)
+	}
+	exp := "eE"
+	if hex {
+		exp = "pP"
+	}
+	if count > 0 && rn.test2(exp) {
+		rn.test2("-+")
+		rn.readDigits(false)
+	}
+	if rn.c != -1 {
+		s.r.UnreadByte()
+	}
+	if v, ok := strToNumber(string(rn.buf)); ok {
+		switch x := v.(type) {
+		case int64:
+			vm.PushInteger(x)
+		case float64:
+			vm.PushFloat(x)
+		}
+		return true, nil
+	}
+	vm.PushNil()
+	return false, nil
+}
+
+// 将lua数字字面量转换为整数或浮点数
+func strToNumber(str string) (interface{}, bool) {
+	if str == "" {
+		return nil, false
+	}
+	if i, ok := number.ParseInteger(str); ok {
+		return i, true
+	}
+	if f, ok := number.ParseFloat(str); ok {
+		return f, true
+	}
+	return nil, false
+}
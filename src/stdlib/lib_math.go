@@ -2,142 +2,358 @@ package stdlib
 
 import (
 	"math"
+	"math/rand/v2"
 
 	"nskbz.cn/lua/api"
 )
 
 var mathFuncs map[string]api.GoFunc = map[string]api.GoFunc{
-	"min":   mathMin,
-	"max":   mathMax,
-	"abs":   mathAbs,
-	"ceil":  mathCeil,
-	"floor": mathFloor,
-	"pow":   mathPow,
-	"sqrt":  mathSqrt,
+	"min":       mathMin,
+	"max":       mathMax,
+	"abs":       mathAbs,
+	"ceil":      mathCeil,
+	"floor":     mathFloor,
+	"sqrt":      mathSqrt,
+	"sin":       mathSin,
+	"cos":       mathCos,
+	"tan":       mathTan,
+	"asin":      mathAsin,
+	"acos":      mathAcos,
+	"atan":      mathAtan,
+	"deg":       mathDeg,
+	"rad":       mathRad,
+	"exp":       mathExp,
+	"log":       mathLog,
+	"fmod":      mathFmod,
+	"modf":      mathModf,
+	"tointeger": mathToInteger,
+	"type":      mathType,
+	"ult":       mathUlt,
 }
 
+// random与randomseed共享的随机数生成器,作为两者的upvalue保存
+// 每次打开math库都会创建新的生成器,所以不同的LuaState互不影响
+var mathRandFuncs map[string]api.GoFunc = map[string]api.GoFunc{
+	"random":     mathRandom,
+	"randomseed": mathRandomSeed,
+}
+
+type mathRand struct {
+	src *rand.PCG
+	r   *rand.Rand
+}
+
+// 未调用randomseed时的默认种子,与官方一致每次运行得到相同的随机数序列
+const RANDOM_DEFAULT_SEED = 0
+
 // 被RequireF调用,带唯一参数modname,具体于该方法的函数栈stack[1]=="math"
 // 用于加载math库的方法,最后应当生成一个table于栈顶返回
 func OpenMathLib(vm api.LuaVM) int {
 	vm.NewLib(mathFuncs)
+
+	src := rand.NewPCG(RANDOM_DEFAULT_SEED, RANDOM_DEFAULT_SEED)
+	mr := &mathRand{src, rand.New(src)}
+	for k, v := range mathRandFuncs {
+		vm.PushLightUserdata(mr)
+		vm.PushGoFunction(v, 1)
+		vm.SetField(-1, k)
+	}
+
+	vm.PushFloat(math.Pi)
+	vm.SetField(-1, "pi")
+	vm.PushFloat(math.Inf(1))
+	vm.SetField(-1, "huge")
+	vm.PushInteger(math.MaxInt64)
+	vm.SetField(-1, "maxinteger")
+	vm.PushInteger(math.MinInt64)
+	vm.SetField(-1, "mininteger")
 	return 1
 }
 
+// 浮点数f能够用整数表示时压入整数,否则压入浮点数
+func _pushNumInt(vm api.LuaVM, f float64) {
+	if f >= -(1<<63) && f < 1<<63 {
+		vm.PushInteger(int64(f))
+	} else {
+		vm.PushFloat(f)
+	}
+}
+
 // math.min(x1, x2, ...)
 // 参数：可以传入任意数量的数值（整数或浮点数）。
-// 返回值：返回所有参数中最小的数值。
+// 返回值：返回所有参数中最小的数值,保持原数值的类型。
 func mathMin(vm api.LuaVM) int {
 	nArgs := vm.GetTop()
-	if nArgs == 0 {
-		return vm.Error2("there is no args!")
-	}
-	var min float64 = math.MaxFloat64
-
+	vm.ArgCheck(nArgs >= 1, 1, "value expected")
+	min := 1
 	for i := 1; i <= nArgs; i++ {
-		if f, ok := vm.ToFloatX(i); !ok {
-			return vm.Error2("%s can't convert to float64", vm.Type(i).String())
-		} else if f < min {
-			min = f
+		vm.CheckFloat(i)
+		if vm.Compare(i, min, api.CompareOp_LT) {
+			min = i
 		}
 	}
-	if min != math.MaxFloat64 {
-		vm.PushFloat(min)
-		return 1
-	}
-	return vm.Error2("do min error")
+	vm.PushValue(min)
+	return 1
 }
 
 // math.max(x1, x2, ...)
 // 参数：可以传入任意数量的数值（整数或浮点数）。
-// 返回值：返回所有参数中最大的数值。
+// 返回值：返回所有参数中最大的数值,保持原数值的类型。
 func mathMax(vm api.LuaVM) int {
 	nArgs := vm.GetTop()
-	if nArgs == 0 {
-		return vm.Error2("there is no args!")
-	}
-	var max float64 = -math.MaxFloat64
-
+	vm.ArgCheck(nArgs >= 1, 1, "value expected")
+	max := 1
 	for i := 1; i <= nArgs; i++ {
-		if f, ok := vm.ToFloatX(i); !ok {
-			return vm.Error2("%s can't convert to float64", vm.Type(i).String())
-		} else if f > max {
-			max = f
+		vm.CheckFloat(i)
+		if vm.Compare(max, i, api.CompareOp_LT) {
+			max = i
 		}
 	}
-	if max != -math.MaxFloat64 {
-		vm.PushFloat(max)
-		return 1
-	}
-	return vm.Error2("do min error")
+	vm.PushValue(max)
+	return 1
 }
 
 // math.abs(x)
 // 返回 x 的绝对值	math.abs(-3.5) → 3.5
 func mathAbs(vm api.LuaVM) int {
-	vm.CheckAny(1)
-	p := vm.ToPointer(1)
-	switch v := p.(type) {
-	case int64:
-		if v < 0 {
-			v = -v
-		}
-		vm.PushInteger(v)
-		return 1
-	case float64:
-		if v < 0 {
-			v = -v
+	if vm.IsIntegerSubtype(1) {
+		i := vm.ToInteger(1)
+		if i < 0 {
+			i = -i //math.mininteger的绝对值仍为其本身
 		}
-		vm.PushFloat(v)
-		return 1
+		vm.PushInteger(i)
+	} else {
+		vm.PushFloat(math.Abs(vm.CheckFloat(1)))
 	}
-	return vm.Error2("expected number ,not support %s", vm.Type(1).String())
+	return 1
 }
 
 // math.ceil(x)
-// 向上取整	math.ceil(3.2) → 4
+// 向上取整,结果能用整数表示时返回整数	math.ceil(3.2) → 4
 func mathCeil(vm api.LuaVM) int {
-	vm.CheckAny(1)
-	if f, ok := vm.ToFloatX(1); ok {
-		f = math.Ceil(f)
-		vm.PushFloat(f)
-		return 1
+	if vm.IsIntegerSubtype(1) {
+		vm.PushValue(1) //整数向上取整为其本身
+	} else {
+		_pushNumInt(vm, math.Ceil(vm.CheckFloat(1)))
 	}
-	return vm.Error2("ceil fail!")
+	return 1
 }
 
 // math.floor(x)
-// 向下取整	math.floor(3.7) → 3
+// 向下取整,结果能用整数表示时返回整数	math.floor(3.7) → 3
 func mathFloor(vm api.LuaVM) int {
+	if vm.IsIntegerSubtype(1) {
+		vm.PushValue(1) //整数向下取整为其本身
+	} else {
+		_pushNumInt(vm, math.Floor(vm.CheckFloat(1)))
+	}
+	return 1
+}
+
+// math.sqrt(x)
+// 计算 x 的平方根	math.sqrt(9) → 3.0
+func mathSqrt(vm api.LuaVM) int {
+	vm.PushFloat(math.Sqrt(vm.CheckFloat(1)))
+	return 1
+}
+
+// math.sin(x)
+// 正弦,x为弧度
+func mathSin(vm api.LuaVM) int {
+	vm.PushFloat(math.Sin(vm.CheckFloat(1)))
+	return 1
+}
+
+// math.cos(x)
+// 余弦,x为弧度
+func mathCos(vm api.LuaVM) int {
+	vm.PushFloat(math.Cos(vm.CheckFloat(1)))
+	return 1
+}
+
+// math.tan(x)
+// 正切,x为弧度
+func mathTan(vm api.LuaVM) int {
+	vm.PushFloat(math.Tan(vm.CheckFloat(1)))
+	return 1
+}
+
+// math.asin(x)
+// 反正弦,返回弧度
+func mathAsin(vm api.LuaVM) int {
+	vm.PushFloat(math.Asin(vm.CheckFloat(1)))
+	return 1
+}
+
+// math.acos(x)
+// 反余弦,返回弧度
+func mathAcos(vm api.LuaVM) int {
+	vm.PushFloat(math.Acos(vm.CheckFloat(1)))
+	return 1
+}
+
+// math.atan(y [, x])
+// 返回y/x的反正切(弧度),通过两个参数的符号确定象限,x默认为1	math.atan(1, -1) → 2.3561944901923
+func mathAtan(vm api.LuaVM) int {
+	y := vm.CheckFloat(1)
+	x := vm.OptFloat(2, 1)
+	vm.PushFloat(math.Atan2(y, x))
+	return 1
+}
+
+// math.deg(x)
+// 将弧度x转换为角度	math.deg(math.pi) → 180.0
+func mathDeg(vm api.LuaVM) int {
+	vm.PushFloat(vm.CheckFloat(1) * (180.0 / math.Pi))
+	return 1
+}
+
+// math.rad(x)
+// 将角度x转换为弧度	math.rad(180) → 3.1415926535898
+func mathRad(vm api.LuaVM) int {
+	vm.PushFloat(vm.CheckFloat(1) * (math.Pi / 180.0))
+	return 1
+}
+
+// math.exp(x)
+// 计算 e 的 x 次方
+func mathExp(vm api.LuaVM) int {
+	vm.PushFloat(math.Exp(vm.CheckFloat(1)))
+	return 1
+}
+
+// math.log(x [, base])
+// 以base为底x的对数,base默认为e	math.log(8, 2) → 3.0
+func mathLog(vm api.LuaVM) int {
+	x := vm.CheckFloat(1)
+	var res float64
+	if vm.IsNoneOrNil(2) {
+		res = math.Log(x)
+	} else {
+		switch base := vm.CheckFloat(2); base {
+		case 2:
+			res = math.Log2(x)
+		case 10:
+			res = math.Log10(x)
+		default:
+			res = math.Log(x) / math.Log(base)
+		}
+	}
+	vm.PushFloat(res)
+	return 1
+}
+
+// math.fmod(x, y)
+// x除以y的余数,商向零取整(与'%'向负无穷取整不同)	math.fmod(-5, 3) → -2
+func mathFmod(vm api.LuaVM) int {
+	if vm.IsIntegerSubtype(1) && vm.IsIntegerSubtype(2) {
+		m, d := vm.ToInteger(1), vm.ToInteger(2)
+		switch d {
+		case 0:
+			return vm.ArgError(2, "zero")
+		case -1:
+			vm.PushInteger(0) //避免math.mininteger % -1溢出
+		default:
+			vm.PushInteger(m % d)
+		}
+	} else {
+		vm.PushFloat(math.Mod(vm.CheckFloat(1), vm.CheckFloat(2)))
+	}
+	return 1
+}
+
+// math.modf(x)
+// 返回x的整数部分与小数部分,整数部分为浮点数	math.modf(3.7) → 3.0 0.7
+func mathModf(vm api.LuaVM) int {
+	if vm.IsIntegerSubtype(1) {
+		vm.PushValue(1) //整数的整数部分为其本身
+		vm.PushFloat(0)
+		return 2
+	}
+	x := vm.CheckFloat(1)
+	ip := math.Trunc(x)
+	vm.PushFloat(ip)
+	if math.IsInf(x, 0) {
+		vm.PushFloat(0)
+	} else {
+		vm.PushFloat(x - ip)
+	}
+	return 2
+}
+
+// math.tointeger(x)
+// x能转换为整数时返回该整数,否则返回nil	math.tointeger(3.0) → 3
+// 与官方5.3一致,可以转换为整数的字符串也被接受	math.tointeger("34.0") → 34
+func mathToInteger(vm api.LuaVM) int {
 	vm.CheckAny(1)
-	if f, ok := vm.ToFloatX(1); ok {
-		f = math.Floor(f)
-		vm.PushFloat(f)
+	if i, ok := vm.ToIntegerX(1); ok {
+		vm.PushInteger(i)
 		return 1
 	}
-	return vm.Error2("ceil fail!")
+	vm.PushNil()
+	return 1
 }
 
-// math.pow(x, y)
-// 计算 x 的 y 次方	math.pow(2, 3) → 8
-func mathPow(vm api.LuaVM) int {
+// math.type(x)
+// x为整数返回"integer",为浮点数返回"float",不是数字返回nil
+func mathType(vm api.LuaVM) int {
 	vm.CheckAny(1)
-	vm.CheckAny(2)
-	if x, ok := vm.ToFloatX(1); ok {
-		if y, ok := vm.ToFloatX(2); ok {
-			vm.PushFloat(math.Pow(x, y))
-			return 1
-		}
+	if vm.Type(1) != api.LUAVALUE_NUMBER {
+		vm.PushNil()
+	} else if vm.IsIntegerSubtype(1) {
+		vm.PushString("integer")
+	} else {
+		vm.PushString("float")
 	}
-	return vm.Error2("get pow error!")
+	return 1
 }
 
-// math.sqrt(x)
-// 计算 x 的平方根	math.sqrt(9) → 3
-func mathSqrt(vm api.LuaVM) int {
-	vm.CheckAny(1)
-	if x, ok := vm.ToFloatX(1); ok {
-		vm.PushFloat(math.Sqrt(x))
+// math.ult(m, n)
+// 将m与n作为无符号整数比较,m<n时返回true	math.ult(1, -1) → true
+func mathUlt(vm api.LuaVM) int {
+	m := vm.CheckInteger(1)
+	n := vm.CheckInteger(2)
+	vm.PushBoolean(uint64(m) < uint64(n))
+	return 1
+}
+
+// math.random([m [, n]])
+// 没有参数时返回[0,1)区间的浮点数
+// 只有m时返回[1,m]区间的整数,有m与n时返回[m,n]区间的整数
+func mathRandom(vm api.LuaVM) int {
+	r := vm.ToUserdata(vm.UpvalueIndex(1)).(*mathRand).r
+	var low, up int64
+	switch vm.GetTop() {
+	case 0:
+		vm.PushFloat(r.Float64())
 		return 1
+	case 1:
+		low, up = 1, vm.CheckInteger(1)
+	case 2:
+		low, up = vm.CheckInteger(1), vm.CheckInteger(2)
+	default:
+		return vm.Error2("wrong number of arguments")
+	}
+	vm.ArgCheck(low <= up, vm.GetTop(), "interval is empty")
+	diff := uint64(up) - uint64(low) //区间长度减一,按无符号计算避免溢出
+	if diff == math.MaxUint64 {
+		vm.PushInteger(int64(r.Uint64()))
+	} else {
+		vm.PushInteger(low + int64(r.Uint64N(diff+1)))
+	}
+	return 1
+}
+
+// math.randomseed(x)
+// 以x作为随机数生成器的种子,相同的种子得到相同的随机数序列
+func mathRandomSeed(vm api.LuaVM) int {
+	mr := vm.ToUserdata(vm.UpvalueIndex(1)).(*mathRand)
+	var seed int64
+	if vm.IsIntegerSubtype(1) {
+		seed = vm.ToInteger(1)
+	} else {
+		seed = int64(vm.CheckFloat(1))
 	}
-	return vm.Error2("get sqrt error!")
+	mr.src.Seed(uint64(seed), RANDOM_DEFAULT_SEED)
+	return 0
 }
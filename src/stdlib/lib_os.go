@@ -1,17 +1,30 @@
 package stdlib
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"nskbz.cn/lua/api"
-	"nskbz.cn/lua/tool"
 )
 
 var osFuncs map[string]api.GoFunc = map[string]api.GoFunc{
-	"time": osTime,
-	"date": osDate,
+	"clock":    osClock,
+	"date":     osDate,
+	"difftime": osDifftime,
+	"execute":  osExecute,
+	"exit":     osExit,
+	"getenv":   osGetenv,
+	"remove":   osRemove,
+	"rename":   osRename,
+	"time":     osTime,
+	"tmpname":  osTmpname,
 }
 
 func OpenOsLib(vm api.LuaVM) int {
@@ -19,6 +32,113 @@ func OpenOsLib(vm api.LuaVM) int {
 	return 1
 }
 
+// os.clock()
+// 返回程序使用的CPU时间(秒)
+func osClock(vm api.LuaVM) int {
+	vm.PushFloat(cpuTime().Seconds())
+	return 1
+}
+
+// os.getenv(varname)
+// 返回环境变量varname的值,不存在则返回nil
+func osGetenv(vm api.LuaVM) int {
+	if val, ok := os.LookupEnv(vm.CheckString(1)); ok {
+		vm.PushString(val)
+	} else {
+		vm.PushNil()
+	}
+	return 1
+}
+
+// os.exit([code [, close]])
+// 终止宿主程序,code为true(默认)时返回EXIT_SUCCESS,false时返回EXIT_FAILURE,也可以是数字
+// 退出前会写入所有文件的缓冲,close为true时还会关闭所有文件
+func osExit(vm api.LuaVM) int {
+	status := 0
+	if vm.Type(1) == api.LUAVALUE_BOOLEAN {
+		if !vm.ToBoolean(1) {
+			status = 1
+		}
+	} else {
+		status = int(vm.OptInteger(1, 0))
+	}
+	flushAllStreams(!vm.IsNone(2) && vm.ToBoolean(2))
+	os.Exit(status)
+	return 0
+}
+
+// os.remove(filename)
+// 删除文件或空目录,成功返回true,失败返回nil,错误信息,错误码
+func osRemove(vm api.LuaVM) int {
+	filename := vm.CheckString(1)
+	return pushFileResult(vm, os.Remove(filename), filename)
+}
+
+// os.rename(oldname, newname)
+// 重命名文件或目录,成功返回true,失败返回nil,错误信息,错误码
+func osRename(vm api.LuaVM) int {
+	oldname := vm.CheckString(1)
+	newname := vm.CheckString(2)
+	return pushFileResult(vm, os.Rename(oldname, newname), oldname)
+}
+
+// os.tmpname()
+// 返回一个可以用作临时文件的文件名,该文件会被创建,不再使用时需要通过os.remove删除
+func osTmpname(vm api.LuaVM) int {
+	f, err := os.CreateTemp("", "lua_")
+	if err != nil {
+		return vm.Error2("unable to generate a unique filename")
+	}
+	f.Close()
+	vm.PushString(f.Name())
+	return 1
+}
+
+// os.difftime(t2 [, t1])
+// 返回t2-t1的秒数(浮点数),t1默认为0
+func osDifftime(vm api.LuaVM) int {
+	t2 := vm.CheckInteger(1)
+	t1 := vm.OptInteger(2, 0)
+	vm.PushFloat(float64(t2) - float64(t1))
+	return 1
+}
+
+// os.execute([command])
+// 通过操作系统的shell执行command
+// 没有command时返回shell是否可用
+// 否则返回三个值:命令正常结束且退出码为0时为true否则为nil,"exit"或"signal",退出码或信号
+func osExecute(vm api.LuaVM) int {
+	shell, flag := "/bin/sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	if vm.IsNoneOrNil(1) {
+		_, err := exec.LookPath(shell)
+		vm.PushBoolean(err == nil)
+		return 1
+	}
+	cmd := exec.Command(shell, flag, vm.CheckString(1))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	flushAllStreams(false) //保证输出顺序与命令的输出一致
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return pushFileResult(vm, err, "") //命令无法执行
+	}
+	what, stat := "exit", cmd.ProcessState.ExitCode()
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		what, stat = "signal", int(ws.Signal())
+	}
+	if what == "exit" && stat == 0 {
+		vm.PushBoolean(true)
+	} else {
+		vm.PushNil()
+	}
+	vm.PushString(what)
+	vm.PushInteger(int64(stat))
+	return 3
+}
+
 // os.time([time_table])
 //
 //	local time_table = {
@@ -30,89 +150,219 @@ func OpenOsLib(vm api.LuaVM) int {
 //	    sec = 0
 //	}
 //
-// 字段名	必填	默认值	取值范围
-// year		是		无		1970+
-// month	是		无		1-12
-// day		是		无		1-31
-// hour		否		12		0-23
-// min		否		0		0-59
-// sec		否		0		0-61
+// 字段名	必填	默认值
+// year		是		无
+// month	是		无
+// day		是		无
+// hour		否		12
+// min		否		0
+// sec		否		0
 //
-// 无参则返回当前时间戳，有参数则返回参数所对应的时间戳
+// 无参则返回当前时间戳，有参数则返回参数(本地时间)所对应的时间戳
+// 超出范围的字段会被规范化,例如month=13表示下一年的一月,同时time_table中的字段也会被更新
 func osTime(vm api.LuaVM) int {
-	if vm.GetTop() == 0 { //无参数
+	if vm.IsNoneOrNil(1) { //无参数
 		vm.PushInteger(time.Now().Unix())
 		return 1
 	}
 	vm.CheckType(1, api.LUAVALUE_TABLE)
-	year, month, day, hour, min, sec := 0, 0, 0, 12, 0, 0
-	if api.LUAVALUE_NIL == vm.GetField(1, "year") {
-		tool.Fatal(vm, "time_table not exist year")
+	sec := getDateField(vm, "sec", 0)
+	min := getDateField(vm, "min", 0)
+	hour := getDateField(vm, "hour", 12)
+	day := getDateField(vm, "day", -1)
+	month := getDateField(vm, "month", -1)
+	year := getDateField(vm, "year", -1)
+	t := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+	setDateFields(vm, t, 1) //更新为规范化后的字段
+	vm.PushInteger(t.Unix())
+	return 1
+}
+
+// 获取time_table中的字段,字段不存在时返回d,d<0表示该字段必须存在
+func getDateField(vm api.LuaVM, key string, d int) int {
+	t := vm.GetField(1, key)
+	defer vm.Pop(1)
+	res, ok := vm.ToIntegerX(0)
+	if !ok {
+		if t != api.LUAVALUE_NIL {
+			vm.Error2("field '%s' is not an integer", key)
+		} else if d < 0 {
+			vm.Error2("field '%s' missing in date table", key)
+		}
+		return d
 	}
-	year = int(vm.ToInteger(0))
-	if year < 1970 {
-		tool.Fatal(vm, fmt.Sprintf("year must between [1970,+), but %d", year))
+	if res < -math.MaxInt32/2 || res > math.MaxInt32/2 {
+		vm.Error2("field '%s' is out-of-bound", key)
 	}
-	if api.LUAVALUE_NIL == vm.GetField(1, "month") {
-		tool.Fatal(vm, "time_table not exist month")
+	return int(res)
+}
+
+// 将时间t的各个字段设置到idx处的表中
+func setDateFields(vm api.LuaVM, t time.Time, idx int) {
+	idx = vm.AbsIndex(idx)
+	fields := []struct {
+		key string
+		val int
+	}{
+		{"sec", t.Second()},
+		{"min", t.Minute()},
+		{"hour", t.Hour()},
+		{"day", t.Day()},
+		{"month", int(t.Month())},
+		{"year", t.Year()},
+		{"wday", int(t.Weekday()) + 1}, //星期日为1
+		{"yday", t.YearDay()},
 	}
-	month = int(vm.ToInteger(0))
-	if month < 1 || month > 12 {
-		tool.Fatal(vm, fmt.Sprintf("month must between [1,12], but %d", month))
+	for _, f := range fields {
+		vm.PushInteger(int64(f.val))
+		vm.SetField(idx, f.key)
 	}
-	if api.LUAVALUE_NIL == vm.GetField(1, "day") {
-		tool.Fatal(vm, "time_table not exist day")
+	vm.PushBoolean(t.IsDST())
+	vm.SetField(idx, "isdst")
+}
+
+// os.date([format [, timestamp]])
+// format = "%Y-%m-%d %H:%M:%S"
+// 用于格式化时间戳为可读字符串或时间表的函数,如果没有timestamp则默认当前时间戳
+// format以'!'开头时使用UTC时间,否则使用本地时间,默认为"%c"
+// 如果format=="*t"则返回一个time_table,包括year,month,day,hour,min,sec,wday,yday,isdst字段
+func osDate(vm api.LuaVM) int {
+	format := vm.OptString(1, "%c")
+	t := time.Now()
+	if !vm.IsNoneOrNil(2) {
+		t = time.Unix(vm.CheckInteger(2), 0)
 	}
-	day = int(vm.ToInteger(0))
-	if day < 1 || day > 31 {
-		tool.Fatal(vm, fmt.Sprintf("day must between [1,31], but %d", day))
+	if strings.HasPrefix(format, "!") {
+		format = format[1:]
+		t = t.UTC()
+	} else {
+		t = t.Local()
 	}
-	if h := vm.GetField(1, "hour"); h != api.LUAVALUE_NIL {
-		hour = int(vm.ToInteger(0))
-		if hour < 0 || hour > 23 {
-			tool.Fatal(vm, fmt.Sprintf("hour must between [0,23], but %d", hour))
-		}
+	if strings.HasPrefix(format, "*t") {
+		vm.CreateTable(0, 9)
+		setDateFields(vm, t, 0)
+		return 1
 	}
-	if m := vm.GetField(1, "min"); m != api.LUAVALUE_NIL {
-		min = int(vm.ToInteger(0))
-		if min < 0 || min > 59 {
-			tool.Fatal(vm, fmt.Sprintf("min must between [0,59], but %d", min))
+	sb := strings.Builder{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
 		}
+		conv := checkDateOption(vm, format[i+1:])
+		i += len(conv)
+		sb.WriteString(strftime(t, conv[len(conv)-1]))
 	}
-	if s := vm.GetField(1, "sec"); s != api.LUAVALUE_NIL {
-		sec = int(vm.ToInteger(0))
-		if sec < 0 || sec > 61 {
-			tool.Fatal(vm, fmt.Sprintf("sec must between [0,61], but %d", sec))
+	vm.PushString(sb.String())
+	return 1
+}
+
+// 有效的转换说明符(C99),E与O修饰符在C locale下与没有修饰符时一致
+var dateOptions = []string{
+	"a", "A", "b", "B", "c", "C", "d", "D", "e", "F", "g", "G", "h", "H", "I", "j", "m", "M", "n",
+	"p", "r", "R", "S", "t", "T", "u", "U", "V", "w", "W", "x", "X", "y", "Y", "z", "Z", "%",
+	"Ec", "EC", "Ex", "EX", "Ey", "EY",
+	"Od", "Oe", "OH", "OI", "Om", "OM", "OS", "Ou", "OU", "OV", "Ow", "OW", "Oy",
+}
+
+// 检查'%'之后的转换说明符是否有效,有效则返回该说明符
+func checkDateOption(vm api.LuaVM, conv string) string {
+	for _, opt := range dateOptions {
+		if strings.HasPrefix(conv, opt) {
+			return opt
 		}
 	}
-
-	timestamp := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.UTC).Unix()
-	vm.PushInteger(timestamp)
-	return 1
+	if len(conv) > 2 {
+		conv = conv[:2]
+	}
+	vm.ArgError(1, fmt.Sprintf("invalid conversion specifier '%%%s'", conv))
+	return ""
 }
 
-// os.date([format [, timestamp]])
-// format = "%Y-%m-%d %H:%M:%S"
-// 用于格式化时间戳为可读字符串或时间表的函数,如果没有timestamp则默认当前时间戳
-// 如果format=="*t"则返回一个time_table
-func osDate(vm api.LuaVM) int {
-	vm.CheckString(1)
-	format := vm.ToString(1)
-	timestamp := time.Now()
-	if format == "*t" {
-		// todo
-	}
-	if vm.GetTop() > 1 {
-		vm.CheckInteger(2)
-		timestamp = time.Unix(vm.ToInteger(2), 0)
-	}
-	//golang的format: "2006-01-02 15:04:05"
-	format = strings.ReplaceAll(format, "%Y", "2006")
-	format = strings.ReplaceAll(format, "%m", "01")
-	format = strings.ReplaceAll(format, "%d", "02")
-	format = strings.ReplaceAll(format, "%H", "15")
-	format = strings.ReplaceAll(format, "%M", "04")
-	format = strings.ReplaceAll(format, "%S", "05")
-	vm.PushString(timestamp.Format(format))
-	return 1
+var weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var monthNames = []string{"January", "February", "March", "April", "May", "June",
+	"July", "August", "September", "October", "November", "December"}
+
+// 按照C locale下strftime的规则格式化转换说明符c
+func strftime(t time.Time, c byte) string {
+	wday := int(t.Weekday())
+	yday := t.YearDay() - 1
+	switch c {
+	case 'a':
+		return weekdayNames[wday][:3]
+	case 'A':
+		return weekdayNames[wday]
+	case 'b', 'h':
+		return monthNames[t.Month()-1][:3]
+	case 'B':
+		return monthNames[t.Month()-1]
+	case 'c':
+		return fmt.Sprintf("%s %s %2d %s %d", strftime(t, 'a'), strftime(t, 'b'), t.Day(), strftime(t, 'T'), t.Year())
+	case 'C':
+		return fmt.Sprintf("%02d", t.Year()/100)
+	case 'd':
+		return fmt.Sprintf("%02d", t.Day())
+	case 'D', 'x':
+		return fmt.Sprintf("%02d/%02d/%02d", int(t.Month()), t.Day(), t.Year()%100)
+	case 'e':
+		return fmt.Sprintf("%2d", t.Day())
+	case 'F':
+		return fmt.Sprintf("%d-%02d-%02d", t.Year(), int(t.Month()), t.Day())
+	case 'g':
+		year, _ := t.ISOWeek()
+		return fmt.Sprintf("%02d", year%100)
+	case 'G':
+		year, _ := t.ISOWeek()
+		return fmt.Sprintf("%d", year)
+	case 'H':
+		return fmt.Sprintf("%02d", t.Hour())
+	case 'I':
+		return fmt.Sprintf("%02d", (t.Hour()+11)%12+1)
+	case 'j':
+		return fmt.Sprintf("%03d", yday+1)
+	case 'm':
+		return fmt.Sprintf("%02d", int(t.Month()))
+	case 'M':
+		return fmt.Sprintf("%02d", t.Minute())
+	case 'n':
+		return "\n"
+	case 'p':
+		if t.Hour() < 12 {
+			return "AM"
+		}
+		return "PM"
+	case 'r':
+		return fmt.Sprintf("%s:%02d:%02d %s", strftime(t, 'I'), t.Minute(), t.Second(), strftime(t, 'p'))
+	case 'R':
+		return fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute())
+	case 'S':
+		return fmt.Sprintf("%02d", t.Second())
+	case 't':
+		return "\t"
+	case 'T', 'X':
+		return fmt.Sprintf("%02d:%02d:%02d", t.Hour(), t.Minute(), t.Second())
+	case 'u':
+		return fmt.Sprintf("%d", (wday+6)%7+1) //星期一为1
+	case 'U':
+		return fmt.Sprintf("%02d", (yday+7-wday)/7) //以星期日作为一周的开始
+	case 'V':
+		_, week := t.ISOWeek()
+		return fmt.Sprintf("%02d", week)
+	case 'w':
+		return fmt.Sprintf("%d", wday) //星期日为0
+	case 'W':
+		return fmt.Sprintf("%02d", (yday+7-(wday+6)%7)/7) //以星期一作为一周的开始
+	case 'y':
+		return fmt.Sprintf("%02d", t.Year()%100)
+	case 'Y':
+		return fmt.Sprintf("%d", t.Year())
+	case 'z':
+		return t.Format("-0700")
+	case 'Z':
+		name, _ := t.Zone()
+		return name
+	case '%':
+		return "%"
+	}
+	return ""
 }
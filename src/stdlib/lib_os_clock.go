@@ -0,0 +1,6 @@
+package stdlib
+
+import "time"
+
+// 进程启动时间,无法获取CPU时间时以进程运行的时间代替
+var processStart = time.Now()
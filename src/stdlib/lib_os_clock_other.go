@@ -0,0 +1,10 @@
+//go:build !unix
+
+package stdlib
+
+import "time"
+
+// 进程使用的CPU时间,该平台不支持时以进程运行的时间代替
+func cpuTime() time.Duration {
+	return time.Since(processStart)
+}
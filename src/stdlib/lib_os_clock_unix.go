@@ -0,0 +1,17 @@
+//go:build unix
+
+package stdlib
+
+import (
+	"syscall"
+	"time"
+)
+
+// 进程使用的CPU时间(用户态+内核态)
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return time.Since(processStart)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
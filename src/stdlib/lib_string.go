@@ -1,17 +1,37 @@
 package stdlib
 
 import (
+	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
 	"nskbz.cn/lua/api"
 )
 
 var stringFuncs map[string]api.GoFunc = map[string]api.GoFunc{
-	"len":   stringLen,
-	"upper": stringUpper,
-	"lower": stringLower,
+	"len":      stringLen,
+	"upper":    stringUpper,
+	"lower":    stringLower,
+	"sub":      stringSub,
+	"rep":      stringRep,
+	"reverse":  stringReverse,
+	"byte":     stringByte,
+	"char":     stringChar,
+	"format":   stringFormat,
+	"find":     stringFind,
+	"match":    stringMatch,
+	"gmatch":   stringGmatch,
+	"gsub":     stringGsub,
+	"pack":     stringPack,
+	"packsize": stringPackSize,
+	"unpack":   stringUnpack,
+	"dump":     stringDump,
 }
 
+// 结果字符串的最大长度
+const MAX_STRING_SIZE = math.MaxInt32
+
 func OpenStringLib(vm api.LuaVM) int {
 	vm.NewLib(stringFuncs)
 	//设置元表以支持 'string':len() 语法
@@ -25,6 +45,16 @@ func OpenStringLib(vm api.LuaVM) int {
 	return 1
 }
 
+// 将相对位置转换为绝对位置,负数表示从字符串末尾开始计数
+func posrelat(pos int64, l int) int64 {
+	if pos >= 0 {
+		return pos
+	} else if -pos > int64(l) {
+		return 0
+	}
+	return int64(l) + pos + 1
+}
+
 // string.len(s)
 // 用于获取字符串的字节长度（byte length）
 func stringLen(vm api.LuaVM) int {
@@ -47,3 +77,526 @@ func stringLower(vm api.LuaVM) int {
 	vm.PushString(strings.ToLower(str))
 	return 1
 }
+
+// string.sub(s, i [, j])
+// 返回s中从i到j的子串,i和j可以为负数
+func stringSub(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	l := len(s)
+	start := posrelat(vm.CheckInteger(2), l)
+	end := posrelat(vm.OptInteger(3, -1), l)
+	if start < 1 {
+		start = 1
+	}
+	if end > int64(l) {
+		end = int64(l)
+	}
+	if start <= end {
+		vm.PushString(s[start-1 : end])
+	} else {
+		vm.PushString("")
+	}
+	return 1
+}
+
+// string.dump(function [, strip])
+// 返回函数的二进制chunk,可以通过load重新加载;strip为true时不包含调试信息
+// Go函数无法序列化
+func stringDump(vm api.LuaVM) int {
+	vm.CheckType(1, api.LUAVALUE_FUNCTION)
+	strip := !vm.IsNone(2) && vm.ToBoolean(2)
+	vm.SetTop(1)
+	chunk, ok := vm.Dump(strip)
+	if !ok {
+		vm.Error2("unable to dump given function")
+	}
+	vm.PushString(string(chunk))
+	return 1
+}
+
+// string.rep(s, n [, sep])
+// 返回n个s以sep分隔拼接而成的字符串
+func stringRep(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	n := vm.CheckInteger(2)
+	sep := vm.OptString(3, "")
+	if n <= 0 {
+		vm.PushString("")
+		return 1
+	}
+	if int64(len(s)+len(sep)) > MAX_STRING_SIZE/n {
+		vm.Error2("resulting string too large")
+	}
+	var b strings.Builder
+	b.Grow(int(n)*len(s) + int(n-1)*len(sep))
+	for i := int64(0); i < n; i++ {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(s)
+	}
+	vm.PushString(b.String())
+	return 1
+}
+
+// string.reverse(s)
+func stringReverse(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	bs := []byte(s)
+	for i, j := 0, len(bs)-1; i < j; i, j = i+1, j-1 {
+		bs[i], bs[j] = bs[j], bs[i]
+	}
+	vm.PushString(string(bs))
+	return 1
+}
+
+// string.byte(s [, i [, j]])
+// 返回s[i],...,s[j]的字节值,i默认为1,j默认为i
+func stringByte(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	l := len(s)
+	posi := posrelat(vm.OptInteger(2, 1), l)
+	pose := posrelat(vm.OptInteger(3, posi), l)
+	if posi < 1 {
+		posi = 1
+	}
+	if pose > int64(l) {
+		pose = int64(l)
+	}
+	if posi > pose {
+		return 0
+	}
+	n := int(pose - posi + 1)
+	vm.CheckStack(n)
+	for i := 0; i < n; i++ {
+		vm.PushInteger(int64(s[int(posi)+i-1]))
+	}
+	return n
+}
+
+// string.char(...)
+// 将每个整数参数作为字节拼接成字符串
+func stringChar(vm api.LuaVM) int {
+	n := vm.GetTop()
+	bs := make([]byte, n)
+	for i := 1; i <= n; i++ {
+		c := vm.CheckInteger(i)
+		vm.ArgCheck(c >= 0 && c <= 255, i, "value out of range")
+		bs[i-1] = byte(c)
+	}
+	vm.PushString(string(bs))
+	return 1
+}
+
+/*
+*	string.format
+ */
+
+const FMT_FLAGS = "-+ #0"
+
+// string.format(formatstring, ...)
+// 格式化规则与C的sprintf一致,额外支持%q
+func stringFormat(vm api.LuaVM) int {
+	format := vm.CheckString(1)
+	top := vm.GetTop()
+	arg := 1
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != L_ESC {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(format) {
+			vm.Error2("invalid conversion '%%' to 'format'")
+		}
+		if format[i] == L_ESC {
+			b.WriteByte(L_ESC)
+			continue
+		}
+		arg++
+		if arg > top {
+			vm.ArgError(arg, "no value")
+		}
+		spec, end := scanFormat(vm, format, i)
+		i = end
+		b.WriteString(formatOne(vm, arg, spec, format[end]))
+	}
+	vm.PushString(b.String())
+	return 1
+}
+
+// 读取一个格式说明(不包括'%'和转换字符),返回说明和转换字符的位置
+func scanFormat(vm api.LuaVM, format string, i int) (string, int) {
+	start := i
+	for i < len(format) && strings.IndexByte(FMT_FLAGS, format[i]) >= 0 {
+		i++
+	}
+	if i-start >= len(FMT_FLAGS)+1 {
+		vm.Error2("invalid format (repeated flags)")
+	}
+	//宽度和精度最多两位数字
+	for n := 0; n < 2 && i < len(format) && isDigit(format[i]); n++ {
+		i++
+	}
+	if i < len(format) && format[i] == '.' {
+		i++
+		for n := 0; n < 2 && i < len(format) && isDigit(format[i]); n++ {
+			i++
+		}
+	}
+	if i >= len(format) || isDigit(format[i]) {
+		vm.Error2("invalid format (width or precision too long)")
+	}
+	return format[start:i], i
+}
+
+func formatOne(vm api.LuaVM, arg int, spec string, conv byte) string {
+	switch conv {
+	case 'c':
+		return formatString(spec, string([]byte{byte(vm.CheckInteger(arg))})) //Go的%c会按UTF-8编码大于127的值
+	case 'd', 'i':
+		return fmt.Sprintf("%"+spec+"d", checkFormatInteger(vm, arg))
+	case 'u':
+		return fmt.Sprintf("%"+spec+"d", uint64(checkFormatInteger(vm, arg)))
+	case 'o', 'x', 'X':
+		//C中以无符号数处理
+		return fmt.Sprintf("%"+spec+string(conv), uint64(checkFormatInteger(vm, arg)))
+	case 'a', 'A':
+		return formatHexFloat(spec, conv, vm.CheckFloat(arg))
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		return formatFloat(spec, conv, vm.CheckFloat(arg))
+	case 'q':
+		return addQuoted(vm, arg)
+	case 's':
+		s := vm.ToString2(arg)
+		vm.Pop(1)
+		if spec != "" { //与C的sprintf一致,带有宽度或精度时字符串中不能有'\0'
+			vm.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+		}
+		return formatString(spec, s)
+	default:
+		vm.Error2("invalid option '%%%c' to 'format'", conv)
+	}
+	return ""
+}
+
+func checkFormatInteger(vm api.LuaVM, arg int) int64 {
+	if n, ok := vm.ToIntegerX(arg); ok {
+		return n
+	}
+	if _, ok := vm.ToFloatX(arg); ok {
+		vm.ArgError(arg, "number has no integer representation")
+	}
+	return vm.CheckInteger(arg)
+}
+
+func formatFloat(spec string, conv byte, f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		//C输出inf/nan,Go输出+Inf/NaN
+		s := "inf"
+		if math.IsNaN(f) {
+			s = "nan"
+		} else if f < 0 {
+			s = "-inf"
+		} else if strings.IndexByte(spec, '+') >= 0 {
+			s = "+inf"
+		}
+		if conv >= 'A' && conv <= 'Z' {
+			s = strings.ToUpper(s)
+		}
+		return formatString(strings.ReplaceAll(spec, "0", ""), s)
+	}
+	if conv == 'F' {
+		conv = 'f'
+	}
+	//Go的%g在未指定精度时使用最短表示,C默认精度为6
+	if strings.IndexByte(spec, '.') < 0 {
+		spec += ".6"
+	}
+	return fmt.Sprintf("%"+spec+string(conv), f)
+}
+
+// %a: Go的十六进制浮点指数至少两位,C不补0
+func formatHexFloat(spec string, conv byte, f float64) string {
+	verb := "x"
+	if conv == 'A' {
+		verb = "X"
+	}
+	s := fmt.Sprintf("%"+spec+verb, f)
+	for _, sign := range []string{"p+0", "p-0", "P+0", "P-0"} {
+		if i := strings.Index(s, sign); i >= 0 && i+3 < len(s) {
+			s = s[:i+2] + s[i+3:]
+			break
+		}
+	}
+	return s
+}
+
+// %s: 宽度与精度按字节计算
+func formatString(spec string, s string) string {
+	left := strings.IndexByte(spec, '-') >= 0
+	spec = strings.TrimLeft(spec, FMT_FLAGS)
+	width, prec := 0, -1
+	if i := strings.IndexByte(spec, '.'); i >= 0 {
+		prec, _ = strconv.Atoi(spec[i+1:])
+		spec = spec[:i]
+	}
+	if spec != "" {
+		width, _ = strconv.Atoi(spec)
+	}
+	if prec >= 0 && prec < len(s) {
+		s = s[:prec]
+	}
+	if pad := width - len(s); pad > 0 {
+		if left {
+			return s + strings.Repeat(" ", pad)
+		}
+		return strings.Repeat(" ", pad) + s
+	}
+	return s
+}
+
+// %q: 生成可被Lua安全读回的字面量
+func addQuoted(vm api.LuaVM, arg int) string {
+	switch vm.Type(arg) {
+	case api.LUAVALUE_STRING:
+		s := vm.ToString(arg)
+		var b strings.Builder
+		b.WriteByte('"')
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			switch {
+			case c == '"' || c == '\\' || c == '\n':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case c == '\r':
+				b.WriteString("\\r")
+			case c == 0 || isCntrl(c):
+				if i+1 < len(s) && isDigit(s[i+1]) {
+					fmt.Fprintf(&b, "\\%03d", c)
+				} else {
+					fmt.Fprintf(&b, "\\%d", c)
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+		return b.String()
+	case api.LUAVALUE_NUMBER:
+		if vm.IsIntegerSubtype(arg) {
+			n := vm.ToInteger(arg)
+			if n == math.MinInt64 { //避免读回时被当作浮点数
+				return "0x8000000000000000"
+			}
+			return strconv.FormatInt(n, 10)
+		}
+		f := vm.ToFloat(arg)
+		switch {
+		case math.IsInf(f, 1):
+			return "1e9999"
+		case math.IsInf(f, -1):
+			return "-1e9999"
+		case math.IsNaN(f):
+			return "(0/0)"
+		case f == math.Floor(f):
+			return fmt.Sprintf("%.0f.0", f) //保留浮点类型
+		}
+		return formatHexFloat("", 'a', f)
+	case api.LUAVALUE_NIL, api.LUAVALUE_BOOLEAN:
+		s := vm.ToString2(arg)
+		vm.Pop(1)
+		return s
+	default:
+		vm.ArgError(arg, "value has no literal form")
+	}
+	return ""
+}
+
+/*
+*	模式匹配相关函数
+ */
+
+// string.find(s, pattern [, init [, plain]])
+func stringFind(vm api.LuaVM) int {
+	return strFindAux(vm, true)
+}
+
+// string.match(s, pattern [, init])
+func stringMatch(vm api.LuaVM) int {
+	return strFindAux(vm, false)
+}
+
+// 模式中是否不含特殊字符
+func noSpecials(pat string) bool {
+	return !strings.ContainsAny(pat, SPECIALS)
+}
+
+func strFindAux(vm api.LuaVM, find bool) int {
+	s := vm.CheckString(1)
+	p := vm.CheckString(2)
+	init := posrelat(vm.OptInteger(3, 1), len(s))
+	if init < 1 {
+		init = 1
+	}
+	if init > int64(len(s))+1 { //起始位置超出字符串
+		vm.PushNil()
+		return 1
+	}
+	//显式要求普通查找或模式中没有特殊字符
+	if find && ((!vm.IsNoneOrNil(4) && vm.ToBoolean(4)) || noSpecials(p)) {
+		if idx := strings.Index(s[init-1:], p); idx >= 0 {
+			start := int(init) + idx
+			vm.PushInteger(int64(start))
+			vm.PushInteger(int64(start + len(p) - 1))
+			return 2
+		}
+	} else {
+		ms := newMatchState(vm, s, p)
+		anchor := len(p) > 0 && p[0] == '^'
+		pat := 0
+		if anchor {
+			pat = 1
+		}
+		for s1 := int(init) - 1; ; s1++ {
+			ms.reprepState()
+			if e := ms.doMatch(s1, pat); e != -1 {
+				if find {
+					vm.PushInteger(int64(s1) + 1)
+					vm.PushInteger(int64(e))
+					return ms.pushCaptures(-1, -1, false) + 2
+				}
+				return ms.pushCaptures(s1, e, true)
+			}
+			if s1 >= len(s) || anchor {
+				break
+			}
+		}
+	}
+	vm.PushNil()
+	return 1
+}
+
+// string.gmatch(s, pattern)
+// 返回一个迭代器,每次调用返回下一个匹配的捕获
+func stringGmatch(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	p := vm.CheckString(2)
+	ms := newMatchState(vm, s, p)
+	pos, lastMatch := 0, -1
+	vm.PushGoFunction(func(vm api.LuaVM) int {
+		ms.vm = vm
+		for src := pos; src <= len(s); src++ {
+			ms.reprepState()
+			//不允许在上次匹配结束的位置再次匹配空串
+			if e := ms.doMatch(src, 0); e != -1 && e != lastMatch {
+				pos, lastMatch = e, e
+				return ms.pushCaptures(src, e, true)
+			}
+		}
+		return 0
+	}, 0)
+	return 1
+}
+
+// string.gsub(s, pattern, repl [, n])
+// repl可以为字符串、表或函数,返回替换后的字符串以及替换次数
+func stringGsub(vm api.LuaVM) int {
+	src := vm.CheckString(1)
+	p := vm.CheckString(2)
+	vm.CheckAny(3)
+	tr := vm.Type(3)
+	maxN := vm.OptInteger(4, int64(len(src))+1)
+	vm.ArgCheck(tr == api.LUAVALUE_NUMBER || tr == api.LUAVALUE_STRING ||
+		tr == api.LUAVALUE_FUNCTION || tr == api.LUAVALUE_TABLE, 3,
+		"string/function/table expected")
+
+	anchor := len(p) > 0 && p[0] == '^'
+	pat := 0
+	if anchor {
+		pat = 1
+	}
+	ms := newMatchState(vm, src, p)
+	var b strings.Builder
+	s, lastMatch := 0, -1
+	n := int64(0)
+	for n < maxN {
+		ms.reprepState()
+		if e := ms.doMatch(s, pat); e != -1 && e != lastMatch {
+			n++
+			ms.addValue(&b, s, e, tr)
+			s, lastMatch = e, e
+		} else if s < len(src) {
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+	vm.PushString(b.String())
+	vm.PushInteger(n)
+	return 2
+}
+
+// 将[s,e)匹配的替换值写入b
+func (ms *matchState) addValue(b *strings.Builder, s, e int, tr api.LuaValueType) {
+	vm := ms.vm
+	switch tr {
+	case api.LUAVALUE_FUNCTION:
+		vm.PushValue(3)
+		n := ms.pushCaptures(s, e, true)
+		vm.Call(n, 1)
+	case api.LUAVALUE_TABLE:
+		ms.pushOneCapture(0, s, e)
+		vm.GetTable(3)
+	default:
+		ms.addString(b, s, e)
+		return
+	}
+	if !vm.ToBoolean(0) { //nil或false则保留原匹配
+		vm.Pop(1)
+		b.WriteString(ms.src[s:e])
+		return
+	}
+	if !vm.IsString(0) {
+		vm.Error2("invalid replacement value (a %s)", vm.TypeName2(0))
+	}
+	b.WriteString(vm.ToString(0))
+	vm.Pop(1)
+}
+
+// 处理替换字符串中的%0-%9和%%
+func (ms *matchState) addString(b *strings.Builder, s, e int) {
+	vm := ms.vm
+	repl := vm.ToString(3)
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		if c != L_ESC {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(repl) {
+			vm.Error2("invalid use of '%%' in replacement string")
+		}
+		c = repl[i]
+		switch {
+		case c == L_ESC:
+			b.WriteByte(c)
+		case c == '0':
+			b.WriteString(ms.src[s:e])
+		case isDigit(c):
+			ms.pushOneCapture(int(c-'1'), s, e)
+			b.WriteString(vm.ToString2(0))
+			vm.Pop(2) //ToString2压入的结果与捕获值
+		default:
+			vm.Error2("invalid use of '%%' in replacement string")
+		}
+	}
+}
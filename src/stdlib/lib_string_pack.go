@@ -0,0 +1,367 @@
+package stdlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"nskbz.cn/lua/api"
+)
+
+/*
+*	string.pack/string.unpack/string.packsize,移植自官方lstrlib.c
+*
+*	<	小端序			>	大端序			=	本地字节序(小端)
+*	![n]	设置最大对齐为n(默认为本地对齐)
+*	b/B	有/无符号char	h/H	有/无符号short	l/L	有/无符号long
+*	j/J	lua_Integer/lua_Unsigned		T	size_t
+*	i[n]/I[n]	n字节的有/无符号int(默认为int的大小)
+*	f	float			d	double			n	lua_Number
+*	s[n]	以n字节整数记录长度的字符串(默认为size_t)
+*	z	以'\0'结尾的字符串	x	1字节填充		c[n]	n字节的定长字符串
+*	Xop	按op的大小对齐	' '	忽略
+ */
+
+const (
+	MAXINTSIZE = 16 //i[n]/I[n]的最大字节数
+	NB         = 8  //一个字节的位数
+	SZINT      = 8  //lua_Integer的字节数
+	MAXALIGN   = 8  //本地最大对齐
+	MAXSIZE    = math.MaxInt32
+)
+
+const LUAL_PACKPADBYTE = 0x00 //填充字节
+
+type kOption int
+
+const (
+	kInt       kOption = iota //有符号整数
+	kUint                     //无符号整数
+	kFloat                    //浮点数
+	kChar                     //定长字符串
+	kString                   //带长度前缀的字符串
+	kZstr                     //以'\0'结尾的字符串
+	kPadding                  //填充
+	kPaddAlign                //对齐
+	kNop                      //无操作
+)
+
+// 格式串的解析状态
+type packHeader struct {
+	vm       api.LuaVM
+	fmt      string
+	pos      int  //当前读取到的fmt位置
+	little   bool //是否小端序
+	maxAlign int
+}
+
+func newPackHeader(vm api.LuaVM, fmt string) *packHeader {
+	return &packHeader{vm: vm, fmt: fmt, little: true, maxAlign: 1}
+}
+
+func (h *packHeader) hasMore() bool {
+	return h.pos < len(h.fmt)
+}
+
+// 读取可选的数字,不存在则返回df
+func (h *packHeader) getNum(df int) int {
+	if !h.hasMore() || !isDigit(h.fmt[h.pos]) {
+		return df
+	}
+	a := 0
+	for h.hasMore() && isDigit(h.fmt[h.pos]) && a <= (MAXSIZE-9)/10 {
+		a = a*10 + int(h.fmt[h.pos]-'0')
+		h.pos++
+	}
+	return a
+}
+
+func (h *packHeader) getNumLimit(df int) int {
+	sz := h.getNum(df)
+	if sz > MAXINTSIZE || sz <= 0 {
+		h.vm.ArgError(1, fmt.Sprintf("integral size (%d) out of limits [1,%d]", sz, MAXINTSIZE))
+	}
+	return sz
+}
+
+// 读取一个选项,返回选项类型及其大小
+func (h *packHeader) getOption() (kOption, int) {
+	opt := h.fmt[h.pos]
+	h.pos++
+	switch opt {
+	case 'b':
+		return kInt, 1
+	case 'B':
+		return kUint, 1
+	case 'h':
+		return kInt, 2
+	case 'H':
+		return kUint, 2
+	case 'l', 'j':
+		return kInt, 8
+	case 'L', 'J', 'T':
+		return kUint, 8
+	case 'f':
+		return kFloat, 4
+	case 'd', 'n':
+		return kFloat, 8
+	case 'i':
+		return kInt, h.getNumLimit(4)
+	case 'I':
+		return kUint, h.getNumLimit(4)
+	case 's':
+		return kString, h.getNumLimit(8)
+	case 'c':
+		size := h.getNum(-1)
+		if size == -1 {
+			h.vm.ArgError(1, "missing size for format option 'c'")
+		}
+		return kChar, size
+	case 'z':
+		return kZstr, 0
+	case 'x':
+		return kPadding, 1
+	case 'X':
+		return kPaddAlign, 0
+	case ' ':
+	case '<':
+		h.little = true
+	case '>':
+		h.little = false
+	case '=':
+		h.little = true //本地字节序为小端
+	case '!':
+		h.maxAlign = h.getNumLimit(MAXALIGN)
+	default:
+		h.vm.ArgError(1, fmt.Sprintf("invalid format option '%c'", opt))
+	}
+	return kNop, 0
+}
+
+// 读取一个选项并计算其前需要填充的对齐字节数
+func (h *packHeader) getDetails(totalSize int) (opt kOption, size, nToAlign int) {
+	opt, size = h.getOption()
+	align := size //通常对齐大小即为选项大小
+	if opt == kPaddAlign {
+		if !h.hasMore() {
+			h.vm.ArgError(1, "invalid next option for option 'X'")
+		}
+		var o kOption
+		o, align = h.getOption()
+		if o == kChar || align == 0 {
+			h.vm.ArgError(1, "invalid next option for option 'X'")
+		}
+	}
+	if align <= 1 || opt == kChar {
+		return opt, size, 0
+	}
+	if align > h.maxAlign {
+		align = h.maxAlign
+	}
+	if align&(align-1) != 0 {
+		h.vm.ArgError(1, "format asks for alignment not power of 2")
+	}
+	nToAlign = (align - (totalSize & (align - 1))) & (align - 1)
+	return opt, size, nToAlign
+}
+
+// 按字节序写入size字节的整数,超过8字节的部分根据符号填充
+func packInt(b *strings.Builder, n uint64, little bool, size int, neg bool) {
+	buf := make([]byte, size)
+	for i := 0; i < size; i++ {
+		var c byte
+		if i < SZINT {
+			c = byte(n >> (i * NB))
+		} else if neg {
+			c = 0xFF
+		}
+		if little {
+			buf[i] = c
+		} else {
+			buf[size-1-i] = c
+		}
+	}
+	b.Write(buf)
+}
+
+func unpackInt(vm api.LuaVM, s string, little bool, size int, signed bool) int64 {
+	var res uint64
+	limit := size
+	if limit > SZINT {
+		limit = SZINT
+	}
+	byteAt := func(i int) byte {
+		if little {
+			return s[i]
+		}
+		return s[size-1-i]
+	}
+	for i := limit - 1; i >= 0; i-- {
+		res = res<<NB | uint64(byteAt(i))
+	}
+	if size < SZINT {
+		if signed { //符号扩展
+			mask := uint64(1) << (size*NB - 1)
+			res = (res ^ mask) - mask
+		}
+	} else if size > SZINT { //多出的字节必须全为0或0xFF(负数)
+		var mask byte
+		if signed && int64(res) < 0 {
+			mask = 0xFF
+		}
+		for i := limit; i < size; i++ {
+			if byteAt(i) != mask {
+				vm.Error2("%d-byte integer does not fit into Lua Integer", size)
+			}
+		}
+	}
+	return int64(res)
+}
+
+func packFloat(b *strings.Builder, f float64, little bool, size int) {
+	var order binary.ByteOrder = binary.BigEndian
+	if little {
+		order = binary.LittleEndian
+	}
+	buf := make([]byte, size)
+	if size == 4 {
+		order.PutUint32(buf, math.Float32bits(float32(f)))
+	} else {
+		order.PutUint64(buf, math.Float64bits(f))
+	}
+	b.Write(buf)
+}
+
+func unpackFloat(s string, little bool, size int) float64 {
+	var order binary.ByteOrder = binary.BigEndian
+	if little {
+		order = binary.LittleEndian
+	}
+	if size == 4 {
+		return float64(math.Float32frombits(order.Uint32([]byte(s[:4]))))
+	}
+	return math.Float64frombits(order.Uint64([]byte(s[:8])))
+}
+
+// string.pack(fmt, v1, v2, ...)
+// 按fmt将参数序列化为二进制字符串
+func stringPack(vm api.LuaVM) int {
+	h := newPackHeader(vm, vm.CheckString(1))
+	var b strings.Builder
+	arg := 1
+	totalSize := 0
+	for h.hasMore() {
+		opt, size, nToAlign := h.getDetails(totalSize)
+		totalSize += nToAlign + size
+		for ; nToAlign > 0; nToAlign-- {
+			b.WriteByte(LUAL_PACKPADBYTE)
+		}
+		arg++
+		switch opt {
+		case kInt:
+			n := vm.CheckInteger(arg)
+			if size < SZINT {
+				lim := int64(1) << (size*NB - 1)
+				vm.ArgCheck(-lim <= n && n < lim, arg, "integer overflow")
+			}
+			packInt(&b, uint64(n), h.little, size, n < 0)
+		case kUint:
+			n := vm.CheckInteger(arg)
+			if size < SZINT {
+				vm.ArgCheck(uint64(n) < uint64(1)<<(size*NB), arg, "unsigned overflow")
+			}
+			packInt(&b, uint64(n), h.little, size, false)
+		case kFloat:
+			packFloat(&b, vm.CheckFloat(arg), h.little, size)
+		case kChar:
+			s := vm.CheckString(arg)
+			vm.ArgCheck(len(s) <= size, arg, "string longer than given size")
+			b.WriteString(s)
+			for i := len(s); i < size; i++ {
+				b.WriteByte(LUAL_PACKPADBYTE)
+			}
+		case kString:
+			s := vm.CheckString(arg)
+			vm.ArgCheck(size >= SZINT || uint64(len(s)) < uint64(1)<<(size*NB), arg,
+				"string length does not fit in given size")
+			packInt(&b, uint64(len(s)), h.little, size, false)
+			b.WriteString(s)
+			totalSize += len(s)
+		case kZstr:
+			s := vm.CheckString(arg)
+			vm.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+			b.WriteString(s)
+			b.WriteByte(0)
+			totalSize += len(s) + 1
+		case kPadding:
+			b.WriteByte(LUAL_PACKPADBYTE)
+			arg--
+		case kPaddAlign, kNop:
+			arg-- //不消耗参数
+		}
+	}
+	vm.PushString(b.String())
+	return 1
+}
+
+// string.packsize(fmt)
+// 返回按fmt序列化的字符串长度,fmt中不能包含变长选项
+func stringPackSize(vm api.LuaVM) int {
+	h := newPackHeader(vm, vm.CheckString(1))
+	totalSize := 0
+	for h.hasMore() {
+		opt, size, nToAlign := h.getDetails(totalSize)
+		vm.ArgCheck(opt != kString && opt != kZstr, 1, "variable-size format in packsize")
+		size += nToAlign
+		vm.ArgCheck(totalSize <= MAXSIZE-size, 1, "format result too large")
+		totalSize += size
+	}
+	vm.PushInteger(int64(totalSize))
+	return 1
+}
+
+// string.unpack(fmt, s [, pos])
+// 按fmt从s的pos位置开始反序列化,最后额外返回下一个未读取字节的位置
+func stringUnpack(vm api.LuaVM) int {
+	h := newPackHeader(vm, vm.CheckString(1))
+	data := vm.CheckString(2)
+	ld := len(data)
+	pos := int(posrelat(vm.OptInteger(3, 1), ld)) - 1
+	vm.ArgCheck(pos >= 0 && pos <= ld, 3, "initial position out of string")
+	n := 0
+	for h.hasMore() {
+		opt, size, nToAlign := h.getDetails(pos)
+		if nToAlign+size > ld-pos {
+			vm.ArgError(2, "data string too short")
+		}
+		pos += nToAlign
+		vm.CheckStack(2)
+		n++
+		switch opt {
+		case kInt, kUint:
+			vm.PushInteger(unpackInt(vm, data[pos:], h.little, size, opt == kInt))
+		case kFloat:
+			vm.PushFloat(unpackFloat(data[pos:], h.little, size))
+		case kChar:
+			vm.PushString(data[pos : pos+size])
+		case kString:
+			l := uint64(unpackInt(vm, data[pos:], h.little, size, false))
+			vm.ArgCheck(l <= uint64(ld-pos-size), 2, "data string too short")
+			vm.PushString(data[pos+size : pos+size+int(l)])
+			pos += int(l)
+		case kZstr:
+			l := strings.IndexByte(data[pos:], 0)
+			if l < 0 {
+				vm.ArgError(2, "unfinished string for format 'z'")
+			}
+			vm.PushString(data[pos : pos+l])
+			pos += l + 1
+		case kPaddAlign, kPadding, kNop:
+			n-- //不产生返回值
+		}
+		pos += size
+	}
+	vm.PushInteger(int64(pos) + 1)
+	return n + 1
+}
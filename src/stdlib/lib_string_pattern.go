@@ -0,0 +1,413 @@
+package stdlib
+
+import (
+	"nskbz.cn/lua/api"
+)
+
+/*
+*	Lua模式匹配,移植自官方lstrlib.c
+*
+*	.	任意字符
+*	%a	字母			%c	控制字符		%d	数字			%g	除空白外的可打印字符
+*	%l	小写字母		%p	标点			%s	空白			%u	大写字母
+*	%w	字母和数字		%x	十六进制数字	%X	(大写表示取补集)
+*	[set]	字符集		[^set]	字符集的补集
+*	*	0或多次(最长)	+	1或多次(最长)	-	0或多次(最短)	?	0或1次
+*	%n	第n个捕获		%bxy	平衡匹配		%f[set]	边界匹配
+*	()	位置捕获		^	锚定开头		$	锚定结尾
+ */
+
+const LUA_MAXCAPTURES = 32
+const MAXCCALLS = 200 //模式匹配的最大递归深度
+
+const L_ESC = '%'
+const SPECIALS = "^$*+?.([%-"
+
+const (
+	CAP_UNFINISHED = -1 //捕获尚未结束
+	CAP_POSITION   = -2 //位置捕获
+)
+
+type capture struct {
+	init int //捕获在src中的起始位置
+	len  int //捕获长度,或CAP_UNFINISHED|CAP_POSITION
+}
+
+type matchState struct {
+	vm         api.LuaVM
+	src        string
+	pat        string
+	matchdepth int //剩余递归深度
+	level      int //捕获的总数(包括已结束与未结束的)
+	capture    [LUA_MAXCAPTURES]capture
+}
+
+func newMatchState(vm api.LuaVM, src, pat string) *matchState {
+	return &matchState{vm: vm, src: src, pat: pat, matchdepth: MAXCCALLS}
+}
+
+func (ms *matchState) reprepState() {
+	ms.level = 0
+	ms.matchdepth = MAXCCALLS
+}
+
+func (ms *matchState) checkCapture(l byte) int {
+	i := int(l) - '1'
+	if i < 0 || i >= ms.level || ms.capture[i].len == CAP_UNFINISHED {
+		ms.vm.Error2("invalid capture index %%%d", i+1)
+	}
+	return i
+}
+
+func (ms *matchState) captureToClose() int {
+	level := ms.level - 1
+	for ; level >= 0; level-- {
+		if ms.capture[level].len == CAP_UNFINISHED {
+			return level
+		}
+	}
+	ms.vm.Error2("invalid pattern capture")
+	return 0
+}
+
+// 返回p处单个字符类的结束位置
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	if c == L_ESC {
+		if p >= len(ms.pat) {
+			ms.vm.Error2("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+	if c == '[' {
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		for { //查找']'
+			if p >= len(ms.pat) {
+				ms.vm.Error2("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == L_ESC && p < len(ms.pat) {
+				p++ //跳过转义字符,例如'%]'
+			}
+			if p < len(ms.pat) && ms.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+func isAlpha(c byte) bool  { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+func isLower(c byte) bool  { return c >= 'a' && c <= 'z' }
+func isUpper(c byte) bool  { return c >= 'A' && c <= 'Z' }
+func isSpace(c byte) bool  { return c == ' ' || c >= '\t' && c <= '\r' }
+func isCntrl(c byte) bool  { return c < 0x20 || c == 0x7F }
+func isGraph(c byte) bool  { return c > 0x20 && c < 0x7F }
+func isPunct(c byte) bool  { return isGraph(c) && !isAlpha(c) && !isDigit(c) }
+func isAlnum(c byte) bool  { return isAlpha(c) || isDigit(c) }
+func isXDigit(c byte) bool { return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' }
+
+func toLower(c byte) byte {
+	if isUpper(c) {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+// 判断字符c是否属于%cl所表示的字符类
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch toLower(cl) {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = isCntrl(c)
+	case 'd':
+		res = isDigit(c)
+	case 'g':
+		res = isGraph(c)
+	case 'l':
+		res = isLower(c)
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = isSpace(c)
+	case 'u':
+		res = isUpper(c)
+	case 'w':
+		res = isAlnum(c)
+	case 'x':
+		res = isXDigit(c)
+	default:
+		return cl == c
+	}
+	if isUpper(cl) {
+		return !res
+	}
+	return res
+}
+
+// p指向'[',ec指向']'
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++ //跳过'^'
+	}
+	for p++; p < ec; p++ {
+		if ms.pat[p] == L_ESC {
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		} else if p+2 < ec && ms.pat[p+1] == '-' {
+			if ms.pat[p] <= c && c <= ms.pat[p+2] {
+				return sig
+			}
+			p += 2
+		} else if ms.pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+// 判断src[s]是否与[p,ep)表示的单个字符类匹配
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case L_ESC:
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+// 匹配成功返回匹配结束的位置,失败返回-1
+func (ms *matchState) doMatch(s, p int) int {
+	ms.matchdepth--
+	if ms.matchdepth == 0 {
+		ms.vm.Error2("pattern too complex")
+	}
+	defer func() { ms.matchdepth++ }()
+
+	for p < len(ms.pat) {
+		switch ms.pat[p] {
+		case '(': //开始捕获
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, CAP_POSITION)
+			}
+			return ms.startCapture(s, p+1, CAP_UNFINISHED)
+		case ')': //结束捕获
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) { //'$'为模式的最后一个字符才表示锚定结尾
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case L_ESC:
+			if p+1 < len(ms.pat) {
+				switch ms.pat[p+1] {
+				case 'b': //平衡匹配%bxy
+					s = ms.matchBalance(s, p+2)
+					if s != -1 {
+						p += 4
+						continue
+					}
+					return -1
+				case 'f': //边界匹配%f[set]
+					p += 2
+					if p >= len(ms.pat) || ms.pat[p] != '[' {
+						ms.vm.Error2("missing '[' after '%%f' in pattern")
+					}
+					ep := ms.classEnd(p)
+					var prev, cur byte
+					if s > 0 {
+						prev = ms.src[s-1]
+					}
+					if s < len(ms.src) {
+						cur = ms.src[s]
+					}
+					if !ms.matchBracketClass(prev, p, ep-1) && ms.matchBracketClass(cur, p, ep-1) {
+						p = ep
+						continue
+					}
+					return -1
+				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': //反向引用%n
+					s = ms.matchCapture(s, ms.pat[p+1])
+					if s != -1 {
+						p += 2
+						continue
+					}
+					return -1
+				}
+			}
+		}
+
+		//默认情况:单个字符类加可选的重复符
+		ep := ms.classEnd(p)
+		if !ms.singleMatch(s, p, ep) {
+			if ep < len(ms.pat) && (ms.pat[ep] == '*' || ms.pat[ep] == '?' || ms.pat[ep] == '-') {
+				p = ep + 1 //允许0次匹配
+				continue
+			}
+			return -1
+		}
+		if ep < len(ms.pat) {
+			switch ms.pat[ep] {
+			case '?':
+				if res := ms.doMatch(s+1, ep+1); res != -1 {
+					return res
+				}
+				p = ep + 1
+				continue
+			case '+':
+				return ms.maxExpand(s+1, p, ep)
+			case '*':
+				return ms.maxExpand(s, p, ep)
+			case '-':
+				return ms.minExpand(s, p, ep)
+			}
+		}
+		s++
+		p = ep
+	}
+	return s
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.vm.Error2("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		if ms.src[s] == e {
+			cont--
+			if cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	//尽可能多地匹配,失败则逐个回退
+	for i >= 0 {
+		if res := ms.doMatch(s+i, ep+1); res != -1 {
+			return res
+		}
+		i--
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.doMatch(s, ep+1); res != -1 {
+			return res
+		} else if ms.singleMatch(s, p, ep) {
+			s++ //多匹配一个字符再尝试
+		} else {
+			return -1
+		}
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= LUA_MAXCAPTURES {
+		ms.vm.Error2("too many captures")
+	}
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	res := ms.doMatch(s, p)
+	if res == -1 {
+		ms.level-- //匹配失败则撤销捕获
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init
+	res := ms.doMatch(s, p)
+	if res == -1 {
+		ms.capture[l].len = CAP_UNFINISHED
+	}
+	return res
+}
+
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := ms.checkCapture(l)
+	cap := ms.src[ms.capture[i].init : ms.capture[i].init+ms.capture[i].len]
+	if len(ms.src)-s >= len(cap) && ms.src[s:s+len(cap)] == cap {
+		return s + len(cap)
+	}
+	return -1
+}
+
+// 获取第i个捕获,s和e为整个匹配的范围
+func (ms *matchState) getOneCapture(i, s, e int) (str string, pos int64, isPos bool) {
+	if i >= ms.level {
+		if i != 0 {
+			ms.vm.Error2("invalid capture index %%%d", i+1)
+		}
+		return ms.src[s:e], 0, false //没有捕获则整个匹配作为捕获
+	}
+	l := ms.capture[i].len
+	if l == CAP_UNFINISHED {
+		ms.vm.Error2("unfinished capture")
+	}
+	if l == CAP_POSITION {
+		return "", int64(ms.capture[i].init) + 1, true
+	}
+	init := ms.capture[i].init
+	return ms.src[init : init+l], 0, false
+}
+
+func (ms *matchState) pushOneCapture(i, s, e int) {
+	str, pos, isPos := ms.getOneCapture(i, s, e)
+	if isPos {
+		ms.vm.PushInteger(pos)
+	} else {
+		ms.vm.PushString(str)
+	}
+}
+
+// 压入所有捕获,wholeIfNone为true时如果没有捕获则压入整个匹配
+func (ms *matchState) pushCaptures(s, e int, wholeIfNone bool) int {
+	n := ms.level
+	if n == 0 && wholeIfNone {
+		n = 1
+	}
+	ms.vm.CheckStack(n)
+	for i := 0; i < n; i++ {
+		ms.pushOneCapture(i, s, e)
+	}
+	return n
+}
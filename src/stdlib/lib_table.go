@@ -1,11 +1,10 @@
 package stdlib
 
 import (
-	"fmt"
+	"math"
 	"strings"
 
 	"nskbz.cn/lua/api"
-	"nskbz.cn/lua/tool"
 )
 
 var tableFuncs map[string]api.GoFunc = map[string]api.GoFunc{
@@ -18,6 +17,9 @@ var tableFuncs map[string]api.GoFunc = map[string]api.GoFunc{
 	"concat": tableConcat,
 }
 
+// table.unpack一次最多返回的值的个数,与官方LUAI_MAXSTACK一致
+const MAX_UNPACK = 1000000
+
 func OpenTableLib(vm api.LuaVM) int {
 	vm.NewLib(tableFuncs)
 	return 1
@@ -27,41 +29,42 @@ func OpenTableLib(vm api.LuaVM) int {
 // 在表 tbl 的 pos 位置插入 value（默认插入末尾），没有返回值
 func tableInsert(vm api.LuaVM) int {
 	vm.CheckType(1, api.LUAVALUE_TABLE)
-	nArgs := vm.GetTop()
-	tbLen := vm.Len2(1)
-	if nArgs == 3 {
-		if i, ok := vm.ToIntegerX(2); !ok {
-			return vm.Error2("expectd 2th arg is integer, but %s", vm.Type(2).String())
-		} else {
-			for j := tbLen; j >= i; j-- {
-				vm.GetI(1, j)
-				vm.SetI(1, j+1)
-			}
+	e := vm.Len2(1) + 1 //第一个空位
+	var pos int64
+	switch vm.GetTop() {
+	case 2:
+		pos = e //插入末尾
+	case 3:
+		pos = vm.CheckInteger(2)
+		vm.ArgCheck(uint64(pos)-1 < uint64(e), 2, "position out of bounds") //1<=pos<=e
+
+		for i := e; i > pos; i-- { //元素后移
+			vm.GetI(1, i-1)
 			vm.SetI(1, i)
-			return 0
 		}
-	} else if nArgs == 2 {
-		vm.SetI(1, tbLen+1)
-		return 0
+	default:
+		return vm.Error2("wrong number of arguments to 'insert'")
 	}
-	return vm.Error2("table insert error!")
+	vm.SetI(1, pos) //栈顶为value
+	return 0
 }
 
 // table.remove(tbl, [pos])
 // 移除表 tbl 中 pos 位置的元素（默认移除最后一个），返回删除的值
 func tableRemove(vm api.LuaVM) int {
 	vm.CheckType(1, api.LUAVALUE_TABLE)
-	nArgs := vm.GetTop()
-	tbLen := vm.Len2(1)
-	idx := tbLen
-	if nArgs == 2 {
-		if i, ok := vm.ToIntegerX(2); !ok {
-			return vm.Error2("expectd 2th arg is integer, but %s", vm.Type(2).String())
-		} else {
-			idx = i
-		}
+	size := vm.Len2(1)
+	pos := vm.OptInteger(2, size)
+	if pos != size { //pos可以为size+1,此时删除的是nil
+		vm.ArgCheck(uint64(pos)-1 <= uint64(size), 2, "position out of bounds")
 	}
-	vm.RemoveI(1, idx)
+	vm.GetI(1, pos) //删除的值
+	for ; pos < size; pos++ {
+		vm.GetI(1, pos+1)
+		vm.SetI(1, pos)
+	}
+	vm.PushNil()
+	vm.SetI(1, pos)
 	return 1
 }
 
@@ -76,7 +79,9 @@ func tableSort(vm api.LuaVM) int {
 			vm.PushBasic(i1)
 			vm.PushBasic(i2)
 			vm.Call(2, 1)
-			return vm.ToBoolean(0) //栈顶的返回值就是compare的比较结果
+			lt := vm.ToBoolean(0) //栈顶的返回值就是compare的比较结果
+			vm.Pop(1)
+			return lt
 		}
 	}
 	vm.SortI(1, compare)
@@ -87,42 +92,31 @@ func tableSort(vm api.LuaVM) int {
 // 将表 a1 的 [f, e] 范围元素复制到 a2 的 t 位置，a2未指定则默认a1
 // 返回目标表 a2（如果指定）或 a1（如果未指定 a2）
 func tableMove(vm api.LuaVM) int {
-	nArgs := vm.GetTop()
-	vm.CheckType(1, api.LUAVALUE_TABLE)
-	vm.CheckInteger(2)
-	vm.CheckInteger(3)
-	vm.CheckInteger(4)
-	src := 1
-	dest := src
-	f := vm.ToInteger(2)
-	e := vm.ToInteger(3)
-	t := vm.ToInteger(4)
-	if nArgs > 4 {
-		vm.CheckType(5, api.LUAVALUE_TABLE)
+	f := vm.CheckInteger(2)
+	e := vm.CheckInteger(3)
+	t := vm.CheckInteger(4)
+	dest := 1
+	if !vm.IsNoneOrNil(5) {
 		dest = 5
 	}
-
-	span := int(e - f + 1)
-
-	//先使dest表扩容以至于可以装载迁移的数据
-	for i := 0; i < span; i++ {
-		vm.PushString("move占位")
-		l := vm.RawLen(dest) //获取table._arr的长度
-		vm.SetI(dest, int64(l+1))
-	}
-	//如果是自生转移还需要将元素后挪
-	for i := t + int64(span); i <= int64(vm.RawLen(dest)); i++ {
-		vm.GetI(dest, i-int64(span))
-		vm.SetI(dest, i)
-	}
-
-	//挪动[f,e]区间内的元素至目的位置
-	for i, j := f, t; i <= e; i++ {
-		vm.GetI(src, i)
-		vm.SetI(dest, j)
-		j++
+	vm.CheckType(1, api.LUAVALUE_TABLE)
+	vm.CheckType(dest, api.LUAVALUE_TABLE)
+	if e >= f {
+		vm.ArgCheck(f > 0 || e < math.MaxInt64+f, 3, "too many elements to move")
+		n := e - f + 1 //移动的元素个数
+		vm.ArgCheck(t <= math.MaxInt64-n+1, 4, "destination wrap around")
+		if t > e || t <= f || (dest != 1 && !vm.Compare(1, dest, api.CompareOp_EQ)) {
+			for i := int64(0); i < n; i++ { //目标区间不与源区间的后半部分重叠,从前往后复制
+				vm.GetI(1, f+i)
+				vm.SetI(dest, t+i)
+			}
+		} else {
+			for i := n - 1; i >= 0; i-- { //同一个表中向后移动,从后往前复制以免覆盖
+				vm.GetI(1, f+i)
+				vm.SetI(dest, t+i)
+			}
+		}
 	}
-
 	vm.PushValue(dest) //返回目标表
 	return 1
 }
@@ -149,27 +143,28 @@ func tablePack(vm api.LuaVM) int {
 }
 
 // table.unpack(list [, i [, j]])
+// i默认为1,j默认为#list,遵循__index与__len元方法
 func tableUnpack(vm api.LuaVM) int {
-	nArgs := vm.GetTop()
-	vm.CheckType(1, api.LUAVALUE_TABLE)
-	start := 1
-	end := vm.RawLen(1)
-	if nArgs > 1 {
-		vm.CheckInteger(2)
-		vm.CheckInteger(3)
-		start = int(vm.ToInteger(2))
-		end = int(vm.ToInteger(3))
+	i := vm.OptInteger(2, 1)
+	var e int64
+	if vm.IsNoneOrNil(3) {
+		e = vm.Len2(1)
+	} else {
+		e = vm.CheckInteger(3)
 	}
-	n := end - start + 1
-	for start <= end {
-		vm.GetI(1, int64(start))
-		start++
+	if i > e { //空区间
+		return 0
 	}
-
-	if n > 0 {
-		return n
+	n := uint64(e) - uint64(i) //结果个数-1,使用无符号数避免溢出
+	if n >= MAX_UNPACK {
+		return vm.Error2("too many results to unpack")
 	}
-	return 0
+	vm.CheckStack(int(n) + 1)
+	for ; i < e; i++ {
+		vm.GetI(1, i)
+	}
+	vm.GetI(1, e)
+	return int(n) + 1
 }
 
 // table.concat(list [, sep] [, i [, j]])
@@ -182,37 +177,26 @@ func tableUnpack(vm api.LuaVM) int {
 //
 // 用于将数组(table)中的元素连接成字符串
 func tableConcat(vm api.LuaVM) int {
-	nArgs := vm.GetTop()
 	vm.CheckType(1, api.LUAVALUE_TABLE)
-	separator := " "
-	start := 1
-	end := vm.RawLen(1)
-	switch nArgs {
-	case 2, 4:
-		vm.CheckString(2)
-		separator = vm.ToString(2)
-		if nArgs == 4 {
-			vm.CheckInteger(3)
-			vm.CheckInteger(4)
-			start = int(vm.ToInteger(3))
-			end = int(vm.ToInteger(4))
-		}
-	}
-	n := end - start + 1
+	sep := vm.OptString(2, "")
+	i := vm.OptInteger(3, 1)
+	last := vm.OptInteger(4, vm.Len2(1))
 	sb := strings.Builder{}
-	for ; start <= end; start++ {
-		vm.GetI(1, int64(start))
-		if s, ok := vm.ToStringX(0); !ok {
-			tool.Fatal(vm, fmt.Sprintf("table concat error,not support %s", vm.Type(0).String()))
-		} else {
-			sb.WriteString(s)
-			sb.WriteString(separator)
+	addField := func(i int64) {
+		vm.GetI(1, i)
+		if !vm.IsString(0) { //数字也可以转换为字符串
+			vm.Error2("invalid value (at index %d) in table for 'concat'", i)
 		}
+		sb.WriteString(vm.ToString(0))
+		vm.Pop(1)
+	}
+	for ; i < last; i++ { //i<last而不是i<=last,避免last为math.maxinteger时溢出
+		addField(i)
+		sb.WriteString(sep)
 	}
-	str := sb.String()
-	if n > 0 {
-		str = strings.TrimSuffix(str, separator)
+	if i == last {
+		addField(i)
 	}
-	vm.PushString(str)
+	vm.PushString(sb.String())
 	return 1
 }
@@ -0,0 +1,206 @@
+package stdlib
+
+import (
+	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/number"
+)
+
+var utf8Funcs map[string]api.GoFunc = map[string]api.GoFunc{
+	"char":      utf8Char,
+	"codes":     utf8Codes,
+	"codepoint": utf8Codepoint,
+	"len":       utf8Len,
+	"offset":    utf8Offset,
+}
+
+const (
+	MAXUNICODE   = 0x10FFFF
+	UTF8_PATTERN = "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*" //匹配一个UTF-8字符的模式串(假设字符串是有效的UTF-8)
+)
+
+// 用于加载utf8库的方法,最后应当生成一个table于栈顶返回
+func OpenUtf8Lib(vm api.LuaVM) int {
+	vm.NewLib(utf8Funcs)
+	vm.PushString(UTF8_PATTERN)
+	vm.SetField(-1, "charpattern")
+	return 1
+}
+
+// 是否为UTF-8的后续字节,即10xxxxxx
+// i超出字符串范围时返回false
+func iscont(s string, i int64) bool {
+	return i < int64(len(s)) && s[i]&0xC0 == 0x80
+}
+
+// 解码s[i:]开头的一个UTF-8字符,移植自官方utf8_decode
+// 返回字符的码点与下一个字符的位置,编码无效时ok为false
+func utf8Decode(s string, i int64) (code rune, next int64, ok bool) {
+	limits := [...]uint32{0xFF, 0x7F, 0x7FF, 0xFFFF} //各字节数能编码的最小码点(不含)
+	c := uint32(s[i])
+	res := uint32(0)
+	if c < 0x80 { //ascii
+		return rune(c), i + 1, true
+	}
+	count := 0 //后续字节数
+	for ; c&0x40 != 0; c <<= 1 {
+		count++
+		if !iscont(s, i+int64(count)) {
+			return 0, 0, false
+		}
+		res = res<<6 | uint32(s[i+int64(count)]&0x3F)
+	}
+	res |= (c & 0x7F) << (count * 5) //加上首字节中的有效位
+	if count > 3 || res > MAXUNICODE || res <= limits[count] {
+		return 0, 0, false //超出范围或非最短编码
+	}
+	return rune(res), i + int64(count) + 1, true
+}
+
+// utf8.char(...)
+// 将若干个码点转换为对应的UTF-8字符并连接成字符串	utf8.char(72, 228, 8364) → "Hä€"
+func utf8Char(vm api.LuaVM) int {
+	n := vm.GetTop()
+	buff := make([]byte, 0, n)
+	for i := 1; i <= n; i++ {
+		code := vm.CheckInteger(i)
+		vm.ArgCheck(uint64(code) <= MAXUNICODE, i, "value out of range")
+		buff = append(buff, number.UTF8Encode(uint32(code))...)
+	}
+	vm.PushString(string(buff))
+	return 1
+}
+
+// utf8.codes(s)
+// 返回迭代函数,用于for in语句遍历s中的每个字符
+// for p, c in utf8.codes(s) do body end	p为字符的字节位置,c为字符的码点
+func utf8Codes(vm api.LuaVM) int {
+	vm.CheckString(1)
+	vm.PushGoFunction(utf8IterAux, 0)
+	vm.PushValue(1)
+	vm.PushInteger(0)
+	return 3
+}
+
+// utf8.codes的迭代函数,参数为字符串与上一个字符的字节位置
+func utf8IterAux(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	l := int64(len(s))
+	n := vm.ToInteger(2) - 1
+	if n < 0 { //第一次迭代
+		n = 0
+	} else if n < l {
+		n++ //跳过当前字符
+		for iscont(s, n) {
+			n++
+		}
+	}
+	if n >= l {
+		return 0 //遍历结束
+	}
+	code, next, ok := utf8Decode(s, n)
+	if !ok || iscont(s, next) {
+		return vm.Error2("invalid UTF-8 code")
+	}
+	vm.PushInteger(n + 1)
+	vm.PushInteger(int64(code))
+	return 2
+}
+
+// utf8.codepoint(s [, i [, j]])
+// 返回s中从字节位置i到j(包括)之间所有字符的码点,i默认为1,j默认为i
+// utf8.codepoint("Hä€", 1, -1) → 72 228 8364
+func utf8Codepoint(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	l := len(s)
+	posi := posrelat(vm.OptInteger(2, 1), l)
+	pose := posrelat(vm.OptInteger(3, posi), l)
+	vm.ArgCheck(posi >= 1, 2, "out of range")
+	vm.ArgCheck(pose <= int64(l), 3, "out of range")
+	if posi > pose {
+		return 0 //空区间
+	}
+	vm.CheckStack(int(pose - posi + 1))
+	n := 0
+	for i := posi - 1; i < pose; n++ {
+		code, next, ok := utf8Decode(s, i)
+		if !ok {
+			return vm.Error2("invalid UTF-8 code")
+		}
+		vm.PushInteger(int64(code))
+		i = next
+	}
+	return n
+}
+
+// utf8.len(s [, i [, j]])
+// 返回s中从字节位置i到j(包括)之间开始的字符数,i默认为1,j默认为-1
+// 遇到无效的字节序列时返回nil及第一个无效字节的位置	utf8.len("\xff") → nil 1
+func utf8Len(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	l := len(s)
+	posi := posrelat(vm.OptInteger(2, 1), l)
+	posj := posrelat(vm.OptInteger(3, -1), l)
+	vm.ArgCheck(1 <= posi && posi-1 <= int64(l), 2, "initial position out of string")
+	vm.ArgCheck(posj-1 < int64(l), 3, "final position out of string")
+	n := int64(0)
+	for i := posi - 1; i <= posj-1; n++ {
+		_, next, ok := utf8Decode(s, i)
+		if !ok { //无效的字节序列
+			vm.PushNil()
+			vm.PushInteger(i + 1)
+			return 2
+		}
+		i = next
+	}
+	vm.PushInteger(n)
+	return 1
+}
+
+// utf8.offset(s, n [, i])
+// 返回从字节位置i开始的第n个字符的字节位置,n为负数时向前查找,n为0时返回i所在字符的起始位置
+// n>=0时i默认为1,否则默认为#s+1		utf8.offset("Hä€", 3) → 4
+func utf8Offset(vm api.LuaVM) int {
+	s := vm.CheckString(1)
+	l := int64(len(s))
+	n := vm.CheckInteger(2)
+	posi := int64(1)
+	if n < 0 {
+		posi = l + 1
+	}
+	posi = posrelat(vm.OptInteger(3, posi), int(l))
+	vm.ArgCheck(1 <= posi && posi-1 <= l, 3, "position out of range")
+	posi-- //转换为从0开始的索引
+	if n == 0 {
+		for posi > 0 && iscont(s, posi) { //查找当前字符的起始位置
+			posi--
+		}
+	} else {
+		if iscont(s, posi) {
+			return vm.Error2("initial position is a continuation byte")
+		}
+		if n < 0 {
+			for n < 0 && posi > 0 { //向前移动
+				posi--
+				for posi > 0 && iscont(s, posi) {
+					posi--
+				}
+				n++
+			}
+		} else {
+			n--                     //第1个字符即为当前字符
+			for n > 0 && posi < l { //向后移动
+				posi++
+				for iscont(s, posi) {
+					posi++
+				}
+				n--
+			}
+		}
+	}
+	if n == 0 {
+		vm.PushInteger(posi + 1)
+	} else {
+		vm.PushNil() //找不到第n个字符
+	}
+	return 1
+}
@@ -0,0 +1,45 @@
+package test
+
+import (
+	"testing"
+
+	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/state"
+)
+
+// 编译chunk后反复执行,报告每次执行的内存分配
+func benchChunk(b *testing.B, chunk string) {
+	s := state.New()
+	s.OpenLibs()
+	if s.Load([]byte(chunk), "@bench.lua", "bt") != api.LUA_OK {
+		b.Fatal(s.ToString(0))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.PushValue(0)
+		if s.PCall(0, 0, false) != api.LUA_OK {
+			b.Fatal(s.ToString(0))
+		}
+	}
+}
+
+func BenchmarkIntegerLoop(b *testing.B) {
+	benchChunk(b, "local s = 0 for i = 1, 1e6 do s = s + i end")
+}
+
+func BenchmarkFloatLoop(b *testing.B) {
+	benchChunk(b, "local s = 0.5 for i = 1, 1e6 do s = s * 1.0000001 + i / 3 end")
+}
+
+func BenchmarkGlobalLoop(b *testing.B) {
+	benchChunk(b, "s = 0 for i = 1, 1e6 do s = s + i end")
+}
+
+func BenchmarkTableArray(b *testing.B) {
+	benchChunk(b, "local t = {} for i = 1, 1e5 do t[i] = i * 2 end local s = 0 for i = 1, #t do s = s + t[i] end")
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchChunk(b, "local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end fib(20)")
+}
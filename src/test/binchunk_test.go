@@ -0,0 +1,191 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/binchunk"
+	"nskbz.cn/lua/compile"
+	"nskbz.cn/lua/instruction"
+	"nskbz.cn/lua/state"
+)
+
+// Compile -> ToBytes -> Undump 应当得到完全一致的Prototype,且编译器生成的代码都能通过校验
+func TestDumpRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proto, err := compile.Compile(data, "@"+file, api.LUA_VERSION_54) //5.4语法兼容5.3,同时覆盖lua54_test.lua
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := binchunk.Verify(proto); err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+		undump, err := binchunk.Undump(proto.ToBytes(), "@"+file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(proto, undump) {
+			t.Errorf("%s: prototype changed after dump/undump", file)
+		}
+	}
+}
+
+func TestDumpLongString(t *testing.T) {
+	long := make([]byte, 300)
+	for i := range long {
+		long[i] = 'a' + byte(i%26)
+	}
+	proto, err := compile.Compile([]byte("local s = '"+string(long)+"' local e = '' return s, e"), "@long", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	undump, err := binchunk.Undump(proto.ToBytes(), "@long")
+	if err != nil || !reflect.DeepEqual(proto, undump) {
+		t.Fail()
+	}
+}
+
+// 被截断或篡改的二进制chunk应当返回LUA_ERR_SYNTAX而不是使虚拟机崩溃
+func TestUndumpMalformed(t *testing.T) {
+	proto, err := compile.Compile([]byte("local t = {} for i = 1, 3 do t[i] = i * 2 end return t[3]"), "@bad", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := proto.ToBytes()
+	s := state.New()
+	cases := map[string]string{
+		string(chunk[:len(chunk)/2]):      "bad: truncated precompiled chunk",
+		"\x1bLua\x52" + string(chunk[5:]): "bad: version mismatch in precompiled chunk",
+	}
+	for data, expected := range cases {
+		if s.Load([]byte(data), "@bad", "b") != api.LUA_ERR_SYNTAX || s.ToString(0) != expected {
+			t.Errorf("got %q, expected %q", s.ToString(0), expected)
+		}
+		s.Pop(1)
+	}
+	//load的chunkname默认为chunk本身
+	if s.Load(chunk[:20], string(chunk[:20]), "bt") != api.LUA_ERR_SYNTAX || s.ToString(0) != "binary string: truncated precompiled chunk" {
+		t.Errorf("got %q", s.ToString(0))
+	}
+	s.Pop(1)
+
+	//寄存器越界
+	proto.Codes[0] = proto.Codes[0] | 0xFF<<6
+	if s.Load(proto.ToBytes(), "=bad", "b") != api.LUA_ERR_SYNTAX || !strings.HasPrefix(s.ToString(0), "bad: bad code in precompiled chunk") {
+		t.Errorf("got %q", s.ToString(0))
+	}
+	s.Pop(1)
+
+	//任意位置的字节被修改都不会引发panic
+	for i := range chunk {
+		data := append([]byte{}, chunk...)
+		data[i] ^= 0xFF
+		if status := s.Load(data, "=bad", "b"); status != api.LUA_OK && status != api.LUA_ERR_SYNTAX {
+			t.Errorf("byte %d: status %d", i, status)
+		}
+		s.Pop(1)
+	}
+}
+
+func abc(op, a, b, c int) instruction.Instruction {
+	return instruction.Instruction(b<<23 | c<<14 | a<<6 | op)
+}
+
+// 校验拒绝会使虚拟机崩溃的指令序列,"return ..."编译为VARARG 0 0; RETURN 0 0; RETURN 0 1
+func TestVerifyCorrupt(t *testing.T) {
+	cases := []struct {
+		name     string
+		corrupt  func(p *binchunk.Prototype)
+		expected string
+	}{
+		{"open RETURN without open call", func(p *binchunk.Prototype) {
+			p.Codes[0] = abc(instruction.OP_LOADNIL, 0, 0, 0)
+		}, "RETURN with B=0 does not follow an open call"},
+		{"open results below RETURN", func(p *binchunk.Prototype) {
+			p.Codes[1] = abc(instruction.OP_RETURN, 1, 0, 0)
+		}, "open results start at register 0 before 1"},
+		{"open CALL at VARARG register", func(p *binchunk.Prototype) {
+			p.Codes[1] = abc(instruction.OP_CALL, 0, 0, 1)
+		}, "open results start at register 0 before 1"},
+		{"VARARG results not used", func(p *binchunk.Prototype) {
+			p.Codes[1] = abc(instruction.OP_RETURN, 0, 1, 0)
+		}, "multiple results of VARARG not used"},
+		{"jump into open RETURN", func(p *binchunk.Prototype) {
+			p.Codes = append([]instruction.Instruction{instruction.Instruction((instruction.MAX_SBX+1)<<14 | instruction.OP_JMP)}, p.Codes...)
+			p.LineInfo = nil
+		}, "jump into an open call at pc 2"},
+		{"upvalue instack", func(p *binchunk.Prototype) {
+			p.Protos = []*binchunk.Prototype{{
+				Codes:           []instruction.Instruction{abc(instruction.OP_RETURN, 0, 1, 0)},
+				Upvalues:        []binchunk.Upvalue{{Instack: 2, Idx: 0}},
+				MaxRegisterSize: 2,
+			}}
+		}, "upvalue 0 has invalid instack 2"},
+	}
+	s := state.New()
+	for _, c := range cases {
+		proto, err := compile.Compile([]byte("return ..."), "=bad", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proto.MaxRegisterSize = 4
+		c.corrupt(proto)
+		if s.Load(proto.ToBytes(), "=bad", "b") != api.LUA_ERR_SYNTAX || !strings.Contains(s.ToString(0), c.expected) {
+			t.Errorf("%s: got %q, expected %q", c.name, s.ToString(0), c.expected)
+		}
+		s.Pop(1)
+	}
+}
+
+// NEWTABLE的B、C只是预留空间的提示,0xFF对应约16G个元素,不会被真正分配
+func TestNewTableSizeHint(t *testing.T) {
+	proto, err := compile.Compile([]byte("local t = {} t[1] = 1 return #t"), "=hint", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proto.Codes[0].Opcode() != instruction.OP_NEWTABLE {
+		t.Fatal(proto.Codes[0].Name())
+	}
+	s := state.New()
+	for _, hint := range []int{0xFF, 0xF7, 0x1FF} {
+		proto.Codes[0] = abc(instruction.OP_NEWTABLE, 0, hint, hint)
+		if s.Load(proto.ToBytes(), "=hint", "b") != api.LUA_OK || s.PCall(0, 1, false) != api.LUA_OK || s.ToInteger(0) != 1 {
+			t.Errorf("%#x: got %s", hint, stackString(s))
+		}
+		s.SetTop(0)
+	}
+}
+
+// string.dump得到的chunk可以重新加载,第一个upvalue为_ENV,其余初始化为nil;strip后错误信息不含位置
+func TestStringDump(t *testing.T) {
+	checkLua(t, `
+local function add(a, b) return a + b end
+local f = load(string.dump(add))
+assert(f(1, 2) == 3)
+local x, y = 10, 20
+local function get() return x, y end
+local a, b = load(string.dump(get))()
+assert(a == _G and b == nil)
+local function fail() error("boom") end
+local ok, msg = pcall(load(string.dump(fail)))
+assert(not ok and string.find(msg, ":%d+: boom$"))
+ok, msg = pcall(load(string.dump(fail, true)))
+assert(not ok and msg == "boom")
+assert(#string.dump(fail, true) < #string.dump(fail))
+ok, msg = pcall(string.dump, print)
+assert(not ok and string.find(msg, "unable to dump given function", 1, true))
+assert(not pcall(string.dump, 1))
+`)
+}
@@ -0,0 +1,217 @@
+package test
+
+import "testing"
+
+// 泛型for循环体中声明的局部变量与循环变量位于同一作用域
+func TestForInBodyLocals(t *testing.T) {
+	checkLua(t, `
+local sum = 0
+for k, v in ipairs({10, 20}) do local a, b = k, v sum = sum + a + b end
+assert(sum == 33)
+for k in pairs({x = 1}) do local s = k .. "!" assert(s == "x!") end
+`)
+}
+
+// 错误信息中的字符串与数字使用源代码文本,不可打印的字符输出为其编码
+// '\'后的换行(\r\n与\n\r视为一个换行)转义为\n,数字可以以'.'开头
+// 数字先读入所有可能的字符再检查格式,\u{XXX}接受最大0x7FFFFFFF的码点
+func TestLexer(t *testing.T) {
+	checkLua(t, `
+local function err(code) return select(2, load(code)) end
+assert(err("x = 1 'a'") == [[[string "x = 1 'a'"]:1: unexpected symbol near ''a'']])
+assert(err("x = 1 \"a\\tb\"") == [[[string "x = 1 "a\tb""]:1: unexpected symbol near '"a\tb"']])
+assert(err("x = 1 0x1P4") == [[[string "x = 1 0x1P4"]:1: unexpected symbol near '0x1P4']])
+assert(err("x = \1"):find(":1: unexpected symbol near '<\\1>'", 1, true))
+assert(err("x = 'a\rb'"):find(":1: unfinished string near ''a'$"))
+local function newlines(...)
+  assert(select("#", ...) == 4)
+  for i = 1, 4 do assert(select(i, ...) == "a\nb") end
+end
+newlines(load("return 'a\\\nb', 'a\\\r\nb', 'a\\\n\rb', 'a\\\rb'")())
+assert(.5 == 0.5 and .5e1 == 5.0 and 3 .. .5 == "30.5")
+assert(err("x = 1e") == [[[string "x = 1e"]:1: malformed number near '1e']])
+assert(err("x = 1e+ 2"):find(":1: malformed number near '1e+'", 1, true))
+assert(err("x = 0x"):find(":1: malformed number near '0x'$"))
+assert(err("x = 1..2"):find(":1: malformed number near '1..2'$"))
+assert(1e+2 == 100.0 and 0xA.8p1 == 21.0 and 0xep1 == 28.0)
+assert("\u{7FF}\u{FFFF}" == "\xDF\xBF\xEF\xBF\xBF" and "\u{D800}" == "\xED\xA0\x80")
+assert("\u{10FFFF}\u{7FFFFFFF}" == "\xF4\x8F\xBF\xBF\xFD\xBF\xBF\xBF\xBF\xBF")
+assert(err([[x = "\u{80000000}"]]):find(":1: UTF-8 value too large near", 1, true))
+`)
+}
+
+// 列表式字段通过SETLIST写入,最后一个字段为函数调用或vararg时展开所有返回值
+func TestTableConstructor(t *testing.T) {
+	checkLua(t, `
+local function f() return 1, 2, 3 end
+local function none() end
+local t = {f()}
+assert(#t == 3 and t[3] == 3)
+t = {f(), f()}
+assert(#t == 4 and t[1] == 1 and t[2] == 1 and t[4] == 3)
+t = {f(), (f())}
+assert(#t == 2 and t[2] == 1)
+assert(#{none()} == 0 and #{none(), nil} == 0)
+t = {1, 2, x = "x", 3, [10] = 10, f()}
+assert(#t == 6 and t.x == "x" and t[10] == 10 and t[6] == 3)
+local function pack(...) return {...}, select("#", ...) end
+local p, n = pack(1, nil, 3)
+assert(n == 3 and p[1] == 1 and p[2] == nil and p[3] == 3)
+-- 超过LFIELDS_PER_FLUSH*MAX_C个列表式字段时SETLIST的C由EXTRAARG给出
+n = 50 * 511 + 10
+local big = load("return {" .. string.rep("7,", n) .. "}")()
+assert(#big == n and big[n] == 7)
+`)
+}
+
+// 左操作数、一元运算的操作数以及被索引的表直接生成到目标寄存器中,长表达式只占用固定数量的寄存器
+func TestRegisterReuse(t *testing.T) {
+	checkLua(t, `
+local t = {}
+t.a = t
+local function run(code) return assert(load("local x, t = ... return " .. code))(1, t) end
+assert(run(string.rep("x + ", 300) .. "x") == 301)
+assert(run(string.rep("- ", 151) .. "x") == -1) --一元运算的嵌套受LUAI_MAXCCALLS限制
+assert(run("t" .. string.rep(".a", 300)) == t)
+assert(run("t" .. string.rep(".a", 300) .. " == t" .. string.rep("['a']", 300)))
+`)
+}
+
+// and/or的第一个表达式直接生成到R(A)中,使用TEST判断后短路
+func TestAndOr(t *testing.T) {
+	checkLua(t, `
+local function run(code) return assert(load("local x = ... return " .. code))(false) end
+assert(run(string.rep("x or ", 300) .. "1") == 1)
+assert(run(string.rep("1 and ", 300) .. "x") == false)
+local a, b = nil, 2
+assert((a or b) == 2 and (b or a) == 2 and (a and b) == nil and (b and 3) == 3 and (false or nil) == nil)
+local calls = 0
+local function f(v) calls = calls + 1 return v end
+assert((f(1) or f(2)) == 1 and calls == 1)
+assert((f(nil) and f(2)) == nil and calls == 2)
+assert((f(false) or f(nil) or f(3)) == 3 and calls == 5)
+`)
+}
+
+// function a.b.c:f() end与表元素的赋值相同,a可以是局部变量、upvalue或全局变量
+func TestMethodDefinition(t *testing.T) {
+	checkLua(t, `
+obj = {}
+function obj:get() return self end
+assert(obj:get() == obj)
+local a = {b = {c = {}}}
+function a.b.c:f(x) return self, x end
+local s, x = a.b.c:f(5)
+assert(s == a.b.c and x == 5)
+function a.b.g(x) return x end
+assert(a.b.g(3) == 3)
+local u = {}
+local function def() function u:m() return self end end
+def()
+assert(u:m() == u)
+`)
+}
+
+// 与官方一致,一个函数最多有200个局部变量与255个upvalue
+func TestCompileLimits(t *testing.T) {
+	checkLua(t, `
+local function err(code) return select(2, load(code, "=limits")) end
+assert(err("local " .. string.rep("a", 201, ", ")) == "limits:1: too many local variables (limit is 200) in main function")
+assert(load("local " .. string.rep("a", 200, ", ")))
+assert(err("local x\n" .. string.rep("local y\n", 200)) == "limits:201: too many local variables (limit is 200) in main function")
+local outer, inner, sum = "", "", "0"
+for i = 1, 256 do
+  if i <= 150 then outer = outer .. "local u" .. i .. "\n" else inner = inner .. "local u" .. i .. "\n" end
+  sum = sum .. " + u" .. i
+end
+local code = outer .. "return function()\n" .. inner .. "return function()\nreturn " .. sum .. " end end"
+assert(err(code) == "limits:259: too many upvalues (limit is 255) in function at line 258")
+`)
+}
+
+// 与官方一致,CALL的行号为前缀表达式开始的行,TFORCALL的行号为in之后第一个表达式的行
+func TestCallLineInfo(t *testing.T) {
+	checkLua(t, `
+local function line(code)
+  local _, msg = pcall(assert(load(code, "=lines")))
+  return tonumber(msg:match("^lines:(%d+):"))
+end
+assert(line("local f\nf(\n1\n)") == 2)
+assert(line("local f\nf {\n}") == 2)
+assert(line("local f\nf\n'x'") == 2)
+assert(line("local t = {}\nt\n:m(\n)") == 2)
+assert(line("for k in\nnil do end") == 2)
+`)
+}
+
+// if的条件为假时跳过的只是if块本身,不能关闭外层作用域中被捕获的局部变量
+func TestIfKeepsUpvaluesOpen(t *testing.T) {
+	checkLua(t, `
+local fs = {}
+for i = 1, 2 do
+  local x = i
+  fs[i] = function() return x end
+  if x > 5 then error("unreachable") end
+  x = x * 10
+end
+assert(fs[1]() == 10 and fs[2]() == 20)
+`)
+}
+
+// return之后只有';'时没有返回值
+func TestEmptyReturn(t *testing.T) {
+	checkLua(t, `
+local function f() return; end
+local function g(x) if x then return; end return x end
+assert(select("#", f()) == 0 and select("#", g(true)) == 0 and g(false) == false)
+`)
+}
+
+// 与官方一致,按照源码中出现的顺序捕获upvalue:被赋值的全局变量所需的_ENV先于右侧表达式中的变量
+func TestUpvalueOrder(t *testing.T) {
+	checkLua(t, `
+local y = 1
+local function f() x = y end
+local function g() local a; a, z = y, 1 end
+for _, fn in ipairs({f, g}) do
+  assert(debug.getupvalue(fn, 1) == "_ENV" and debug.getupvalue(fn, 2) == "y")
+end
+`)
+}
+
+// goto跳出有被捕获变量的作用域时关闭这些变量,即使label之后还有语句复用了它们的寄存器
+func TestGotoClosesUpvalues(t *testing.T) {
+	checkLua(t, `
+local f
+do local c = 42 f = function() return c end goto out end
+::out::
+local a, b, d = "x", "y", "z"
+assert(f() == 42)
+local gs = {}
+for k = 1, 3 do
+  do local y = k * 2 gs[k] = function() return y end goto next end
+  ::next::
+  local z = "oops"
+end
+assert(gs[1]() == 2 and gs[2]() == 4 and gs[3]() == 6)
+`)
+}
+
+// 语句与表达式的嵌套超过LUAI_MAXCCALLS层时load返回错误,而不是耗尽Go的调用栈
+func TestNestingLimit(t *testing.T) {
+	checkLua(t, `
+local function check(code)
+  local f, err = load(code)
+  assert(f == nil and err:find(":1: chunk has too many C levels$"), err)
+end
+check("return " .. ("{"):rep(1e6) .. ("}"):rep(1e6))
+check(("do "):rep(2e5) .. (" end"):rep(2e5))
+check("return " .. ("("):rep(1e5) .. "1" .. (")"):rep(1e5))
+check("return " .. ("- "):rep(1e5) .. "1")
+check("return " .. ("2^"):rep(1e5) .. "1")
+check("return " .. ("f{"):rep(1e5) .. ("}"):rep(1e5))
+assert(load("return " .. ("{"):rep(150) .. ("}"):rep(150)))
+assert(load(("do "):rep(150) .. (" end"):rep(150)))
+assert(load(("do end "):rep(2e5)))
+`)
+}
@@ -0,0 +1,10 @@
+-- 整数与浮点数运算、比较及数字的字符串表示
+print(1 + 2, 1 + 2.0, 7 // 2, 7.0 // 2, -7 // 2, 7 % -3, -7 % 3, 7.5 % 2)
+print(2 ^ 10, 10 / 2, 1 / 3, 3 | 5, 3 & 5, 3 ~ 5, ~0, 1 << 63, 1 << 64, -1 >> 1)
+print(math.maxinteger + 1 == math.mininteger, math.maxinteger + 1.0)
+print(1e100, 1e15, 123456789012345.0, 0.1 + 0.2, -0.0, 1 / 0, -1 / 0)
+print(3 == 3.0, 1 < 1.5, "a" < "b", "10" + 1, "1e1" * 1, " 2 " * 2, "3" | 0)
+print(math.type(1), math.type(1.0), math.type("1"), math.tointeger(3.0), math.tointeger(3.5))
+print(tostring(10 // 0.0), string.format("%d %5.2f %x %q", 42, math.pi, 255, "a\n"))
+print(tonumber("  12  "), tonumber("z", 36), tonumber("ff", 16), tonumber("1e"), tonumber("1.5e1"))
+print(8 // 3 * 3 + 8 % 3, 2 ^ 0.5 * 2 ^ 0.5, math.floor(-3.5), math.ceil(-3.5), math.abs(math.mininteger))
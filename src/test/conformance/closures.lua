@@ -0,0 +1,51 @@
+-- 闭包、upvalue、可变参数与尾调用
+local function counter()
+  local n = 0
+  return function() n = n + 1 return n end
+end
+local c1, c2 = counter(), counter()
+print(c1(), c1(), c2(), c1())
+
+local fns = {}
+for i = 1, 3 do fns[i] = function() return i end end
+print(fns[1](), fns[2](), fns[3]())
+
+local function va(...)
+  local a, b = ...
+  return select("#", ...), a, b, ...
+end
+print(va())
+print(va(1, nil, 3))
+print((va(1, 2)))
+
+local function sum(n, acc)
+  if n == 0 then return acc end
+  return sum(n - 1, acc + n)
+end
+print(sum(10000, 0))
+
+local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end
+print(fib(20))
+
+local x = 1
+local function shadow()
+  local x = x + 1
+  do local x = x * 10 print(x) end
+  return x
+end
+print(shadow(), x)
+
+local i = 1
+while true do
+  i = i * 2
+  if i > 100 then break end
+end
+repeat local j = i; i = i - 1 until j < 125
+print(i)
+for k = 10, 1, -3 do io.write(k, " ") end
+for k = 1.0, 2.0, 0.5 do io.write(k, " ") end
+print()
+goto skip
+print("not printed")
+::skip::
+print("after goto")
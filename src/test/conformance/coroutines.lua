@@ -0,0 +1,32 @@
+-- 协程
+local co = coroutine.create(function(a, b)
+  print("start", a, b)
+  local c = coroutine.yield(a + b)
+  print("got", c)
+  local d, e = coroutine.yield(c * 2)
+  return d + e
+end)
+print(coroutine.resume(co, 1, 2))
+print(coroutine.status(co))
+print(coroutine.resume(co, 10))
+print(coroutine.resume(co, 3, 4))
+print(coroutine.status(co), coroutine.resume(co))
+
+local gen = coroutine.wrap(function()
+  for i = 1, 3 do coroutine.yield(i) end
+end)
+print(gen(), gen(), gen())
+
+local failing = coroutine.create(function() error("inside") end)
+print(coroutine.resume(failing))
+print(coroutine.status(failing))
+print(coroutine.isyieldable(), select(2, coroutine.running()))
+
+local function producer()
+  return coroutine.wrap(function()
+    for _, v in ipairs({"a", "b", "c"}) do coroutine.yield(v) end
+  end)
+end
+local out = {}
+for v in producer() do out[#out + 1] = v end
+print(table.concat(out))
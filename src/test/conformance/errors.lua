@@ -0,0 +1,20 @@
+-- 错误处理:pcall、xpcall、error的级别与错误值
+print(pcall(error, "msg"))
+print(pcall(error, "msg", 0))
+print(select(2, pcall(error, {code = 1})).code)
+print(pcall(error))
+print(select("#", pcall(error)))
+local ok, e = pcall(function() return (select(1, nil)).x end)
+print(ok, e)
+ok, e = pcall(function() return 1 + {} end)
+print(ok, e)
+ok, e = pcall(function() return #5 end)
+print(ok, e)
+ok, e = pcall(function() error("level2", 2) end)
+print(ok, e)
+print(xpcall(function() error("boom") end, function(m) return "handled: " .. m end))
+print(xpcall(function(...) return ... end, print, 1, 2))
+print(pcall(pcall, error, "nested"))
+ok, e = pcall(function() local a = "x" .. {} end)
+print(ok, e)
+print(tostring(select(2, pcall(error, setmetatable({}, {__tostring = function() return "custom" end})))))
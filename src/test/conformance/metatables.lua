@@ -0,0 +1,42 @@
+-- 元表与元方法
+local V = {}
+V.__index = V
+local function vec(x, y) return setmetatable({x = x, y = y}, V) end
+V.__add = function(a, b) return vec(a.x + b.x, a.y + b.y) end
+V.__eq = function(a, b) return a.x == b.x and a.y == b.y end
+V.__lt = function(a, b) return a.x < b.x end
+V.__le = function(a, b) return a.x <= b.x end
+V.__tostring = function(v) return "(" .. v.x .. "," .. v.y .. ")" end
+V.__len = function() return 2 end
+V.__call = function(v, k) return v[k] end
+V.__concat = function(a, b) return tostring(a) .. tostring(b) end
+V.__unm = function(v) return vec(-v.x, -v.y) end
+function V:dot(o) return self.x * o.x + self.y * o.y end
+
+local a, b = vec(1, 2), vec(3, 4)
+print(tostring(a + b), a == vec(1, 2), a < b, a <= b, a > b, #a, a("y"))
+print(a .. b, tostring(-a), a:dot(b), getmetatable(a) == V)
+
+local defaults = setmetatable({}, {__index = function(_, k) return k .. "!" end})
+print(defaults.foo, rawget(defaults, "foo"))
+
+local log = {}
+local proxy = setmetatable({}, {__newindex = function(t, k, v) log[#log + 1] = k rawset(t, k, v) end})
+proxy.a = 1
+proxy.a = 2
+proxy.b = 3
+print(table.concat(log, ","), proxy.a)
+
+local protected = setmetatable({}, {__metatable = "locked"})
+print(getmetatable(protected), pcall(setmetatable, protected, {}))
+print(getmetatable("").__index == string, ("x"):rep(3))
+
+local Base = {}
+Base.__index = Base
+function Base.new(name) return setmetatable({name = name}, Base) end
+function Base:greet() return "hi " .. self.name end
+local Derived = setmetatable({}, {__index = Base})
+Derived.__index = Derived
+function Derived.new(name) return setmetatable(Base.new(name), Derived) end
+function Derived:greet() return Base.greet(self) .. "!" end
+print(Derived.new("lua"):greet())
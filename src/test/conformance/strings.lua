@@ -0,0 +1,13 @@
+-- 字符串库与模式匹配
+local s = "hello world from lua"
+print(#s, s:upper(), s:sub(7, 11), s:sub(-3), s:rep(2, "|"))
+print(s:find("o w"), s:find("l+"), s:find("%s(%a+)"), s:match("(%a+) (%a+)"))
+print(s:gsub("o", "0"), s:gsub("%w+", string.upper, 2), ("abc"):reverse(), ("%d"):format(3))
+for w in s:gmatch("%a+") do io.write(w, ".") end
+print()
+print(string.byte("ABC", 1, -1), string.char(72, 105), ("x"):len())
+print(("key=val; k2=v2"):gsub("(%w+)=(%w+)", "%2=%1"))
+print(string.format("[%10s][%-5s][%.3s]", "right", "left", "truncate"))
+print(("  trim  "):match("^%s*(.-)%s*$") .. "|", ("a,b,,c"):find(",", 1, true))
+print(table.concat({1, 2.5, "x"}, "-"), ("%5.1f"):format(2.25), tostring(nil) .. tostring(true))
+print(pcall(string.rep), select("#", ("abc"):byte(1, 3)))
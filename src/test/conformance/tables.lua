@@ -0,0 +1,21 @@
+-- 表构造、长度、table库以及有序遍历
+local t = {10, 20, 30, nil, 50, n = 5, ["k"] = "v"}
+print(#{1, 2, 3}, t.n, t.k, t[5], select("#", table.unpack({1, 2, 3})))
+local list = {5, 3, 8, 1, 9, 2}
+table.sort(list)
+print(table.concat(list, " "))
+table.sort(list, function(a, b) return a > b end)
+print(table.concat(list, " "))
+table.insert(list, 1, 0)
+table.insert(list, 100)
+print(table.remove(list), table.remove(list, 1), table.concat(list, ","))
+print(table.unpack(table.move({1, 2, 3}, 1, 3, 2)))
+local keys = {}
+for k in pairs({a = 1, b = 2, c = 3, d = 4}) do keys[#keys + 1] = k end
+table.sort(keys)
+print(table.concat(keys))
+for i, v in ipairs({"x", "y", nil, "z"}) do io.write(i, "=", v, " ") end
+print()
+local nested = {a = {b = {c = "deep"}}}
+print(nested.a.b.c, rawlen({1, 2}), rawequal(nested, nested), rawget(nested, "a") == nested.a)
+print(next({}), type(next), select(-1, 1, 2, 3))
@@ -0,0 +1,56 @@
+-- 尾调用:调用链不随尾递归增长,调试信息标记尾调用
+local function loop(n, acc)
+  if n == 0 then return acc end
+  return loop(n - 1, acc + 1)
+end
+print(loop(200000, 0))
+
+local even, odd
+function even(n) if n == 0 then return true end return odd(n - 1) end
+function odd(n) if n == 0 then return false end return even(n - 1) end
+print(even(100001), odd(100001))
+
+-- 状态机
+local states = {}
+function states.a(n) if n <= 0 then return "a" end return states.b(n - 1) end
+function states.b(n) if n <= 0 then return "b" end return states.a(n - 2) end
+print(states.a(100001))
+
+-- 尾调用可变参数函数、Go函数与__call元方法
+local function va(...) return select("#", ...), ... end
+local function tva(...) return va(...) end
+print(tva(1, nil, 3))
+local function tgo(s) return s:upper() end
+print(tgo("go"))
+local callable = setmetatable({}, {__call = function(self, n) return n * 2 end})
+local function tcall(n) return callable(n) end
+print(tcall(21))
+print((function() return (loop(3, 0)) end)())
+
+-- 调试信息
+local function info() return debug.getinfo(1, "nt") end
+local function viaTail() return info() end
+local function viaCall() local i = info() return i end
+local a, b = viaTail(), viaCall()
+print(a.istailcall, a.name, b.istailcall, b.name)
+local function tb() return debug.traceback() end
+local function viaTb() return tb() end
+print(select(2, viaTb():gsub("%(%.%.%.tail calls%.%.%.%)", "")))
+
+local events = {}
+local function g() return 1 end
+local function h() return g() end
+debug.sethook(function(e) events[#events + 1] = e end, "cr")
+h()
+debug.sethook()
+print(table.concat(events, " "))
+
+-- 尾调用中yield
+local co = coroutine.wrap(function(n)
+  local function f(k)
+    if k == 0 then return coroutine.yield("yielded") end
+    return f(k - 1)
+  end
+  return f(n)
+end)
+print(co(100000), co("resumed"))
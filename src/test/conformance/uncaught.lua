@@ -0,0 +1,5 @@
+-- 未捕获的错误:比较出错前的输出以及执行状态
+print("before")
+local t = {}
+t.field.x = 1
+print("after")
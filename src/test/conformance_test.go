@@ -0,0 +1,178 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"nskbz.cn/lua/api"
+	"nskbz.cn/lua/binchunk"
+	"nskbz.cn/lua/compile"
+	"nskbz.cn/lua/state"
+)
+
+/*
+*	差异测试
+*
+*	conformance目录下的脚本分别交给参考实现(仓库顶层的lua、luac)与本解释器执行,比较标准输出以及是否出错
+*	参考实现的路径可以通过环境变量LUA_REF、LUAC_REF指定,无法在当前环境运行时跳过相应的测试
+*	脚本的输出需要是确定的:不输出地址、时间,也不依赖pairs的遍历顺序
+ */
+
+const conformanceDir = "conformance"
+
+var (
+	refLuaOnce, refLuacOnce sync.Once
+	refLua, refLuac         string
+)
+
+// 参考实现lua的绝对路径,不可用时为""
+func referenceLua() string {
+	refLuaOnce.Do(func() { refLua = referenceBinary("LUA_REF", "../../lua") })
+	return refLua
+}
+
+// 参考实现luac的绝对路径,不可用时为""
+func referenceLuac() string {
+	refLuacOnce.Do(func() { refLuac = referenceBinary("LUAC_REF", "../../luac") })
+	return refLuac
+}
+
+func referenceBinary(env, def string) string {
+	path := os.Getenv(env)
+	if path == "" {
+		path = def
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	if exec.Command(abs, "-v").Run() != nil { //不存在或缺少依赖的动态库等
+		return ""
+	}
+	return abs
+}
+
+func conformanceFiles(t *testing.T) []string {
+	files, err := filepath.Glob(filepath.Join(conformanceDir, "*.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no scripts in %s", conformanceDir)
+	}
+	return files
+}
+
+// 使用参考实现执行脚本,返回标准输出以及是否正常结束
+func runReference(t *testing.T, file string) (string, bool) {
+	out, err := exec.Command(referenceLua(), file).Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("%s: %v", file, err)
+	}
+	return string(out), err == nil
+}
+
+// 使用本解释器执行load压入的chunk,返回标准输出以及是否正常结束
+// print直接写入os.Stdout,io库的标准输出在OpenLibs时创建,所以需要在创建state之前重定向
+func runLocal(t *testing.T, load func(ls api.LuaState) int) (string, bool) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+
+	status := func() int {
+		defer func() { os.Stdout = stdout }()
+		ls := state.New()
+		ls.OpenLibs()
+		if status := load(ls); status != api.LUA_OK {
+			return status
+		}
+		return ls.PCall(0, api.LUA_MULTRET, false)
+	}()
+	w.Close()
+	out := <-done
+	r.Close()
+	return string(out), status == api.LUA_OK
+}
+
+func runSource(t *testing.T, file string) (string, bool) {
+	return runLocal(t, func(ls api.LuaState) int { return ls.LoadFile(file) })
+}
+
+// 执行二进制chunk,加载前先确认binchunk.Undump能够正确解析
+func runChunk(t *testing.T, file string, chunk []byte) (string, bool) {
+	if _, err := binchunk.Undump(chunk, "@"+file); err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+	return runLocal(t, func(ls api.LuaState) int { return ls.Load(chunk, "@"+file, "b") })
+}
+
+func compareRun(t *testing.T, what, file, wantOut string, wantOk bool, gotOut string, gotOk bool) {
+	if gotOk != wantOk {
+		t.Errorf("%s: %s: ok = %t, want %t", file, what, gotOk, wantOk)
+	}
+	if gotOut != wantOut {
+		t.Errorf("%s: %s: stdout differs\n--- got ---\n%s--- want ---\n%s", file, what, gotOut, wantOut)
+	}
+}
+
+// 参考实现与本解释器执行源码的结果一致
+func TestConformance(t *testing.T) {
+	if referenceLua() == "" {
+		t.Skip("reference lua is not runnable in this environment")
+	}
+	for _, file := range conformanceFiles(t) {
+		wantOut, wantOk := runReference(t, file)
+		gotOut, gotOk := runSource(t, file)
+		compareRun(t, "source", file, wantOut, wantOk, gotOut, gotOk)
+	}
+}
+
+// 同一脚本的源码与二进制chunk的执行结果一致
+// 二进制chunk分别来自compile.Compile以及参考实现luac(可用时)
+func TestConformanceChunk(t *testing.T) {
+	luac := referenceLuac()
+	for _, file := range conformanceFiles(t) {
+		wantOut, wantOk := runSource(t, file)
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proto, err := compile.Compile(data, "@"+file, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotOut, gotOk := runChunk(t, file, proto.ToBytes())
+		compareRun(t, "compile.Compile", file, wantOut, wantOk, gotOut, gotOk)
+
+		if luac == "" {
+			continue
+		}
+		out := filepath.Join(t.TempDir(), "luac.out")
+		if msg, err := exec.Command(luac, "-o", out, file).CombinedOutput(); err != nil {
+			t.Fatalf("%s: luac: %v\n%s", file, err, msg)
+		}
+		if data, err = os.ReadFile(out); err != nil {
+			t.Fatal(err)
+		}
+		gotOut, gotOk = runChunk(t, file, data)
+		compareRun(t, "luac", file, wantOut, wantOk, gotOut, gotOk)
+	}
+	if luac == "" {
+		t.Log("reference luac is not runnable in this environment, only compile.Compile chunks were checked")
+	}
+}
@@ -30,7 +30,7 @@
 
 -- 测试用例
 
-local coA = coroutine.create(function()
+local coA; coA = coroutine.create(function()
     print("协程A 开始")
     local val1 = coroutine.yield(999)
     print("协程A 的状态:", coroutine.status(coA))
@@ -75,4 +75,38 @@ end)
 local ok, err = pcall(faulty) --以保护模式调用
 print(ok, err)  -- 输出: false  出错了!
 
--- faulty() --直接调用则会panic
\ No newline at end of file
+-- faulty() --直接调用则会panic
+-- 在元方法、迭代器以及pcall中yield
+
+local mt = {
+    __add = function(a, b) return coroutine.yield("add") end,
+    __index = function(t, k) return coroutine.yield("index " .. k) end,
+    __lt = function(a, b) return coroutine.yield("lt") end,
+}
+local obj = setmetatable({}, mt)
+local co_meta = coroutine.wrap(function()
+    local sum = obj + 1
+    local field = obj.name
+    local le = obj <= obj --没有__le时以not (b < a)计算
+    local ok, v = pcall(function() return coroutine.yield("pcall") + 1 end)
+    local ok2, err = pcall(function() coroutine.yield("before error") error("boom", 0) end)
+    return sum, field, le, ok, v, ok2, err
+end)
+print(co_meta())                 -- add
+print(co_meta(10))               -- index name
+print(co_meta("lua"))            -- lt
+print(co_meta(true))             -- pcall
+print(co_meta(41))               -- before error
+print(co_meta())                 -- 10  lua  false  true  42  false  boom
+
+local gen = coroutine.wrap(function()
+    for _, v in function(_, i) if i < 3 then return i + 1, coroutine.yield(i) end end, nil, 0 do
+        coroutine.yield("got " .. v)
+    end
+end)
+print(gen(), gen("a"), gen(), gen("b"))  -- 0  got a  1  got b
+
+-- 没有continuation的Go函数中不能yield
+print(coroutine.resume(coroutine.create(function()
+    table.sort({3, 2, 1}, function(a, b) return coroutine.yield() end)
+end)))                           -- false  attempt to yield across a C-call boundary
@@ -0,0 +1,79 @@
+-- debug.getinfo([thread,] f [, what])
+
+local function foo(a, b, ...)
+    local c = a + b
+    local info = debug.getinfo(1)
+    print(info.short_src, info.currentline, info.linedefined, info.lastlinedefined) -- debuglib_test.lua 5 3 10
+    print(info.what, info.name, info.namewhat)                                       -- Lua foo local
+    print(info.nups, info.nparams, info.isvararg, info.func == foo)                  -- 2 2 true true
+    return c
+end
+foo(1, 2)
+print(debug.getinfo(1, "S").what, debug.getinfo(print).what)                       -- main C
+print(debug.getinfo(100), pcall(debug.getinfo, 1, "x"))                             -- nil false ...invalid option
+
+local obj = {}
+function obj.method() return debug.getinfo(1, "n") end
+local n = obj.method()
+print(n.name, n.namewhat)                                                           -- method field
+
+-- debug.getlocal([thread,] f, local) / debug.setlocal([thread,] level, local, value)
+
+local function locals(x, ...)
+    local y = x * 2
+    print(debug.getlocal(1, 1), debug.getlocal(1, 2))                               -- x y 6
+    print(debug.getlocal(1, -1))                                                    -- (*vararg) v
+    print(debug.setlocal(1, 2, 100), y)                                             -- y 100
+end
+locals(3, "v")
+print(debug.getlocal(locals, 1), debug.getlocal(locals, 2))                         -- x nil
+
+-- debug.getupvalue / setupvalue / upvalueid / upvaluejoin
+
+local u1, u2 = 10, 20
+local function g() return u1 + u2 end
+local function h() return u2 end
+print(debug.getupvalue(g, 1), debug.getupvalue(g, 3))                               -- u1
+print(debug.setupvalue(g, 1, 5), g(), u1)                                           -- u1 25 5
+print(debug.upvalueid(g, 2) == debug.upvalueid(h, 1))                               -- true
+debug.upvaluejoin(g, 1, h, 1)
+print(g())                                                                          -- 40
+
+-- debug.getmetatable / setmetatable / getregistry
+
+local t = setmetatable({}, {__metatable = "locked"})
+print(debug.getmetatable(t).__metatable)                                            -- locked
+print(debug.setmetatable(t, nil) == t, getmetatable(t))                             -- true nil
+print(type(debug.getregistry()))                                                    -- table
+
+-- debug.traceback([thread,] [message [, level]])
+
+print(debug.traceback("msg"))                                                       -- msg stack traceback: ...
+local co = coroutine.create(function() coroutine.yield() end)
+coroutine.resume(co)
+print(debug.traceback(co))                                                          -- stack traceback: ...
+
+-- debug.sethook([thread,] hook, mask [, count]) / debug.gethook([thread])
+
+local lines = {}
+debug.sethook(function(ev, line) lines[#lines + 1] = line end, "l")
+local z = 1
+z = z + 1
+debug.sethook()
+print(table.concat(lines, " "))                                                     -- 60 61 62
+
+local events = {}
+local function callee() return 1 end
+debug.sethook(function(ev)
+    events[#events + 1] = ev .. ":" .. tostring(debug.getinfo(2, "n").name)
+end, "cr")
+callee()
+debug.sethook()
+print(table.concat(events, " "))                                  -- return:sethook call:callee return:callee call:sethook
+
+local count = 0
+debug.sethook(function() count = count + 1 end, "", 10)
+for i = 1, 100 do end
+print(count > 0, select(2, debug.gethook()), select(3, debug.gethook()))           -- true  10
+debug.sethook()
+print(debug.gethook())                                                              -- nil  0
@@ -0,0 +1,126 @@
+-- continue
+
+for i = 1, 5 do
+    local x = i * 10
+    if i % 2 == 0 then goto continue end
+    io.write(x, " ")
+    ::continue::
+end
+print()                                                                  -- 10 30 50
+
+local i = 0
+while i < 5 do
+    i = i + 1
+    if i == 3 then goto continue end
+    io.write(i, " ")
+    ::continue::
+end
+print()                                                                  -- 1 2 4 5
+
+local r = 0
+repeat
+    r = r + 1
+    if r == 2 then goto skip end
+    io.write(r, " ")
+    ::skip::
+until r >= 4
+print()                                                                  -- 1 3 4
+
+-- 向后跳转实现循环
+
+local n = 0
+::top::
+n = n + 1
+if n < 3 then goto top end
+print(n)                                                                 -- 3
+
+-- 跳出多层循环
+
+for i = 1, 3 do
+    for j = 1, 3 do
+        if i * j == 4 then
+            print("found", i, j)                                         -- found 2 2
+            goto out
+        end
+    end
+end
+::out::
+
+-- 跳出作用域时关闭upvalue,每个closure捕获的是不同的局部变量
+
+local fns = {}
+do
+    local k = 1
+    ::again::
+    local x = k
+    fns[#fns + 1] = function() return x end
+    k = k + 1
+    if k <= 3 then goto again end
+end
+print(fns[1](), fns[2](), fns[3]())                                      -- 1 2 3
+
+local gs = {}
+for k = 1, 3 do
+    do
+        local y = k * 2
+        gs[k] = function() return y end
+        goto next
+    end
+    ::next::
+end
+print(gs[1](), gs[2](), gs[3]())                                         -- 2 4 6
+
+-- label之后还有语句时,跳出作用域的goto同样需要关闭被捕获的变量
+
+local f
+do
+    local c = 42
+    f = function() return c end
+    goto escaped
+end
+::escaped::
+local a, b, d = "x", "y", "z"
+print(f())                                                               -- 42
+
+local hs = {}
+for k = 1, 3 do
+    do
+        local y = k * 2
+        hs[k] = function() return y end
+        goto next
+    end
+    ::next::
+    local z = "oops"
+end
+print(hs[1](), hs[2](), hs[3]())                                         -- 2 4 6
+
+-- 内层作用域可以定义与外层同名的label,goto跳转到最近的可见label
+
+do
+    goto L
+    do ::L:: print("inner") end
+    ::L::
+end
+print("outer")                                                           -- outer
+
+local function f()
+    goto done
+    do return 1 end
+    ::done::
+    return 2
+end
+print(f())                                                               -- 2
+
+-- 编译错误
+
+local function check(code)
+    local _, err = load(code, "=goto")
+    print(err)
+end
+check("goto x")                               -- goto:1: no visible label 'x' for <goto> at line 1
+check("do ::a:: end goto a")                  -- goto:1: no visible label 'a' for <goto> at line 1
+check("::a:: ::a::")                          -- goto:1: label 'a' already defined on line 1
+check("goto f local a ::f:: print(a)")        -- goto:1: <goto f> at line 1 jumps into the scope of local 'a'
+check("repeat goto c local k ::c:: until k")  -- goto:1: <goto c> at line 1 jumps into the scope of local 'k'
+check("local function g() goto l end ::l::")  -- goto:1: no visible label 'l' for <goto> at line 1
+check("do goto e local q end ::e::")          -- nil
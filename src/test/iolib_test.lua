@@ -0,0 +1,57 @@
+-- io.open(filename [, mode]) / file:write(...) / file:close()
+
+local path = os.tmpname and os.tmpname() or "/tmp/iolib_test.txt"
+local f = assert(io.open(path, "w"))
+print(io.type(f))                                  -- file
+f:write("line1\n", 42, " ", 3.5, "\n", "0x10 -7.5e1 abc\n", "last")
+f:close()
+print(io.type(f), tostring(f))                     -- closed file   file (closed)
+print(pcall(f.read, f))                            -- false attempt to use a closed file
+
+-- file:read(...) "n" "l" "L" "a" n
+
+f = io.open(path)
+print(f:read())                                    -- line1
+print(f:read("n", "n", "l"))                       -- 42 3.5
+print(f:read("n", "n"))                            -- 16 -75.0
+print(f:read("L"))                                 -- " abc\n"
+print(f:read(2), f:read("a"))                      -- la st
+print(f:read("a"), f:read("l"), f:read(0))         -- "" nil nil
+
+-- file:seek([whence [, offset]])
+
+print(f:seek("set", 2), f:read(3), f:seek(), f:seek("end")) -- 2 ne1 5 33
+f:close()
+
+-- io.lines([filename, ...]) / file:lines(...)
+
+for l in io.lines(path) do
+    io.write("[", l, "]")
+end
+print()
+f = io.open(path)
+for a, b in f:lines(1, 2) do
+    print(a, b)                                    -- l in
+    break
+end
+f:close()
+
+-- io.input([file]) / io.output([file]) / io.tmpfile()
+
+io.output(path)
+io.write("redirected\n")
+io.close()
+io.output(io.stdout)
+io.input(path)
+print(io.read("L"))                                -- redirected
+io.input():close()
+io.input(io.stdin)
+
+local t = io.tmpfile()
+t:write("tmp data")
+t:seek("set")
+print(t:read("a"))                                 -- tmp data
+t:close()
+
+print(io.open("/nonexistent/file"))                -- nil /nonexistent/file: No such file or directory 2
+print(io.stdout:close())                           -- nil cannot close standard file
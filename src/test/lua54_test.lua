@@ -0,0 +1,83 @@
+-- 需要以5.4语法运行: lua -std 5.4 lua54_test.lua
+
+print(_VERSION)                                                          -- Lua 5.4
+
+local function closable(name)
+    return setmetatable({}, { __close = function(_, err) print("close", name, err) end })
+end
+
+-- <const>
+local limit <const> = 3
+print(limit)                                                             -- 3
+
+-- <close>按照与声明相反的顺序关闭
+do
+    local a <close> = closable("a")
+    local b <close> = closable("b")
+    print("in block")                                                    -- in block
+end
+-- close	b	nil
+-- close	a	nil
+
+-- 函数返回时关闭,返回值不受影响
+local function f()
+    local c <close> = closable("c")
+    return "ret"
+end
+print(f())
+-- close	c	nil
+-- ret
+
+-- 出错时关闭,__close的第二个参数为错误值
+print(pcall(function()
+    local d <close> = closable("d")
+    error("boom", 0)
+end))
+-- close	d	boom
+-- false	boom
+
+-- break跳出循环时关闭
+for i = 1, 3 do
+    local e <close> = closable("e" .. i)
+    if i == 2 then break end
+end
+-- close	e1	nil
+-- close	e2	nil
+
+-- nil与false不需要关闭
+do
+    local n <close> = nil
+    local m <close> = false
+end
+
+-- 整数for循环不会溢出
+local n = 0
+for i = math.maxinteger - 2, math.maxinteger do n = n + 1 end
+print(n)                                                                 -- 3
+for i = math.mininteger, math.mininteger + 4, 2 do n = n + 1 end
+print(n)                                                                 -- 6
+for i = 1, 3.5 do io.write(i, " ") end
+print()                                                                  -- 1 2 3
+for i = 3, 1.5, -1 do io.write(i, " ") end
+print()                                                                  -- 3 2
+print(pcall(function() for i = 1, 10, 0 do end end))                     -- false	lua54_test.lua:63: 'for' step is zero
+
+-- coroutine.close
+local co = coroutine.create(function()
+    local h <close> = closable("h")
+    coroutine.yield(1)
+    print("not reached")
+end)
+print(coroutine.resume(co))                                              -- true	1
+print(coroutine.close(co))
+-- close	h	nil
+-- true
+print(coroutine.status(co))                                              -- DEAD
+local co2 = coroutine.create(function() error("oops", 0) end)
+print(coroutine.resume(co2))                                             -- false	oops
+print(coroutine.close(co2))                                              -- false	oops
+
+-- 编译错误
+print(load("local z <const> = 1; z = 2"))                                -- nil	[string "local z <const> = 1; z = 2"]:1: attempt to assign to const variable 'z'
+print(load("local z <foo> = 1"))                                         -- nil	[string "local z <foo> = 1"]:1: unknown attribute 'foo'
+print(pcall(function() local q <close> = 42 end))                        -- false	lua54_test.lua:83: variable 'q' got a non-closable value
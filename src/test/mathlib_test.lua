@@ -0,0 +1,45 @@
+-- 常量
+
+print(math.maxinteger, math.mininteger)                                  -- 9223372036854775807 -9223372036854775808
+print(math.maxinteger + 1 == math.mininteger, math.huge > math.maxinteger) -- true true
+print(math.pi > 3.14159 and math.pi < 3.1416)                            -- true
+
+-- 取整与绝对值,能用整数表示时返回整数
+
+print(math.floor(3.7), math.ceil(3.2), math.floor(-3.5), math.ceil(-3.5)) -- 3 4 -4 -3
+print(math.type(math.floor(3.7)), math.type(math.floor(1e100)))          -- integer float
+print(math.abs(-3), math.abs(math.mininteger) == math.mininteger)        -- 3 true
+print(math.max(1, 2, 3), math.min(3, 1, 2), math.type(math.max(1, 2)))   -- 3 1 integer
+print(pcall(math.max))                                                   -- false ...value expected
+
+-- 三角函数、指数与对数
+
+print(math.sin(0) == 0, math.cos(0) == 1, math.tan(0) == 0)              -- true true true
+print(math.asin(1) == math.pi / 2, math.acos(1) == 0)                    -- true true
+print(math.atan(1, -1) == 3 * math.pi / 4, math.atan(1) == math.pi / 4)  -- true true
+print(math.exp(0) == 1, math.log(8, 2) == 3, math.log(100, 10) == 2)     -- true true true
+print(math.log(math.exp(2)) == 2, math.sqrt(9) == 3)                     -- true true
+
+-- fmod与modf
+
+print(math.fmod(-5, 3), math.fmod(5, -3), math.fmod(math.mininteger, -1)) -- -2 2 0
+print(math.fmod(5.5, 2) == 1.5, pcall(math.fmod, 1, 0))                  -- true false ...zero
+local ip, fp = math.modf(-3.5)
+print(ip == -3, fp == -0.5, math.type(ip))                               -- true true float
+print(math.modf(5))                                                      -- 5 0.0
+
+-- 整数相关
+
+print(math.tointeger(3.0), math.tointeger(3.5), math.tointeger("8"))     -- 3 nil 8(与Lua 5.3一致,可转换的字符串也会被转换)
+print(math.type(1), math.type(1.0), math.type("1"))                      -- integer float nil
+print(math.ult(1, -1), math.ult(-1, 1), math.ult(1, 2))                  -- true false true
+
+-- 随机数,相同的种子得到相同的序列
+
+math.randomseed(42)
+local a, b, c = math.random(), math.random(10), math.random(-5, 5)
+math.randomseed(42)
+print(a == math.random(), b == math.random(10), c == math.random(-5, 5)) -- true true true
+print(a >= 0 and a < 1, b >= 1 and b <= 10, c >= -5 and c <= 5)          -- true true true
+print(math.random(3, 3), math.type(math.random(math.mininteger, math.maxinteger))) -- 3 integer
+print(pcall(math.random, 2, 1))                                          -- false ...interval is empty
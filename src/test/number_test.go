@@ -0,0 +1,71 @@
+package test
+
+import "testing"
+
+// 整除与取模向下取整,边界值不会溢出
+func TestFloorDivMod(t *testing.T) {
+	checkLua(t, `
+assert(7 // -2 == -4 and 7 % -2 == -1 and -7 % 2 == 1)
+assert((1 << 62 | 1) // 2 == 1 << 61)
+assert(math.mininteger // -1 == math.mininteger and math.mininteger % -1 == 0)
+assert(5.5 % -2 == -0.5 and -5.5 % 2 == 0.5)
+assert(5 % math.huge == 5 and -5 % math.huge == math.huge and 5 % -math.huge == -math.huge)
+assert(math.tointeger(2^63) == nil and math.tointeger(-2^63) == math.mininteger)
+`)
+}
+
+// 位移量为0、负数或不小于64时与官方一致,math.mininteger不会导致无限递归
+func TestShift(t *testing.T) {
+	checkLua(t, `
+assert(5 << 0 == 5 and 5 >> 0 == 5)
+assert(1 << 63 == math.mininteger and 1 << 64 == 0 and -1 >> 63 == 1 and -1 >> 64 == 0)
+assert(8 << -2 == 2 and 8 >> -2 == 32 and -1 << -1 == math.maxinteger)
+assert(1 << math.mininteger == 0 and 1 >> math.mininteger == 0)
+assert(1 << math.maxinteger == 0 and 1 >> math.maxinteger == 0)
+`)
+}
+
+// 字符串按照lua数字的语法转换:十六进制整数回绕,支持十六进制浮点数,不接受inf与nan
+func TestStringToNumber(t *testing.T) {
+	checkLua(t, `
+assert("0x10" | 0 == 16 and "0xffffffffffffffff" | 0 == -1 and "-0x1" | 0 == -1)
+assert("0x1p4" + 0 == 16.0 and "0x.8" + 0 == 0.5 and "0xA" * 1.0 == 10.0)
+assert(" \t10\n" + 0 == 10 and "1e400" + 0 == math.huge)
+for _, s in ipairs({"inf", "nan", "1_000", "0x", "- 1", "0x-1"}) do
+  assert(not pcall(function() return s + 0 end), s)
+end
+`)
+}
+
+// 整数与浮点数比较时不经过有损的类型转换
+func TestIntFloatCompare(t *testing.T) {
+	checkLua(t, `
+assert(math.maxinteger < 2^63 and math.maxinteger ~= 2^63 and 2^63 > math.maxinteger)
+assert(math.maxinteger - 1 < math.maxinteger + 0.0 and math.mininteger == -2^63)
+assert((1 << 53) + 1 > 2^53 and (1 << 53) + 1 ~= 2^53 and 2^53 <= (1 << 53) + 1)
+assert(-0.5 < 0 and -0.5 <= 0 and not (0.5 <= 0) and 1 == 1.0)
+local nan = 0/0
+assert(not (1 < nan) and not (nan <= 1) and not (math.maxinteger < nan) and nan ~= 1)
+assert(not (math.mininteger < -math.huge) and math.maxinteger < math.huge)
+`)
+}
+
+// 5.3的数值for循环:init与step为整数时limit向循环方向取整,否则按浮点数循环,非数字的控制值报错
+func TestForLoop53(t *testing.T) {
+	checkLua(t, `
+local n = 0
+for i = 1, 2.9 do n = n + 1 assert(math.type(i) == "integer") end
+for i = 3, 1.5, -1 do n = n + 1 assert(math.type(i) == "integer") end
+for i = 1, math.huge do n = n + 1 if i == 3 then break end end
+for i = 1, -math.huge do error("unreachable") end
+for i = 1, 0/0 do error("unreachable") end
+assert(n == 7)
+for i = 1, 2, 0.5 do n = n + 1 assert(math.type(i) == "float") end
+for i = "1", 2 do n = n + 1 assert(math.type(i) == "float") end
+assert(n == 12)
+for _, c in ipairs({{"initial value", {}, 2, 1}, {"limit", 1, {}, 1}, {"step", 1, 2, {}}}) do
+  local ok, msg = pcall(function() for i = c[2], c[3], c[4] do end end)
+  assert(not ok and msg:find("'for' " .. c[1] .. " must be a number", 1, true), msg)
+end
+`)
+}
@@ -16,3 +16,39 @@ local time_str = os.date("%Y-%d-%m-%m %H:%M:%S", os.time())
 print(time_str)
 time_str = os.date("%H:%M:%S")
 print(time_str)
+
+-- os.date("*t") / os.date("!*t")
+
+local t0 = 1689431400
+print(os.date("!%Y-%m-%d %H:%M:%S", t0)) -- 2023-07-15 14:30:00
+print(os.date("!%c", t0)) -- Sat Jul 15 14:30:00 2023
+print(os.date("!%a %b %j %p %U %W %V %G %x %X %%", t0)) -- Sat Jul 196 PM 28 28 28 2023 07/15/23 14:30:00 %
+local t = os.date("!*t", t0)
+print(t.year, t.month, t.day, t.hour, t.min, t.sec, t.wday, t.yday, t.isdst) -- 2023 7 15 14 30 0 7 196 false
+print(os.time(os.date("*t", t0)) == t0) -- true
+print(pcall(os.date, "%Q")) -- false bad argument #1 to 'os.date' (invalid conversion specifier '%Q')
+
+-- os.time会规范化时间表中的字段
+
+local n = {year = 2023, month = 13, day = 32, hour = 12}
+os.time(n)
+print(n.year, n.month, n.day) -- 2024 2 1
+print(pcall(os.time, {year = 2023})) -- false field 'day' missing in date table
+
+-- os.clock() / os.difftime(t2, t1) / os.getenv(varname)
+
+print(type(os.clock())) -- number
+print(os.difftime(t0 + 10, t0)) -- 10
+print(os.getenv("NO_SUCH_VARIABLE_FOR_LUA_TEST")) -- nil
+
+-- os.tmpname() / os.rename(oldname, newname) / os.remove(filename)
+
+local name = os.tmpname()
+print(os.rename(name, name .. ".bak")) -- true
+print(os.remove(name .. ".bak")) -- true
+print(select(3, os.remove(name .. ".bak"))) -- 2
+
+-- os.execute([command])
+
+print(os.execute()) -- true
+print(os.execute("exit 3")) -- nil exit 3
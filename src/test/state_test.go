@@ -1,9 +1,12 @@
 package test
 
 import (
+	"context"
 	"fmt"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"nskbz.cn/lua/api"
 	"nskbz.cn/lua/state"
@@ -83,6 +86,16 @@ func printStack(s api.LuaState) {
 	fmt.Println()
 }
 
+// 执行lua代码(通常由assert组成),出错时报告错误信息
+func checkLua(t *testing.T, code string) {
+	t.Helper()
+	s := state.New()
+	s.OpenLibs()
+	if s.DoString(code) {
+		t.Error(s.ToString(0))
+	}
+}
+
 func TestSetTop(t *testing.T) {
 	state := newState()
 	state.CheckStack(20)
@@ -150,6 +163,27 @@ func TestState(t *testing.T) {
 	}
 }
 
+func TestIntegerSubtype(t *testing.T) {
+	s := state.New()
+	s.PushInteger(3)
+	s.PushFloat(3)
+	s.PushString("3")
+	//IsInteger进行类型转换,IsIntegerSubtype只接受整数子类型
+	for i, expected := range []bool{true, false, false} {
+		if !s.IsInteger(i+1) || s.IsIntegerSubtype(i+1) != expected {
+			t.Errorf("index %d: IsIntegerSubtype got %t, expected %t", i+1, !expected, expected)
+		}
+	}
+	if s.IsIntegerSubtype(4) {
+		t.Error("none value is not an integer")
+	}
+	//ToPointer只对引用类型返回非nil
+	s.NewTable()
+	if s.ToPointer(1) != nil || s.ToPointer(3) != nil || s.ToPointer(4) == nil || s.ToPointer(4) == s.ToPointer(1) {
+		t.Errorf("got %v %v %v", s.ToPointer(1), s.ToPointer(3), s.ToPointer(4))
+	}
+}
+
 func TestOperation(t *testing.T) {
 	ls := state.New()
 	ls.PushInteger(1)
@@ -183,3 +217,643 @@ func TestCheckStack(t *testing.T) {
 	s.CheckStack(1)
 	s.PushInteger(1)
 }
+
+// 栈满时栈顶的槽位仍是有效索引
+func TestFullStackIndex(t *testing.T) {
+	s := state.New()
+	for i := 1; i <= api.LUA_MIN_STACK; i++ {
+		s.PushInteger(int64(i))
+	}
+	if n := s.ToInteger(api.LUA_MIN_STACK); n != api.LUA_MIN_STACK {
+		t.Errorf("stack[%d] = %d", api.LUA_MIN_STACK, n)
+	}
+	s.Pop(1)
+	s.PushString("top")
+	if s.ToString(0) != "top" {
+		t.Error(s.ToString(0))
+	}
+}
+
+type point struct{ x, y int }
+
+func TestUserdata(t *testing.T) {
+	s := state.New()
+	p := &point{1, 2}
+	s.PushUserdata(p)
+	if s.Type(1) != api.LUAVALUE_USERDATA || s.ToUserdata(1) != p {
+		t.FailNow()
+	}
+	block := s.NewUserdata(4)
+	if len(block) != 4 || !s.IsUserdata(2) {
+		t.FailNow()
+	}
+	//每个full userdata拥有独立的元表
+	if !s.NewMetatable("point") || s.NewMetatable("point") {
+		t.FailNow()
+	}
+	s.Pop(2)
+	s.PushValue(1)
+	s.SetMetatableByName("point")
+	s.Pop(1)
+	if s.CheckUdata(1, "point") != p || s.TestUdata(2, "point") != nil {
+		t.FailNow()
+	}
+	//user value
+	s.PushString("uv")
+	s.SetUserValue(1)
+	if s.GetUserValue(1) != api.LUAVALUE_STRING || s.ToString(0) != "uv" {
+		t.FailNow()
+	}
+	s.Pop(1)
+	//包装同一个Go值的light userdata相等
+	s.PushLightUserdata(p)
+	s.PushLightUserdata(p)
+	if !s.IsLightUserdata(3) || !s.RawEqual(3, 4) || s.ToUserdata(3) != p {
+		t.FailNow()
+	}
+}
+
+// light userdata可以作为table的键;切片等不可比较的Go值在压栈时被拒绝,而不是在作为键或比较时panic
+func TestLightUserdataKey(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	p := &point{1, 2}
+	s.Register("lud", func(vm api.LuaVM) int {
+		switch vm.OptInteger(1, 0) {
+		case 1:
+			vm.PushLightUserdata([]int{1})
+		case 2:
+			vm.PushLightUserdata(struct{ v interface{} }{map[int]int{}})
+		default:
+			vm.PushLightUserdata(p)
+		}
+		return 1
+	})
+	if s.DoString(`
+local t = {}
+t[lud()] = "p"
+assert(t[lud()] == "p" and lud() == lud())
+local ok, msg = pcall(lud, 1)
+assert(not ok and string.find(msg, "light userdata must wrap a comparable value, got []int", 1, true))
+assert(not pcall(lud, 2))
+`) {
+		t.Error(s.ToString(0))
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	cases := map[string]string{
+		"local t = nil\nreturn t.x": `[string "local t = nil..."]:2: attempt to index a nil value`,
+		"return 1 + {}":             `[string "return 1 + {}"]:1: attempt to perform arithmetic on a table value`,
+		"local f\nf()":              `[string "local f..."]:2: attempt to call a nil value`,
+		"error('msg')":              `[string "error('msg')"]:1: msg`,
+		"error('msg', 0)":           `msg`,
+		"local function f() error('msg', 2) end\n\nf()":          `[string "local function f() error('msg', 2) end..."]:3: msg`,
+		"local c = coroutine.wrap(function() error(1) end)\nc()": `1`,
+		"local c = coroutine.wrap(error)\nc(\"x\")":              `[string "local c = coroutine.wrap(error)..."]:2: x`,
+	}
+	for code, expected := range cases {
+		s.SetTop(0)
+		s.LoadString(code)
+		if s.PCall(0, 0, false) != api.LUA_ERR_RUN || s.ToString(0) != expected {
+			t.Errorf("%q: got %q, expected %q", code, s.ToString(0), expected)
+		}
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	s.PushGoFunction(func(vm api.LuaVM) int {
+		vm.Traceback(vm, vm.ToString(1), 1)
+		return 1
+	}, 0)
+	s.Load([]byte("local function f()\n  error('boom')\nend\nf()\n"), "@tb.lua", "bt")
+	if s.PCall(0, 0, true) != api.LUA_ERR_RUN {
+		t.FailNow()
+	}
+	//Go函数以全局名显示,最后一层是调用Lua的宿主
+	expected := "tb.lua:2: boom\nstack traceback:\n\t[C]: in function 'error'\n\ttb.lua:2: in local 'f'\n\ttb.lua:4: in main chunk\n\t[C]: in ?"
+	if s.ToString(0) != expected {
+		t.Errorf("got %q", s.ToString(0))
+	}
+
+	//协程中的traceback不以宿主层结尾
+	s.SetTop(0)
+	s.Register("gotb", func(vm api.LuaVM) int {
+		vm.Traceback(vm, "", 0)
+		return 1
+	})
+	s.Load([]byte("local co = coroutine.wrap(function() return (gotb()) end)\nreturn co()\n"), "@co.lua", "bt")
+	s.Call(0, 1)
+	expected = "stack traceback:\n\t[C]: in function 'gotb'\n\tco.lua:1: in function <co.lua:1>"
+	if s.ToString(0) != expected {
+		t.Errorf("got %q", s.ToString(0))
+	}
+}
+
+func TestHook(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	lines := []int{}
+	calls := 0
+	s.SetHook(func(ls api.LuaState, ar *api.DebugInfo) {
+		switch ar.Event {
+		case api.LUA_HOOKLINE:
+			lines = append(lines, ar.CurrentLine)
+		case api.LUA_HOOKCALL:
+			ls.GetInfo("S", ar)
+			if ar.What == "Lua" {
+				calls++
+			}
+		}
+	}, api.LUA_MASKLINE|api.LUA_MASKCALL, 0)
+	s.Load([]byte("local function f()\n  return 1\nend\nlocal x = f()\nx = f()\n"), "@hook.lua", "bt")
+	s.Call(0, 0)
+	if fmt.Sprint(lines) != "[1 4 2 5 2]" || calls != 2 {
+		t.Errorf("lines %v calls %d", lines, calls)
+	}
+	if s.GetHook() == nil || s.GetHookMask() != api.LUA_MASKLINE|api.LUA_MASKCALL {
+		t.FailNow()
+	}
+	s.SetHook(nil, 0, 0)
+	if s.GetHook() != nil || s.GetHookMask() != 0 {
+		t.FailNow()
+	}
+}
+
+func TestInstructionLimit(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	s.SetInstructionLimit(1000)
+	//lua中的pcall无法忽略该错误
+	s.Load([]byte("while true do pcall(function() while true do end end) end"), "@limit.lua", "bt")
+	if s.PCall(0, 0, false) != api.LUA_ERR_RUN {
+		t.FailNow()
+	}
+	if msg := s.ToString(0); !strings.HasSuffix(msg, "instruction limit exceeded") {
+		t.Errorf("got %q", msg)
+	}
+	s.Pop(1)
+
+	s.SetInstructionLimit(0)
+	s.Load([]byte("local n = 0 for i = 1, 100 do n = n + i end return n"), "@limit.lua", "bt")
+	if s.PCall(0, 1, false) != api.LUA_OK || s.ToInteger(0) != 5050 || s.InstructionCount() == 0 {
+		t.FailNow()
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	s.SetCallDepthLimit(100)
+	s.Load([]byte("local function f(n) return 1 + f(n + 1) end\nlocal ok, msg = pcall(f, 1)\nreturn msg"), "@depth.lua", "bt")
+	if s.PCall(0, 1, false) != api.LUA_OK {
+		t.Fatal(s.ToString(0))
+	}
+	if msg := s.ToString(0); msg != "depth.lua:1: stack overflow" {
+		t.Errorf("got %q", msg)
+	}
+	s.Pop(1)
+
+	//Go代码发起的调用嵌套过深时报错而不是使Go的调用栈溢出
+	s.SetCallDepthLimit(0)
+	s.Load([]byte("local function f() return pcall(f) end\nreturn select('#', f())"), "@cstack.lua", "bt")
+	if s.PCall(0, 1, false) != api.LUA_OK {
+		t.Fatal(s.ToString(0))
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.SetContext(ctx)
+	s.Load([]byte("local co = coroutine.wrap(function() while true do end end)\nco()"), "@ctx.lua", "bt")
+	if s.PCall(0, 0, false) != api.LUA_ERR_RUN {
+		t.FailNow()
+	}
+	if msg := s.ToString(0); !strings.HasSuffix(msg, context.DeadlineExceeded.Error()) {
+		t.Errorf("got %q", msg)
+	}
+}
+
+func TestGlobalAssign(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	//全局变量与全局函数在不同的代码块间共享
+	if s.DoString("local function g() return 2, 3 end\nx, y, z = 1, g()\nfunction f(a) return a * 2 end") {
+		t.Fatal(s.ToString(0))
+	}
+	s.LoadString("local function none() end\nreturn x + y + z, f(21), none()")
+	if s.PCall(0, api.LUA_MULTRET, false) != api.LUA_OK || !testState(s, 6, 42) {
+		t.Errorf("got %s", stackString(s))
+	}
+	s.Pop(s.GetTop())
+	if s.GetGlobal("f") != api.LUAVALUE_FUNCTION || s.GetTop() != 1 {
+		t.Errorf("got %s", stackString(s))
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	cases := map[string]string{
+		"x = ":                              `[string "x = "]:1: unexpected symbol near <eof>`,
+		"if x then\nprint(x)":               `[string "if x then..."]:2: 'end' expected near <eof>`,
+		"local s = 'abc\n'":                 `[string "local s = 'abc..."]:1: unfinished string near ''abc'`,
+		"for i do end":                      `[string "for i do end"]:1: '=' or 'in' expected near 'do'`,
+		"x":                                 `[string "x"]:1: syntax error near <eof>`,
+		"break":                             `[string "break"]:1: <break> at line 1 not inside a loop`,
+		"local function f() return ... end": `[string "local function f() return ... end"]:1: cannot use '...' outside a vararg function near '...'`,
+		"do\n goto l\nend\ndo ::l:: end":    `[string "do..."]:4: no visible label 'l' for <goto> at line 2`,
+		"goto l\nlocal x\n::l::\nprint(x)":  `[string "goto l..."]:3: <goto l> at line 1 jumps into the scope of local 'x'`,
+	}
+	for code, expected := range cases {
+		s.Pop(s.GetTop())
+		if s.LoadString(code) != api.LUA_ERR_SYNTAX || s.ToString(0) != expected {
+			t.Errorf("%q: got %q, expected %q", code, s.ToString(0), expected)
+		}
+	}
+	//lua中的load返回nil与错误信息
+	s.Pop(s.GetTop())
+	s.LoadString("return load('x = ')")
+	if s.PCall(0, 2, false) != api.LUA_OK || !s.IsNil(1) || s.ToString(2) != `[string "x = "]:1: unexpected symbol near <eof>` {
+		t.Errorf("got %s", stackString(s))
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	s := state.New()
+	s.OpenLibsWith(api.SandboxProfile())
+	if s.DoString("assert(load == nil and dofile == nil and rawset == nil and io == nil and os.exit == nil and os.time ~= nil)") {
+		t.Fatal(s.ToString(0))
+	}
+	//脚本不能修改全局表,也不能取得原全局表
+	cases := map[string]string{
+		"x = 1":                          "attempt to modify read-only global 'x'",
+		"_G.print = nil":                 "attempt to modify read-only global 'print'",
+		"setmetatable(_G, nil)":          "cannot change a protected metatable",
+		"getmetatable(_G).__index.x = 1": "attempt to index a boolean value",
+		"rawset(_G, 'print', error)":     "attempt to call a nil value",
+		"table.insert(_G, 1)":            "attempt to modify read-only global '1'",
+	}
+	for code, expected := range cases {
+		s.Pop(s.GetTop())
+		if !s.DoString(code) || !strings.HasSuffix(s.ToString(0), expected) {
+			t.Errorf("%q: got %q, expected %q", code, s.ToString(0), expected)
+		}
+	}
+	if s.DoString("assert(rawget(_G, 'print') == nil and print ~= error)") {
+		t.Fatal(s.ToString(0))
+	}
+	//pairs遍历原全局表,但状态参数仍为代理
+	s.Pop(s.GetTop())
+	if s.DoString(`
+		local n, found = 0, false
+		for k, v in pairs(_G) do
+			n = n + 1
+			found = found or (k == "print" and v == print)
+		end
+		local _, t = pairs(_G)
+		assert(n > 10 and found and t == _G and next(_G) == nil)`) {
+		t.Fatal(s.ToString(0))
+	}
+	//宿主程序仍可以设置全局变量
+	s.Pop(s.GetTop())
+	s.PushInteger(42)
+	s.SetGlobal("answer")
+	s.LoadString("local n = answer return n")
+	if s.PCall(0, 1, false) != api.LUA_OK || !testState(s, 42) {
+		t.Errorf("got %s", stackString(s))
+	}
+	//只允许加载文本chunk
+	s.Pop(s.GetTop())
+	if s.Load([]byte("\x1bLua"), "=bin", "bt") != api.LUA_ERR_SYNTAX || s.ToString(0) != "attempt to load a binary chunk (mode is 't')" {
+		t.Errorf("got %s", stackString(s))
+	}
+}
+
+func TestLoadMode(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	s.LoadString(`return load("\27Lua", "x", "t")`)
+	if s.PCall(0, 2, false) != api.LUA_OK || !s.IsNil(1) || s.ToString(2) != "attempt to load a binary chunk (mode is 't')" {
+		t.Errorf("got %s", stackString(s))
+	}
+	s.Pop(s.GetTop())
+	if s.Load([]byte("return 1"), "=src", "b") != api.LUA_ERR_SYNTAX || s.ToString(0) != "attempt to load a text chunk (mode is 'b')" {
+		t.Errorf("got %s", stackString(s))
+	}
+}
+
+func TestLua54(t *testing.T) {
+	//默认为5.3,不接受属性
+	s := state.New()
+	s.OpenLibs()
+	if s.LoadString("local x <const> = 1") != api.LUA_ERR_SYNTAX {
+		t.Errorf("5.3 accepted attribs")
+	}
+
+	s = state.New()
+	s.SetVersion(api.LUA_VERSION_54)
+	s.OpenLibs()
+	code := `
+local log = {}
+local function closable(name)
+  return setmetatable({}, {__close = function(_, err) log[#log + 1] = name .. ":" .. tostring(err) end})
+end
+do
+  local a <close> = closable("a")
+  local b <close>, c <const> = closable("b"), 1
+end
+for i = 1, 3 do
+  local l <close> = closable("l" .. i)
+  if i == 2 then break end
+end
+pcall(function()
+  local e <close> = closable("e")
+  error("x", 0)
+end)
+local co = coroutine.create(function()
+  local y <close> = closable("y")
+  coroutine.yield()
+end)
+coroutine.resume(co)
+assert(coroutine.close(co) and coroutine.status(co) == "dead")
+local n = 0
+for i = math.maxinteger - 1, math.maxinteger do n = n + 1 end
+for i = math.mininteger, math.mininteger + 2, 2 do n = n + 1 end
+return table.concat(log, " "), n, _VERSION`
+	if s.LoadString(code) != api.LUA_OK || s.PCall(0, 3, false) != api.LUA_OK {
+		t.Fatal(s.ToString(0))
+	}
+	if !testState(s, "b:nil a:nil l1:nil l2:nil e:x y:nil", 4, "Lua 5.4") {
+		t.Errorf("got %s", stackString(s))
+	}
+
+	cases := map[string]string{
+		"local x <const> = 1; x = 2":                       "attempt to assign to const variable 'x'",
+		"local x <const> = 1; return function() x = 2 end": "attempt to assign to const variable 'x'",
+		"local x <what> = 1":                               "unknown attribute 'what'",
+		"local a <close>, b <close> = nil":                 "multiple to-be-closed variables in local list",
+		"local x <close> = 1":                              "variable 'x' got a non-closable value",
+		"for i = 1, 10, 0 do end":                          "'for' step is zero",
+	}
+	for code, expected := range cases {
+		s.Pop(s.GetTop())
+		if !s.DoString(code) || !strings.HasSuffix(s.ToString(0), expected) {
+			t.Errorf("%q: got %q, expected %q", code, s.ToString(0), expected)
+		}
+	}
+}
+
+func TestNumberToString(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	cases := map[string]string{
+		"3.0":                 "3.0",
+		"-0.0":                "-0.0",
+		"1e100":               "1e+100",
+		"1e15":                "1e+15",
+		"2^53":                "9.007199254741e+15",
+		"0.1":                 "0.1",
+		"1/3":                 "0.33333333333333",
+		"1/0":                 "inf",
+		"-1/0":                "-inf",
+		"math.mininteger":     "-9223372036854775808",
+		"123456789012345.0":   "1.2345678901234e+14",
+		"2.5 .. ''":           "2.5",
+		"table.concat({1.0})": "1.0",
+	}
+	for exp, want := range cases {
+		s.LoadString("return tostring(" + exp + ")")
+		if s.PCall(0, 1, false) != api.LUA_OK {
+			t.Fatal(s.ToString(0))
+		}
+		if got := s.ToString(0); got != want {
+			t.Errorf("%s: got %s, want %s", exp, got, want)
+		}
+		s.Pop(1)
+	}
+}
+
+// 连接是右结合的:从右向左依次连接,__concat只在两侧不能直接连接时调用
+func TestConcatRightAssoc(t *testing.T) {
+	checkLua(t, `
+local calls, left = 0, nil
+local mt = {__concat = function (a, b)
+  calls, left = calls + 1, a
+  return (type(a) == "table" and "T" or a) .. (type(b) == "table" and "T" or b)
+end}
+local t = setmetatable({}, mt)
+assert(t .. "x" .. 1 .. "y" == "Tx1y" and calls == 1)
+calls = 0
+assert("a" .. t .. "b" .. t == "aTbT" and calls == 2)
+calls = 0
+assert("a" .. "b" .. t == "abT" and calls == 1 and left == "b")
+local ok, msg = pcall(function () return "a" .. {} .. "b" end)
+assert(not ok and string.find(msg, "attempt to concatenate a table value", 1, true))
+`)
+}
+
+// __call元方法本身不是函数时继续使用它的__call元方法,被调用的值依次作为第一个参数
+func TestCallChain(t *testing.T) {
+	checkLua(t, `
+local f = setmetatable({}, {__call = function (self, a, b) return self, a, b end})
+local g = setmetatable({}, {__call = f})
+local x, y, z = g(1)
+assert(x == f and y == g and z == 1)
+local ok, msg = pcall(setmetatable({}, {__call = 1}))
+assert(not ok and string.find(msg, "attempt to call a number value", 1, true))
+`)
+}
+
+// 协程无限递归地resume新的协程时报错"C stack overflow"而不是耗尽内存
+func TestResumeDepth(t *testing.T) {
+	checkLua(t, `
+local depth = 0
+local function f()
+  depth = depth + 1
+  local ok, msg = coroutine.resume(coroutine.create(f))
+  if not ok then error(msg, 0) end
+end
+local ok, msg = coroutine.resume(coroutine.create(f))
+assert(not ok and msg == "C stack overflow" and depth > 100 and depth <= 200) --resume本身也占用一层Go调用
+`)
+}
+
+// 错误处理函数本身出错时以新的错误再次调用它,一直出错时返回"error in error handling"
+func TestErrorHandlerRetry(t *testing.T) {
+	checkLua(t, `
+local n = 0
+local ok, msg = xpcall(error, function (m)
+  n = n + 1
+  if n == 1 then error("again", 0) end
+  return "handled " .. m
+end, "x", 0)
+assert(not ok and msg == "handled again" and n == 2)
+ok, msg = xpcall(error, function (m) error(m, 0) end, "x")
+assert(not ok and msg == "error in error handling")
+`)
+}
+
+// 在主协程中yield报错而不是阻塞
+func TestYieldOutsideCoroutine(t *testing.T) {
+	checkLua(t, `
+local ok, msg = pcall(coroutine.yield, 1)
+assert(not ok and string.find(msg, "attempt to yield from outside a coroutine", 1, true))
+assert(not coroutine.isyieldable())
+`)
+}
+
+// 参数错误信息与官方一致:"bad argument #n to 'name' (msg)",方法调用不计算self,
+// 无法从调用指令得到函数名时使用已加载库中的名称
+func TestArgError(t *testing.T) {
+	checkLua(t, `
+local function check(msg, f, ...)
+  local ok, err = pcall(f, ...)
+  assert(not ok and string.find(err, msg, 1, true), err)
+end
+check("bad argument #1 to 'string.rep' (string expected, got no value)", string.rep)
+check("bad argument #1 to 'math.floor' (number expected, got string)", math.floor, "a")
+check("bad argument #1 to 'setmetatable' (table expected, got number)", setmetatable, 1)
+check("bad argument #1 to 'select' (number expected, got no value)", select)
+check(":10: bad argument #1 to 'rep' (number expected, got table)", function () return ("x"):rep({}) end)
+check(":11: bad argument #1 to 'char' (number has no integer representation)", function () return string.char(1.5) end)
+check("bad argument #1 to 'insert' (table expected, got nil)", function () table.insert(nil, 1) end)
+`)
+}
+
+func TestCoroutineReclaim(t *testing.T) {
+	s := state.New()
+	s.OpenLibs()
+	//协程与创建者运行在同一个goroutine中,挂起的协程不会留下阻塞的goroutine
+	goroutines := runtime.NumGoroutine()
+	code := "for i = 1, 1000 do local co = coroutine.wrap(function() coroutine.yield() end) co() end"
+	if s.DoString(code) {
+		t.Fatal(s.ToString(0))
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("goroutines %d -> %d", goroutines, n)
+	}
+
+	//不再被引用的挂起协程由Go的垃圾回收器回收,sentinel只被协程中yield的Go函数引用
+	reclaimed := make(chan struct{})
+	sentinel := new([4]int64)
+	runtime.AddCleanup(sentinel, func(ch chan struct{}) { close(ch) }, reclaimed)
+	co := s.NewCoroutine()
+	func(p *[4]int64) {
+		co.PushGoFunction(func(vm api.LuaVM) int {
+			p[0]++
+			return vm.Yield()
+		}, 0)
+	}(sentinel)
+	if s.Resume(co, 0) != api.LUA_SUSPENDED {
+		t.Fatal(co.ToString(0))
+	}
+	sentinel, co = nil, nil
+	s.Pop(1)
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		select {
+		case <-reclaimed:
+			runtime.KeepAlive(s) //创建者仍然存活
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Error("suspended coroutine was not reclaimed")
+}
+
+func TestCloseCoroutine(t *testing.T) {
+	s := state.New()
+	s.SetVersion(api.LUA_VERSION_54)
+	s.OpenLibs()
+	code := `log = {}
+local function closable(name, err)
+  return setmetatable({}, {__close = function(_, e)
+    log[#log + 1] = name .. ":" .. tostring(e)
+    if err then error(err, 0) end
+  end})
+end
+return function()
+  local a <close> = closable("a")
+  pcall(function()
+    local b <close> = closable("b", "oops")
+    coroutine.yield()
+  end)
+  log[#log + 1] = "unreachable"
+end`
+	if s.LoadString(code) != api.LUA_OK || s.PCall(0, 1, false) != api.LUA_OK {
+		t.Fatal(s.ToString(0))
+	}
+	co := s.NewCoroutine()
+	s.Insert(-1) //co f
+	s.XMove(co, 1)
+	if s.Resume(co, 0) != api.LUA_SUSPENDED {
+		t.Fatal(co.ToString(0))
+	}
+	//展开时依次关闭待关闭变量,__close的错误不会被途经的pcall拦截
+	if s.CloseCoroutine(co) != api.LUA_ERR_RUN || co.ToString(0) != "oops" || co.Status() != api.LUA_DEAD {
+		t.Errorf("got %q", co.ToString(0))
+	}
+	if s.DoString("return table.concat(log, ' ')") || s.ToString(0) != "b:nil a:oops" {
+		t.Errorf("got %q", s.ToString(0))
+	}
+	//已经死亡的协程可以重复关闭
+	if s.CloseCoroutine(co) != api.LUA_OK {
+		t.FailNow()
+	}
+
+	//关闭协程时同时关闭捕获变量:闭包之间仍然共享变量,但不再引用协程的函数栈
+	//sentinel只被协程函数栈上的keep引用,捕获变量未关闭时会随函数栈一起保留
+	reclaimed := make(chan struct{})
+	sentinel := new([4]int64)
+	runtime.AddCleanup(sentinel, func(ch chan struct{}) { close(ch) }, reclaimed)
+	s.SetTop(0)
+	code = `return function(keep)
+  local x = 1
+  coroutine.yield(function() return x end, function(v) x = v end)
+  return keep
+end`
+	if s.LoadString(code) != api.LUA_OK || s.PCall(0, 1, false) != api.LUA_OK {
+		t.Fatal(s.ToString(0))
+	}
+	co = s.NewCoroutine()
+	s.Insert(-1) //co f
+	s.XMove(co, 1)
+	func(p *[4]int64) {
+		co.PushGoFunction(func(vm api.LuaVM) int {
+			p[0]++
+			return 0
+		}, 0)
+	}(sentinel)
+	if s.Resume(co, 1) != api.LUA_SUSPENDED {
+		t.Fatal(co.ToString(0))
+	}
+	co.XMove(s, 2)
+	s.SetGlobal("set")
+	s.SetGlobal("get")
+	if s.CloseCoroutine(co) != api.LUA_OK {
+		t.Fatal(co.ToString(0))
+	}
+	if s.DoString("assert(get() == 1) set(5) assert(get() == 5)") {
+		t.Fatal(s.ToString(0))
+	}
+	sentinel, co = nil, nil
+	s.SetTop(0)
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		select {
+		case <-reclaimed:
+			runtime.KeepAlive(s)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Error("closed coroutine's stack is still referenced by upvalues")
+}
@@ -0,0 +1,273 @@
+package test
+
+import "testing"
+
+// print通过全局函数tostring转换每个参数,tostring返回的不是字符串时报错
+func TestPrintUsesToString(t *testing.T) {
+	checkLua(t, `
+local calls = 0
+local tostr = tostring
+tostring = function (v) calls = calls + 1 return "" end
+print(1, nil, {})
+tostring = function () return {} end
+local ok, msg = pcall(print, 1)
+tostring = tostr
+assert(calls == 3)
+assert(not ok and string.find(msg, "'tostring' must return a string to 'print'", 1, true))
+`)
+}
+
+// ipairs遵循__index元方法并且每次返回同一个迭代函数;pairs使用__pairs元方法
+func TestIPairsPairs(t *testing.T) {
+	checkLua(t, `
+local proxy = setmetatable({}, {__index = function (_, i) if i <= 3 then return i * 10 end end})
+local n = 0
+for i, v in ipairs(proxy) do n = n + 1 assert(v == i * 10) end
+assert(n == 3)
+assert(ipairs({}) == ipairs({}))
+assert(not pcall(ipairs))
+local mt = {__pairs = function (t) return function (_, k) if not k then return 1, "one" end end, t, nil, "extra" end}
+local keys = {}
+for k, v in pairs(setmetatable({}, mt)) do keys[#keys + 1] = v end
+assert(#keys == 1 and keys[1] == "one")
+assert(select("#", pairs(setmetatable({}, mt))) == 3)
+`)
+}
+
+// load的env参数(包括nil)作为第一个upvalue;读取函数出错或返回非字符串时load返回nil与错误信息
+func TestLoadEnvAndReader(t *testing.T) {
+	checkLua(t, `
+local env = {y = 2}
+assert(load("x = 1 return y", "chunk", "t", env)() == 2 and env.x == 1)
+local f = load("return print", "chunk", "t", nil)
+assert(not pcall(f))
+local parts, i = {"return ", "1 ", "+ 2"}, 0
+f = load(function () i = i + 1 return parts[i] end)
+assert(f() == 3)
+local g, msg = load(function () error("reader failed", 0) end)
+assert(g == nil and msg == "reader failed")
+g, msg = load(function () return {} end)
+assert(g == nil and msg == "reader function must return a string")
+assert(not pcall(load, 1))
+`)
+}
+
+// collectgarbage的各个选项映射到Go的runtime上,未知选项报错
+func TestCollectGarbage(t *testing.T) {
+	checkLua(t, `
+assert(collectgarbage() == 0 and collectgarbage("collect") == 0)
+assert(math.type(collectgarbage("count")) == "float" and collectgarbage("count") > 0)
+assert(collectgarbage("step") == true and collectgarbage("isrunning") == true)
+assert(collectgarbage("stop") == 0 and collectgarbage("restart") == 0)
+assert(collectgarbage("setpause") == 200 and collectgarbage("setstepmul") == 100)
+local ok, msg = pcall(collectgarbage, "nope")
+assert(not ok and string.find(msg, "invalid option 'nope'", 1, true))
+`)
+}
+
+// tonumber按照lua数字的语法转换字符串;指定base时只接受字符串,结果为整数
+func TestToNumber(t *testing.T) {
+	checkLua(t, `
+assert(math.type(tonumber(" 0x10 ")) == "integer" and tonumber(" 0x10 ") == 16)
+assert(tonumber("1e1") == 10.0 and tonumber("0x1p4") == 16.0 and tonumber(".5") == 0.5)
+assert(tonumber("") == nil and tonumber("1 2") == nil and tonumber({}) == nil)
+assert(tonumber("ff", 16) == 255 and tonumber("-Zz", 36) == -1295 and tonumber(" 10 ", 2) == 2)
+assert(tonumber("8", 8) == nil and tonumber("1.0", 10) == nil and tonumber("", 10) == nil)
+assert(tonumber("7fffffffffffffff", 16) == math.maxinteger)
+assert(tonumber("10000000000000000", 16) == 0)
+assert(not pcall(tonumber, 10, 16))
+local ok, msg = pcall(tonumber, "1", 99)
+assert(not ok and string.find(msg, "base out of range", 1, true))
+assert(not pcall(tonumber))
+`)
+}
+
+// assert至少需要一个参数;rawget与rawset忽略多余的参数
+func TestAssertRawArgs(t *testing.T) {
+	checkLua(t, `
+local ok, msg = pcall(assert)
+assert(not ok and string.find(msg, "value expected", 1, true))
+local a, b, c = assert(1, 2, 3)
+assert(a == 1 and b == 2 and c == 3)
+local t = {x = 1}
+assert(rawget(t, "x", "extra") == 1)
+assert(rawset(t, "y", 2, "extra") == t and t.y == 2)
+`)
+}
+
+// table.insert检查插入位置与参数个数
+func TestTableInsert(t *testing.T) {
+	checkLua(t, `
+local t = {1, 2, 3}
+table.insert(t, 1, 0)
+table.insert(t, 5, 4)
+table.insert(t, 5)
+assert(#t == 6 and t[1] == 0 and t[4] == 3 and t[5] == 4 and t[6] == 5)
+local ok, msg = pcall(table.insert, t, 9, 1)
+assert(not ok and string.find(msg, "position out of bounds", 1, true))
+ok, msg = pcall(table.insert, t, 0, 1)
+assert(not ok and string.find(msg, "position out of bounds", 1, true))
+ok, msg = pcall(table.insert, t, 1, 2, 3)
+assert(not ok and string.find(msg, "wrong number of arguments to 'insert'", 1, true))
+assert(not pcall(table.insert, t, "x", 1))
+`)
+}
+
+// table.sort每次调用比较函数后弹出其结果,排序较大的数组时栈不会溢出
+func TestTableSortComparator(t *testing.T) {
+	checkLua(t, `
+local t = {}
+for i = 1, 200 do t[i] = (i * 37) % 200 end
+table.sort(t, function (a, b) return a > b end)
+for i = 2, #t do assert(t[i - 1] >= t[i]) end
+`)
+}
+
+// table.move覆盖目标区间而不是插入,区间重叠时结果与逐个复制一致
+func TestTableMove(t *testing.T) {
+	checkLua(t, `
+local t = table.move({1, 2, 3, 4, 5}, 1, 3, 4)
+assert(#t == 6 and t[4] == 1 and t[5] == 2 and t[6] == 3)
+t = table.move({1, 2, 3, 4, 5}, 2, 5, 1)
+assert(t[1] == 2 and t[4] == 5 and t[5] == 5)
+local a = {}
+assert(table.move({1, 2}, 1, 2, 3, a) == a and a[3] == 1 and a[4] == 2 and a[1] == nil)
+assert(#table.move({}, 1, 0, 1) == 0)
+local ok, msg = pcall(table.move, {}, 1, math.maxinteger, 2)
+assert(not ok and string.find(msg, "destination wrap around", 1, true))
+ok, msg = pcall(table.move, {}, -1, math.maxinteger, 1)
+assert(not ok and string.find(msg, "too many elements to move", 1, true))
+`)
+}
+
+// table.unpack遵循__index与__len元方法,结果过多时报错而不是耗尽内存
+func TestTableUnpack(t *testing.T) {
+	checkLua(t, `
+assert(select("#", table.unpack({1, 2, 3})) == 3)
+assert(select("#", table.unpack({1, 2, 3}, 2)) == 2)
+assert(select("#", table.unpack({}, 1, 3)) == 3)
+assert(select("#", table.unpack({}, 3, 1)) == 0)
+local p = setmetatable({}, {__index = function (_, i) return i * 2 end, __len = function () return 2 end})
+local a, b, c = table.unpack(p)
+assert(a == 2 and b == 4 and c == nil)
+local ok, msg = pcall(table.unpack, {}, 1, 1e7)
+assert(not ok and string.find(msg, "too many results to unpack", 1, true))
+ok, msg = pcall(table.unpack, {}, math.mininteger, math.maxinteger)
+assert(not ok and string.find(msg, "too many results to unpack", 1, true))
+`)
+}
+
+// table.concat默认分隔符为空字符串,遇到不是字符串或数字的值时报告其索引
+func TestTableConcat(t *testing.T) {
+	checkLua(t, `
+assert(table.concat({1, 2, 3}) == "123")
+assert(table.concat({1, 2, 3}, ", ", 2) == "2, 3")
+assert(table.concat({1, 2, 3}, "-", 3, 2) == "")
+assert(table.concat({}, "x", math.maxinteger, math.maxinteger - 1) == "")
+local ok, msg = pcall(table.concat, {1, {}, 3})
+assert(not ok and string.find(msg, "invalid value (at index 2) in table for 'concat'", 1, true))
+for i = 1, 100 do table.concat({"a", "b"}, ",") end
+`)
+}
+
+// math.deg与math.rad在角度与弧度之间转换,结果为浮点数
+func TestMathDegRad(t *testing.T) {
+	checkLua(t, `
+assert(math.deg(math.pi) == 180.0 and math.rad(180) == math.pi)
+assert(math.type(math.deg(0)) == "float" and math.rad(0) == 0)
+assert(math.abs(math.deg(math.rad(45)) - 45) < 1e-12)
+assert(not pcall(math.deg, "x"))
+`)
+}
+
+// 与官方一致,没有参数时math.min与math.max报告"value expected"
+func TestMathMinMaxNoValue(t *testing.T) {
+	checkLua(t, `
+local ok, msg = pcall(math.max)
+assert(not ok and string.find(msg, "bad argument #1 to 'math.max' (value expected)", 1, true))
+ok, msg = pcall(math.min)
+assert(not ok and string.find(msg, "bad argument #1 to 'math.min' (value expected)", 1, true))
+`)
+}
+
+// 与官方5.3一致,math.tointeger也接受可以转换为整数的字符串
+func TestMathToInteger(t *testing.T) {
+	checkLua(t, `
+assert(math.tointeger(3.0) == 3 and math.tointeger(3.5) == nil)
+assert(math.tointeger("8") == 8 and math.tointeger("34.0") == 34 and math.tointeger("0x10") == 16)
+assert(math.tointeger("x") == nil and math.tointeger({}) == nil and math.tointeger(2^63) == nil)
+`)
+}
+
+// string.format的%c输出单个字节,大于127的值不按UTF-8编码
+func TestFormatChar(t *testing.T) {
+	checkLua(t, `
+assert(string.format("%c", 65) == "A")
+assert(string.format("%c", 200) == "\200" and #string.format("%c", 255) == 1)
+assert(string.format("%3c|%-3c", 66, 67) == "  B|C  ")
+assert(string.format("%c", 0) == "\0")
+`)
+}
+
+// 与C的sprintf一致,带有宽度或精度的%s不接受含有'\0'的字符串
+func TestFormatStringZeros(t *testing.T) {
+	checkLua(t, `
+assert(string.format("%s", "a\0b") == "a\0b")
+local ok, msg = pcall(string.format, "%5s", "a\0b")
+assert(not ok and string.find(msg, "string contains zeros", 1, true))
+assert(not pcall(string.format, "%.1s", "\0"))
+assert(string.format("%5s", "ab") == "   ab")
+`)
+}
+
+// 正在运行或处于normal状态的协程不能被恢复
+func TestResumeNonSuspended(t *testing.T) {
+	checkLua(t, `
+local co
+co = coroutine.create(function ()
+  local ok, msg = coroutine.resume(co)
+  assert(not ok and msg == "cannot resume non-suspended coroutine")
+  local inner = coroutine.create(function ()
+    local ok, msg = coroutine.resume(co)
+    assert(not ok and msg == "cannot resume non-suspended coroutine")
+    return true
+  end)
+  return select(2, coroutine.resume(inner))
+end)
+local ok, res = coroutine.resume(co)
+assert(ok and res == true)
+`)
+}
+
+// 在主协程中coroutine.running返回主协程本身与true
+func TestRunningMainCoroutine(t *testing.T) {
+	checkLua(t, `
+local main, ismain = coroutine.running()
+assert(type(main) == "thread" and ismain == true)
+assert(coroutine.status(main) == "running")
+local co = coroutine.create(function () return coroutine.running() end)
+local _, c, m = coroutine.resume(co)
+assert(c == co and m == false)
+`)
+}
+
+// coroutine.close在5.3中同样可用,关闭挂起的协程后其捕获变量保持关闭时的值
+func TestCoroutineCloseLua53(t *testing.T) {
+	checkLua(t, `
+local get
+local co = coroutine.create(function ()
+  local x = 1
+  get = function () return x end
+  coroutine.yield()
+  x = 2
+end)
+coroutine.resume(co)
+assert(coroutine.close(co) == true and coroutine.status(co) == "dead" and get() == 1)
+assert(coroutine.close(co) == true)
+co = coroutine.create(function () error("oops", 0) end)
+coroutine.resume(co)
+local ok, err = coroutine.close(co)
+assert(ok == false and err == "oops")
+assert(not pcall(coroutine.close, (coroutine.running())))
+`)
+}
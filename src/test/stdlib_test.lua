@@ -166,6 +166,10 @@ print(type(print))          --> "function"
 print(tostring(nil))        --> nil
 print(tostring(true))       --> true
 print(tostring(42.31))         --> 42.31
+print(tostring(3.0), tostring(-0.0), tostring(100))   --> 3.0	-0.0	100
+print(1e100, 2^53, 1/3)     --> 1e+100	9.007199254741e+15	0.33333333333333
+print(1/0, -1/0, 0/0)       --> inf	-inf	-nan
+print(1.0 .. "|" .. 2)      --> 1.0|2
 print(tostring("hello"))    --> hello
 local t = {name = "Lua", version = 5.4}
 print(tostring(t))  --> table: 0x7f8e5bc12340 (默认输出)
@@ -0,0 +1,67 @@
+-- string.sub(s, i [, j]) / string.rep(s, n [, sep]) / string.reverse(s)
+
+local s = "hello world"
+print(s:sub(1, 5), s:sub(-5), s:sub(7, -1))    -- hello world world
+print(("ab"):rep(3), ("ab"):rep(3, ","))       -- ababab ab,ab,ab
+print(s:reverse())                             -- dlrow olleh
+
+-- string.byte(s [, i [, j]]) / string.char(...)
+
+print(string.byte("ABC"), string.byte("ABC", 1, -1)) -- 65 65 66 67
+print(string.char(76, 117, 97))                       -- Lua
+
+-- string.format(formatstring, ...)
+
+print(string.format("%5d|%-5s|%05.2f|%x|%X", 42, "ab", 3.14159, 255, 255))
+print(string.format("%g %g %e %c%%", 0.1, 1e20, 12345.678, 65))
+print(string.format("%q", 'a "quoted"\nline'))
+print(string.format("%s %s %10.3s|", nil, true, "abcdef"))
+
+-- string.find(s, pattern [, init [, plain]])
+
+print(s:find("o w"))               -- 5 7
+print(s:find("(o)%s(w)"))          -- 5 7 o w
+print(s:find(".", 1, true))        -- nil
+print(s:find("l+"))                -- 3 4
+print(s:find("o", -3))             -- nil
+
+-- string.match(s, pattern [, init])
+
+print(string.match("key = value", "(%w+)%s*=%s*(%w+)")) -- key value
+print(string.match("hello", "()ll()"))                  -- 3 5
+print(string.match("  trim  ", "^%s*(.-)%s*$") .. "|")  -- trim|
+print(string.match("f(a(b)c)d", "%b()"))                -- (a(b)c)
+print(string.match("2024-01-15", "(%d+)-(%d+)-(%d+)"))  -- 2024 01 15
+
+-- string.gmatch(s, pattern)
+
+for k, v in string.gmatch("a=1, b=2, c=3", "(%w+)=(%w+)") do
+    print(k, v)
+end
+local words = {}
+for w in s:gmatch("%a+") do
+    words[#words + 1] = w
+end
+print(#words, words[1], words[2]) -- 2 hello world
+
+-- string.gsub(s, pattern, repl [, n])
+
+print(s:gsub("o", "0"))                                  -- hell0 w0rld 2
+print(s:gsub("(%w+)", "<%1>"))                           -- <hello> <world> 2
+print(s:gsub("%w+", "%0 %0", 1))                         -- hello hello world 1
+print(string.gsub("$name is $age", "%$(%w+)", { name = "lua", age = 30 })) -- lua is 30 2
+print(string.gsub("hello", "l", function(c) return string.upper(c) end)) -- heLLo 2
+print(string.gsub("abc", "", "-"))                       -- -a-b-c- 4
+print(string.gsub("THE (quick) fox", "%f[%a]%a+", "W"))  -- W (W) W 3
+
+-- string.pack(fmt, v1, v2, ...) / string.unpack(fmt, s [, pos]) / string.packsize(fmt)
+
+local packed = string.pack("<i4 >I2 s1 z", 100, 4660, "hi", "zs")
+print(#packed, string.byte(packed, 1, -1))     -- 12 100 0 0 0 18 52 2 104 105 122 115 0
+print(string.unpack("<i4 >I2 s1 z", packed))   -- 100 4660 hi zs 13
+print(string.unpack("<d f", string.pack("<d f", 3.5, 0.25))) -- 3.5 0.25 13
+print(string.unpack("i16", string.pack("i16", -3)))           -- -3 17
+print(string.unpack("c3 B", "abc\255"))                       -- abc 255 5
+print(string.packsize("!8 b d"), string.packsize("!4 b Xi4 b")) -- 16 5
+print(pcall(string.pack, "b", 200))           -- false integer overflow
+print(pcall(string.packsize, "s"))            -- false variable-size format in packsize
@@ -0,0 +1,100 @@
+package test
+
+import "testing"
+
+// 数组部分只保存1..n的连续元素:构造时只预留容量,补齐空位后map中后续的整数键移入数组部分
+func TestTableLength(t *testing.T) {
+	checkLua(t, `
+assert(#{[3] = 1} == 0 and #{n = 1, [2] = 2} == 0)
+local t = {}
+t[3] = 3 t[2] = 2 t[1] = 1
+assert(#t == 3 and t[3] == 3)
+t[4] = 4
+assert(#t == 4)
+local keys = 0
+for k, v in pairs(t) do keys = keys + 1 assert(k == v) end
+assert(keys == 4)
+`)
+}
+
+// 值为整数的浮点数键与对应的整数键是同一个键;nil与NaN不能作为键写入,但可以读取
+func TestTableKeys(t *testing.T) {
+	checkLua(t, `
+local t = {}
+t[1.0] = "a" t[2^53] = "b" t[-3.0] = "c"
+assert(t[1] == "a" and t[math.tointeger(2^53)] == "b" and t[-3] == "c")
+local n = 0
+for k in pairs(t) do n = n + 1 assert(math.type(k) == "integer") end
+assert(n == 3)
+t[1.5] = "d"
+assert(t[1.5] == "d" and t[1] == "a")
+assert(t[nil] == nil and t[0/0] == nil)
+local ok, msg = pcall(function () t[nil] = 1 end)
+assert(not ok and string.find(msg, "table index is nil", 1, true))
+ok, msg = pcall(function () t[0/0] = 1 end)
+assert(not ok and string.find(msg, "table index is NaN", 1, true))
+`)
+}
+
+// 遍历期间可以删除或修改已有的键;next的参数不是表中的键时报错
+func TestTableTraversal(t *testing.T) {
+	checkLua(t, `
+local t = {1, 2, 3, a = 1, b = 2, c = 3}
+for k in pairs(t) do t[k] = nil end
+assert(next(t) == nil)
+t = {1, 2, 3, a = 1, b = 2, c = 3}
+local n = 0
+for k, v in pairs(t) do n = n + 1 t[k] = v * 10 end
+assert(n == 6 and t[3] == 30 and t.c == 30)
+n = 0
+for k in pairs(t) do
+  n = n + 1
+  if k == "a" then t.b = nil t.c = nil t[1] = nil end
+end
+assert(n <= 6 and t.b == nil and t.c == nil and t[1] == nil)
+assert(next({}, nil) == nil and next({10}, 1.0) == nil)
+local ok, msg = pcall(next, {a = 1}, "x")
+assert(not ok and string.find(msg, "invalid key to 'next'", 1, true))
+assert(not pcall(next, 1))
+`)
+}
+
+// __index与__newindex的元表链循环查找而不是递归,链过长时认为存在循环并报错
+func TestIndexChain(t *testing.T) {
+	checkLua(t, `
+local t = {x = 1}
+for i = 1, 1000 do t = setmetatable({}, {__index = t, __newindex = t}) end
+assert(t.x == 1)
+t.x = 2
+assert(t.x == 2 and rawget(t, "x") == nil)
+t.y = 3
+assert(t.y == 3 and rawget(t, "y") == nil)
+local a, b = {}, {}
+setmetatable(a, {__index = b, __newindex = b})
+setmetatable(b, {__index = a, __newindex = a})
+local ok, msg = pcall(function () return a.x end)
+assert(not ok and string.find(msg, "'__index' chain too long; possibly a loop", 1, true))
+ok, msg = pcall(function () a.x = 1 end)
+assert(not ok and string.find(msg, "'__newindex' chain too long; possibly a loop", 1, true))
+`)
+}
+
+// 任意类型都可以通过元表提供比较与取长度的元方法,第一个操作数没有元方法时使用第二个操作数的
+func TestMetamethodsOfAllTypes(t *testing.T) {
+	checkLua(t, `
+local mt = {__lt = function (a, b) return true end, __le = function (a, b) return false end}
+local t = setmetatable({}, mt)
+assert(t < 1 and 1 < t and "x" < t)
+assert(not (t <= 1) and not (1 <= t))
+local ok = pcall(function () return {} < 1 end)
+assert(not ok)
+debug.setmetatable(nil, {__len = function () return 42 end, __lt = function () return true end})
+assert(#nil == 42 and nil < nil)
+debug.setmetatable(nil, nil)
+assert(not pcall(function () return #nil end))
+local f = function () end
+debug.setmetatable(f, {__len = function () return 7 end})
+assert(#f == 7 and #print == 7)
+debug.setmetatable(f, nil)
+`)
+}
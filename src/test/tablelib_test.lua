@@ -8,7 +8,7 @@ end
 
 local t = {1, 2, 3, 4, 5}
 table.move(t, 1, 3, 4)  -- 将前3个元素复制到从第4位开始的位置
--- t 变为 {1, 2, 3, 1, 2, 3, 4, 5}
+-- t 变为 {1, 2, 3, 1, 2, 3}(覆盖而不是插入)
 printTable(t)
 
 local src = {10, 20, 30}
@@ -0,0 +1,32 @@
+local s = "Hä€😀"
+
+-- utf8.char(...) / utf8.charpattern
+
+print(utf8.char(72, 228, 8364, 128512) == s, utf8.char() == "")         -- true true
+print(utf8.char(55296):byte(1, -1))                                      -- 237 160 128
+print(pcall(utf8.char, 1114112))                                         -- false ...value out of range
+for c in s:gmatch(utf8.charpattern) do io.write(c, "|") end print()      -- H|ä|€|😀|
+
+-- utf8.len(s [, i [, j]])
+
+print(utf8.len(s), #s, utf8.len(""), utf8.len(s, 2), utf8.len(s, -4))   -- 4 10 0 3 1
+print(utf8.len("\xff"))                                                  -- nil 1
+print(utf8.len("ab\x80c"))                                               -- nil 3
+print(pcall(utf8.len, s, 12))                                            -- false ...initial position out of string
+
+-- utf8.codepoint(s [, i [, j]])
+
+print(utf8.codepoint(s), utf8.codepoint(s, 2))                           -- 72 228
+print(utf8.codepoint(s, 1, -1))                                          -- 72 228 8364 128512
+print(pcall(utf8.codepoint, "\xff"))                                     -- false invalid UTF-8 code
+
+-- utf8.codes(s)
+
+for p, c in utf8.codes(s) do io.write(p, ":", c, " ") end print()        -- 1:72 2:228 4:8364 7:128512
+print(pcall(function() for p, c in utf8.codes("a\xffb") do end end))     -- false utf8lib_test.lua:26: invalid UTF-8 code
+
+-- utf8.offset(s, n [, i])
+
+print(utf8.offset(s, 3), utf8.offset(s, 5), utf8.offset(s, 6))           -- 4 11 nil
+print(utf8.offset(s, -1), utf8.offset(s, -2, 7), utf8.offset(s, 0, 5))   -- 7 2 4
+print(pcall(utf8.offset, s, 1, 3))                                       -- false initial position is a continuation byte